@@ -2,56 +2,84 @@ package main
 
 import (
 	"bytes"
+	"crypto/ecdh"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/engigu/baihu-panel/internal/constant"
 	"github.com/engigu/baihu-panel/internal/executor"
 	"github.com/engigu/baihu-panel/internal/logger"
 	"github.com/engigu/baihu-panel/internal/utils"
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
 // WebSocket 消息类型
 const (
-	WSTypeHeartbeat     = constant.WSTypeHeartbeat
-	WSTypeHeartbeatAck  = constant.WSTypeHeartbeatAck
-	WSTypeTasks         = constant.WSTypeTasks
-	WSTypeTaskResult    = constant.WSTypeTaskResult
-	WSTypeUpdate        = constant.WSTypeUpdate
-	WSTypeConnected     = constant.WSTypeConnected
-	WSTypeDisabled      = constant.WSTypeDisabled
-	WSTypeEnabled       = constant.WSTypeEnabled
-	WSTypeFetchTasks    = constant.WSTypeFetchTasks
-	WSTypeTaskLog       = constant.WSTypeTaskLog
-	WSTypeExecute       = constant.WSTypeExecute
-	WSTypeTaskHeartbeat = constant.WSTypeTaskHeartbeat
-	WSTypeStop          = constant.WSTypeStop
+	WSTypeHeartbeat      = constant.WSTypeHeartbeat
+	WSTypeHeartbeatAck   = constant.WSTypeHeartbeatAck
+	WSTypeTasks          = constant.WSTypeTasks
+	WSTypeTaskResult     = constant.WSTypeTaskResult
+	WSTypeUpdate         = constant.WSTypeUpdate
+	WSTypeConnected      = constant.WSTypeConnected
+	WSTypeDisabled       = constant.WSTypeDisabled
+	WSTypeEnabled        = constant.WSTypeEnabled
+	WSTypeFetchTasks     = constant.WSTypeFetchTasks
+	WSTypeTaskLog        = constant.WSTypeTaskLog
+	WSTypeExecute        = constant.WSTypeExecute
+	WSTypeTaskHeartbeat  = constant.WSTypeTaskHeartbeat
+	WSTypeStop           = constant.WSTypeStop
+	WSTypeShellOpen      = constant.WSTypeShellOpen
+	WSTypeShellOpened    = constant.WSTypeShellOpened
+	WSTypeShellInput     = constant.WSTypeShellInput
+	WSTypeShellOutput    = constant.WSTypeShellOutput
+	WSTypeShellClose     = constant.WSTypeShellClose
+	WSTypeFileRequest    = constant.WSTypeFileRequest
+	WSTypeFileResponse   = constant.WSTypeFileResponse
+	WSTypeStreamOpen     = constant.WSTypeStreamOpen
+	WSTypeStreamClose    = constant.WSTypeStreamClose
+	WSTypeSyncManifest   = constant.WSTypeSyncManifest
+	WSTypeSyncRequest    = constant.WSTypeSyncRequest
+	WSTypeSyncFiles      = constant.WSTypeSyncFiles
+	WSTypeSyncResult     = constant.WSTypeSyncResult
+	WSTypeSyncPull       = constant.WSTypeSyncPull
+	WSTypeSecretsRequest = constant.WSTypeSecretsRequest
+	WSTypeSecrets        = constant.WSTypeSecrets
+	WSTypeConfig         = constant.WSTypeConfig
+	WSTypeConfigAck      = constant.WSTypeConfigAck
 )
 
 type WSMessage struct {
-	Type string          `json:"type"`
-	Data json.RawMessage `json:"data,omitempty"`
+	Type  string          `json:"type"`
+	Data  json.RawMessage `json:"data,omitempty"`
+	TS    int64           `json:"ts,omitempty"`    // 签名时间戳
+	Nonce string          `json:"nonce,omitempty"` // 防重放随机串
+	Sig   string          `json:"sig,omitempty"`   // HMAC 签名
 }
 
 type AgentTask struct {
-	ID       uint   `json:"id"`
-	Name     string `json:"name"`
-	Command  string `json:"command"`
-	Schedule string `json:"schedule"`
-	Cron     string `json:"cron"`
-	Timeout  int    `json:"timeout"`
-	WorkDir  string `json:"work_dir"`
-	Envs     string `json:"envs"`
-	Enabled  bool   `json:"enabled"`
+	ID         uint   `json:"id"`
+	Name       string `json:"name"`
+	Command    string `json:"command"`
+	Schedule   string `json:"schedule"`
+	Cron       string `json:"cron"`
+	Timeout    int    `json:"timeout"`
+	WorkDir    string `json:"work_dir"`
+	Envs       string `json:"envs"`
+	HiddenEnvs string `json:"hidden_envs"` // 隐藏环境变量名称，其值需在日志中脱敏
+	Enabled    bool   `json:"enabled"`
+	Dispatched bool   `json:"dispatched"` // 由服务端调度下发执行，本地不按计划执行
 }
 
 func (t *AgentTask) GetID() string {
@@ -86,6 +114,7 @@ func (t *AgentTask) GetSchedule() string {
 }
 
 type TaskResult struct {
+	RunID     string `json:"run_id"` // 本次执行的标识，服务端据此关联日志、心跳和结果
 	TaskID    uint   `json:"task_id"`
 	LogID     uint   `json:"log_id"`
 	AgentID   uint   `json:"agent_id"` // 仅用于 HTTP 上报时后端补充
@@ -115,6 +144,42 @@ type Agent struct {
 	wsStopCh      chan struct{}     // 用于停止当前 WebSocket 相关的 goroutine
 	taskLogs      map[uint][]string // 记录最近的日志行，用于失败显示
 	logMu         sync.Mutex        // taskLogs 的锁
+	redactRules   []string          // 服务端下发的日志脱敏规则
+	spool         *Spool            // 离线消息缓存，为空时断线期间的消息直接丢弃
+	outbox        []outboxMsg       // 等待 deliverLoop 按顺序发送的任务日志和结果
+	outboxBusy    bool              // deliverLoop 正在发送取出的消息或补发离线缓存
+	replayReq     bool              // 连上面板后请求补发离线缓存
+	deliverMu     sync.Mutex        // outbox、outboxBusy 和 replayReq 的锁，不在持有时进行网络读写
+	deliverCond   *sync.Cond        // outbox 变化时通知 deliverLoop 和等待中的写入
+	auth          *agentAuth        // 申请证书后的连接方式，为空时直连 server_url
+	renewCert     bool              // 面板拒绝当前证书，下次连接前重新申请
+	authMu        sync.RWMutex      // auth 和 renewCert 的锁
+	replay        *utils.ReplayGuard
+	updating      atomic.Bool   // 正在下载或安装新版本
+	connectedCh   chan struct{} // 首次连上面板后关闭，用于确认更新
+	connectedOnce sync.Once
+	shells        map[string]*remoteShell // 面板打开的远程终端
+	shellMu       sync.Mutex
+	wsProto       int                           // 当前连接协商的协议版本，收到 connected 前按 v1 发送
+	wsSession     []byte                        // v2 帧签名使用的连接随机数
+	wsGen         uint64                        // 连接代数，每次连接递增，日志流只属于打开它的连接
+	streams       map[uint32]*RealTimeLogWriter // 当前连接上打开的日志流
+	nextStream    uint32
+	streamMu      sync.Mutex          // streams、nextStream 及各流额度的锁
+	hasSyncDirs   atomic.Bool         // 面板为本机配置了脚本同步映射
+	syncJobs      map[string]*syncJob // 进行中的脚本同步
+	syncPullID    string              // 执行任务前发起的同步，为空表示没有
+	syncPullDone  chan struct{}
+	syncMu        sync.Mutex
+	secretsKey    *ecdh.PrivateKey             // 接收隐藏环境变量的私钥
+	secretsWaits  map[string]chan secretsReply // 等待面板返回隐藏变量的任务
+	secretsMu     sync.Mutex
+	runs          map[string]*localRun // 本机正在执行的任务 (run_id -> 执行)
+	runsMu        sync.Mutex
+	controls      []*http.Server // 本地控制接口和 status_listen
+	stats         agentStats
+	remote        *remoteConfig // 当前生效的运行配置（已合并配置文件中的覆盖项）
+	remoteMu      sync.RWMutex
 }
 
 func NewAgent(config *Config, configFile string) *Agent {
@@ -127,7 +192,16 @@ func NewAgent(config *Config, configFile string) *Agent {
 		stopCh:        make(chan struct{}),
 		lastTaskCount: -1,
 		taskLogs:      make(map[uint][]string),
+		replay:        utils.NewReplayGuard(),
+		connectedCh:   make(chan struct{}),
+		shells:        make(map[string]*remoteShell),
+		streams:       make(map[uint32]*RealTimeLogWriter),
+		syncJobs:      make(map[string]*syncJob),
+		secretsWaits:  make(map[string]chan secretsReply),
+		runs:          make(map[string]*localRun),
 	}
+	a.deliverCond = sync.NewCond(&a.deliverMu)
+	a.stats.startedAt = time.Now()
 
 	// 初始化调度器
 	handler := &AgentHandler{agent: a}
@@ -142,6 +216,26 @@ func NewAgent(config *Config, configFile string) *Agent {
 	a.cronManager = executor.NewCronManager(a.scheduler)
 	a.cronManager.SetLogger(logger.NewSchedulerLogger())
 
+	// 应用上次收到的运行配置，连上面板前也按其执行任务
+	if _, err := a.applyRemoteConfig(loadRemoteConfig()); err != nil {
+		logger.Warnf("运行配置部分未生效: %v", err)
+	}
+
+	// 初始化离线缓存
+	spoolMB := config.SpoolSize
+	if spoolMB <= 0 {
+		spoolMB = defaultSpoolMaxMB
+	}
+	spool, err := NewSpool(filepath.Join(dataDir, "spool"), defaultSpoolMaxEntries, int64(spoolMB)*1024*1024)
+	if err != nil {
+		logger.Warnf("初始化离线缓存失败，断线期间的日志和结果将无法补发: %v", err)
+	} else {
+		a.spool = spool
+		if n := spool.Len(); n > 0 {
+			logger.Infof("离线缓存中有 %d 条待补发消息", n)
+		}
+	}
+
 	return a
 }
 
@@ -153,20 +247,70 @@ type AgentHandler struct {
 func (h *AgentHandler) OnTaskScheduled(req *executor.ExecutionRequest) {}
 
 func (h *AgentHandler) OnTaskExecuting(req *executor.ExecutionRequest) (io.Writer, io.Writer, error) {
-	if req.LogID > 0 {
-		writer := &RealTimeLogWriter{agent: h.agent, logID: req.LogID}
-		return writer, writer, nil
+	if req.Metadata == nil {
+		req.Metadata = make(map[string]interface{})
+	}
+	// 先同步面板上的脚本，保证执行的是最新版本
+	h.agent.syncBeforeRun()
+
+	// 隐藏环境变量只在执行时向面板请求
+	if err := h.agent.fetchSecrets(req); err != nil {
+		return nil, nil, err
 	}
-	return nil, nil, nil
+	// 按运行配置检查工作目录、注入代理和资源限制
+	if err := h.agent.prepareRun(req); err != nil {
+		return nil, nil, err
+	}
+
+	redactor := h.agent.buildRedactor(req)
+	req.Metadata["redactor"] = redactor
+
+	// 每次执行生成 run_id，计划任务无需等待服务端分配日志 ID 即可实时上报日志
+	ref := newRunRef(req)
+	req.Metadata["run_ref"] = ref
+	h.agent.trackRun(ref, req)
+
+	writer := &RealTimeLogWriter{agent: h.agent, ref: ref, redact: redactor.NewStream(), wake: make(chan struct{}, 1)}
+	req.Metadata["log_writer"] = writer
+	return writer, writer, nil
 }
 
-func (h *AgentHandler) OnTaskHeartbeat(req *executor.ExecutionRequest, duration int64) {
-	if req.LogID > 0 {
-		h.agent.sendWSMessage(WSTypeTaskHeartbeat, map[string]interface{}{
-			"log_id":   req.LogID,
-			"duration": duration,
-		})
+// RunRef 执行标识，随 task_log / task_heartbeat / task_result 一起发送
+type RunRef struct {
+	RunID     string `json:"run_id"`
+	TaskID    uint   `json:"task_id"`
+	LogID     uint   `json:"log_id,omitempty"` // 仅服务端下发的手动执行存在
+	StartTime int64  `json:"start_time"`
+}
+
+func newRunRef(req *executor.ExecutionRequest) RunRef {
+	var taskID uint
+	fmt.Sscanf(req.TaskID, "%d", &taskID)
+	return RunRef{RunID: uuid.NewString(), TaskID: taskID, LogID: req.LogID, StartTime: time.Now().Unix()}
+}
+
+// runRefOf 获取请求的执行标识（执行前失败时尚未生成则新建）
+func runRefOf(req *executor.ExecutionRequest) RunRef {
+	if ref, ok := req.Metadata["run_ref"].(RunRef); ok {
+		return ref
 	}
+	return newRunRef(req)
+}
+
+// finishTaskLog 输出脱敏缓存中剩余的日志，返回本次执行的脱敏器
+func (h *AgentHandler) finishTaskLog(req *executor.ExecutionRequest) *utils.Redactor {
+	if writer, ok := req.Metadata["log_writer"].(*RealTimeLogWriter); ok {
+		writer.Flush()
+	}
+	redactor, _ := req.Metadata["redactor"].(*utils.Redactor)
+	return redactor
+}
+
+func (h *AgentHandler) OnTaskHeartbeat(req *executor.ExecutionRequest, duration int64) {
+	h.agent.sendWSMessage(WSTypeTaskHeartbeat, struct {
+		RunRef
+		Duration int64 `json:"duration"`
+	}{runRefOf(req), duration})
 
 	// 每分钟打印一次任务还在运行的日志，提升长任务的存在感
 	if duration >= 60000 && (duration/60000 > (duration-3000)/60000) {
@@ -181,12 +325,16 @@ func (h *AgentHandler) OnTaskCompleted(req *executor.ExecutionRequest, result *e
 	var taskID uint
 	fmt.Sscanf(req.TaskID, "%d", &taskID)
 
+	h.agent.untrackRun(req)
+	h.agent.stats.onRunFinished(result.Status)
+	redactor := h.finishTaskLog(req)
 	h.agent.sendTaskResult(&TaskResult{
+		RunID:     runRefOf(req).RunID,
 		TaskID:    taskID,
 		LogID:     result.LogID,
-		Command:   req.Command,
-		Output:    result.Output,
-		Error:     result.Error,
+		Command:   commandOf(req),
+		Output:    redactor.Redact(result.Output),
+		Error:     redactor.Redact(result.Error),
 		Status:    result.Status,
 		Duration:  result.Duration,
 		ExitCode:  result.ExitCode,
@@ -201,20 +349,22 @@ func (h *AgentHandler) OnTaskCompleted(req *executor.ExecutionRequest, result *e
 }
 
 func (h *AgentHandler) OnTaskFailed(req *executor.ExecutionRequest, err error) {
+	h.agent.untrackRun(req)
+	h.agent.stats.onRunFinished(constant.TaskStatusFailed)
+	h.finishTaskLog(req)
+	ref := runRefOf(req)
 	errMsg := fmt.Sprintf("任务执行失败: %v", err)
 	// 先发送日志，确保服务端能收到错误信息
-	h.agent.sendWSMessage(WSTypeTaskLog, map[string]interface{}{
-		"log_id":  req.LogID,
-		"content": errMsg,
-	})
+	h.agent.deliver(WSTypeTaskLog, taskLogMessage{ref, errMsg})
 
 	var taskID uint
 	fmt.Sscanf(req.TaskID, "%d", &taskID)
 
 	h.agent.sendTaskResult(&TaskResult{
+		RunID:     ref.RunID,
 		TaskID:    taskID,
 		LogID:     req.LogID,
-		Command:   req.Command,
+		Command:   commandOf(req),
 		Output:    "",
 		Error:     err.Error(),
 		Status:    constant.TaskStatusFailed,
@@ -235,10 +385,16 @@ func (a *Agent) Start() error {
 		return fmt.Errorf("缺少令牌，请在配置文件中设置 token")
 	}
 
-	logger.Infof("机器识别码: %s", a.machineID[:16]+"...")
+	logger.Infof("机器识别码: %s", utils.ShortID(a.machineID, 16))
+	a.checkPendingUpdate()
 	a.scheduler.Start()
 	a.cronManager.Start()
+	a.startControl()
 
+	// 先按本地缓存的任务列表调度，服务器不可达时也能按计划执行
+	a.loadTaskCache()
+
+	go a.deliverLoop()
 	go a.wsLoop()
 
 	logger.Info("Agent 已启动 (时区: Asia/Shanghai, 模式: WebSocket)")
@@ -247,9 +403,11 @@ func (a *Agent) Start() error {
 
 func (a *Agent) Stop() {
 	close(a.stopCh)
+	a.stopControl()
 	a.closeWS()
 	a.cronManager.Stop()
 	a.scheduler.Stop()
+	a.waitDelivered(outboxStopTimeout)
 	logger.Info("Agent 已停止")
 }
 
@@ -276,32 +434,51 @@ func (a *Agent) wsLoop() {
 }
 
 func (a *Agent) connectWS() error {
-	serverURL := a.config.ServerURL
-	wsURL := strings.Replace(serverURL, "http://", "ws://", 1)
+	a.ensureEnrollment()
+	auth := a.currentAuth()
+
+	wsURL := strings.Replace(auth.baseURL, "http://", "ws://", 1)
 	wsURL = strings.Replace(wsURL, "https://", "wss://", 1)
-	wsURL = fmt.Sprintf("%s/api/agent/ws?token=%s&machine_id=%s", wsURL, url.QueryEscape(a.config.Token), url.QueryEscape(a.machineID))
+	wsURL = fmt.Sprintf("%s/api/agent/ws?token=%s&machine_id=%s&proto=%d", wsURL, url.QueryEscape(a.config.Token), url.QueryEscape(a.machineID), utils.WSProtocolLatest)
+	// 令牌需要审批时，面板据此向管理员展示待审批的机器
+	hostname, _ := os.Hostname()
+	wsURL += fmt.Sprintf("&hostname=%s&os=%s&arch=%s&version=%s", url.QueryEscape(hostname), runtime.GOOS, runtime.GOARCH, url.QueryEscape(Version))
 
 	logger.Infof("正在连接 WebSocket: %s", wsURL)
-	logger.Infof("Token: %s..., MachineID: %s...", a.config.Token[:8], a.machineID[:16])
+	logger.Infof("Token: %s, MachineID: %s", utils.ShortID(a.config.Token, 8), utils.ShortID(a.machineID, 16))
 
-	dialer := websocket.Dialer{HandshakeTimeout: 10 * time.Second}
-	conn, resp, err := dialer.Dial(wsURL, nil)
+	conn, resp, err := auth.dialer.Dial(wsURL, nil)
 	if err != nil {
 		if resp != nil {
 			bodyBytes, _ := io.ReadAll(resp.Body)
 			logger.Errorf("WebSocket 握手失败: HTTP %d, Body: %s", resp.StatusCode, string(bodyBytes))
 			resp.Body.Close()
+			if resp.StatusCode == http.StatusForbidden && strings.Contains(string(bodyBytes), "审批") {
+				logger.Warnf("本机 (machine_id: %s) 等待管理员在面板中批准", a.machineID)
+			}
+			if resp.StatusCode == http.StatusUnauthorized {
+				a.markCertRejected()
+			}
 		} else {
 			logger.Errorf("WebSocket 连接失败: %v", err)
+			if auth.baseURL != a.config.ServerURL {
+				// mTLS 握手失败可能是面板 CA 已变化，下次连接前重新申请证书
+				a.markCertRejected()
+			}
 		}
+		a.stats.onConnectFailed(err)
 		return err
 	}
 
 	a.wsMu.Lock()
 	a.wsConn = conn
 	a.wsStopCh = make(chan struct{})
+	a.wsProto = utils.WSProtocolV1
+	a.wsSession = nil
+	a.wsGen++
 	a.wsMu.Unlock()
 
+	a.stats.onConnected()
 	logger.Info("WebSocket 已连接")
 	a.sendHeartbeat()
 	go a.heartbeatLoop()
@@ -315,17 +492,20 @@ func (a *Agent) closeWS() {
 	if a.wsStopCh != nil {
 		close(a.wsStopCh)
 		a.wsStopCh = nil
+		a.stats.onDisconnected()
 	}
 	if a.wsConn != nil {
 		a.wsConn.Close()
 		a.wsConn = nil
 	}
+	a.resetStreams()
 }
 
 func (a *Agent) readWS() {
 	defer func() {
 		logger.Info("readWS 退出，准备关闭连接")
 		a.closeWS()
+		a.closeShells()
 	}()
 
 	for {
@@ -338,16 +518,33 @@ func (a *Agent) readWS() {
 			return
 		}
 
-		_, message, err := conn.ReadMessage()
+		mt, message, err := conn.ReadMessage()
 		if err != nil {
 			logger.Warnf("WebSocket 读取错误: %v", err)
 			return
 		}
 
+		// v2 面板发送二进制帧，额度帧直接交给日志流处理
+		if mt == websocket.BinaryMessage {
+			if message = a.handleFrame(message); message == nil {
+				continue
+			}
+		}
+
 		var msg WSMessage
 		if err := json.Unmarshal(message, &msg); err != nil {
 			continue
 		}
+		if err := a.verifyWSMessage(&msg); err != nil {
+			if msg.Type == WSTypeConnected {
+				// 面板没有本机的签名密钥（如数据库已重置），重新申请后重连
+				logger.Warnf("连接消息校验失败，重新申请证书后重连: %v", err)
+				a.markCertRejected()
+				return
+			}
+			logger.Warnf("丢弃消息 %s: %v", msg.Type, err)
+			continue
+		}
 
 		a.handleWSMessage(&msg)
 	}
@@ -362,8 +559,12 @@ func (a *Agent) handleWSMessage(msg *WSMessage) {
 	case WSTypeTasks:
 		a.handleTasks(msg.Data)
 	case WSTypeUpdate:
+		var req struct {
+			Rollback bool `json:"rollback"`
+		}
+		json.Unmarshal(msg.Data, &req)
 		logger.Info("收到更新指令，开始更新...")
-		go a.selfUpdate()
+		go a.selfUpdate(req.Rollback)
 	case WSTypeDisabled:
 		logger.Warn("Agent 已被禁用，清空所有任务")
 		a.clearAllTasks()
@@ -374,6 +575,22 @@ func (a *Agent) handleWSMessage(msg *WSMessage) {
 		a.handleExecute(msg.Data)
 	case WSTypeStop:
 		a.handleStop(msg.Data)
+	case WSTypeShellOpen:
+		go a.handleShellOpen(msg.Data)
+	case WSTypeShellInput:
+		a.handleShellInput(msg.Data)
+	case WSTypeShellClose:
+		a.handleShellClose(msg.Data)
+	case WSTypeFileRequest:
+		a.handleFileRequest(msg.Data)
+	case WSTypeSyncManifest:
+		a.handleSyncManifest(msg.Data)
+	case WSTypeSyncFiles:
+		a.handleSyncFiles(msg.Data)
+	case WSTypeSecrets:
+		a.handleSecrets(msg.Data)
+	case WSTypeConfig:
+		a.handleRemoteConfig(msg.Data)
 	}
 }
 
@@ -390,14 +607,26 @@ func (a *Agent) handleConnected(data json.RawMessage) {
 		Name            string                 `json:"name"`
 		IsNewAgent      bool                   `json:"is_new_agent"`
 		MachineID       string                 `json:"machine_id"`
+		Protocol        int                    `json:"protocol"`
+		Session         string                 `json:"session"`
 		SchedulerConfig map[string]interface{} `json:"scheduler_config"`
 	}
 	json.Unmarshal(data, &resp)
+	a.stats.onAgentID(resp.AgentID)
+
+	// 旧版面板不返回协议版本，继续使用 v1
+	if resp.Protocol >= utils.WSProtocolV2 {
+		a.wsMu.Lock()
+		a.wsProto = utils.WSProtocolV2
+		a.wsSession = []byte(resp.Session)
+		a.wsMu.Unlock()
+		logger.Infof("已协商协议 v%d", resp.Protocol)
+	}
 
 	if resp.IsNewAgent {
-		logger.Infof("注册成功: Agent #%d, 机器码: %s", resp.AgentID, a.machineID[:16]+"...")
+		logger.Infof("注册成功: Agent #%d, 机器码: %s", resp.AgentID, utils.ShortID(a.machineID, 16))
 	} else {
-		logger.Infof("连接成功: Agent #%d (已存在), 机器码: %s", resp.AgentID, a.machineID[:16]+"...")
+		logger.Infof("连接成功: Agent #%d (已存在), 机器码: %s", resp.AgentID, utils.ShortID(a.machineID, 16))
 	}
 
 	// 更新调度器配置
@@ -405,7 +634,9 @@ func (a *Agent) handleConnected(data json.RawMessage) {
 		a.updateSchedulerConfig(resp.SchedulerConfig)
 	}
 
+	a.connectedOnce.Do(func() { close(a.connectedCh) })
 	a.fetchTasks()
+	a.requestReplay()
 }
 
 func (a *Agent) updateSchedulerConfig(config map[string]interface{}) {
@@ -429,8 +660,15 @@ func (a *Agent) updateSchedulerConfig(config map[string]interface{}) {
 			newCfg.RateInterval = time.Duration(v) * time.Millisecond
 		}
 	}
+	// 配置文件中的并发和队列设置优先
+	local, _, _ := mergeLocal(&remoteConfig{}, a.config.Local)
+	if local.WorkerCount > 0 {
+		newCfg.WorkerCount = local.WorkerCount
+	}
+	if local.QueueSize > 0 {
+		newCfg.QueueSize = local.QueueSize
+	}
 
-	// 只有当配置发生变化时才重新加载
 	// 只有当配置发生变化时才重新加载
 	if newCfg != currentCfg {
 		logger.Infof("收到调度配置更新: workers=%d, queue=%d, rate=%v",
@@ -448,22 +686,50 @@ func (a *Agent) handleHeartbeatAck(data json.RawMessage) {
 		Name          string `json:"name"`
 		NeedUpdate    bool   `json:"need_update"`
 		ForceUpdate   bool   `json:"force_update"`
+		Rollback      bool   `json:"rollback"` // 管理员发起的回滚，允许安装比当前旧的版本
 		LatestVersion string `json:"latest_version"`
 	}
 	json.Unmarshal(data, &resp)
+	a.stats.onHeartbeat()
 
 	if resp.NeedUpdate && (a.config.AutoUpdate || resp.ForceUpdate) {
-		logger.Infof("发现新版本 %s，开始更新...", resp.LatestVersion)
-		go a.selfUpdate()
+		// 已回滚过的版本不再自动更新，面板强制更新时仍然尝试
+		if !resp.ForceUpdate && resp.LatestVersion == failedUpdateVersion() {
+			return
+		}
+		if a.updating.Load() {
+			return
+		}
+		if UpdatePublicKey == "" {
+			logger.Debugf("跳过更新: %v", errNoPublicKey)
+			return
+		}
+		rollback := resp.ForceUpdate && resp.Rollback
+		// 面板上的版本不比当前新时不下载，避免每次心跳重复下载后被拒绝
+		if err := checkUpgrade(resp.LatestVersion, rollback); err != nil {
+			logger.Debugf("跳过更新: %v", err)
+			return
+		}
+		if rollback {
+			logger.Infof("面板发起回滚到 %s，开始更新...", resp.LatestVersion)
+		} else {
+			logger.Infof("发现新版本 %s，开始更新...", resp.LatestVersion)
+		}
+		go a.selfUpdate(rollback)
 	}
 }
 
 func (a *Agent) handleTasks(data json.RawMessage) {
 	var resp struct {
-		Tasks []AgentTask `json:"tasks"`
+		Tasks          []AgentTask `json:"tasks"`
+		RedactionRules []string    `json:"redaction_rules"`
 	}
 	json.Unmarshal(data, &resp)
 
+	a.mu.Lock()
+	a.redactRules = resp.RedactionRules
+	a.mu.Unlock()
+
 	newCount := len(resp.Tasks)
 	if newCount != a.lastTaskCount || newCount == 0 {
 		logger.Infof("任务列表同步成功: 共获取到 %d 个任务", newCount)
@@ -526,10 +792,23 @@ func (a *Agent) handleStop(data json.RawMessage) {
 	}
 }
 
+// taskLogMessage 实时日志消息
+type taskLogMessage struct {
+	RunRef
+	Content string `json:"content"`
+}
+
 // RealTimeLogWriter 实时日志写入器，通过 WebSocket 发送日志
+// 小块写入先合并到缓冲区再发送；协议 v2 下按日志流发送并受面板授予的额度限制
 type RealTimeLogWriter struct {
-	agent *Agent
-	logID uint
+	agent  *Agent
+	ref    RunRef
+	mu     sync.Mutex
+	redact *utils.RedactStream // 流式脱敏状态，为空时不脱敏
+	buf    []byte              // 等待发送的日志
+	timer  *time.Timer         // 合并发送的定时器
+	stream *logStream          // v2 日志流，连接变化后重新打开
+	wake   chan struct{}       // 收到额度或连接断开时通知等待中的写入
 }
 
 func (w *RealTimeLogWriter) Write(p []byte) (n int, err error) {
@@ -537,22 +816,156 @@ func (w *RealTimeLogWriter) Write(p []byte) (n int, err error) {
 		return 0, nil
 	}
 
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	content := string(p)
+	if w.redact != nil {
+		content = w.redact.Push(content)
+	}
+	w.send(content)
+	// 脱敏缓存中有未结束的行时也要定时输出，否则进度条和输入提示会一直等到换行
+	if w.redact != nil && w.redact.Pending() && w.timer == nil {
+		w.timer = time.AfterFunc(logBatchInterval, w.flushPending)
+	}
+	return len(p), nil
+}
+
+// Flush 发送脱敏缓存和缓冲区中剩余的内容，并关闭日志流
+func (w *RealTimeLogWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.redact != nil {
+		w.send(w.redact.Flush())
+	}
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	w.drain(0)
+	w.agent.closeStream(w)
+}
+
+func (w *RealTimeLogWriter) send(content string) {
+	if content == "" {
+		return
+	}
+
 	// 记录到本地缓存，用于失败时显示
-	w.agent.addTaskLog(w.logID, p)
+	w.agent.addTaskLog(w.ref.LogID, []byte(content))
 
-	// 构造消息
-	msg := map[string]interface{}{
-		"log_id":  w.logID,
-		"content": string(p),
+	w.buf = append(w.buf, content...)
+	if len(w.buf) < utils.StreamMaxFrameData {
+		// 等待合并更多的小块写入
+		if w.timer == nil {
+			w.timer = time.AfterFunc(logBatchInterval, w.flushPending)
+		}
+		return
 	}
+	// 额度不足时积压过多则阻塞任务输出，等待面板处理
+	w.drain(logMaxPending)
+}
 
-	// 发送消息
-	if err := w.agent.sendWSMessage(WSTypeTaskLog, msg); err != nil {
-		// 如果发送失败，不阻塞程序执行，只记录日志
-		return len(p), nil
+// flushPending 发送缓冲区中已有的内容，额度不足的部分等收到额度后再发送
+func (w *RealTimeLogWriter) flushPending() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.timer = nil
+	if w.redact != nil {
+		w.send(w.redact.Idle())
+		if w.timer != nil {
+			w.timer.Stop()
+			w.timer = nil
+		}
 	}
+	w.flushLocked()
+}
 
-	return len(p), nil
+// drain 发送缓冲区直到剩余不超过 limit 字节，额度长时间不足时丢弃积压的日志
+func (w *RealTimeLogWriter) drain(limit int) {
+	deadline := time.NewTimer(logCreditTimeout)
+	defer deadline.Stop()
+
+	for w.flushLocked(); len(w.buf) > limit; w.flushLocked() {
+		w.mu.Unlock()
+		select {
+		case <-w.wake:
+			w.mu.Lock()
+		case <-deadline.C:
+			w.mu.Lock()
+			w.dropBuf(limit)
+			return
+		}
+	}
+}
+
+// dropBuf 丢弃积压的日志，只保留最新的 limit 字节并在前面加上丢弃标记；limit 为 0（执行结束）时
+// 只发送丢弃标记。不绕过额度改用 task_log 消息，避免日志挤占心跳，完整日志仍保留在本地缓存
+func (w *RealTimeLogWriter) dropBuf(limit int) {
+	start := len(w.buf) - limit
+	if start <= 0 {
+		return
+	}
+	for start < len(w.buf) && !utf8.RuneStart(w.buf[start]) {
+		start++
+	}
+	logger.Warnf("日志流等待额度超时，丢弃 %d 字节日志: RunID=%s", start, w.ref.RunID)
+	marker := fmt.Sprintf("\n[面板处理日志过慢，已丢弃 %d 字节日志]\n", start)
+	if limit == 0 {
+		w.buf = nil
+		w.agent.deliver(WSTypeTaskLog, taskLogMessage{w.ref, marker})
+		return
+	}
+	w.buf = append([]byte(marker), w.buf[start:]...)
+}
+
+// flushLocked 发送缓冲区，连接不支持日志流时整体作为 task_log 消息发送，
+// 断线时写入离线缓存，不阻塞程序执行
+func (w *RealTimeLogWriter) flushLocked() {
+	if len(w.buf) == 0 {
+		return
+	}
+	if !w.agent.streamLog(w) {
+		w.deliverBuf()
+	}
+}
+
+func (w *RealTimeLogWriter) deliverBuf() {
+	if len(w.buf) == 0 {
+		return
+	}
+	w.agent.deliver(WSTypeTaskLog, taskLogMessage{w.ref, string(w.buf)})
+	w.buf = nil
+}
+
+// buildRedactor 根据任务的隐藏环境变量及服务端规则构建日志脱敏器
+func (a *Agent) buildRedactor(req *executor.ExecutionRequest) *utils.Redactor {
+	var taskID uint
+	fmt.Sscanf(req.TaskID, "%d", &taskID)
+
+	a.mu.RLock()
+	rules := a.redactRules
+	var hiddenEnvs string
+	if task, ok := a.tasks[taskID]; ok {
+		hiddenEnvs = task.HiddenEnvs
+	}
+	a.mu.RUnlock()
+
+	hidden := make(map[string]bool)
+	for _, name := range strings.Split(hiddenEnvs, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			hidden[name] = true
+		}
+	}
+
+	secrets := make([]string, 0, len(hidden))
+	for _, env := range req.Envs {
+		if k, v, ok := strings.Cut(env, "="); ok && hidden[k] {
+			secrets = append(secrets, v)
+		}
+	}
+	return utils.NewRedactor(secrets, rules)
 }
 
 func (a *Agent) sendWSMessage(msgType string, data interface{}) error {
@@ -565,16 +978,41 @@ func (a *Agent) sendWSMessage(msgType string, data interface{}) error {
 
 	dataBytes, _ := json.Marshal(data)
 	msg := WSMessage{Type: msgType, Data: dataBytes}
+	if key := a.currentAuth().signKey; key != nil {
+		msg.TS = time.Now().Unix()
+		msg.Nonce = utils.RandomString(24)
+		msg.Sig = utils.SignMessage(key, msg.Type, msg.TS, msg.Nonce, msg.Data)
+	}
 	msgBytes, _ := json.Marshal(msg)
 
+	frameType := websocket.TextMessage
+	if a.wsProto >= utils.WSProtocolV2 {
+		frameType = websocket.BinaryMessage
+		msgBytes = utils.EncodeFrame(&utils.Frame{Type: utils.FrameMessage, Payload: msgBytes}, nil, nil)
+	}
 	a.wsConn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-	if err := a.wsConn.WriteMessage(websocket.TextMessage, msgBytes); err != nil {
+	if err := a.wsConn.WriteMessage(frameType, msgBytes); err != nil {
 		logger.Warnf("发送消息失败 (%s): %v", msgType, err)
 		return err
 	}
 	return nil
 }
 
+// verifyWSMessage 已申请签名密钥时，面板发来的消息必须签名正确且不是重放
+func (a *Agent) verifyWSMessage(msg *WSMessage) error {
+	key := a.currentAuth().signKey
+	if key == nil {
+		return nil
+	}
+	if msg.Sig == "" {
+		return fmt.Errorf("消息缺少签名")
+	}
+	if !utils.VerifyMessage(key, msg.Type, msg.TS, msg.Nonce, msg.Data, msg.Sig) {
+		return fmt.Errorf("消息签名错误")
+	}
+	return a.replay.Check(msg.Nonce, msg.TS)
+}
+
 func (a *Agent) heartbeatLoop() {
 	ticker := time.NewTicker(time.Duration(a.config.Interval) * time.Second)
 	defer ticker.Stop()
@@ -614,6 +1052,9 @@ func (a *Agent) sendHeartbeat() {
 		"os":          runtime.GOOS,
 		"arch":        runtime.GOARCH,
 		"auto_update": a.config.AutoUpdate,
+		"group":       a.config.Group,
+		"labels":      a.config.Labels,
+		"telemetry":   a.collectTelemetry(),
 	}
 	if err := a.sendWSMessage(WSTypeHeartbeat, data); err != nil {
 		logger.Warnf("发送心跳失败: %v", err)
@@ -621,21 +1062,213 @@ func (a *Agent) sendHeartbeat() {
 }
 
 func (a *Agent) sendTaskResult(result *TaskResult) {
-	if err := a.sendWSMessage(WSTypeTaskResult, result); err != nil {
-		logger.Warnf("发送任务结果失败: %v，尝试 HTTP 上报", err)
-		a.reportResultHTTP(result)
+	a.deliver(WSTypeTaskResult, result)
+}
+
+// 发送队列：任务日志和结果先进入内存队列，由单独的协程按顺序发送或写入离线缓存
+const (
+	outboxMax         = 256              // 队列中最多的消息数，超过后写入方等待
+	outboxStopTimeout = 10 * time.Second // 退出时等待队列发送完的最长时间
+)
+
+// outboxMsg 发送队列中的一条消息
+type outboxMsg struct {
+	msgType string
+	data    interface{}
+}
+
+// deliver 将任务日志或结果加入发送队列，由 deliverLoop 按加入的顺序发送
+// 队列已满时等待，避免连接缓慢时积压过多内存
+func (a *Agent) deliver(msgType string, data interface{}) {
+	a.deliverMu.Lock()
+	for len(a.outbox) >= outboxMax {
+		a.deliverCond.Wait()
 	}
+	a.outbox = append(a.outbox, outboxMsg{msgType, data})
+	a.deliverCond.Broadcast()
+	a.deliverMu.Unlock()
+}
+
+// requestReplay 请求 deliverLoop 在发送队列中的消息之前补发离线缓存
+func (a *Agent) requestReplay() {
+	a.deliverMu.Lock()
+	a.replayReq = true
+	a.deliverCond.Broadcast()
+	a.deliverMu.Unlock()
 }
 
-func (a *Agent) reportResultHTTP(result *TaskResult) error {
+// deliverPendingLocked 是否有尚未发送完的任务日志和结果，日志流需要排在它们后面
+// 调用方需持有 deliverMu
+func (a *Agent) deliverPendingLocked() bool {
+	return len(a.outbox) > 0 || a.outboxBusy || (a.spool != nil && a.spool.Len() > 0)
+}
+
+// deliverLoop 依次发送队列中的消息，只在取消息时持锁，发送期间不阻塞新消息入队
+func (a *Agent) deliverLoop() {
+	for {
+		a.deliverMu.Lock()
+		for len(a.outbox) == 0 && !a.replayReq {
+			a.deliverCond.Wait()
+		}
+		replay := a.replayReq
+		a.replayReq = false
+		var msg outboxMsg
+		if !replay {
+			msg = a.outbox[0]
+			a.outbox[0] = outboxMsg{}
+			a.outbox = a.outbox[1:]
+		}
+		a.outboxBusy = true
+		a.deliverCond.Broadcast()
+		a.deliverMu.Unlock()
+
+		if replay {
+			a.replaySpool()
+		} else {
+			a.sendDelivery(msg)
+		}
+
+		a.deliverMu.Lock()
+		a.outboxBusy = false
+		a.deliverCond.Broadcast()
+		a.deliverMu.Unlock()
+	}
+}
+
+// waitDelivered 等待发送队列清空（断线时写入离线缓存），最多等待 timeout
+func (a *Agent) waitDelivered(timeout time.Duration) {
+	expired := false
+	timer := time.AfterFunc(timeout, func() {
+		a.deliverMu.Lock()
+		expired = true
+		a.deliverCond.Broadcast()
+		a.deliverMu.Unlock()
+	})
+	defer timer.Stop()
+
+	a.deliverMu.Lock()
+	defer a.deliverMu.Unlock()
+	for (len(a.outbox) > 0 || a.outboxBusy) && !expired {
+		a.deliverCond.Wait()
+	}
+	if n := len(a.outbox); n > 0 {
+		logger.Warnf("退出时仍有 %d 条任务日志或结果未发送", n)
+	}
+}
+
+// sendDelivery 发送一条任务日志或结果
+// 离线缓存中有待补发的消息时直接追加到缓存，保证服务端按产生顺序收到；
+// WebSocket 发送失败时，执行结果先尝试 HTTP 上报，仍失败则写入缓存等待重连后补发
+func (a *Agent) sendDelivery(msg outboxMsg) {
+	if a.spool == nil || a.spool.Len() == 0 {
+		err := a.sendWSMessage(msg.msgType, msg.data)
+		if err == nil {
+			return
+		}
+		if msg.msgType == WSTypeTaskResult {
+			logger.Warnf("发送任务结果失败: %v，尝试 HTTP 上报", err)
+			if err := a.reportResultHTTP(msg.data); err == nil {
+				return
+			}
+		}
+	}
+
+	if a.spool == nil {
+		return
+	}
+	if err := a.spool.Push(msg.msgType, msg.data); err != nil {
+		logger.Errorf("写入离线缓存失败 (%s): %v", msg.msgType, err)
+	}
+}
+
+// replaySpool 按顺序补发离线缓存中的消息，发送失败时停止，等待下次重连
+// 只在 deliverLoop 中调用，补发期间新消息留在发送队列中
+func (a *Agent) replaySpool() {
+	if a.spool == nil {
+		return
+	}
+	total := a.spool.Len()
+	if total == 0 {
+		return
+	}
+	logger.Infof("开始补发离线缓存消息: 共 %d 条", total)
+
+	sent := 0
+	for {
+		seq, msgType, data, ok := a.spool.Peek()
+		if !ok {
+			break
+		}
+		if err := a.sendWSMessage(msgType, data); err != nil {
+			logger.Warnf("补发离线消息中断: 已发送 %d 条，剩余 %d 条", sent, a.spool.Len())
+			return
+		}
+		a.spool.Remove(seq)
+		sent++
+	}
+	logger.Infof("离线缓存消息补发完成: 共 %d 条", sent)
+}
+
+func (a *Agent) reportResultHTTP(result interface{}) error {
 	resp, err := a.doRequest("POST", "/api/agent/report", result)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
 	return nil
 }
 
+// taskCache 本地缓存的任务列表，Agent 重启且服务器不可达时用于恢复调度
+type taskCache struct {
+	Tasks          []AgentTask `json:"tasks"`
+	RedactionRules []string    `json:"redaction_rules"`
+}
+
+func getTaskCacheFile() string {
+	return filepath.Join(dataDir, "tasks.json")
+}
+
+// saveTaskCache 保存任务列表（需持有 a.mu）
+func (a *Agent) saveTaskCache() {
+	cache := taskCache{Tasks: make([]AgentTask, 0, len(a.tasks)), RedactionRules: a.redactRules}
+	for _, t := range a.tasks {
+		cache.Tasks = append(cache.Tasks, *t)
+	}
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return
+	}
+	os.MkdirAll(dataDir, 0755)
+	tmp := getTaskCacheFile() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		logger.Warnf("保存任务缓存失败: %v", err)
+		return
+	}
+	os.Rename(tmp, getTaskCacheFile())
+}
+
+// loadTaskCache 加载本地缓存的任务列表并开始调度
+func (a *Agent) loadTaskCache() {
+	data, err := os.ReadFile(getTaskCacheFile())
+	if err != nil {
+		return
+	}
+	var cache taskCache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		logger.Warnf("解析任务缓存失败: %v", err)
+		return
+	}
+
+	a.mu.Lock()
+	a.redactRules = cache.RedactionRules
+	a.mu.Unlock()
+	a.updateTasks(cache.Tasks)
+	logger.Infof("已从本地缓存恢复 %d 个任务", len(cache.Tasks))
+}
+
 func (a *Agent) updateTasks(tasks []AgentTask) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
@@ -659,14 +1292,19 @@ func (a *Agent) updateTasks(tasks []AgentTask) {
 		oldTask, exists := a.tasks[id]
 		if !exists || oldTask.Schedule != task.Schedule || oldTask.Command != task.Command ||
 			oldTask.Enabled != task.Enabled || oldTask.Timeout != task.Timeout ||
-			oldTask.WorkDir != task.WorkDir || oldTask.Envs != task.Envs {
-			if task.Enabled {
+			oldTask.WorkDir != task.WorkDir || oldTask.Envs != task.Envs ||
+			oldTask.HiddenEnvs != task.HiddenEnvs || oldTask.Dispatched != task.Dispatched {
+			if task.Enabled && !task.Dispatched {
 				err := a.cronManager.AddTask(task)
 				if err != nil {
 					logger.Errorf("添加调度任务 #%d 失败: %v", id, err)
 					continue
 				}
 				logger.Infof("已添加调度任务 #%d %s (%s)", id, task.Name, task.GetSchedule())
+			} else if task.Dispatched {
+				// 保留任务配置，等待服务端下发执行指令
+				a.cronManager.RemoveTask(fmt.Sprintf("%d", id))
+				logger.Infof("任务 #%d %s 由服务端调度", id, task.Name)
 			} else {
 				a.cronManager.RemoveTask(fmt.Sprintf("%d", id))
 				logger.Infof("调度任务 #%d 已禁用", id)
@@ -674,6 +1312,7 @@ func (a *Agent) updateTasks(tasks []AgentTask) {
 			a.tasks[id] = task
 		}
 	}
+	a.saveTaskCache()
 }
 
 func (a *Agent) clearAllTasks() {
@@ -687,6 +1326,7 @@ func (a *Agent) clearAllTasks() {
 
 	a.tasks = make(map[uint]*AgentTask)
 	a.lastTaskCount = 0
+	a.saveTaskCache()
 	logger.Info("所有任务已清空")
 }
 
@@ -746,7 +1386,8 @@ func (a *Agent) doRequest(method, path string, body interface{}) (*http.Response
 		bodyReader = bytes.NewReader(data)
 	}
 
-	req, err := http.NewRequest(method, a.config.ServerURL+path, bodyReader)
+	auth := a.currentAuth()
+	req, err := http.NewRequest(method, auth.baseURL+path, bodyReader)
 	if err != nil {
 		return nil, err
 	}
@@ -755,5 +1396,5 @@ func (a *Agent) doRequest(method, path string, body interface{}) (*http.Response
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Machine-ID", a.machineID)
 
-	return a.client.Do(req)
+	return auth.client.Do(req)
 }
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/engigu/baihu-panel/internal/utils"
+)
+
+// 命令行工具：exec 通过面板执行任务，ps / kill 通过本地控制接口查看和停止本机的执行，doctor 检查运行环境
+
+// loadCommandConfig 加载命令行使用的配置
+func loadCommandConfig() (*Config, error) {
+	config := &Config{Interval: 30, ScriptSync: true}
+	if err := loadConfigFile(configFile, config); err != nil {
+		return nil, err
+	}
+	if v := os.Getenv("AGENT_SERVER"); v != "" {
+		config.ServerURL = v
+	}
+	return config, nil
+}
+
+// newCommandAgent 创建请求面板用的 Agent，已申请证书时使用 mTLS 连接
+func newCommandAgent(config *Config) *Agent {
+	agent := &Agent{
+		config:    config,
+		machineID: utils.GenerateMachineID(),
+		client:    &http.Client{Timeout: 30 * time.Second},
+	}
+	if auth, err := agent.loadEnrollment(); err == nil {
+		agent.auth = auth
+	}
+	return agent
+}
+
+// decodeAPIResponse 解析面板的 code/msg/data 响应
+func decodeAPIResponse(resp *http.Response, data interface{}) error {
+	body, _ := io.ReadAll(resp.Body)
+	var apiResp struct {
+		Code int             `json:"code"`
+		Msg  string          `json:"msg"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &apiResp); err != nil {
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	if resp.StatusCode != http.StatusOK || apiResp.Code != 200 {
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, apiResp.Msg)
+	}
+	if data != nil && len(apiResp.Data) > 0 {
+		return json.Unmarshal(apiResp.Data, data)
+	}
+	return nil
+}
+
+// commandArg 返回命令后的第一个参数，缺少时打印用法并退出
+func commandArg(usage string) string {
+	if len(os.Args) < 3 || strings.HasPrefix(os.Args[2], "-") {
+		fmt.Printf("用法: %s\n", usage)
+		os.Exit(1)
+	}
+	return os.Args[2]
+}
+
+func cmdExec() {
+	taskID, err := strconv.ParseUint(commandArg("exec <任务ID>"), 10, 64)
+	if err != nil || taskID == 0 {
+		fmt.Println("错误: 无效的任务 ID")
+		os.Exit(1)
+	}
+	config, err := loadCommandConfig()
+	if err != nil {
+		fmt.Printf("加载配置文件失败: %v\n", err)
+		os.Exit(1)
+	}
+
+	resp, err := newCommandAgent(config).doRequest("POST", "/api/agent/execute", map[string]uint64{"task_id": taskID})
+	if err != nil {
+		fmt.Printf("请求面板失败: %v\n", err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+	if err := decodeAPIResponse(resp, nil); err != nil {
+		fmt.Printf("执行失败: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("任务 #%d 已加入面板的执行队列，将在本机执行，可使用 ps 查看\n", taskID)
+}
+
+// fetchRuns 从本地控制接口获取正在执行的任务
+func fetchRuns(config *Config) ([]controlRun, error) {
+	resp, err := controlClient(config).Get("http://agent/runs")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	var runs []controlRun
+	if err := json.NewDecoder(resp.Body).Decode(&runs); err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+func cmdPs() {
+	config, err := loadCommandConfig()
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Printf("加载配置文件失败: %v\n", err)
+		os.Exit(1)
+	}
+	if config == nil {
+		config = &Config{}
+	}
+
+	runs, err := fetchRuns(config)
+	if err != nil {
+		fmt.Printf("无法连接本地控制接口 %s（Agent 是否在运行？）: %v\n", controlSocketPath(config), err)
+		os.Exit(1)
+	}
+	if len(runs) == 0 {
+		fmt.Println("当前没有正在执行的任务")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LOG ID\tRUN ID\tTASK\tNAME\tTYPE\tSTARTED\tDURATION")
+	now := time.Now()
+	for _, run := range runs {
+		logID := "-"
+		if run.LogID > 0 {
+			logID = strconv.FormatUint(uint64(run.LogID), 10)
+		}
+		started := time.Unix(run.StartTime, 0)
+		fmt.Fprintf(w, "%s\t%s\t#%d\t%s\t%s\t%s\t%s\n", logID, run.RunID[:8], run.TaskID, run.Name, run.Type,
+			started.Format("01-02 15:04:05"), now.Sub(started).Round(time.Second))
+	}
+	w.Flush()
+}
+
+func cmdKill() {
+	id := commandArg("kill <日志ID|run_id>")
+	config, err := loadCommandConfig()
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Printf("加载配置文件失败: %v\n", err)
+		os.Exit(1)
+	}
+	if config == nil {
+		config = &Config{}
+	}
+
+	body, _ := json.Marshal(map[string]string{"id": id})
+	resp, err := controlClient(config).Post("http://agent/runs/kill", "application/json", bytes.NewReader(body))
+	if err != nil {
+		fmt.Printf("无法连接本地控制接口 %s（Agent 是否在运行？）: %v\n", controlSocketPath(config), err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Killed int    `json:"killed"`
+		Error  string `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+	if resp.StatusCode != http.StatusOK {
+		fmt.Printf("停止失败: %s\n", result.Error)
+		os.Exit(1)
+	}
+	fmt.Printf("已停止 %d 个执行\n", result.Killed)
+}
+
+// ========== doctor ==========
+
+// doctorInterpreters 常用的脚本解释器，任务命令以它们开头时检查是否已安装
+var doctorInterpreters = []string{"bash", "sh", "python3", "python", "node", "ts-node", "deno", "bun", "php", "perl", "ruby", "lua", "go"}
+
+type doctorReport struct {
+	failed bool
+}
+
+func (r *doctorReport) ok(name, format string, args ...interface{}) {
+	fmt.Printf("[ OK ] %s: %s\n", name, fmt.Sprintf(format, args...))
+}
+
+func (r *doctorReport) warn(name, format string, args ...interface{}) {
+	fmt.Printf("[WARN] %s: %s\n", name, fmt.Sprintf(format, args...))
+}
+
+func (r *doctorReport) fail(name, format string, args ...interface{}) {
+	r.failed = true
+	fmt.Printf("[FAIL] %s: %s\n", name, fmt.Sprintf(format, args...))
+}
+
+func cmdDoctor() {
+	r := &doctorReport{}
+	defer func() {
+		if r.failed {
+			os.Exit(1)
+		}
+	}()
+
+	config, err := loadCommandConfig()
+	switch {
+	case err != nil:
+		r.fail("配置文件", "%s: %v", configFile, err)
+		return
+	case config.ServerURL == "":
+		r.fail("配置文件", "缺少 server_url")
+		return
+	case config.Token == "":
+		r.fail("配置文件", "缺少 token")
+		return
+	default:
+		r.ok("配置文件", "%s，服务器 %s", configFile, config.ServerURL)
+	}
+
+	agent := newCommandAgent(config)
+	if agent.auth != nil {
+		if remaining := time.Until(agent.auth.expiresAt); !agent.auth.expiresAt.IsZero() && remaining < 0 {
+			r.warn("客户端证书", "已于 %s 过期，Agent 连接时会重新申请", agent.auth.expiresAt.Format("2006-01-02"))
+		} else {
+			r.ok("客户端证书", "通过 %s 连接", agent.auth.baseURL)
+		}
+	} else if _, err := os.Stat(filepath.Join(pkiDir(), "enrollment.json")); err == nil {
+		r.warn("客户端证书", "data/pki 中的证书不完整，使用 server_url 直连")
+	}
+
+	doctorServer(r, agent)
+	doctorLocal(r, config)
+	doctorInterpretersCheck(r)
+}
+
+// doctorServer 检查面板连通性、令牌和时钟偏差
+func doctorServer(r *doctorReport, agent *Agent) {
+	sent := time.Now()
+	resp, err := agent.doRequest("GET", "/api/agent/check", nil)
+	if err != nil {
+		r.fail("连接面板", "%v", err)
+		return
+	}
+	defer resp.Body.Close()
+	received := time.Now()
+	latency := received.Sub(sent)
+
+	var check struct {
+		AgentID    uint   `json:"agent_id"`
+		Name       string `json:"name"`
+		Online     bool   `json:"online"`
+		ServerTime int64  `json:"server_time"`
+	}
+	r.ok("连接面板", "延迟 %s", latency.Round(time.Millisecond))
+	// 旧版面板没有自检接口，未知路径返回 404 或前端页面
+	if resp.StatusCode == http.StatusNotFound || strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		r.warn("令牌", "面板版本过旧，不支持自检，跳过令牌和时钟检查")
+		return
+	}
+	if err := decodeAPIResponse(resp, &check); err != nil {
+		if resp.StatusCode == http.StatusUnauthorized {
+			r.fail("令牌", "面板拒绝了令牌或客户端证书（%v）", err)
+		} else {
+			r.fail("令牌", "%v", err)
+		}
+		return
+	}
+	r.ok("令牌", "有效，Agent #%d %s", check.AgentID, check.Name)
+	if check.Online {
+		r.ok("WebSocket", "面板显示本机在线")
+	} else {
+		r.warn("WebSocket", "面板显示本机离线，请确认 Agent 正在运行")
+	}
+
+	// 以请求往返的中点估算本机时间
+	local := sent.Add(latency / 2)
+	skew := local.Sub(time.UnixMilli(check.ServerTime))
+	abs := skew
+	if abs < 0 {
+		abs = -abs
+	}
+	switch {
+	case abs > utils.MessageMaxSkew:
+		r.fail("时钟偏差", "本机比面板%s %s，超过消息签名允许的 %s，请同步系统时间", skewDirection(skew), abs.Round(time.Millisecond), utils.MessageMaxSkew)
+	case abs > 30*time.Second:
+		r.warn("时钟偏差", "本机比面板%s %s，建议同步系统时间", skewDirection(skew), abs.Round(time.Millisecond))
+	default:
+		r.ok("时钟偏差", "%s", abs.Round(time.Millisecond))
+	}
+}
+
+func skewDirection(skew time.Duration) string {
+	if skew > 0 {
+		return "快"
+	}
+	return "慢"
+}
+
+// doctorLocal 检查本机 Agent 进程和控制接口
+func doctorLocal(r *doctorReport, config *Config) {
+	pid := readPidFile()
+	if pid == 0 || !isProcessRunning(pid) {
+		r.warn("Agent 进程", "未运行")
+		return
+	}
+	r.ok("Agent 进程", "运行中 (PID: %d)", pid)
+	if runs, err := fetchRuns(config); err != nil {
+		r.warn("控制接口", "%s 无法连接: %v", controlSocketPath(config), err)
+	} else {
+		r.ok("控制接口", "%s，正在执行 %d 个任务", controlSocketPath(config), len(runs))
+	}
+}
+
+// doctorInterpretersCheck 检查默认 shell 和本机任务用到的解释器
+func doctorInterpretersCheck(r *doctorReport) {
+	shell, _ := utils.GetShell()
+	if path, err := exec.LookPath(shell); err != nil {
+		r.fail("Shell", "%s 不可用，任务无法执行", shell)
+	} else {
+		r.ok("Shell", "%s", path)
+	}
+
+	// 本地缓存的任务命令用到的解释器
+	used := make(map[string][]string)
+	if data, err := os.ReadFile(getTaskCacheFile()); err == nil {
+		var cache taskCache
+		if json.Unmarshal(data, &cache) == nil {
+			for _, task := range cache.Tasks {
+				if name := commandInterpreter(task.Command); name != "" {
+					used[name] = append(used[name], task.Name)
+				}
+			}
+		}
+	}
+
+	names := []string{"python3", "node"}
+	for name := range used {
+		if name != "python3" && name != "node" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names[2:])
+	for _, name := range names {
+		path, err := exec.LookPath(name)
+		switch {
+		case err == nil:
+			r.ok("解释器", "%s (%s)", name, path)
+		case len(used[name]) > 0:
+			r.fail("解释器", "%s 未安装，任务 %s 将无法执行", name, strings.Join(used[name], ", "))
+		default:
+			r.warn("解释器", "%s 未安装", name)
+		}
+	}
+}
+
+// commandInterpreter 返回命令开头的解释器名称，不是常见解释器时返回空
+func commandInterpreter(command string) string {
+	fields := strings.Fields(command)
+	for len(fields) > 0 && strings.Contains(fields[0], "=") {
+		fields = fields[1:] // 跳过 KEY=VALUE 前缀
+	}
+	if len(fields) == 0 {
+		return ""
+	}
+	name := fields[0]
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	for _, known := range doctorInterpreters {
+		if name == known {
+			return name
+		}
+	}
+	return ""
+}
@@ -15,6 +15,20 @@ type Config struct {
 	Token      string
 	Interval   int
 	AutoUpdate bool
+	SpoolSize  int    // 离线缓存上限（MB），0 表示使用默认值
+	Group      string // 分组，面板设置的分组优先
+	Labels     string // 标签，如 region=hk,env=prod，与面板设置的标签合并（面板优先）
+	MTLSURL    string // mTLS 地址，为空时使用 server_url 的主机和面板返回的 mTLS 端口
+	// UpdateTimeout 更新后新版本需在多少秒内连上面板，否则回滚到旧版本，0 表示使用默认值
+	UpdateTimeout int
+	RemoteAccess  bool   // 允许面板管理员打开终端和管理文件，默认关闭
+	RemoteRoot    string // 远程文件管理的根目录，为空时使用 Agent 工作目录
+	ScriptSync    bool   // 接收面板同步的脚本目录，默认开启
+	SyncRoot      string // 脚本同步的根目录，为空时使用 Agent 工作目录
+	ControlSocket string // 本地控制接口的 socket 路径，为空时使用 data/agent.sock，off 表示关闭
+	StatusListen  string // 只读状态和 Prometheus 指标的 HTTP 地址，只允许本机回环地址，为空表示关闭
+	// Local 配置文件中覆盖面板运行配置的项（键见 remoteConfigKeys），值为空的项不覆盖
+	Local map[string]string
 }
 
 func loadConfigFile(path string, config *Config) error {
@@ -41,6 +55,35 @@ func loadConfigFile(path string, config *Config) error {
 	if v := section.Key("auto_update").String(); v != "" {
 		config.AutoUpdate = v == "true" || v == "1"
 	}
+	if v := section.Key("spool_size").String(); v != "" {
+		if i, err := strconv.Atoi(v); err == nil && i > 0 {
+			config.SpoolSize = i
+		}
+	}
+	config.Group = section.Key("group").String()
+	config.Labels = section.Key("labels").String()
+	config.MTLSURL = section.Key("mtls_url").String()
+	if v := section.Key("update_timeout").String(); v != "" {
+		if i, err := strconv.Atoi(v); err == nil && i > 0 {
+			config.UpdateTimeout = i
+		}
+	}
+	if v := section.Key("remote_access").String(); v != "" {
+		config.RemoteAccess = v == "true" || v == "1"
+	}
+	config.RemoteRoot = section.Key("remote_root").String()
+	if v := section.Key("script_sync").String(); v != "" {
+		config.ScriptSync = v == "true" || v == "1"
+	}
+	config.SyncRoot = section.Key("sync_root").String()
+	config.ControlSocket = section.Key("control_socket").String()
+	config.StatusListen = section.Key("status_listen").String()
+	config.Local = make(map[string]string)
+	for _, key := range remoteConfigKeys {
+		if v := section.Key(key).String(); v != "" {
+			config.Local[key] = v
+		}
+	}
 	return nil
 }
 
@@ -61,6 +104,44 @@ func saveConfigFile(path string, config *Config) error {
 	} else {
 		section.Key("auto_update").SetValue("false")
 	}
+	if config.SpoolSize > 0 {
+		section.Key("spool_size").SetValue(strconv.Itoa(config.SpoolSize))
+	}
+	if config.Group != "" {
+		section.Key("group").SetValue(config.Group)
+	}
+	if config.Labels != "" {
+		section.Key("labels").SetValue(config.Labels)
+	}
+	if config.MTLSURL != "" {
+		section.Key("mtls_url").SetValue(config.MTLSURL)
+	}
+	if config.UpdateTimeout > 0 {
+		section.Key("update_timeout").SetValue(strconv.Itoa(config.UpdateTimeout))
+	}
+	if config.RemoteAccess {
+		section.Key("remote_access").SetValue("true")
+	}
+	if config.RemoteRoot != "" {
+		section.Key("remote_root").SetValue(config.RemoteRoot)
+	}
+	if !config.ScriptSync {
+		section.Key("script_sync").SetValue("false")
+	}
+	if config.SyncRoot != "" {
+		section.Key("sync_root").SetValue(config.SyncRoot)
+	}
+	if config.ControlSocket != "" {
+		section.Key("control_socket").SetValue(config.ControlSocket)
+	}
+	if config.StatusListen != "" {
+		section.Key("status_listen").SetValue(config.StatusListen)
+	}
+	for _, key := range remoteConfigKeys {
+		if v := config.Local[key]; v != "" {
+			section.Key(key).SetValue(v)
+		}
+	}
 
 	return cfg.SaveTo(path)
 }
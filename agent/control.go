@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/engigu/baihu-panel/internal/executor"
+	"github.com/engigu/baihu-panel/internal/logger"
+)
+
+// 本地控制接口：Agent 运行时在 Unix socket 上提供 HTTP 接口，供 status / ps / kill 等命令查询和停止本机的执行，
+// socket 文件权限为 0600，只有运行 Agent 的用户可以访问；可用 control_socket = off 关闭
+
+// localRun 本机正在执行的任务
+type localRun struct {
+	ref  RunRef
+	name string
+	typ  executor.TaskType
+	req  *executor.ExecutionRequest
+}
+
+// controlRun 控制接口返回的执行信息
+type controlRun struct {
+	RunID     string `json:"run_id"`
+	TaskID    uint   `json:"task_id"`
+	LogID     uint   `json:"log_id,omitempty"`
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	StartTime int64  `json:"start_time"`
+}
+
+func controlSocketPath(config *Config) string {
+	if config.ControlSocket != "" {
+		return config.ControlSocket
+	}
+	return filepath.Join(dataDir, "agent.sock")
+}
+
+// trackRun 记录开始执行的任务
+func (a *Agent) trackRun(ref RunRef, req *executor.ExecutionRequest) {
+	a.runsMu.Lock()
+	defer a.runsMu.Unlock()
+	a.runs[ref.RunID] = &localRun{ref: ref, name: req.Name, typ: req.Type, req: req}
+}
+
+// untrackRun 移除结束的任务
+func (a *Agent) untrackRun(req *executor.ExecutionRequest) {
+	ref, ok := req.Metadata["run_ref"].(RunRef)
+	if !ok {
+		return
+	}
+	a.runsMu.Lock()
+	defer a.runsMu.Unlock()
+	delete(a.runs, ref.RunID)
+}
+
+// listRuns 按开始时间排序的本机执行列表
+func (a *Agent) listRuns() []controlRun {
+	a.runsMu.Lock()
+	runs := make([]controlRun, 0, len(a.runs))
+	for _, run := range a.runs {
+		runs = append(runs, controlRun{
+			RunID:     run.ref.RunID,
+			TaskID:    run.ref.TaskID,
+			LogID:     run.ref.LogID,
+			Name:      run.name,
+			Type:      string(run.typ),
+			StartTime: run.ref.StartTime,
+		})
+	}
+	a.runsMu.Unlock()
+	sort.Slice(runs, func(i, j int) bool { return runs[i].StartTime < runs[j].StartTime })
+	return runs
+}
+
+// killRun 停止执行，id 可以是日志 ID 或 run_id（至少 8 位前缀）
+func (a *Agent) killRun(id string) (int, error) {
+	logID, _ := strconv.ParseUint(id, 10, 64)
+	var targets []*localRun
+	a.runsMu.Lock()
+	for runID, run := range a.runs {
+		if (logID > 0 && uint64(run.ref.LogID) == logID) || runID == id || (len(id) >= 8 && strings.HasPrefix(runID, id)) {
+			targets = append(targets, run)
+		}
+	}
+	a.runsMu.Unlock()
+	if len(targets) == 0 {
+		return 0, errors.New("没有匹配的执行")
+	}
+	if len(targets) > 1 && logID == 0 && len(id) < 36 {
+		return 0, errors.New("run_id 前缀匹配到多个执行，请提供更长的前缀")
+	}
+
+	killed := 0
+	for _, run := range targets {
+		if a.scheduler.StopRequest(run.req) {
+			logger.Infof("[Agent] 本地命令停止任务 #%d (run_id: %s)", run.ref.TaskID, run.ref.RunID)
+			killed++
+		}
+	}
+	if killed == 0 {
+		return 0, errors.New("执行已结束")
+	}
+	return killed, nil
+}
+
+// controlDisabled 配置为 off / false 时不启动本地控制接口
+func controlDisabled(config *Config) bool {
+	v := strings.ToLower(config.ControlSocket)
+	return v == "off" || v == "false"
+}
+
+// startControl 启动本地控制接口和 status_listen，失败时只记录日志
+func (a *Agent) startControl() {
+	if !controlDisabled(a.config) {
+		if err := a.listenControlSocket(); err != nil {
+			logger.Warnf("启动本地控制接口失败，ps / kill 命令将不可用: %v", err)
+		}
+	}
+	if a.config.StatusListen != "" {
+		if err := a.listenStatus(); err != nil {
+			logger.Warnf("启动状态接口 %s 失败: %v", a.config.StatusListen, err)
+		}
+	}
+}
+
+// listenControlSocket 在 Unix socket 上提供全部接口，包括停止执行
+func (a *Agent) listenControlSocket() error {
+	path := controlSocketPath(a.config)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	// 清理上次异常退出遗留的 socket 文件（文件锁保证同一目录只有一个 Agent 运行）
+	os.Remove(path)
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return err
+	}
+
+	mux := a.statusMux()
+	mux.HandleFunc("/runs/kill", a.handleControlKill)
+	a.serveControl(ln, mux)
+	logger.Infof("本地控制接口: %s", path)
+	return nil
+}
+
+// listenStatus 在本机回环地址上提供只读的状态和指标接口，本机任意用户都能访问，因此不提供停止执行
+func (a *Agent) listenStatus() error {
+	host, _, err := net.SplitHostPort(a.config.StatusListen)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return errors.New("只允许监听 127.0.0.1、::1 或 localhost")
+	}
+	ln, err := net.Listen("tcp", a.config.StatusListen)
+	if err != nil {
+		return err
+	}
+	a.serveControl(ln, a.statusMux())
+	logger.Infof("状态接口: http://%s/metrics", ln.Addr())
+	return nil
+}
+
+// statusMux 只读接口
+func (a *Agent) statusMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", a.handleControlStatus)
+	mux.HandleFunc("/entries", a.handleControlEntries)
+	mux.HandleFunc("/runs", a.handleControlRuns)
+	mux.HandleFunc("/metrics", a.handleControlMetrics)
+	return mux
+}
+
+func (a *Agent) serveControl(ln net.Listener, handler http.Handler) {
+	srv := &http.Server{Handler: handler, ReadHeaderTimeout: 5 * time.Second}
+	a.controls = append(a.controls, srv)
+	go srv.Serve(ln)
+}
+
+// stopControl 关闭本地控制接口和 status_listen
+func (a *Agent) stopControl() {
+	if len(a.controls) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	for _, srv := range a.controls {
+		srv.Shutdown(ctx)
+	}
+	if !controlDisabled(a.config) {
+		os.Remove(controlSocketPath(a.config))
+	}
+}
+
+func (a *Agent) handleControlRuns(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeControlJSON(w, http.StatusOK, a.listRuns())
+}
+
+func (a *Agent) handleControlKill(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" {
+		writeControlJSON(w, http.StatusBadRequest, map[string]string{"error": "参数错误"})
+		return
+	}
+	killed, err := a.killRun(req.ID)
+	if err != nil {
+		writeControlJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+	writeControlJSON(w, http.StatusOK, map[string]int{"killed": killed})
+}
+
+func writeControlJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// controlClient 连接本机 Agent 控制接口的 HTTP 客户端，请求地址的主机部分不使用
+func controlClient(config *Config) *http.Client {
+	path := controlSocketPath(config)
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/engigu/baihu-panel/internal/logger"
+	"github.com/gorilla/websocket"
+)
+
+// certRenewBefore 客户端证书到期前多久自动续签
+const certRenewBefore = 30 * 24 * time.Hour
+
+// enrollment 面板签发的证书信息，与证书和私钥一起保存在 data/pki
+type enrollment struct {
+	MTLSPort   int    `json:"mtls_port"`
+	SigningKey string `json:"signing_key"`
+	ExpiresAt  int64  `json:"expires_at"`
+}
+
+// agentAuth 当前使用的连接方式，申请或续签证书后整体替换
+type agentAuth struct {
+	baseURL   string // 启用 mTLS 时为 mTLS 端口的地址
+	client    *http.Client
+	dialer    *websocket.Dialer
+	signKey   []byte    // 消息签名密钥，为空表示面板不支持或未申请
+	expiresAt time.Time // 客户端证书到期时间，零值表示没有证书
+}
+
+func pkiDir() string {
+	return filepath.Join(dataDir, "pki")
+}
+
+// currentAuth 返回当前的连接方式，尚未申请时使用配置的服务器地址直连
+func (a *Agent) currentAuth() *agentAuth {
+	a.authMu.RLock()
+	defer a.authMu.RUnlock()
+	if a.auth != nil {
+		return a.auth
+	}
+	return &agentAuth{
+		baseURL: a.config.ServerURL,
+		client:  a.client,
+		dialer:  &websocket.Dialer{HandshakeTimeout: 10 * time.Second, EnableCompression: true},
+	}
+}
+
+// ensureEnrollment 在连接前确保已申请证书和签名密钥，证书即将到期或被面板拒绝时重新申请
+// 申请失败（如旧版面板不支持）时继续使用现有的连接方式
+func (a *Agent) ensureEnrollment() {
+	a.authMu.RLock()
+	auth, renew := a.auth, a.renewCert
+	a.authMu.RUnlock()
+
+	if auth == nil {
+		if loaded, err := a.loadEnrollment(); err == nil {
+			auth = loaded
+			a.setAuth(auth, false)
+		}
+	}
+	if auth != nil && !renew && time.Until(auth.expiresAt) > certRenewBefore {
+		return
+	}
+
+	if err := a.enroll(); err != nil {
+		logger.Warnf("申请客户端证书失败，继续使用当前连接方式: %v", err)
+		return
+	}
+	loaded, err := a.loadEnrollment()
+	if err != nil {
+		logger.Warnf("加载客户端证书失败: %v", err)
+		return
+	}
+	a.setAuth(loaded, false)
+	logger.Infof("已获取客户端证书，有效期至 %s", loaded.expiresAt.Format("2006-01-02"))
+}
+
+func (a *Agent) setAuth(auth *agentAuth, renew bool) {
+	a.authMu.Lock()
+	defer a.authMu.Unlock()
+	a.auth = auth
+	a.renewCert = renew
+}
+
+// markCertRejected 面板拒绝当前证书或签名时（如已重新签发、面板数据已重置），下次连接前重新申请
+func (a *Agent) markCertRejected() {
+	a.authMu.Lock()
+	defer a.authMu.Unlock()
+	if a.auth != nil {
+		a.renewCert = true
+	}
+}
+
+// enroll 提交证书签名请求，私钥只保存在本地
+func (a *Agent) enroll() error {
+	key, err := loadOrCreateKey(filepath.Join(pkiDir(), "agent.key"))
+	if err != nil {
+		return err
+	}
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "baihu-agent"},
+	}, key)
+	if err != nil {
+		return err
+	}
+
+	hostname, _ := os.Hostname()
+	body, _ := json.Marshal(map[string]string{
+		"machine_id": a.machineID,
+		"csr":        string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER})),
+		"hostname":   hostname,
+		"os":         runtime.GOOS,
+		"arch":       runtime.GOARCH,
+		"version":    Version,
+	})
+
+	// 使用当前连接方式申请：已保存面板 CA 并启用 mTLS 后只通过 mTLS 端口续签，握手失败时不回退到直连 server_url，
+	// 避免中间人阻断 mTLS 后诱使 Agent 以明文重新申请
+	auth := a.currentAuth()
+	resp, err := a.postEnroll(auth.baseURL, auth.client, body)
+	if err != nil {
+		if auth.baseURL != a.config.ServerURL {
+			return fmt.Errorf("通过 mTLS 端口申请证书失败: %v（如面板 CA 已重新生成，请在面板中重置本机证书并删除 %s 后重启）", err, pkiDir())
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var failed struct {
+			Msg string `json:"msg"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&failed) == nil && failed.Msg != "" {
+			return fmt.Errorf("HTTP %d: %s", resp.StatusCode, failed.Msg)
+		}
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+		Data struct {
+			Certificate   string `json:"certificate"`
+			CACertificate string `json:"ca_certificate"`
+			ExpiresAt     int64  `json:"expires_at"`
+			SigningKey    string `json:"signing_key"`
+			MTLSPort      int    `json:"mtls_port"`
+			RequireMTLS   bool   `json:"require_mtls"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	if result.Code != 200 {
+		return errors.New(result.Msg)
+	}
+	if result.Data.Certificate == "" {
+		return errors.New("面板未返回证书")
+	}
+	if result.Data.RequireMTLS && result.Data.MTLSPort == 0 && a.config.MTLSURL == "" {
+		logger.Warn("面板要求 mTLS 但未返回 mTLS 端口，请在配置文件中设置 mtls_url")
+	}
+
+	dir := pkiDir()
+	if err := os.WriteFile(filepath.Join(dir, "agent.crt"), []byte(result.Data.Certificate), 0644); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "ca.crt"), []byte(result.Data.CACertificate), 0644); err != nil {
+		return err
+	}
+	state, _ := json.MarshalIndent(enrollment{
+		MTLSPort:   result.Data.MTLSPort,
+		SigningKey: result.Data.SigningKey,
+		ExpiresAt:  result.Data.ExpiresAt,
+	}, "", "  ")
+	return os.WriteFile(filepath.Join(dir, "enrollment.json"), state, 0600)
+}
+
+func (a *Agent) postEnroll(baseURL string, client *http.Client, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest("POST", baseURL+"/api/agent/enroll", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+a.config.Token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Machine-ID", a.machineID)
+	return client.Do(req)
+}
+
+// loadEnrollment 根据保存的证书构建连接方式
+func (a *Agent) loadEnrollment() (*agentAuth, error) {
+	dir := pkiDir()
+	data, err := os.ReadFile(filepath.Join(dir, "enrollment.json"))
+	if err != nil {
+		return nil, err
+	}
+	var state enrollment
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, "agent.crt"), filepath.Join(dir, "agent.key"))
+	if err != nil {
+		return nil, err
+	}
+	caPEM, err := os.ReadFile(filepath.Join(dir, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+	caPool := x509.NewCertPool()
+	if !caPool.AppendCertsFromPEM(caPEM) {
+		return nil, errors.New("CA 证书格式错误")
+	}
+
+	auth := &agentAuth{
+		baseURL:   a.config.ServerURL,
+		client:    a.client,
+		dialer:    &websocket.Dialer{HandshakeTimeout: 10 * time.Second, EnableCompression: true},
+		expiresAt: time.Unix(state.ExpiresAt, 0),
+	}
+	if state.SigningKey != "" {
+		if auth.signKey, err = hex.DecodeString(state.SigningKey); err != nil {
+			return nil, err
+		}
+	}
+
+	mtlsURL := a.config.MTLSURL
+	if mtlsURL == "" && state.MTLSPort > 0 {
+		if mtlsURL, err = deriveMTLSURL(a.config.ServerURL, state.MTLSPort); err != nil {
+			return nil, err
+		}
+	}
+	if mtlsURL == "" {
+		return auth, nil
+	}
+
+	// 面板 CA 只为面板签发 ServerAuth 证书，因此校验证书链和用途即可，不要求服务器地址出现在证书中
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		Certificates:       []tls.Certificate{cert},
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("服务器未提供证书")
+			}
+			certs := make([]*x509.Certificate, len(rawCerts))
+			for i, raw := range rawCerts {
+				c, err := x509.ParseCertificate(raw)
+				if err != nil {
+					return err
+				}
+				certs[i] = c
+			}
+			intermediates := x509.NewCertPool()
+			for _, c := range certs[1:] {
+				intermediates.AddCert(c)
+			}
+			_, err := certs[0].Verify(x509.VerifyOptions{
+				Roots:         caPool,
+				Intermediates: intermediates,
+				KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			})
+			return err
+		},
+	}
+	auth.baseURL = mtlsURL
+	auth.client = &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig},
+	}
+	auth.dialer = &websocket.Dialer{HandshakeTimeout: 10 * time.Second, EnableCompression: true, TLSClientConfig: tlsConfig}
+	return auth, nil
+}
+
+// deriveMTLSURL 使用 server_url 的主机和路径前缀，替换为面板返回的 mTLS 端口
+func deriveMTLSURL(serverURL string, port int) (string, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return "", err
+	}
+	u.Scheme = "https"
+	u.Host = net.JoinHostPort(u.Hostname(), strconv.Itoa(port))
+	return u.String(), nil
+}
+
+// loadOrCreateKey 读取 Agent 私钥，不存在时生成
+func loadOrCreateKey(path string) (*ecdsa.PrivateKey, error) {
+	if data, err := os.ReadFile(path); err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("私钥格式错误: %s", path)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
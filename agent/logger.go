@@ -16,6 +16,9 @@ import (
 var loggerInstance *zap.Logger
 var log *zap.SugaredLogger
 
+// logLevel 日志级别，可由面板的运行配置调整
+var logLevel = zap.NewAtomicLevelAt(zap.InfoLevel)
+
 // ANSI 颜色代码
 const (
 	colorReset  = "\033[0m"
@@ -98,10 +101,19 @@ func initLogger(logFile string, fileOnly bool) {
 	}
 
 	core := &customCore{
-		level:  zap.NewAtomicLevelAt(zap.InfoLevel),
+		level:  logLevel,
 		writer: output,
 	}
 
 	loggerInstance = zap.New(core)
 	log = loggerInstance.Sugar()
 }
+
+// setLogLevel 设置日志级别，为空或无法识别时使用 info
+func setLogLevel(level string) {
+	l, err := zapcore.ParseLevel(level)
+	if err != nil || level == "" {
+		l = zapcore.InfoLevel
+	}
+	logLevel.SetLevel(l)
+}
@@ -9,9 +9,11 @@ import (
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/engigu/baihu-panel/internal/executor"
 	internalLogger "github.com/engigu/baihu-panel/internal/logger"
 	"github.com/engigu/baihu-panel/internal/systime"
 	"github.com/engigu/baihu-panel/internal/utils"
@@ -80,6 +82,16 @@ func main() {
 		cmdTasks()
 	case "logs":
 		cmdLogs()
+	case "exec":
+		cmdExec()
+	case "ps":
+		cmdPs()
+	case "kill":
+		cmdKill()
+	case "doctor":
+		cmdDoctor()
+	case "selftest":
+		cmdSelfTest()
 	case "install":
 		cmdInstall()
 	case "uninstall":
@@ -116,6 +128,10 @@ func printUsage() {
   status      查看运行状态
   tasks       查看已下发的任务列表
   logs        查看日志（实时跟踪）
+  exec <ID>   通过面板在本机执行任务（记录日志和通知）
+  ps          查看本机正在执行的任务
+  kill <ID>   停止本机正在执行的任务（日志 ID 或 run_id）
+  doctor      检查配置、面板连通性、令牌、时钟偏差和解释器
   install     安装为系统服务（开机自启）
   uninstall   卸载系统服务
   version     显示版本信息
@@ -134,7 +150,9 @@ func printUsage() {
   %s install
   %s status
   %s tasks
-`, Version, binName, binName, binName, binName, binName, binName, binName, binName, binName)
+  %s exec 12
+  %s doctor
+`, Version, binName, binName, binName, binName, binName, binName, binName, binName, binName, binName, binName)
 }
 
 // daemon 模式标记
@@ -168,7 +186,7 @@ func cmdStart() {
 	initLogger(logFile, true)
 	internalLogger.SetOutput(loggerInstance)
 
-	config := &Config{Interval: 30}
+	config := &Config{Interval: 30, ScriptSync: true}
 	if err := loadConfigFile(configFile, config); err != nil {
 		if !os.IsNotExist(err) {
 			log.Warnf("加载配置文件失败: %v", err)
@@ -236,7 +254,7 @@ func cmdRun() {
 	initLogger(logFile, false)
 	internalLogger.SetOutput(loggerInstance)
 
-	config := &Config{Interval: 30}
+	config := &Config{Interval: 30, ScriptSync: true}
 	if err := loadConfigFile(configFile, config); err != nil {
 		if !os.IsNotExist(err) {
 			log.Warnf("加载配置文件失败: %v", err)
@@ -329,7 +347,7 @@ func startDaemon() {
 }
 
 func cmdTasks() {
-	config := &Config{Interval: 30}
+	config := &Config{Interval: 30, ScriptSync: true}
 	if err := loadConfigFile(configFile, config); err != nil {
 		fmt.Printf("加载配置文件失败: %v\n", err)
 		return
@@ -399,11 +417,32 @@ func cmdTasks() {
 		if task.WorkDir != "" {
 			fmt.Printf("    工作目录: %s\n", task.WorkDir)
 		}
+		if names := envNames(task); len(names) > 0 {
+			fmt.Printf("    环境变量: %s\n", strings.Join(names, ", "))
+		}
 		fmt.Printf("    启用: %v\n", task.Enabled)
 		fmt.Println()
 	}
 }
 
+// envNames 任务环境变量的名称，只显示名称不显示值，隐藏变量单独标注
+func envNames(task AgentTask) []string {
+	var names, hidden []string
+	isHidden := make(map[string]bool)
+	for _, name := range strings.Split(task.HiddenEnvs, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			isHidden[name] = true
+			hidden = append(hidden, name+"(隐藏)")
+		}
+	}
+	for _, env := range executor.ParseEnvVars(task.Envs) {
+		if k, _, ok := strings.Cut(env, "="); ok && !isHidden[k] {
+			names = append(names, k)
+		}
+	}
+	return append(names, hidden...)
+}
+
 func cmdLogs() {
 	// 检查日志文件是否存在
 	if _, err := os.Stat(logFile); os.IsNotExist(err) {
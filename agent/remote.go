@@ -0,0 +1,439 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/creack/pty"
+	"github.com/engigu/baihu-panel/internal/logger"
+	"github.com/engigu/baihu-panel/internal/utils"
+)
+
+// 面板通过 WebSocket 打开的远程终端和文件操作，默认关闭，需在配置文件中设置 remote_access = true
+
+const (
+	remoteFileMaxSize  = 8 << 20 // 单次读写的文件大小上限，与面板一致
+	remoteTreeMaxNodes = 5000    // 文件树节点上限，避免根目录过大时消息过长
+)
+
+// shellMessage 终端消息，打开、输入、输出和关闭共用
+type shellMessage struct {
+	Session string `json:"session"`
+	Cols    uint16 `json:"cols,omitempty"`
+	Rows    uint16 `json:"rows,omitempty"`
+	Mode    string `json:"mode,omitempty"`
+	Data    []byte `json:"data,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+type remoteFile struct {
+	Path    string `json:"path"`
+	Content []byte `json:"content"`
+}
+
+type fileRequest struct {
+	ID      string       `json:"id"`
+	Op      string       `json:"op"`
+	Path    string       `json:"path"`
+	IsDir   bool         `json:"is_dir,omitempty"`
+	Content []byte       `json:"content,omitempty"`
+	Files   []remoteFile `json:"files,omitempty"`
+}
+
+type fileResponse struct {
+	ID      string    `json:"id"`
+	Error   string    `json:"error,omitempty"`
+	Tree    *fileNode `json:"tree,omitempty"`
+	Content []byte    `json:"content,omitempty"`
+}
+
+// fileNode 与面板文件管理的节点结构相同
+type fileNode struct {
+	Name     string      `json:"name"`
+	Path     string      `json:"path"`
+	IsDir    bool        `json:"isDir"`
+	Children []*fileNode `json:"children,omitempty"`
+}
+
+// remoteShell Agent 上的一个终端会话
+type remoteShell struct {
+	id    string
+	cmd   *exec.Cmd
+	input io.WriteCloser // PTY 模式为 pty 主端，pipe 模式为 stdin
+	once  sync.Once
+}
+
+func (s *remoteShell) close() {
+	s.once.Do(func() {
+		s.input.Close()
+		if s.cmd.Process != nil {
+			s.cmd.Process.Kill()
+		}
+	})
+}
+
+// handleShellOpen 打开终端，Unix 使用 PTY，Windows 使用 pipe
+func (a *Agent) handleShellOpen(data json.RawMessage) {
+	var req shellMessage
+	if err := json.Unmarshal(data, &req); err != nil || req.Session == "" {
+		return
+	}
+	if !a.config.RemoteAccess {
+		a.sendWSMessage(WSTypeShellOpened, shellMessage{Session: req.Session, Error: "Agent 未开启远程访问，需在配置文件中设置 remote_access = true"})
+		return
+	}
+
+	shell, mode, outputs, err := startRemoteShell(req)
+	if err != nil {
+		a.sendWSMessage(WSTypeShellOpened, shellMessage{Session: req.Session, Error: "启动 shell 失败: " + err.Error()})
+		return
+	}
+
+	a.shellMu.Lock()
+	a.shells[shell.id] = shell
+	a.shellMu.Unlock()
+	logger.Infof("面板打开远程终端 %s (%s)", shell.id, mode)
+	a.sendWSMessage(WSTypeShellOpened, shellMessage{Session: shell.id, Mode: mode})
+
+	var wg sync.WaitGroup
+	for _, r := range outputs {
+		wg.Add(1)
+		go func(r io.Reader) {
+			defer wg.Done()
+			buf := make([]byte, 4096)
+			for {
+				n, err := r.Read(buf)
+				if n > 0 {
+					if a.sendWSMessage(WSTypeShellOutput, shellMessage{Session: shell.id, Data: buf[:n]}) != nil {
+						shell.close()
+						return
+					}
+				}
+				if err != nil {
+					return
+				}
+			}
+		}(r)
+	}
+
+	go func() {
+		wg.Wait()
+		err := shell.cmd.Wait()
+		shell.close()
+
+		a.shellMu.Lock()
+		_, active := a.shells[shell.id]
+		delete(a.shells, shell.id)
+		a.shellMu.Unlock()
+
+		// 面板主动关闭的会话无需回复
+		if active {
+			msg := shellMessage{Session: shell.id}
+			if err != nil {
+				msg.Error = "shell 已退出: " + err.Error()
+			}
+			a.sendWSMessage(WSTypeShellClose, msg)
+		}
+		logger.Infof("远程终端 %s 已关闭", shell.id)
+	}()
+}
+
+func startRemoteShell(req shellMessage) (*remoteShell, string, []io.Reader, error) {
+	cmd := utils.NewShellCmd()
+	cmd.Env = append(os.Environ(), "TERM=xterm-256color")
+	shell := &remoteShell{id: req.Session, cmd: cmd}
+
+	if runtime.GOOS != "windows" {
+		ptmx, err := pty.Start(cmd)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		cols, rows := req.Cols, req.Rows
+		if cols == 0 || rows == 0 {
+			cols, rows = 80, 24
+		}
+		pty.Setsize(ptmx, &pty.Winsize{Rows: rows, Cols: cols})
+		shell.input = ptmx
+		return shell, "pty", []io.Reader{ptmx}, nil
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, "", nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, "", nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, "", nil, err
+	}
+	shell.input = stdin
+	return shell, "pipe", []io.Reader{stdout, stderr}, nil
+}
+
+func (a *Agent) handleShellInput(data json.RawMessage) {
+	var msg shellMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return
+	}
+	a.shellMu.Lock()
+	shell := a.shells[msg.Session]
+	a.shellMu.Unlock()
+	if shell == nil {
+		return
+	}
+	if _, err := shell.input.Write(msg.Data); err != nil {
+		shell.close()
+	}
+}
+
+func (a *Agent) handleShellClose(data json.RawMessage) {
+	var msg shellMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return
+	}
+	a.shellMu.Lock()
+	shell := a.shells[msg.Session]
+	delete(a.shells, msg.Session)
+	a.shellMu.Unlock()
+	if shell != nil {
+		shell.close()
+	}
+}
+
+// closeShells 与面板断开时结束所有终端
+func (a *Agent) closeShells() {
+	a.shellMu.Lock()
+	shells := a.shells
+	a.shells = make(map[string]*remoteShell)
+	a.shellMu.Unlock()
+	for _, shell := range shells {
+		shell.close()
+	}
+}
+
+// handleFileRequest 处理面板的文件操作，路径限定在 remote_root（默认为 Agent 工作目录）内
+func (a *Agent) handleFileRequest(data json.RawMessage) {
+	var req fileRequest
+	if err := json.Unmarshal(data, &req); err != nil || req.ID == "" {
+		return
+	}
+	go func() {
+		resp := &fileResponse{ID: req.ID}
+		if !a.config.RemoteAccess {
+			resp.Error = "Agent 未开启远程访问，需在配置文件中设置 remote_access = true"
+		} else if err := a.doFileRequest(&req, resp); err != nil {
+			resp.Error = err.Error()
+		}
+		if req.Op != "tree" {
+			logger.Infof("面板文件操作 %s %s: %s", req.Op, req.Path, resp.Error)
+		}
+		a.sendWSMessage(WSTypeFileResponse, resp)
+	}()
+}
+
+func (a *Agent) doFileRequest(req *fileRequest, resp *fileResponse) error {
+	root, err := a.remoteRoot()
+	if err != nil {
+		return err
+	}
+
+	switch req.Op {
+	case "tree":
+		tree, err := buildFileTree(root)
+		if err != nil {
+			return err
+		}
+		resp.Tree = tree
+		return nil
+
+	case "read":
+		fullPath, err := checkRemotePath(root, req.Path, false)
+		if err != nil {
+			return err
+		}
+		info, err := os.Stat(fullPath)
+		if err != nil || info.IsDir() {
+			return fmt.Errorf("文件不存在")
+		}
+		if info.Size() > remoteFileMaxSize {
+			return fmt.Errorf("文件过大（超过 8MB）")
+		}
+		resp.Content, err = os.ReadFile(fullPath)
+		return err
+
+	case "write":
+		fullPath, err := checkRemotePath(root, req.Path, false)
+		if err != nil {
+			return err
+		}
+		os.MkdirAll(filepath.Dir(fullPath), 0755)
+		return os.WriteFile(fullPath, req.Content, 0644)
+
+	case "create":
+		fullPath, err := checkRemotePath(root, req.Path, false)
+		if err != nil {
+			return err
+		}
+		if req.IsDir {
+			return os.MkdirAll(fullPath, 0755)
+		}
+		os.MkdirAll(filepath.Dir(fullPath), 0755)
+		return os.WriteFile(fullPath, []byte(""), 0644)
+
+	case "delete":
+		fullPath, err := checkRemotePath(root, req.Path, false)
+		if err != nil {
+			return err
+		}
+		// 不允许删除包含证书目录的上级目录
+		if pki, err := filepath.Abs(pkiDir()); err == nil {
+			if rel, err := filepath.Rel(fullPath, pki); err == nil && !strings.HasPrefix(rel, "..") {
+				return fmt.Errorf("访问被拒绝")
+			}
+		}
+		return os.RemoveAll(fullPath)
+
+	case "upload":
+		if _, err := checkRemotePath(root, req.Path, true); err != nil {
+			return err
+		}
+		for _, f := range req.Files {
+			fullPath, err := checkRemotePath(root, filepath.Join(req.Path, f.Path), false)
+			if err != nil {
+				continue
+			}
+			os.MkdirAll(filepath.Dir(fullPath), 0755)
+			if err := os.WriteFile(fullPath, f.Content, 0644); err != nil {
+				return fmt.Errorf("保存文件失败: %v", err)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("不支持的操作: %s", req.Op)
+}
+
+// remoteRoot 文件操作的根目录
+func (a *Agent) remoteRoot() (string, error) {
+	root := a.config.RemoteRoot
+	if root == "" {
+		root = "."
+	}
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	if info, err := os.Stat(abs); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("文件根目录不存在: %s", abs)
+	}
+	return abs, nil
+}
+
+// checkRemotePath 校验路径在根目录内，且不是 Agent 的证书目录或配置文件
+func checkRemotePath(root, path string, allowRoot bool) (string, error) {
+	fullPath := filepath.Join(root, filepath.Clean(path))
+	rel, err := filepath.Rel(root, fullPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("访问被拒绝")
+	}
+	if !allowRoot && rel == "." {
+		return "", fmt.Errorf("访问被拒绝")
+	}
+	if isProtectedPath(fullPath) {
+		return "", fmt.Errorf("访问被拒绝")
+	}
+	return fullPath, nil
+}
+
+// isProtectedPath 证书、私钥和配置文件（含令牌）不允许通过面板读写，经符号链接访问的也一样
+func isProtectedPath(fullPath string) bool {
+	paths := []string{fullPath}
+	if real, err := filepath.EvalSymlinks(fullPath); err == nil && real != fullPath {
+		paths = append(paths, real)
+	}
+	for _, p := range paths {
+		if p, err := filepath.Abs(p); err == nil && (isWithin(pkiDir(), p) || isConfigFile(p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isWithin path 是否为 dir 或其中的文件
+func isWithin(dir, path string) bool {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	if real, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = real
+	}
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && (rel == "." || !strings.HasPrefix(rel, ".."))
+}
+
+// isConfigFile path 是否为 Agent 的配置文件（文件不存在时按路径比较）
+func isConfigFile(path string) bool {
+	abs, err := filepath.Abs(configFile)
+	if err != nil {
+		return false
+	}
+	if abs == path {
+		return true
+	}
+	a, err := os.Stat(abs)
+	if err != nil {
+		return false
+	}
+	b, err := os.Stat(path)
+	return err == nil && os.SameFile(a, b)
+}
+
+// buildFileTree 生成文件树，过滤 __pycache__ 和证书目录
+func buildFileTree(root string) (*fileNode, error) {
+	tree := &fileNode{Name: filepath.Base(root), IsDir: true, Children: []*fileNode{}}
+	dirs := map[string]*fileNode{".": tree}
+	count := 0
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == root {
+			return nil
+		}
+		if d.IsDir() && (d.Name() == "__pycache__" || isProtectedPath(path)) {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && isProtectedPath(path) {
+			return nil
+		}
+		if count++; count > remoteTreeMaxNodes {
+			return fs.SkipAll
+		}
+
+		rel, _ := filepath.Rel(root, path)
+		parent := dirs[filepath.Dir(rel)]
+		if parent == nil {
+			return nil
+		}
+		node := &fileNode{Name: d.Name(), Path: filepath.ToSlash(rel), IsDir: d.IsDir()}
+		if d.IsDir() {
+			node.Children = []*fileNode{}
+			dirs[rel] = node
+		}
+		parent.Children = append(parent.Children, node)
+		return nil
+	})
+	return tree, err
+}
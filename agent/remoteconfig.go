@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/engigu/baihu-panel/internal/executor"
+	"github.com/engigu/baihu-panel/internal/logger"
+)
+
+// 运行配置：面板按 Agent 管理并发、队列、日志级别、允许的工作目录、代理和资源限制，保存后推送，
+// Agent 应用后回报版本；配置文件中同名的项优先于面板，并回报给面板显示为差异。
+// 最近一次收到的配置保存在 data/remote_config.json，断线或重启后仍然生效
+
+// remoteConfigKeys 可在配置文件中覆盖面板配置的项
+var remoteConfigKeys = []string{
+	"worker_count", "queue_size", "log_level", "allowed_work_dirs",
+	"proxy", "no_proxy", "memory_limit", "cpu_limit", "open_files_limit",
+}
+
+// remoteConfig 面板下发的运行配置，数值为 0、字符串为空表示不限制或使用 Agent 默认值
+type remoteConfig struct {
+	AgentID         uint     `json:"agent_id"` // 面板上的 Agent ID，重新注册后版本号从头计数
+	Version         uint     `json:"version"`
+	WorkerCount     int      `json:"worker_count"`
+	QueueSize       int      `json:"queue_size"`
+	RateInterval    int      `json:"rate_interval"` // 毫秒
+	LogLevel        string   `json:"log_level"`
+	AllowedWorkDirs []string `json:"allowed_work_dirs"`
+	Proxy           string   `json:"proxy"`
+	NoProxy         string   `json:"no_proxy"`
+	MemoryLimit     int      `json:"memory_limit"`     // MB
+	CPULimit        int      `json:"cpu_limit"`        // 秒
+	OpenFilesLimit  int      `json:"open_files_limit"` // 个
+	Group           string   `json:"group"`
+	Labels          string   `json:"labels"`
+}
+
+func getRemoteConfigFile() string {
+	return filepath.Join(dataDir, "remote_config.json")
+}
+
+// loadRemoteConfig 读取上次收到的配置，不存在时返回空配置
+func loadRemoteConfig() *remoteConfig {
+	cfg := &remoteConfig{}
+	if data, err := os.ReadFile(getRemoteConfigFile()); err == nil {
+		json.Unmarshal(data, cfg)
+	}
+	return cfg
+}
+
+func saveRemoteConfig(cfg *remoteConfig) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return
+	}
+	os.MkdirAll(dataDir, 0755)
+	tmp := getRemoteConfigFile() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		logger.Warnf("保存运行配置失败: %v", err)
+		return
+	}
+	os.Rename(tmp, getRemoteConfigFile())
+}
+
+// mergeLocal 用配置文件中的值覆盖面板配置，返回生效的配置和覆盖项（值的格式与面板一致），
+// 格式错误的本地值不生效并返回错误
+func mergeLocal(remote *remoteConfig, local map[string]string) (*remoteConfig, map[string]string, error) {
+	eff := *remote
+	eff.AllowedWorkDirs = append([]string(nil), remote.AllowedWorkDirs...)
+	overrides := make(map[string]string)
+	var errs []string
+
+	for _, key := range remoteConfigKeys {
+		raw, ok := local[key]
+		if !ok {
+			continue
+		}
+		value, err := applyLocalValue(&eff, key, strings.TrimSpace(raw))
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("配置文件 %s: %v", key, err))
+			continue
+		}
+		overrides[key] = value
+	}
+
+	if len(errs) > 0 {
+		return &eff, overrides, errors.New(strings.Join(errs, "; "))
+	}
+	return &eff, overrides, nil
+}
+
+// applyLocalValue 解析单个本地值并写入配置，返回规范化后的值
+func applyLocalValue(cfg *remoteConfig, key, raw string) (string, error) {
+	switch key {
+	case "worker_count", "queue_size", "memory_limit", "cpu_limit", "open_files_limit":
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return "", errors.New("需为非负整数")
+		}
+		switch key {
+		case "worker_count":
+			cfg.WorkerCount = n
+		case "queue_size":
+			cfg.QueueSize = n
+		case "memory_limit":
+			cfg.MemoryLimit = n
+		case "cpu_limit":
+			cfg.CPULimit = n
+		case "open_files_limit":
+			cfg.OpenFilesLimit = n
+		}
+		return strconv.Itoa(n), nil
+	case "log_level":
+		level := strings.ToLower(raw)
+		switch level {
+		case "debug", "info", "warn", "error":
+		default:
+			return "", errors.New("只能是 debug、info、warn 或 error")
+		}
+		cfg.LogLevel = level
+		return level, nil
+	case "allowed_work_dirs":
+		var dirs []string
+		for _, dir := range strings.Split(raw, ",") {
+			if dir = strings.TrimSpace(dir); dir == "" {
+				continue
+			}
+			if !filepath.IsAbs(dir) {
+				return "", fmt.Errorf("%s 不是绝对路径", dir)
+			}
+			dirs = append(dirs, dir)
+		}
+		cfg.AllowedWorkDirs = dirs
+		return strings.Join(dirs, ","), nil
+	case "proxy":
+		if raw != "" {
+			if u, err := url.Parse(raw); err != nil || u.Host == "" {
+				return "", errors.New("格式错误")
+			}
+		}
+		cfg.Proxy = raw
+		return raw, nil
+	case "no_proxy":
+		cfg.NoProxy = raw
+		return raw, nil
+	}
+	return "", errors.New("不支持的配置项")
+}
+
+// applyRemoteConfig 合并本地覆盖后应用配置，返回覆盖项
+func (a *Agent) applyRemoteConfig(remote *remoteConfig) (map[string]string, error) {
+	eff, overrides, err := mergeLocal(remote, a.config.Local)
+
+	current := a.scheduler.GetConfig()
+	next := current
+	if eff.WorkerCount > 0 {
+		next.WorkerCount = eff.WorkerCount
+	}
+	if eff.QueueSize > 0 {
+		next.QueueSize = eff.QueueSize
+	}
+	if eff.RateInterval > 0 {
+		next.RateInterval = time.Duration(eff.RateInterval) * time.Millisecond
+	}
+	if next != current {
+		logger.Infof("调度配置更新: workers=%d, queue=%d, rate=%v", next.WorkerCount, next.QueueSize, next.RateInterval)
+		a.scheduler.Reload(next)
+	}
+
+	setLogLevel(eff.LogLevel)
+
+	if runtime.GOOS == "windows" && (eff.MemoryLimit > 0 || eff.CPULimit > 0 || eff.OpenFilesLimit > 0) {
+		err = errors.Join(err, errors.New("Windows 不支持资源限制，已忽略"))
+	}
+
+	a.remoteMu.Lock()
+	a.remote = eff
+	a.remoteMu.Unlock()
+	return overrides, err
+}
+
+// currentRemoteConfig 当前生效的运行配置
+func (a *Agent) currentRemoteConfig() *remoteConfig {
+	a.remoteMu.RLock()
+	defer a.remoteMu.RUnlock()
+	return a.remote
+}
+
+// handleRemoteConfig 应用面板推送的配置并回报结果
+func (a *Agent) handleRemoteConfig(data json.RawMessage) {
+	var cfg remoteConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		logger.Warnf("解析运行配置失败: %v", err)
+		return
+	}
+	// 推送可能乱序到达（如保存时的推送与重连时的推送），旧版本不再覆盖已应用的配置
+	if current := a.currentRemoteConfig(); current != nil && current.AgentID == cfg.AgentID && cfg.Version < current.Version {
+		logger.Infof("忽略过期的运行配置 v%d（已应用 v%d）", cfg.Version, current.Version)
+		return
+	}
+
+	overrides, err := a.applyRemoteConfig(&cfg)
+	saveRemoteConfig(&cfg)
+
+	ack := struct {
+		Version   uint              `json:"version"`
+		Error     string            `json:"error,omitempty"`
+		Overrides map[string]string `json:"overrides"`
+	}{Version: cfg.Version, Overrides: overrides}
+	if err != nil {
+		ack.Error = err.Error()
+		logger.Warnf("运行配置 v%d 部分未生效: %v", cfg.Version, err)
+	} else {
+		logger.Infof("已应用运行配置 v%d", cfg.Version)
+	}
+	if len(overrides) > 0 {
+		keys := make([]string, 0, len(overrides))
+		for _, key := range remoteConfigKeys {
+			if _, ok := overrides[key]; ok {
+				keys = append(keys, key)
+			}
+		}
+		logger.Infof("配置文件覆盖了面板配置: %s", strings.Join(keys, ", "))
+	}
+	if err := a.sendWSMessage(WSTypeConfigAck, ack); err != nil {
+		logger.Warnf("回报运行配置失败: %v", err)
+	}
+}
+
+// prepareRun 按运行配置检查工作目录、注入代理并添加资源限制
+func (a *Agent) prepareRun(req *executor.ExecutionRequest) error {
+	cfg := a.currentRemoteConfig()
+	if cfg == nil {
+		return nil
+	}
+	if err := checkWorkDir(req.WorkDir, cfg.AllowedWorkDirs); err != nil {
+		return err
+	}
+
+	// 放在任务自身的环境变量之前，任务中设置的同名变量优先
+	var envs []string
+	if cfg.Proxy != "" {
+		envs = append(envs, "HTTP_PROXY="+cfg.Proxy, "HTTPS_PROXY="+cfg.Proxy, "http_proxy="+cfg.Proxy, "https_proxy="+cfg.Proxy)
+	}
+	if cfg.NoProxy != "" {
+		envs = append(envs, "NO_PROXY="+cfg.NoProxy, "no_proxy="+cfg.NoProxy)
+	}
+	if len(envs) > 0 {
+		req.Envs = append(envs, req.Envs...)
+	}
+
+	if prefix := limitPrefix(cfg); prefix != "" {
+		req.Metadata["command"] = req.Command
+		req.Command = prefix + req.Command
+	}
+	return nil
+}
+
+// commandOf 任务原始命令（不含资源限制前缀）
+func commandOf(req *executor.ExecutionRequest) string {
+	if cmd, ok := req.Metadata["command"].(string); ok {
+		return cmd
+	}
+	return req.Command
+}
+
+// checkWorkDir 工作目录需在允许的目录之内，未配置时不限制
+func checkWorkDir(dir string, allowed []string) error {
+	if len(allowed) == 0 {
+		return nil
+	}
+	if dir = strings.TrimSpace(dir); dir == "" {
+		dir, _ = os.Getwd()
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if real, err := filepath.EvalSymlinks(abs); err == nil {
+		abs = real
+	}
+	for _, base := range allowed {
+		base = filepath.Clean(base)
+		if real, err := filepath.EvalSymlinks(base); err == nil {
+			base = real
+		}
+		rel, err := filepath.Rel(base, abs)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("工作目录 %s 不在允许的目录内", dir)
+}
+
+// limitPrefix 用 ulimit 限制本次执行的资源，设置失败时不执行任务；Windows 不支持
+func limitPrefix(cfg *remoteConfig) string {
+	if runtime.GOOS == "windows" {
+		return ""
+	}
+	var b strings.Builder
+	if cfg.MemoryLimit > 0 {
+		fmt.Fprintf(&b, "ulimit -v %d || exit 1\n", cfg.MemoryLimit*1024)
+	}
+	if cfg.CPULimit > 0 {
+		fmt.Fprintf(&b, "ulimit -t %d || exit 1\n", cfg.CPULimit)
+	}
+	if cfg.OpenFilesLimit > 0 {
+		fmt.Fprintf(&b, "ulimit -n %d || exit 1\n", cfg.OpenFilesLimit)
+	}
+	return b.String()
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/ecdh"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/engigu/baihu-panel/internal/executor"
+	"github.com/engigu/baihu-panel/internal/utils"
+)
+
+// 隐藏环境变量不随任务列表下发，执行任务时向面板请求，面板使用本机公钥加密后返回，
+// 解密后只存在于本次执行的环境变量中，不写入 tasks.json
+
+// secretsTimeout 等待面板返回隐藏变量的最长时间
+const secretsTimeout = 15 * time.Second
+
+type secretsReply struct {
+	Sealed *utils.SealedSecrets
+	Error  string
+}
+
+func secretsKeyPath() string {
+	return filepath.Join(pkiDir(), "secrets.key")
+}
+
+// loadSecretsKey 读取接收隐藏变量的私钥，不存在时生成
+func (a *Agent) loadSecretsKey() (*ecdh.PrivateKey, error) {
+	a.secretsMu.Lock()
+	defer a.secretsMu.Unlock()
+	if a.secretsKey != nil {
+		return a.secretsKey, nil
+	}
+
+	path := secretsKeyPath()
+	if data, err := os.ReadFile(path); err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("私钥格式错误: %s", path)
+		}
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := parsed.(*ecdh.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("私钥类型错误: %s", path)
+		}
+		a.secretsKey = key
+		return key, nil
+	}
+
+	key, err := utils.GenerateSecretsKey()
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return nil, err
+	}
+	a.secretsKey = key
+	return key, nil
+}
+
+// fetchSecrets 请求任务的隐藏环境变量并追加到本次执行的环境变量，任务没有隐藏变量时直接返回
+// 未连接面板或请求失败时返回错误，任务不会在缺少变量的情况下执行
+func (a *Agent) fetchSecrets(req *executor.ExecutionRequest) error {
+	var taskID uint
+	fmt.Sscanf(req.TaskID, "%d", &taskID)
+	a.mu.RLock()
+	task, ok := a.tasks[taskID]
+	a.mu.RUnlock()
+	if !ok || task.HiddenEnvs == "" {
+		return nil
+	}
+	// 旧版面板仍随任务列表下发隐藏变量的值，此时无需再请求
+	present := make(map[string]bool, len(req.Envs))
+	for _, env := range req.Envs {
+		if k, _, ok := strings.Cut(env, "="); ok {
+			present[k] = true
+		}
+	}
+	missing := false
+	for _, name := range strings.Split(task.HiddenEnvs, ",") {
+		if name = strings.TrimSpace(name); name != "" && !present[name] {
+			missing = true
+		}
+	}
+	if !missing {
+		return nil
+	}
+
+	key, err := a.loadSecretsKey()
+	if err != nil {
+		return fmt.Errorf("读取隐藏变量私钥失败: %v", err)
+	}
+	a.wsMu.Lock()
+	wsStopCh := a.wsStopCh
+	a.wsMu.Unlock()
+	if wsStopCh == nil {
+		return errors.New("未连接面板，无法获取隐藏环境变量")
+	}
+
+	id := utils.RandomString(16)
+	ch := make(chan secretsReply, 1)
+	a.secretsMu.Lock()
+	a.secretsWaits[id] = ch
+	a.secretsMu.Unlock()
+	defer func() {
+		a.secretsMu.Lock()
+		delete(a.secretsWaits, id)
+		a.secretsMu.Unlock()
+	}()
+
+	if err := a.sendWSMessage(WSTypeSecretsRequest, map[string]interface{}{
+		"id":      id,
+		"task_id": taskID,
+		"key":     utils.EncodeSecretsPublicKey(key.PublicKey()),
+	}); err != nil {
+		return fmt.Errorf("请求隐藏环境变量失败: %v", err)
+	}
+
+	var reply secretsReply
+	select {
+	case reply = <-ch:
+	case <-wsStopCh:
+		return errors.New("与面板的连接已断开，无法获取隐藏环境变量")
+	case <-time.After(secretsTimeout):
+		return errors.New("等待面板返回隐藏环境变量超时")
+	}
+	if reply.Error != "" {
+		return fmt.Errorf("获取隐藏环境变量失败: %s", reply.Error)
+	}
+	if reply.Sealed == nil {
+		return errors.New("获取隐藏环境变量失败: 面板未返回内容")
+	}
+
+	plaintext, err := utils.OpenSecrets(key, reply.Sealed, utils.SecretsAAD(taskID, id))
+	if err != nil {
+		return fmt.Errorf("解密隐藏环境变量失败: %v", err)
+	}
+	var secrets []string
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return fmt.Errorf("解密隐藏环境变量失败: %v", err)
+	}
+	req.Envs = append(req.Envs, secrets...)
+	return nil
+}
+
+// handleSecrets 将面板的返回交给等待的任务
+func (a *Agent) handleSecrets(data json.RawMessage) {
+	var msg struct {
+		ID     string               `json:"id"`
+		Sealed *utils.SealedSecrets `json:"sealed"`
+		Error  string               `json:"error"`
+	}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return
+	}
+	a.secretsMu.Lock()
+	ch, ok := a.secretsWaits[msg.ID]
+	a.secretsMu.Unlock()
+	if ok {
+		select {
+		case ch <- secretsReply{Sealed: msg.Sealed, Error: msg.Error}:
+		default:
+		}
+	}
+}
@@ -119,6 +119,7 @@ func cmdStatus() {
 	}
 
 	fmt.Printf("状态: 运行中 (PID: %d)\n", pid)
+	printDaemonStatus()
 }
 
 func isProcessRunning(pid int) bool {
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/engigu/baihu-panel/internal/logger"
+)
+
+// 离线缓存默认上限
+const (
+	defaultSpoolMaxEntries = 10000
+	defaultSpoolMaxMB      = 64
+)
+
+// Spool 离线消息队列
+// WebSocket 断开期间的任务日志和执行结果按顺序写入本地磁盘（每条消息一个文件），重连后依次补发
+// 超出上限时优先丢弃最早的日志片段，其次才丢弃最早的执行结果
+type Spool struct {
+	dir        string
+	maxEntries int
+	maxBytes   int64
+	entries    []spoolEntry // 按序号升序
+	size       int64
+	nextSeq    uint64
+	mu         sync.Mutex
+}
+
+type spoolEntry struct {
+	seq     uint64
+	msgType string
+	size    int64
+}
+
+func (e spoolEntry) fileName() string {
+	return fmt.Sprintf("%020d.%s.json", e.seq, e.msgType)
+}
+
+// NewSpool 打开（或创建）离线缓存目录，并加载上次未补发的消息
+func NewSpool(dir string, maxEntries int, maxBytes int64) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Spool{dir: dir, maxEntries: maxEntries, maxBytes: maxBytes, nextSeq: 1}
+	for _, f := range files {
+		parts := strings.SplitN(f.Name(), ".", 3)
+		if f.IsDir() || len(parts) != 3 || parts[2] != "json" {
+			continue
+		}
+		seq, err := strconv.ParseUint(parts[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		s.entries = append(s.entries, spoolEntry{seq: seq, msgType: parts[1], size: info.Size()})
+		s.size += info.Size()
+		if seq >= s.nextSeq {
+			s.nextSeq = seq + 1
+		}
+	}
+	sort.Slice(s.entries, func(i, j int) bool { return s.entries[i].seq < s.entries[j].seq })
+	return s, nil
+}
+
+// Len 待补发的消息数
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+// Push 追加一条消息
+func (s *Spool) Push(msgType string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	size := int64(len(payload))
+	dropped := 0
+	for len(s.entries) > 0 && (len(s.entries) >= s.maxEntries || s.size+size > s.maxBytes) {
+		s.evictLocked()
+		dropped++
+	}
+	if dropped > 0 {
+		logger.Warnf("[Spool] 离线缓存已满，丢弃最早的 %d 条消息", dropped)
+	}
+
+	entry := spoolEntry{seq: s.nextSeq, msgType: msgType, size: size}
+	if err := os.WriteFile(filepath.Join(s.dir, entry.fileName()), payload, 0644); err != nil {
+		return err
+	}
+	s.nextSeq++
+	s.entries = append(s.entries, entry)
+	s.size += size
+	return nil
+}
+
+// evictLocked 丢弃一条消息：优先最早的日志片段，没有日志片段时丢弃最早的消息
+func (s *Spool) evictLocked() {
+	idx := 0
+	for i, e := range s.entries {
+		if e.msgType == WSTypeTaskLog {
+			idx = i
+			break
+		}
+	}
+	s.removeLocked(idx)
+}
+
+func (s *Spool) removeLocked(idx int) {
+	e := s.entries[idx]
+	os.Remove(filepath.Join(s.dir, e.fileName()))
+	s.size -= e.size
+	s.entries = append(s.entries[:idx], s.entries[idx+1:]...)
+}
+
+// Peek 读取最早的一条消息，文件损坏时直接丢弃并继续
+func (s *Spool) Peek() (seq uint64, msgType string, data json.RawMessage, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.entries) > 0 {
+		e := s.entries[0]
+		payload, err := os.ReadFile(filepath.Join(s.dir, e.fileName()))
+		if err != nil || !json.Valid(payload) {
+			logger.Warnf("[Spool] 离线消息 #%d 读取失败，已丢弃", e.seq)
+			s.removeLocked(0)
+			continue
+		}
+		return e.seq, e.msgType, payload, true
+	}
+	return 0, "", nil, false
+}
+
+// Remove 移除已补发的消息
+func (s *Spool) Remove(seq uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, e := range s.entries {
+		if e.seq == seq {
+			s.removeLocked(i)
+			return
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/engigu/baihu-panel/internal/constant"
+)
+
+// 运行状态：本地控制接口和 status_listen 提供 /status、/entries、/runs 和 Prometheus 格式的 /metrics，
+// 供 status 命令和主机自己的监控工具查询
+
+// agentStats 连接和执行统计
+type agentStats struct {
+	mu             sync.Mutex
+	startedAt      time.Time
+	agentID        uint
+	connectedAt    time.Time
+	disconnectedAt time.Time
+	lastHeartbeat  time.Time // 最近一次收到面板心跳响应
+	connects       uint64
+	lastError      string
+	runs           map[string]uint64 // 各状态的执行次数
+}
+
+func (s *agentStats) onConnected() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connectedAt = time.Now()
+	s.connects++
+	s.lastError = ""
+}
+
+func (s *agentStats) onDisconnected() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.disconnectedAt = time.Now()
+}
+
+func (s *agentStats) onConnectFailed(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastError = err.Error()
+}
+
+func (s *agentStats) onAgentID(id uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.agentID = id
+}
+
+func (s *agentStats) onHeartbeat() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastHeartbeat = time.Now()
+}
+
+func (s *agentStats) onRunFinished(status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.runs == nil {
+		s.runs = make(map[string]uint64)
+	}
+	s.runs[status]++
+}
+
+// unixOrZero 零值时间返回 0
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// agentStatus /status 返回的运行状态
+type agentStatus struct {
+	Version    string `json:"version"`
+	BuildTime  string `json:"build_time,omitempty"`
+	PID        int    `json:"pid"`
+	Name       string `json:"name"`
+	ServerURL  string `json:"server_url"`
+	StartedAt  int64  `json:"started_at"`
+	Uptime     int64  `json:"uptime"` // 秒
+	Connection struct {
+		Connected      bool   `json:"connected"`
+		AgentID        uint   `json:"agent_id,omitempty"`
+		Protocol       int    `json:"protocol,omitempty"`
+		ConnectedAt    int64  `json:"connected_at,omitempty"`
+		DisconnectedAt int64  `json:"disconnected_at,omitempty"`
+		LastHeartbeat  int64  `json:"last_heartbeat,omitempty"`
+		Connects       uint64 `json:"connects"`
+		LastError      string `json:"last_error,omitempty"`
+	} `json:"connection"`
+	Scheduler struct {
+		Workers       int      `json:"workers"`
+		QueueCapacity int      `json:"queue_capacity"`
+		QueueLength   int      `json:"queue_length"`
+		Scheduled     int      `json:"scheduled"`
+		Running       []string `json:"running"` // 正在执行的任务 ID
+	} `json:"scheduler"`
+	Config struct {
+		Version  uint   `json:"version"` // 面板下发的运行配置版本，0 表示未收到
+		LogLevel string `json:"log_level"`
+		Group    string `json:"group,omitempty"` // 面板设置的分组和标签
+		Labels   string `json:"labels,omitempty"`
+	} `json:"config"`
+	Runs         map[string]uint64 `json:"runs"` // 启动以来各状态的执行次数
+	SpoolEntries int               `json:"spool_entries"`
+}
+
+// cronEntry /entries 返回的计划任务
+type cronEntry struct {
+	TaskID    string `json:"task_id"`
+	Name      string `json:"name"`
+	Schedule  string `json:"schedule"`
+	NextRun   int64  `json:"next_run"`
+	PrevRun   int64  `json:"prev_run,omitempty"`
+	LastFired int64  `json:"last_fired,omitempty"`
+}
+
+func (a *Agent) collectStatus() *agentStatus {
+	st := &agentStatus{
+		Version:   Version,
+		BuildTime: BuildTime,
+		PID:       os.Getpid(),
+		Name:      a.config.Name,
+		ServerURL: a.config.ServerURL,
+	}
+
+	a.wsMu.Lock()
+	st.Connection.Connected = a.wsStopCh != nil
+	if st.Connection.Connected {
+		st.Connection.Protocol = a.wsProto
+	}
+	a.wsMu.Unlock()
+
+	a.stats.mu.Lock()
+	st.StartedAt = a.stats.startedAt.Unix()
+	st.Uptime = int64(time.Since(a.stats.startedAt).Seconds())
+	st.Connection.AgentID = a.stats.agentID
+	st.Connection.ConnectedAt = unixOrZero(a.stats.connectedAt)
+	st.Connection.DisconnectedAt = unixOrZero(a.stats.disconnectedAt)
+	st.Connection.LastHeartbeat = unixOrZero(a.stats.lastHeartbeat)
+	st.Connection.Connects = a.stats.connects
+	st.Connection.LastError = a.stats.lastError
+	st.Runs = make(map[string]uint64, len(a.stats.runs))
+	for k, v := range a.stats.runs {
+		st.Runs[k] = v
+	}
+	a.stats.mu.Unlock()
+
+	cfg := a.scheduler.GetConfig()
+	st.Scheduler.Workers = cfg.WorkerCount
+	st.Scheduler.QueueCapacity = cfg.QueueSize
+	st.Scheduler.QueueLength = a.scheduler.GetQueueSize()
+	st.Scheduler.Scheduled = a.cronManager.GetScheduledCount()
+	st.Scheduler.Running = a.scheduler.GetRunningTasks()
+	sort.Strings(st.Scheduler.Running)
+	if remote := a.currentRemoteConfig(); remote != nil {
+		st.Config.Version = remote.Version
+		st.Config.Group = remote.Group
+		st.Config.Labels = remote.Labels
+	}
+	st.Config.LogLevel = logLevel.String()
+	if a.spool != nil {
+		st.SpoolEntries = a.spool.Len()
+	}
+	return st
+}
+
+// collectEntries 按下次执行时间排序的计划任务
+func (a *Agent) collectEntries() []cronEntry {
+	ids := a.cronManager.ScheduledTaskIDs()
+	entries := make([]cronEntry, 0, len(ids))
+	for _, id := range ids {
+		entry, ok := a.cronManager.GetEntry(id)
+		if !ok {
+			continue
+		}
+		e := cronEntry{TaskID: id, NextRun: unixOrZero(entry.Next), PrevRun: unixOrZero(entry.Prev)}
+		if fired, ok := a.cronManager.LastFired(id); ok {
+			e.LastFired = fired.Unix()
+		}
+		if taskID, err := strconv.ParseUint(id, 10, 64); err == nil {
+			a.mu.RLock()
+			if task, ok := a.tasks[uint(taskID)]; ok {
+				e.Name, e.Schedule = task.Name, task.Schedule
+			}
+			a.mu.RUnlock()
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].NextRun < entries[j].NextRun })
+	return entries
+}
+
+func (a *Agent) handleControlStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeControlJSON(w, http.StatusOK, a.collectStatus())
+}
+
+func (a *Agent) handleControlEntries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeControlJSON(w, http.StatusOK, a.collectEntries())
+}
+
+// handleControlMetrics 输出 Prometheus 文本格式的指标
+func (a *Agent) handleControlMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	st := a.collectStatus()
+	var b strings.Builder
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+	boolValue := func(v bool) int {
+		if v {
+			return 1
+		}
+		return 0
+	}
+
+	metric("baihu_agent_info", "gauge", "Agent version and name.")
+	fmt.Fprintf(&b, "baihu_agent_info{version=\"%s\",name=\"%s\"} 1\n", promLabel(st.Version), promLabel(st.Name))
+	metric("baihu_agent_start_time_seconds", "gauge", "Unix time the agent started.")
+	fmt.Fprintf(&b, "baihu_agent_start_time_seconds %d\n", st.StartedAt)
+	metric("baihu_agent_connected", "gauge", "Whether the WebSocket connection to the panel is up.")
+	fmt.Fprintf(&b, "baihu_agent_connected %d\n", boolValue(st.Connection.Connected))
+	metric("baihu_agent_connects_total", "counter", "WebSocket connections established since start.")
+	fmt.Fprintf(&b, "baihu_agent_connects_total %d\n", st.Connection.Connects)
+	metric("baihu_agent_last_heartbeat_timestamp_seconds", "gauge", "Unix time of the last heartbeat acknowledged by the panel.")
+	fmt.Fprintf(&b, "baihu_agent_last_heartbeat_timestamp_seconds %d\n", st.Connection.LastHeartbeat)
+	metric("baihu_agent_workers", "gauge", "Scheduler worker count.")
+	fmt.Fprintf(&b, "baihu_agent_workers %d\n", st.Scheduler.Workers)
+	metric("baihu_agent_queue_capacity", "gauge", "Scheduler queue capacity.")
+	fmt.Fprintf(&b, "baihu_agent_queue_capacity %d\n", st.Scheduler.QueueCapacity)
+	metric("baihu_agent_queue_length", "gauge", "Executions waiting in the scheduler queue.")
+	fmt.Fprintf(&b, "baihu_agent_queue_length %d\n", st.Scheduler.QueueLength)
+	metric("baihu_agent_scheduled_tasks", "gauge", "Tasks with a cron entry on this agent.")
+	fmt.Fprintf(&b, "baihu_agent_scheduled_tasks %d\n", st.Scheduler.Scheduled)
+	metric("baihu_agent_running_tasks", "gauge", "Tasks currently executing.")
+	fmt.Fprintf(&b, "baihu_agent_running_tasks %d\n", len(st.Scheduler.Running))
+	metric("baihu_agent_spool_entries", "gauge", "Messages waiting in the offline spool.")
+	fmt.Fprintf(&b, "baihu_agent_spool_entries %d\n", st.SpoolEntries)
+	metric("baihu_agent_config_version", "gauge", "Version of the runtime configuration pushed by the panel.")
+	fmt.Fprintf(&b, "baihu_agent_config_version %d\n", st.Config.Version)
+
+	metric("baihu_agent_task_runs_total", "counter", "Finished executions by status since start.")
+	for _, status := range []string{constant.TaskStatusSuccess, constant.TaskStatusFailed, constant.TaskStatusTimeout, constant.TaskStatusCancelled} {
+		fmt.Fprintf(&b, "baihu_agent_task_runs_total{status=\"%s\"} %d\n", status, st.Runs[status])
+	}
+
+	metric("baihu_agent_task_next_run_timestamp_seconds", "gauge", "Unix time of the next scheduled run of each task.")
+	for _, e := range a.collectEntries() {
+		fmt.Fprintf(&b, "baihu_agent_task_next_run_timestamp_seconds{task_id=\"%s\",name=\"%s\"} %d\n", promLabel(e.TaskID), promLabel(e.Name), e.NextRun)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
+
+// promLabel 转义 Prometheus 标签值
+func promLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// printDaemonStatus 通过本地控制接口输出运行中 Agent 的状态（status 命令）
+func printDaemonStatus() {
+	config := &Config{}
+	loadConfigFile(configFile, config)
+	if controlDisabled(config) {
+		return
+	}
+	resp, err := controlClient(config).Get("http://agent/status")
+	if err != nil {
+		fmt.Printf("控制接口: 无法连接 %s (%v)\n", controlSocketPath(config), err)
+		return
+	}
+	defer resp.Body.Close()
+	var st agentStatus
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&st) != nil {
+		// 旧版本 Agent 的控制接口没有 /status
+		return
+	}
+
+	fmt.Printf("版本: %s\n", st.Version)
+	fmt.Printf("运行时长: %s\n", (time.Duration(st.Uptime) * time.Second).String())
+	if st.Connection.Connected {
+		fmt.Printf("面板连接: 已连接 (Agent #%d, 协议 v%d, 连接于 %s)\n", st.Connection.AgentID, st.Connection.Protocol,
+			time.Unix(st.Connection.ConnectedAt, 0).Format("2006-01-02 15:04:05"))
+	} else if st.Connection.LastError != "" {
+		fmt.Printf("面板连接: 未连接 (%s)\n", st.Connection.LastError)
+	} else {
+		fmt.Println("面板连接: 未连接")
+	}
+	if st.Connection.LastHeartbeat > 0 {
+		fmt.Printf("最近心跳: %s\n", time.Unix(st.Connection.LastHeartbeat, 0).Format("2006-01-02 15:04:05"))
+	}
+	fmt.Printf("调度: %d 个计划任务, %d 个正在执行, 队列 %d/%d, %d 个 worker\n", st.Scheduler.Scheduled,
+		len(st.Scheduler.Running), st.Scheduler.QueueLength, st.Scheduler.QueueCapacity, st.Scheduler.Workers)
+	if st.Config.Version > 0 {
+		fmt.Printf("运行配置: v%d (日志级别 %s)\n", st.Config.Version, st.Config.LogLevel)
+	}
+	if st.SpoolEntries > 0 {
+		fmt.Printf("离线缓存: %d 条待补发\n", st.SpoolEntries)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/engigu/baihu-panel/internal/logger"
+	"github.com/engigu/baihu-panel/internal/utils"
+	"github.com/gorilla/websocket"
+)
+
+// 协议 v2 的任务日志流：每次执行打开一个流，日志合并后以数据帧发送，
+// 额度用完后在本地缓存，等面板处理完归还额度再发送，避免日志挤占心跳
+const (
+	logBatchInterval = 200 * time.Millisecond // 小块日志合并发送的间隔
+	logMaxPending    = 1 << 20                // 本地积压超过该字节数时阻塞任务输出
+	logCreditTimeout = 30 * time.Second       // 等待额度的最长时间，超时后丢弃积压的日志
+)
+
+var errConnChanged = errors.New("WebSocket 连接已更换")
+
+// logStream Agent 侧的日志流状态，只属于打开它的那次连接
+type logStream struct {
+	id      uint32
+	gen     uint64 // 打开时的连接代数
+	sendSeq uint32 // 下一个数据帧的序号
+	recvSeq uint32 // 下一个额度帧的序号
+	credit  int    // 剩余发送额度
+}
+
+// streamLog 通过日志流发送缓冲区，额度不足的部分留在缓冲区
+// 当前连接不支持 v2、有未发送的消息或发送失败时返回 false，由调用方改用 task_log 消息
+func (a *Agent) streamLog(w *RealTimeLogWriter) bool {
+	// 发送队列或离线缓存中有未发送的消息时，日志也要排在后面；只在锁内判断，
+	// 发送帧时不持有 deliverMu，避免慢连接阻塞其他消息入队。同一次执行的写入由 w.mu 串行，
+	// 判断之后入队的消息都晚于这些日志
+	a.deliverMu.Lock()
+	pending := a.deliverPendingLocked()
+	a.deliverMu.Unlock()
+	if pending {
+		return false
+	}
+
+	a.wsMu.Lock()
+	gen, v2 := a.wsGen, a.wsConn != nil && a.wsProto >= utils.WSProtocolV2
+	a.wsMu.Unlock()
+	if !v2 {
+		return false
+	}
+
+	if w.stream == nil || w.stream.gen != gen {
+		if !a.openStream(w, gen) {
+			return false
+		}
+	}
+
+	for len(w.buf) > 0 {
+		a.streamMu.Lock()
+		n := min(len(w.buf), utils.StreamMaxFrameData, w.stream.credit)
+		if n == 0 {
+			a.streamMu.Unlock()
+			return true
+		}
+		w.stream.credit -= n
+		frame := &utils.Frame{Type: utils.FrameData, Stream: w.stream.id, Seq: w.stream.sendSeq, Payload: w.buf[:n]}
+		w.stream.sendSeq++
+		a.streamMu.Unlock()
+
+		if err := a.sendFrame(frame, gen); err != nil {
+			logger.Warnf("发送日志帧失败: %v", err)
+			return false
+		}
+		w.buf = w.buf[n:]
+	}
+	w.buf = nil
+	return true
+}
+
+// openStream 在当前连接上为执行打开日志流，执行标识只随 stream_open 发送一次
+func (a *Agent) openStream(w *RealTimeLogWriter, gen uint64) bool {
+	a.streamMu.Lock()
+	a.nextStream++
+	s := &logStream{id: a.nextStream, gen: gen, credit: utils.StreamInitialWindow}
+	a.streamMu.Unlock()
+
+	if err := a.sendWSMessage(WSTypeStreamOpen, struct {
+		RunRef
+		Stream uint32 `json:"stream"`
+	}{w.ref, s.id}); err != nil {
+		return false
+	}
+
+	a.streamMu.Lock()
+	a.streams[s.id] = w
+	w.stream = s
+	a.streamMu.Unlock()
+	return true
+}
+
+// closeStream 日志发送完毕后关闭流，流所在的连接已断开时无需通知
+func (a *Agent) closeStream(w *RealTimeLogWriter) {
+	s := w.stream
+	if s == nil {
+		return
+	}
+
+	a.streamMu.Lock()
+	w.stream = nil
+	if a.streams[s.id] == w {
+		delete(a.streams, s.id)
+	}
+	a.streamMu.Unlock()
+
+	a.wsMu.Lock()
+	current := a.wsGen == s.gen
+	a.wsMu.Unlock()
+	if current {
+		a.sendWSMessage(WSTypeStreamClose, map[string]uint32{"stream": s.id})
+	}
+}
+
+// resetStreams 连接断开时清空日志流，唤醒等待额度的写入以便在新连接上重新打开
+// 调用方需持有 wsMu
+func (a *Agent) resetStreams() {
+	a.streamMu.Lock()
+	defer a.streamMu.Unlock()
+	for id, w := range a.streams {
+		delete(a.streams, id)
+		notify(w.wake)
+	}
+	a.nextStream = 0
+}
+
+// sendFrame 在指定的连接上发送数据帧，连接已更换时返回错误
+func (a *Agent) sendFrame(frame *utils.Frame, gen uint64) error {
+	a.wsMu.Lock()
+	defer a.wsMu.Unlock()
+
+	if a.wsConn == nil || a.wsGen != gen {
+		return errConnChanged
+	}
+	data := utils.EncodeFrame(frame, a.currentAuth().signKey, a.wsSession)
+	a.wsConn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return a.wsConn.WriteMessage(websocket.BinaryMessage, data)
+}
+
+// handleFrame 处理面板发来的二进制帧，消息帧返回其中的 JSON，其他帧在此处理完返回 nil
+func (a *Agent) handleFrame(data []byte) []byte {
+	a.wsMu.Lock()
+	session := a.wsSession
+	a.wsMu.Unlock()
+
+	frame, err := utils.DecodeFrame(data, a.currentAuth().signKey, session)
+	if err != nil {
+		logger.Warnf("丢弃帧: %v", err)
+		return nil
+	}
+
+	switch frame.Type {
+	case utils.FrameMessage:
+		return frame.Payload
+	case utils.FrameCredit:
+		a.handleCredit(frame)
+	}
+	return nil
+}
+
+// handleCredit 增加流的发送额度并唤醒等待中的写入
+func (a *Agent) handleCredit(frame *utils.Frame) {
+	n, err := utils.ParseCredit(frame.Payload)
+	if err != nil {
+		logger.Warnf("丢弃额度帧: %v", err)
+		return
+	}
+
+	a.streamMu.Lock()
+	w, ok := a.streams[frame.Stream]
+	if !ok || w.stream == nil || frame.Seq != w.stream.recvSeq {
+		a.streamMu.Unlock()
+		return
+	}
+	w.stream.recvSeq++
+	w.stream.credit += n
+	a.streamMu.Unlock()
+
+	notify(w.wake)
+	go w.flushPending()
+}
+
+// notify 非阻塞地发送通知
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/engigu/baihu-panel/internal/logger"
+	"github.com/engigu/baihu-panel/internal/utils"
+)
+
+// 面板脚本目录同步：面板下发各目录映射的内容哈希清单，Agent 只请求缺少或已变化的文件，
+// 执行任务前也会请求一次同步，可用 script_sync = false 关闭
+
+// syncPullTimeout 执行任务前等待同步完成的最长时间，超时后使用本地已有的文件执行
+const syncPullTimeout = 60 * time.Second
+
+type syncFileEntry struct {
+	Path string `json:"path"`
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+	Mode uint32 `json:"mode"`
+}
+
+type syncDir struct {
+	Mapping uint            `json:"mapping"`
+	Target  string          `json:"target"`
+	Delete  bool            `json:"delete"`
+	Files   []syncFileEntry `json:"files"`
+	Skipped []string        `json:"skipped"` // 面板上过大未同步的文件，本地同名文件保留
+}
+
+type syncDirResult struct {
+	Mapping uint   `json:"mapping"`
+	Updated int    `json:"updated"`
+	Deleted int    `json:"deleted"`
+	Error   string `json:"error,omitempty"`
+}
+
+// syncJob 一次同步的进度，收到面板的全部文件后删除多余文件并返回结果
+type syncJob struct {
+	dirs    map[uint]*syncDir
+	roots   map[uint]string // 各映射在本机的目标目录
+	results map[uint]*syncDirResult
+}
+
+// handleSyncManifest 对比清单和本地文件，请求缺少或已变化的文件
+func (a *Agent) handleSyncManifest(data json.RawMessage) {
+	var manifest struct {
+		ID   string    `json:"id"`
+		Dirs []syncDir `json:"dirs"`
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil || manifest.ID == "" {
+		return
+	}
+	a.hasSyncDirs.Store(len(manifest.Dirs) > 0)
+
+	if !a.config.ScriptSync {
+		a.sendWSMessage(WSTypeSyncRequest, map[string]string{"id": manifest.ID, "error": "Agent 已关闭脚本同步（script_sync = false）"})
+		a.finishSyncPull(manifest.ID)
+		return
+	}
+
+	job := &syncJob{
+		dirs:    make(map[uint]*syncDir),
+		roots:   make(map[uint]string),
+		results: make(map[uint]*syncDirResult),
+	}
+	for i := range manifest.Dirs {
+		dir := &manifest.Dirs[i]
+		job.dirs[dir.Mapping] = dir
+		job.results[dir.Mapping] = &syncDirResult{Mapping: dir.Mapping}
+	}
+	a.syncMu.Lock()
+	a.syncJobs[manifest.ID] = job
+	a.syncMu.Unlock()
+
+	// 计算本地文件哈希可能较慢，不阻塞消息读取
+	go func() {
+		need := make(map[uint][]string)
+		for id, dir := range job.dirs {
+			root, err := a.syncTarget(dir)
+			if err != nil {
+				job.results[id].Error = err.Error()
+				continue
+			}
+			job.roots[id] = root
+			for _, f := range dir.Files {
+				fullPath, err := checkRemotePath(root, f.Path, false)
+				if err != nil {
+					continue
+				}
+				if !sameFile(fullPath, &f) {
+					need[id] = append(need[id], f.Path)
+				}
+			}
+		}
+		if err := a.sendWSMessage(WSTypeSyncRequest, map[string]interface{}{"id": manifest.ID, "need": need}); err != nil {
+			a.syncMu.Lock()
+			delete(a.syncJobs, manifest.ID)
+			a.syncMu.Unlock()
+			a.finishSyncPull(manifest.ID)
+		}
+	}()
+}
+
+// handleSyncFiles 写入面板发送的文件，全部发送完毕后删除多余文件并返回结果
+func (a *Agent) handleSyncFiles(data json.RawMessage) {
+	var batch struct {
+		ID      string `json:"id"`
+		Mapping uint   `json:"mapping"`
+		Files   []struct {
+			Path    string `json:"path"`
+			Content []byte `json:"content"`
+			Mode    uint32 `json:"mode"`
+		} `json:"files"`
+		Done bool `json:"done"`
+	}
+	if err := json.Unmarshal(data, &batch); err != nil {
+		return
+	}
+	a.syncMu.Lock()
+	job, ok := a.syncJobs[batch.ID]
+	a.syncMu.Unlock()
+	if !ok {
+		return
+	}
+
+	if root, ok := job.roots[batch.Mapping]; ok {
+		result := job.results[batch.Mapping]
+		for _, f := range batch.Files {
+			fullPath, err := checkRemotePath(root, f.Path, false)
+			if err != nil {
+				continue
+			}
+			if err := writeSyncFile(fullPath, f.Content, f.Mode); err != nil {
+				result.Error = fmt.Sprintf("写入 %s 失败: %v", f.Path, err)
+				continue
+			}
+			result.Updated++
+		}
+	}
+	if !batch.Done {
+		return
+	}
+
+	a.syncMu.Lock()
+	delete(a.syncJobs, batch.ID)
+	a.syncMu.Unlock()
+
+	results := make([]*syncDirResult, 0, len(job.results))
+	for id, result := range job.results {
+		if dir := job.dirs[id]; dir.Delete && result.Error == "" {
+			result.Deleted = removeExtraFiles(job.roots[id], dir.Files, dir.Skipped)
+		}
+		if result.Updated > 0 || result.Deleted > 0 || result.Error != "" {
+			logger.Infof("脚本同步 %s: 更新 %d 个, 删除 %d 个 %s", job.dirs[id].Target, result.Updated, result.Deleted, result.Error)
+		}
+		results = append(results, result)
+	}
+	a.sendWSMessage(WSTypeSyncResult, map[string]interface{}{"id": batch.ID, "results": results})
+	a.finishSyncPull(batch.ID)
+}
+
+// syncBeforeRun 执行任务前请求同步并等待完成，有多个任务同时执行时共用一次同步
+// 面板没有为本机配置映射或未连接时直接返回
+func (a *Agent) syncBeforeRun() {
+	if !a.config.ScriptSync || !a.hasSyncDirs.Load() {
+		return
+	}
+	a.wsMu.Lock()
+	wsStopCh := a.wsStopCh
+	a.wsMu.Unlock()
+	if wsStopCh == nil {
+		return
+	}
+
+	a.syncMu.Lock()
+	id, done := a.syncPullID, a.syncPullDone
+	if done == nil {
+		id = utils.RandomString(16)
+		done = make(chan struct{})
+		a.syncPullID, a.syncPullDone = id, done
+		a.syncMu.Unlock()
+		if err := a.sendWSMessage(WSTypeSyncPull, map[string]string{"id": id}); err != nil {
+			a.finishSyncPull(id)
+			return
+		}
+	} else {
+		a.syncMu.Unlock()
+	}
+
+	select {
+	case <-done:
+	case <-wsStopCh:
+		a.finishSyncPull(id)
+	case <-time.After(syncPullTimeout):
+		logger.Warn("等待脚本同步超时，使用本地文件执行")
+		a.finishSyncPull(id)
+	}
+}
+
+// finishSyncPull 唤醒等待同步 id 的任务
+func (a *Agent) finishSyncPull(id string) {
+	a.syncMu.Lock()
+	defer a.syncMu.Unlock()
+	if a.syncPullID == id && a.syncPullDone != nil {
+		close(a.syncPullDone)
+		a.syncPullID, a.syncPullDone = "", nil
+	}
+}
+
+// syncTarget 映射在本机的目标目录，限定在 sync_root 内；开启删除时不能包含 Agent 的数据目录
+func (a *Agent) syncTarget(dir *syncDir) (string, error) {
+	root := a.config.SyncRoot
+	if root == "" {
+		root = "."
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	target, err := checkRemotePath(root, dir.Target, false)
+	if err != nil {
+		return "", fmt.Errorf("目标目录 %s: %v", dir.Target, err)
+	}
+	if dir.Delete {
+		if data, err := filepath.Abs(dataDir); err == nil {
+			if rel, err := filepath.Rel(target, data); err == nil && !strings.HasPrefix(rel, "..") {
+				return "", fmt.Errorf("目标目录 %s 包含 Agent 数据目录，不能开启删除", dir.Target)
+			}
+		}
+	}
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+// sameFile 本地文件与清单中的内容和权限一致；权限按 writeSyncFile 实际写入的比较，
+// Windows 只有只读属性，不比较权限
+func sameFile(fullPath string, f *syncFileEntry) bool {
+	info, err := os.Stat(fullPath)
+	if err != nil || !info.Mode().IsRegular() || info.Size() != f.Size {
+		return false
+	}
+	if runtime.GOOS != "windows" && uint32(info.Mode().Perm()) != syncFileMode(f.Mode) {
+		return false
+	}
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return false
+	}
+	return hex.EncodeToString(h.Sum(nil)) == f.Hash
+}
+
+// syncFileMode 同步文件在本机的权限，属主始终可读写，以便之后更新
+func syncFileMode(mode uint32) uint32 {
+	return mode&0777 | 0600
+}
+
+// writeSyncFile 先写入临时文件再替换，避免任务读到写了一半的脚本
+func writeSyncFile(fullPath string, content []byte, mode uint32) error {
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return err
+	}
+	tmp := fullPath + ".sync-tmp"
+	if err := os.WriteFile(tmp, content, 0600); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, os.FileMode(syncFileMode(mode))); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, fullPath); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// removeExtraFiles 删除目标目录中清单没有的文件，面板上存在但未同步的 skipped 文件不删除，返回删除的数量
+func removeExtraFiles(root string, files []syncFileEntry, skipped []string) int {
+	keep := make(map[string]bool, len(files)+len(skipped))
+	for _, f := range files {
+		keep[filepath.FromSlash(f.Path)] = true
+	}
+	for _, p := range skipped {
+		keep[filepath.FromSlash(p)] = true
+	}
+	deleted := 0
+	filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil || keep[rel] || isProtectedPath(p) {
+			return nil
+		}
+		if os.Remove(p) == nil {
+			deleted++
+		}
+		return nil
+	})
+	return deleted
+}
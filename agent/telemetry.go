@@ -0,0 +1,37 @@
+package main
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/engigu/baihu-panel/internal/models"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/mem"
+)
+
+// collectTelemetry 采集资源使用情况，随心跳上报
+// CPU 使用率为距上次采集的平均值，采集失败的项保持为 0
+func (a *Agent) collectTelemetry() *models.AgentTelemetry {
+	t := &models.AgentTelemetry{
+		Time:    time.Now().Unix(),
+		NumCPU:  runtime.NumCPU(),
+		Running: a.scheduler.GetRunningTaskCount(),
+		Queued:  a.scheduler.GetQueueSize(),
+	}
+	if percents, err := cpu.Percent(0, false); err == nil && len(percents) > 0 {
+		t.CPU = percents[0]
+	}
+	if vm, err := mem.VirtualMemory(); err == nil {
+		t.MemUsed, t.MemTotal, t.MemPercent = vm.Used, vm.Total, vm.UsedPercent
+	}
+	if avg, err := load.Avg(); err == nil {
+		t.Load1, t.Load5, t.Load15 = avg.Load1, avg.Load5, avg.Load15
+	}
+	if usage, err := disk.Usage(dataDir); err == nil {
+		t.DiskUsed, t.DiskTotal, t.DiskPercent = usage.Used, usage.Total, usage.UsedPercent
+	}
+	return t
+}
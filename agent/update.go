@@ -2,147 +2,560 @@ package main
 
 import (
 	"archive/tar"
+	"bytes"
 	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
+
+	"github.com/engigu/baihu-panel/internal/utils"
 )
 
-// selfUpdate 自动更新
-func (a *Agent) selfUpdate() {
-	// 获取当前可执行文件路径
-	exePath, err := os.Executable()
+// UpdatePublicKey 发布公钥（base64，通过 ldflags 注入）
+// 只接受签名有效的发布包；为空时（如本地开发构建）不自动更新，需手动替换
+var UpdatePublicKey = ""
+
+// errNoPublicKey 未内置发布公钥，无法校验发布包
+var errNoPublicKey = errors.New("未内置发布公钥，无法校验发布包，拒绝自动更新；请使用带 AGENT_PUBLIC_KEY 构建的 Agent 或手动替换")
+
+// defaultUpdateTimeout 更新后新版本连上面板的默认期限
+const defaultUpdateTimeout = 120 * time.Second
+
+// pendingUpdate 已切换到新版本但尚未确认的更新，保存在 data/update/pending.json
+type pendingUpdate struct {
+	FromVersion string `json:"from_version"`
+	ToVersion   string `json:"to_version"`
+	Attempts    int    `json:"attempts"` // 新版本的启动次数，大于 1 说明上次启动未能确认（如崩溃后被服务管理器拉起）
+	UpdatedAt   int64  `json:"updated_at"`
+}
+
+// failedUpdate 最近一次回滚的版本，自动更新不再尝试该版本，面板强制更新除外
+type failedUpdate struct {
+	Version string `json:"version"`
+	Reason  string `json:"reason"`
+	Time    int64  `json:"time"`
+}
+
+func updateDir() string {
+	return filepath.Join(dataDir, "update")
+}
+
+func binaryName() string {
+	if runtime.GOOS == "windows" {
+		return "baihu-agent.exe"
+	}
+	return "baihu-agent"
+}
+
+// exePaths 当前可执行文件路径和不带 .bak 后缀的基础路径
+func exePaths() (exePath, basePath string, err error) {
+	exePath, err = os.Executable()
 	if err != nil {
-		log.Errorf("获取可执行文件路径失败: %v", err)
-		return
+		return "", "", err
 	}
 	exePath, _ = filepath.Abs(exePath)
+	basePath = exePath
+	for strings.HasSuffix(basePath, ".bak") {
+		basePath = strings.TrimSuffix(basePath, ".bak")
+	}
+	return exePath, basePath, nil
+}
+
+// selfUpdate 自动更新：下载并校验新版本，试运行通过后替换，保留旧版本用于回滚；
+// 只接受比当前更新的版本，rollback 为 true（管理员在面板发起回滚）时才允许降级
+func (a *Agent) selfUpdate(rollback bool) {
+	if !a.updating.CompareAndSwap(false, true) {
+		return
+	}
+	defer a.updating.Store(false)
+
+	if err := a.installUpdate(rollback); err != nil {
+		log.Errorf("更新失败: %v", err)
+		return
+	}
+
+	log.Info("更新完成，正在重启...")
+	if err := a.restart(); err != nil {
+		// 新版本没能启动，当前进程仍是旧版本，恢复旧文件后继续运行
+		log.Errorf("启动新版本失败: %v", err)
+		if p, perr := loadPendingUpdate(); perr == nil {
+			if rerr := restoreBackup(p, fmt.Sprintf("启动新版本失败: %v", err)); rerr != nil {
+				log.Errorf("恢复旧版本失败: %v", rerr)
+				return
+			}
+			log.Warnf("已恢复到 %s，自动更新将跳过 %s", p.FromVersion, p.ToVersion)
+		}
+	}
+}
+
+func (a *Agent) installUpdate(rollback bool) error {
+	if UpdatePublicKey == "" {
+		return errNoPublicKey
+	}
+	exePath, basePath, err := exePaths()
+	if err != nil {
+		return fmt.Errorf("获取可执行文件路径失败: %v", err)
+	}
+
+	newBinary, sig, err := a.downloadUpdate()
+	if err != nil {
+		return err
+	}
+	if err := verifyRelease(newBinary, sig, rollback); err != nil {
+		return err
+	}
+	toVersion := sig.Version
+
+	// 先写到当前可执行文件旁边并试运行，工作目录、配置和证书与正式启动时一致，
+	// 确认新版本能在本机启动再替换
+	stagedFile := basePath + ".new"
+	if err := os.WriteFile(stagedFile, newBinary, 0755); err != nil {
+		return fmt.Errorf("保存新版本失败: %v", err)
+	}
+	_, err = smokeTest(stagedFile, toVersion)
+	if err == nil {
+		err = startupTest(stagedFile)
+	}
+	if err != nil {
+		os.Remove(stagedFile)
+		return err
+	}
+
+	// 记录待确认的更新，新版本启动后在期限内连上面板才算成功
+	if err := savePendingUpdate(&pendingUpdate{
+		FromVersion: Version,
+		ToVersion:   toVersion,
+		UpdatedAt:   time.Now().Unix(),
+	}); err != nil {
+		os.Remove(stagedFile)
+		return fmt.Errorf("保存更新记录失败: %v", err)
+	}
+
+	// 如果当前运行的就是 .bak 文件，直接删除它（更新后会用新版本）
+	// 否则需要备份当前文件
+	backupFile := basePath + ".bak"
+	if exePath != backupFile {
+		os.Remove(backupFile)
+		if err := os.Rename(exePath, backupFile); err != nil {
+			os.Remove(stagedFile)
+			os.Remove(pendingFile())
+			return fmt.Errorf("备份旧版本失败: %v", err)
+		}
+	}
 
-	// 下载新版本 tar.gz
-	downloadURL := a.config.ServerURL + "/api/agent/download?os=" + runtime.GOOS + "&arch=" + runtime.GOARCH
+	// 替换为新版本（放到 basePath，即不带 .bak 的路径）
+	if err := os.Rename(stagedFile, basePath); err != nil {
+		if exePath != backupFile {
+			os.Rename(backupFile, exePath) // 恢复旧版本
+		}
+		os.Remove(pendingFile())
+		return fmt.Errorf("替换新版本失败: %v", err)
+	}
+	return nil
+}
+
+// downloadUpdate 下载发布包，校验下载校验和并解出可执行文件
+func (a *Agent) downloadUpdate() ([]byte, *utils.ReleaseSignature, error) {
+	auth := a.currentAuth()
+	downloadURL := auth.baseURL + "/api/agent/download?os=" + runtime.GOOS + "&arch=" + runtime.GOARCH
 	req, err := http.NewRequest("GET", downloadURL, nil)
 	if err != nil {
-		log.Errorf("创建下载请求失败: %v", err)
-		return
+		return nil, nil, fmt.Errorf("创建下载请求失败: %v", err)
 	}
 	req.Header.Set("Authorization", "Bearer "+a.config.Token)
 
-	client := &http.Client{Timeout: 5 * time.Minute}
+	client := &http.Client{Timeout: 5 * time.Minute, Transport: auth.client.Transport}
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Errorf("下载新版本失败: %v", err)
-		return
+		return nil, nil, fmt.Errorf("下载新版本失败: %v", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		log.Errorf("下载新版本失败: HTTP %d", resp.StatusCode)
-		return
+		return nil, nil, fmt.Errorf("下载新版本失败: HTTP %d", resp.StatusCode)
 	}
-
-	// 读取 tar.gz 内容
-	gzReader, err := gzip.NewReader(resp.Body)
+	data, err := io.ReadAll(resp.Body)
 	if err != nil {
-		log.Errorf("解压 gzip 失败: %v", err)
-		return
+		return nil, nil, fmt.Errorf("下载新版本失败: %v", err)
 	}
-	defer gzReader.Close()
 
-	tarReader := tar.NewReader(gzReader)
+	checksum := resp.Header.Get("X-Agent-Checksum")
+	if checksum == "" {
+		return nil, nil, errors.New("面板未提供下载校验和，拒绝更新")
+	}
+	sum := sha256.Sum256(data)
+	if checksum != "sha256="+hex.EncodeToString(sum[:]) {
+		return nil, nil, errors.New("下载校验和不匹配，发布包可能已损坏")
+	}
 
-	// 解压并找到二进制文件
-	var newBinary []byte
-	binaryName := "baihu-agent"
-	if runtime.GOOS == "windows" {
-		binaryName = "baihu-agent.exe"
+	var sig *utils.ReleaseSignature
+	if header := resp.Header.Get("X-Agent-Signature"); header != "" {
+		raw, err := base64.StdEncoding.DecodeString(header)
+		if err != nil {
+			return nil, nil, errors.New("发布签名格式错误")
+		}
+		sig = &utils.ReleaseSignature{}
+		if err := json.Unmarshal(raw, sig); err != nil {
+			return nil, nil, errors.New("发布签名格式错误")
+		}
+	}
+
+	binary, err := extractBinary(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return binary, sig, nil
+}
+
+// extractBinary 从 tar.gz 中解出可执行文件
+func extractBinary(data []byte) ([]byte, error) {
+	gzReader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("解压 gzip 失败: %v", err)
 	}
+	defer gzReader.Close()
 
+	name := binaryName()
+	tarReader := tar.NewReader(gzReader)
 	for {
 		header, err := tarReader.Next()
 		if err == io.EOF {
-			break
+			return nil, fmt.Errorf("tar.gz 中未找到 %s", name)
 		}
 		if err != nil {
-			log.Errorf("读取 tar 失败: %v", err)
-			return
+			return nil, fmt.Errorf("读取 tar 失败: %v", err)
 		}
-
-		if header.Typeflag == tar.TypeReg && header.Name == binaryName {
-			newBinary, err = io.ReadAll(tarReader)
+		if header.Typeflag == tar.TypeReg && header.Name == name {
+			binary, err := io.ReadAll(tarReader)
 			if err != nil {
-				log.Errorf("读取二进制文件失败: %v", err)
-				return
+				return nil, fmt.Errorf("读取二进制文件失败: %v", err)
 			}
+			return binary, nil
+		}
+	}
+}
+
+// verifyRelease 校验发布签名、可执行文件与签名一致且版本比当前新；签名和内置公钥都是必须的
+func verifyRelease(binary []byte, sig *utils.ReleaseSignature, rollback bool) error {
+	if UpdatePublicKey == "" {
+		return errNoPublicKey
+	}
+	if sig == nil {
+		return errors.New("发布包未签名，拒绝更新")
+	}
+	pub, err := utils.ParseReleasePublicKey(UpdatePublicKey)
+	if err != nil {
+		return err
+	}
+	if err := sig.Verify(pub); err != nil {
+		return err
+	}
+	if sig.OS != runtime.GOOS || sig.Arch != runtime.GOARCH {
+		return fmt.Errorf("发布包平台 %s/%s 与本机 %s/%s 不符", sig.OS, sig.Arch, runtime.GOOS, runtime.GOARCH)
+	}
+	sum := sha256.Sum256(binary)
+	if sig.SHA256 != hex.EncodeToString(sum[:]) {
+		return errors.New("可执行文件与发布签名不符")
+	}
+	return checkUpgrade(sig.Version, rollback)
+}
+
+// checkUpgrade 目标版本需比当前版本新，管理员发起的回滚除外；
+// 当前版本无法识别（如本地开发构建 dev）时不限制
+func checkUpgrade(target string, rollback bool) error {
+	if rollback {
+		return nil
+	}
+	current, ok := parseVersion(Version)
+	if !ok {
+		return nil
+	}
+	next, ok := parseVersion(target)
+	if !ok {
+		return fmt.Errorf("无法识别发布包版本 %q，拒绝更新", target)
+	}
+	if compareVersions(next, current) <= 0 {
+		return fmt.Errorf("发布包版本 %s 不比当前版本 %s 新，拒绝更新（降级需由管理员在面板发起回滚）", target, Version)
+	}
+	return nil
+}
+
+// parseVersion 解析 1.2.3 形式的版本号，忽略前缀 v 和 - 之后的后缀
+func parseVersion(v string) ([]int, bool) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	if v == "" {
+		return nil, false
+	}
+	parts := strings.Split(v, ".")
+	nums := make([]int, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		nums[i] = n
+	}
+	return nums, true
+}
+
+// compareVersions 逐段比较版本号，缺少的段按 0 处理
+func compareVersions(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// smokeTest 试运行新版本的 version 命令，返回其报告的版本号
+func smokeTest(path, expectVersion string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, path, "version").CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("新版本试运行失败: %v", err)
+	}
+	// 输出格式: Baihu Agent v<版本>
+	reported := ""
+	for _, line := range strings.Split(string(out), "\n") {
+		if v, ok := strings.CutPrefix(strings.TrimSpace(line), "Baihu Agent v"); ok {
+			reported = v
 			break
 		}
 	}
+	if reported == "" {
+		return "", errors.New("新版本试运行输出异常")
+	}
+	if expectVersion != "" && reported != expectVersion {
+		return "", fmt.Errorf("新版本报告的版本 %s 与发布签名 %s 不符", reported, expectVersion)
+	}
+	return reported, nil
+}
+
+// startupTest 用新版本按正式启动的流程加载配置、证书和运行配置并连接面板，
+// 不接管当前 Agent 的锁、PID 文件和离线缓存；新版本无法启动时不替换
+func startupTest(path string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, path, "selftest", "-c", configFile).CombinedOutput()
+	if err != nil {
+		msg := strings.TrimSpace(string(out))
+		if len(msg) > 500 {
+			msg = msg[len(msg)-500:]
+		}
+		return fmt.Errorf("新版本启动检查失败: %v %s", err, msg)
+	}
+	return nil
+}
+
+// cmdSelfTest 更新前的启动检查（隐藏命令）：按 run 的流程准备启动，连上面板并通过认证后退出
+func cmdSelfTest() {
+	config, err := loadCommandConfig()
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Printf("加载配置文件失败: %v\n", err)
+		os.Exit(1)
+	}
+	if config == nil {
+		config = &Config{Interval: 30, ScriptSync: true}
+	}
+	if config.ServerURL == "" {
+		fmt.Println("配置文件中缺少 server_url")
+		os.Exit(1)
+	}
+	if _, _, err := mergeLocal(loadRemoteConfig(), config.Local); err != nil {
+		// 与正式启动一致，格式错误的本地值不生效但不影响启动
+		fmt.Printf("运行配置部分未生效: %v\n", err)
+	}
 
-	if newBinary == nil {
-		log.Errorf("tar.gz 中未找到 %s", binaryName)
+	agent := newCommandAgent(config)
+	if _, err := os.Stat(filepath.Join(pkiDir(), "enrollment.json")); err == nil && agent.auth == nil {
+		fmt.Println("无法加载 data/pki 中的客户端证书")
+		os.Exit(1)
+	}
+	resp, err := agent.doRequest("GET", "/api/agent/check", nil)
+	if err != nil {
+		fmt.Printf("连接面板失败: %v\n", err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+	// 旧版面板没有自检接口，能连上即可
+	if resp.StatusCode == http.StatusNotFound || strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		fmt.Println("ok")
 		return
 	}
+	if err := decodeAPIResponse(resp, nil); err != nil {
+		fmt.Printf("面板拒绝了令牌或客户端证书: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("ok")
+}
 
-	// 保存到临时文件（放到 data 目录）
-	os.MkdirAll(dataDir, 0755)
-	tmpFile := filepath.Join(dataDir, binaryName+".new")
-	if err := os.WriteFile(tmpFile, newBinary, 0755); err != nil {
-		log.Errorf("保存新版本失败: %v", err)
+// checkPendingUpdate 启动时确认上次更新：新版本需在期限内连上面板，否则回滚到旧版本
+func (a *Agent) checkPendingUpdate() {
+	p, err := loadPendingUpdate()
+	if err != nil {
+		return
+	}
+	if p.ToVersion != Version {
+		// 当前运行的不是待确认的版本（如已手动替换或已回滚），不再跟踪
+		os.Remove(pendingFile())
 		return
 	}
 
-	// 计算基础路径（去掉所有 .bak 后缀）
-	basePath := exePath
-	for strings.HasSuffix(basePath, ".bak") {
-		basePath = strings.TrimSuffix(basePath, ".bak")
+	p.Attempts++
+	if p.Attempts > 1 {
+		a.rollbackUpdate(p, "新版本上次启动后未能连接面板")
+		return
 	}
-	backupFile := basePath + ".bak"
+	savePendingUpdate(p)
 
-	// 如果当前运行的就是 .bak 文件，直接删除它（更新后会用新版本）
-	// 否则需要备份当前文件
-	if exePath != backupFile {
-		os.Remove(backupFile)
-		if err := os.Rename(exePath, backupFile); err != nil {
-			log.Errorf("备份旧版本失败: %v", err)
-			os.Remove(tmpFile)
-			return
-		}
+	timeout := defaultUpdateTimeout
+	if a.config.UpdateTimeout > 0 {
+		timeout = time.Duration(a.config.UpdateTimeout) * time.Second
 	}
+	log.Infof("已更新到 %s，等待连接面板确认（%v 内未连接将回滚到 %s）", Version, timeout, p.FromVersion)
 
-	// 替换为新版本（放到 basePath，即不带 .bak 的路径）
-	if err := os.Rename(tmpFile, basePath); err != nil {
-		log.Errorf("替换新版本失败: %v", err)
-		if exePath != backupFile {
-			os.Rename(backupFile, exePath) // 恢复旧版本
+	go func() {
+		select {
+		case <-a.connectedCh:
+			os.Remove(pendingFile())
+			os.Remove(failedFile())
+			log.Infof("更新到 %s 已确认", Version)
+		case <-time.After(timeout):
+			a.rollbackUpdate(p, fmt.Sprintf("%v 内未能连接面板", timeout))
+		case <-a.stopCh:
+			// 正常停止不计入启动次数，下次启动重新计时
+			p.Attempts--
+			savePendingUpdate(p)
 		}
+	}()
+}
+
+// rollbackUpdate 恢复 .bak 中的旧版本并重启
+func (a *Agent) rollbackUpdate(p *pendingUpdate, reason string) {
+	_, basePath, err := exePaths()
+	if err != nil {
+		log.Errorf("回滚失败: %v", err)
+		return
+	}
+	backupFile := basePath + ".bak"
+	if _, err := os.Stat(backupFile); err != nil {
+		log.Errorf("更新到 %s 失败（%s），但未找到旧版本 %s，无法回滚", p.ToVersion, reason, backupFile)
+		os.Remove(pendingFile())
+		return
+	}
+	if err := restoreBackup(p, reason); err != nil {
+		log.Errorf("回滚失败: %v", err)
 		return
 	}
 
-	// 如果之前运行的是 .bak 文件，现在可以删除它了
-	if exePath == backupFile {
-		os.Remove(exePath)
+	log.Warnf("更新到 %s 失败（%s），已回滚到 %s，自动更新将跳过该版本，正在重启...", p.ToVersion, reason, p.FromVersion)
+	if err := a.restart(); err != nil {
+		log.Errorf("重启旧版本失败，请手动重启: %v", err)
 	}
+}
 
-	log.Info("更新完成，正在重启...")
+// restoreBackup 用 .bak 中的旧版本替换新版本，并记录失败的版本
+func restoreBackup(p *pendingUpdate, reason string) error {
+	_, basePath, err := exePaths()
+	if err != nil {
+		return err
+	}
+	backupFile := basePath + ".bak"
+
+	// 正在运行的文件无法直接覆盖（Windows），先移开再恢复
+	failedBinary := basePath + ".failed"
+	os.Remove(failedBinary)
+	if err := os.Rename(basePath, failedBinary); err != nil {
+		return err
+	}
+	if err := os.Rename(backupFile, basePath); err != nil {
+		os.Rename(failedBinary, basePath)
+		return err
+	}
+	os.Remove(failedBinary)
 
-	// 重启服务
-	a.restart()
+	data, _ := json.MarshalIndent(failedUpdate{Version: p.ToVersion, Reason: reason, Time: time.Now().Unix()}, "", "  ")
+	os.WriteFile(failedFile(), data, 0644)
+	os.Remove(pendingFile())
+	return nil
 }
 
-// restart 重启服务
-func (a *Agent) restart() {
-	exePath, _ := os.Executable()
+func pendingFile() string {
+	return filepath.Join(updateDir(), "pending.json")
+}
 
-	// 计算基础路径（去掉所有 .bak 后缀），确保启动的是正确的可执行文件
-	basePath := exePath
-	for strings.HasSuffix(basePath, ".bak") {
-		basePath = strings.TrimSuffix(basePath, ".bak")
+func failedFile() string {
+	return filepath.Join(updateDir(), "failed.json")
+}
+
+func loadPendingUpdate() (*pendingUpdate, error) {
+	data, err := os.ReadFile(pendingFile())
+	if err != nil {
+		return nil, err
+	}
+	var p pendingUpdate
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
 	}
+	return &p, nil
+}
+
+func savePendingUpdate(p *pendingUpdate) error {
+	if err := os.MkdirAll(updateDir(), 0755); err != nil {
+		return err
+	}
+	data, _ := json.MarshalIndent(p, "", "  ")
+	return os.WriteFile(pendingFile(), data, 0644)
+}
+
+// failedUpdateVersion 最近一次回滚的版本
+func failedUpdateVersion() string {
+	data, err := os.ReadFile(failedFile())
+	if err != nil {
+		return ""
+	}
+	var f failedUpdate
+	if json.Unmarshal(data, &f) != nil {
+		return ""
+	}
+	return f.Version
+}
+
+// restart 重启服务，成功时不返回；新进程无法启动时返回错误，当前进程继续运行
+func (a *Agent) restart() error {
+	// 计算基础路径（去掉所有 .bak 后缀），确保启动的是正确的可执行文件
+	_, basePath, _ := exePaths()
 
 	// 删除 PID 文件，避免新进程检测到旧 PID 而拒绝启动
 	removePidFile()
@@ -150,12 +563,16 @@ func (a *Agent) restart() {
 	if runtime.GOOS == "windows" {
 		// Windows: 启动新进程后退出
 		cmd := exec.Command(basePath, "start")
-		cmd.Start()
+		if err := cmd.Start(); err != nil {
+			writePidFile()
+			return err
+		}
 		os.Exit(0)
-	} else {
-		// Linux/macOS: 使用 exec 替换当前进程，直接运行（不需要 daemon）
-		// 因为 syscall.Exec 会替换当前进程，当前进程本身就是 daemon
-		// --restart 标记告诉新进程这是重启，只输出到文件
-		syscall.Exec(basePath, []string{basePath, "run", "--restart"}, os.Environ())
 	}
+	// Linux/macOS: 使用 exec 替换当前进程，直接运行（不需要 daemon）
+	// 因为 syscall.Exec 会替换当前进程，当前进程本身就是 daemon
+	// --restart 标记告诉新进程这是重启，只输出到文件
+	err := syscall.Exec(basePath, []string{basePath, "run", "--restart"}, os.Environ())
+	writePidFile()
+	return err
 }
@@ -2,6 +2,7 @@ package bootstrap
 
 import (
 	"fmt"
+	"net/http"
 	"os"
 
 	"github.com/engigu/baihu-panel/internal/constant"
@@ -9,6 +10,7 @@ import (
 	"github.com/engigu/baihu-panel/internal/logger"
 	"github.com/engigu/baihu-panel/internal/router"
 	"github.com/engigu/baihu-panel/internal/services"
+	"github.com/engigu/baihu-panel/internal/services/agentpki"
 
 	"github.com/gin-gonic/gin"
 )
@@ -70,7 +72,37 @@ func (a *App) initRouter() {
 }
 
 func (a *App) Run() {
+	if a.Config.AgentAuth.MTLSPort > 0 {
+		go a.runAgentMTLS()
+	} else if a.Config.AgentAuth.RequireMTLS {
+		logger.Warn("已开启 require_mtls 但未配置 mtls_port，Agent 将无法连接")
+	}
+
 	addr := fmt.Sprintf("%s:%d", a.Config.Server.Host, a.Config.Server.Port)
 	logger.Infof("Starting server on %s", addr)
 	a.Router.Run(addr)
 }
+
+// runAgentMTLS 启动供 Agent 使用的 mTLS 端口，与主端口共用路由
+func (a *App) runAgentMTLS() {
+	ca, err := agentpki.Load()
+	if err != nil {
+		logger.Errorf("Failed to load agent CA: %v", err)
+		return
+	}
+	tlsConfig, err := ca.ServerTLSConfig()
+	if err != nil {
+		logger.Errorf("Failed to create agent TLS config: %v", err)
+		return
+	}
+
+	server := &http.Server{
+		Addr:      fmt.Sprintf("%s:%d", a.Config.Server.Host, a.Config.AgentAuth.MTLSPort),
+		Handler:   a.Router,
+		TLSConfig: tlsConfig,
+	}
+	logger.Infof("Starting agent mTLS server on %s", server.Addr)
+	if err := server.ListenAndServeTLS("", ""); err != nil {
+		logger.Errorf("Agent mTLS server stopped: %v", err)
+	}
+}
@@ -30,9 +30,13 @@ const (
 	DefaultTaskTimeout = 30
 
 	// Settings Section 常量
-	SectionSite      = "site"
-	SectionSystem    = "system"
-	SectionScheduler = "scheduler"
+	SectionSite         = "site"
+	SectionSystem       = "system"
+	SectionScheduler    = "scheduler"
+	SectionRetention    = "retention"
+	SectionRedaction    = "redaction"
+	SectionScriptNotify = "script_notify"
+	SectionHealth       = "health"
 
 	// Site Settings Key 常量
 	KeyTitle      = "title"
@@ -49,31 +53,80 @@ const (
 	KeyQueueSize    = "queue_size"
 	KeyRateInterval = "rate_interval"
 
+	// Retention Settings Key 常量
+	KeyRetentionEnabled    = "enabled"          // 是否启用全局日志清理
+	KeyRetentionInterval   = "interval"         // 清理间隔（分钟）
+	KeyRetentionKeepDays   = "keep_days"        // 成功日志保留天数，0 表示不限制
+	KeyRetentionFailedDays = "failed_keep_days" // 失败日志保留天数，0 表示与 keep_days 相同
+	KeyRetentionKeepCount  = "keep_count"       // 每个任务最多保留条数，0 表示不限制
+	KeyRetentionTaskSize   = "task_max_size"    // 每个任务日志总大小上限（MB），0 表示不限制
+	KeyRetentionDiskQuota  = "disk_quota"       // 全部任务日志总大小上限（MB），0 表示不限制
+	KeyRetentionLoginDays  = "login_log_days"   // 登录日志保留天数，0 表示不限制
+
+	// Redaction Settings Key 常量
+	KeyRedactionRules = "rules" // 日志脱敏正则规则，每行一条
+
+	// Script Notify Settings Key 常量（脚本 sendNotify 使用的内置发送渠道）
+	KeyScriptNotifyWebhook = "webhook" // Webhook 渠道配置（JSON），为空表示不启用
+	KeyScriptNotifySMTP    = "smtp"    // SMTP 渠道配置（JSON），为空表示不启用
+
+	// Health Settings Key 常量
+	KeyHealthFailStreak   = "fail_streak"   // 连续失败达到该次数判定为 failing，0 表示不按连续失败判定
+	KeyHealthWindow       = "window_hours"  // 失败率统计窗口（小时）
+	KeyHealthFailRate     = "fail_rate"     // 窗口内失败率（%）达到该值判定为 failing，0 表示不按失败率判定
+	KeyHealthDegradedRate = "degraded_rate" // 窗口内失败率（%）达到该值判定为 degraded，0 表示不按失败率判定
+	KeyHealthMinRuns      = "min_runs"      // 窗口内执行次数少于该值时不按失败率判定
+	KeyHealthMissedGrace  = "missed_grace"  // 预期执行时间后超过该分钟数仍无执行记录则判定为漏执行，0 表示不检测
+
 	// WebSocket 消息类型
-	WSTypeHeartbeat     = "heartbeat"
-	WSTypeHeartbeatAck  = "heartbeat_ack"
-	WSTypeTasks         = "tasks"
-	WSTypeTaskResult    = "task_result"
-	WSTypeTaskLog       = "task_log"
-	WSTypeExecute       = "execute"
-	WSTypeUpdate        = "update"
-	WSTypeDisconnect    = "disconnect"
-	WSTypeConnected     = "connected"
-	WSTypeDisabled      = "disabled"
-	WSTypeEnabled       = "enabled"
-	WSTypeFetchTasks    = "fetch_tasks"
-	WSTypeTaskHeartbeat = "task_heartbeat"
-	WSTypeStop          = "stop"
+	WSTypeHeartbeat      = "heartbeat"
+	WSTypeHeartbeatAck   = "heartbeat_ack"
+	WSTypeTasks          = "tasks"
+	WSTypeTaskResult     = "task_result"
+	WSTypeTaskLog        = "task_log"
+	WSTypeExecute        = "execute"
+	WSTypeUpdate         = "update"
+	WSTypeDisconnect     = "disconnect"
+	WSTypeConnected      = "connected"
+	WSTypeDisabled       = "disabled"
+	WSTypeEnabled        = "enabled"
+	WSTypeFetchTasks     = "fetch_tasks"
+	WSTypeTaskHeartbeat  = "task_heartbeat"
+	WSTypeStop           = "stop"
+	WSTypeShellOpen      = "shell_open"      // 面板请求打开远程终端
+	WSTypeShellOpened    = "shell_opened"    // Agent 回复终端已打开（或失败原因）
+	WSTypeShellInput     = "shell_input"     // 终端输入
+	WSTypeShellOutput    = "shell_output"    // 终端输出
+	WSTypeShellClose     = "shell_close"     // 任一方关闭终端
+	WSTypeFileRequest    = "file_request"    // 面板请求文件操作
+	WSTypeFileResponse   = "file_response"   // Agent 返回文件操作结果
+	WSTypeStreamOpen     = "stream_open"     // 协议 v2：Agent 为一次执行打开日志流
+	WSTypeStreamClose    = "stream_close"    // 协议 v2：日志流发送完毕
+	WSTypeSyncManifest   = "sync_manifest"   // 面板下发脚本同步清单
+	WSTypeSyncRequest    = "sync_request"    // Agent 请求缺少或已变化的文件
+	WSTypeSyncFiles      = "sync_files"      // 面板发送文件内容
+	WSTypeSyncResult     = "sync_result"     // Agent 返回同步结果
+	WSTypeSyncPull       = "sync_pull"       // Agent 执行任务前请求同步
+	WSTypeSecretsRequest = "secrets_request" // Agent 执行任务前请求隐藏环境变量
+	WSTypeSecrets        = "secrets"         // 面板返回加密后的隐藏环境变量
+	WSTypeConfig         = "config"          // 面板推送 Agent 运行配置
+	WSTypeConfigAck      = "config_ack"      // Agent 确认已应用的配置版本
 
 	// 任务状态
 	TaskStatusSuccess   = "success"
 	TaskStatusFailed    = "failed"
+	TaskStatusWarning   = "warning"
 	TaskStatusRunning   = "running"
 	TaskStatusPending   = "pending"
 	TaskStatusTimeout   = "timeout"
 	TaskStatusCancelled = "cancelled"
 	TaskStatusQueued    = "queued"
 
+	// 任务健康状态
+	TaskHealthHealthy  = "healthy"
+	TaskHealthDegraded = "degraded"
+	TaskHealthFailing  = "failing"
+
 	// 任务类型
 	TaskTypeNormal = "task"
 	TaskTypeRepo   = "repo"
@@ -81,6 +134,35 @@ const (
 	// Agent 状态
 	AgentStatusOnline  = "online"
 	AgentStatusOffline = "offline"
+
+	// Agent 审批状态，使用需要审批的令牌注册的新机器在管理员批准前不能连接，也不会下发任务
+	AgentApprovalPending  = "pending"
+	AgentApprovalApproved = "approved"
+
+	// 按标签选择 Agent 时的分发模式
+	DispatchAny    = "any"    // 任选一个匹配的在线 Agent（负载均衡）
+	DispatchAll    = "all"    // 所有匹配的 Agent 各执行一次，每个 Agent 一条日志
+	DispatchSticky = "sticky" // 优先在指定 Agent 执行，不可用时切换到其他匹配的 Agent
+
+	// LabelGroup 分组在标签选择器中的键名
+	LabelGroup = "group"
+
+	// Agent 升级计划状态
+	RolloutStatusRunning   = "running"
+	RolloutStatusPaused    = "paused"
+	RolloutStatusCompleted = "completed"
+	RolloutStatusCancelled = "cancelled"
+
+	// 升级计划中单个 Agent 的状态
+	RolloutTargetPending  = "pending"  // 所在批次尚未开始
+	RolloutTargetUpdating = "updating" // 已下发更新，等待上报新版本
+	RolloutTargetSuccess  = "success"
+	RolloutTargetFailed   = "failed"
+	RolloutTargetSkipped  = "skipped" // 失败后恢复计划时跳过，不再计入失败数
+
+	// Agent 远程会话类型
+	AgentSessionShell = "shell"
+	AgentSessionFile  = "file"
 )
 
 // TablePrefix 表前缀，从配置文件读取
@@ -109,4 +191,29 @@ var DefaultSettings = map[string]map[string]string{
 		KeyQueueSize:    "100",
 		KeyRateInterval: "200",
 	},
+	SectionRetention: {
+		KeyRetentionEnabled:    "false",
+		KeyRetentionInterval:   "60",
+		KeyRetentionKeepDays:   "30",
+		KeyRetentionFailedDays: "90",
+		KeyRetentionKeepCount:  "0",
+		KeyRetentionTaskSize:   "0",
+		KeyRetentionDiskQuota:  "1024",
+		KeyRetentionLoginDays:  "90",
+	},
+	SectionRedaction: {
+		KeyRedactionRules: "",
+	},
+	SectionScriptNotify: {
+		KeyScriptNotifyWebhook: "",
+		KeyScriptNotifySMTP:    "",
+	},
+	SectionHealth: {
+		KeyHealthFailStreak:   "3",
+		KeyHealthWindow:       "24",
+		KeyHealthFailRate:     "50",
+		KeyHealthDegradedRate: "20",
+		KeyHealthMinRuns:      "4",
+		KeyHealthMissedGrace:  "5",
+	},
 }
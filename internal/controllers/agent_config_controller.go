@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"github.com/engigu/baihu-panel/internal/models"
+	"github.com/engigu/baihu-panel/internal/models/vo"
+	"github.com/engigu/baihu-panel/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type agentConfigRequest struct {
+	WorkerCount     int    `json:"worker_count"`
+	QueueSize       int    `json:"queue_size"`
+	LogLevel        string `json:"log_level"`
+	AllowedWorkDirs string `json:"allowed_work_dirs"`
+	Proxy           string `json:"proxy"`
+	NoProxy         string `json:"no_proxy"`
+	MemoryLimit     int    `json:"memory_limit"`
+	CPULimit        int    `json:"cpu_limit"`
+	OpenFilesLimit  int    `json:"open_files_limit"`
+}
+
+// GetConfig 获取 Agent 运行配置及同步状态
+func (c *AgentController) GetConfig(ctx *gin.Context) {
+	agent := c.getAgent(ctx)
+	if agent == nil {
+		return
+	}
+	utils.Success(ctx, c.agentConfigVO(agent))
+}
+
+// SaveConfig 保存 Agent 运行配置，在线的 Agent 立即生效
+func (c *AgentController) SaveConfig(ctx *gin.Context) {
+	agent := c.getAgent(ctx)
+	if agent == nil {
+		return
+	}
+
+	var req agentConfigRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(ctx, "参数错误")
+		return
+	}
+
+	cfg := &models.AgentConfig{
+		AgentID:         agent.ID,
+		WorkerCount:     req.WorkerCount,
+		QueueSize:       req.QueueSize,
+		LogLevel:        req.LogLevel,
+		AllowedWorkDirs: req.AllowedWorkDirs,
+		Proxy:           req.Proxy,
+		NoProxy:         req.NoProxy,
+		MemoryLimit:     req.MemoryLimit,
+		CPULimit:        req.CPULimit,
+		OpenFilesLimit:  req.OpenFilesLimit,
+	}
+	if err := c.configService.Save(cfg); err != nil {
+		utils.BadRequest(ctx, err.Error())
+		return
+	}
+	utils.Success(ctx, c.agentConfigVO(agent))
+}
+
+func (c *AgentController) agentConfigVO(agent *models.Agent) *vo.AgentConfigVO {
+	cfg := c.configService.Get(agent.ID)
+	effective := c.configService.Payload(agent).Values()
+	return vo.ToAgentConfigVO(cfg, agent, effective, c.configService.Overrides(cfg), c.wsManager.IsOnline(agent.ID))
+}
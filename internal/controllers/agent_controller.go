@@ -1,50 +1,81 @@
 package controllers
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/engigu/baihu-panel/internal/constant"
 	"github.com/engigu/baihu-panel/internal/logger"
 	"github.com/engigu/baihu-panel/internal/models"
 	"github.com/engigu/baihu-panel/internal/models/vo"
 	"github.com/engigu/baihu-panel/internal/services"
 	"github.com/engigu/baihu-panel/internal/services/tasks"
+	"github.com/engigu/baihu-panel/internal/services/telemetry"
 	"github.com/engigu/baihu-panel/internal/utils"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 )
 
-var agentUpgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
-}
+// agentUpgrader 使用默认的同源检查：Agent 不发送 Origin 头，浏览器跨站发起的连接会被拒绝
+// 开启 permessage-deflate 压缩，由握手协商，旧版 Agent 不请求时不压缩
+var agentUpgrader = websocket.Upgrader{EnableCompression: true}
 
 // AgentController Agent 控制器
 type AgentController struct {
 	agentService    *services.AgentService
 	wsManager       *services.AgentWSManager
 	settingsService *services.SettingsService
+	rolloutService  *services.AgentRolloutService
+	remoteService   *services.AgentRemoteService
+	syncService     *services.AgentSyncService
+	configService   *services.AgentConfigService
+	executorService *tasks.ExecutorService
 }
 
 // NewAgentController 创建 Agent 控制器
-func NewAgentController(settingsService *services.SettingsService) *AgentController {
+func NewAgentController(agentService *services.AgentService, settingsService *services.SettingsService, rolloutService *services.AgentRolloutService, remoteService *services.AgentRemoteService, syncService *services.AgentSyncService, configService *services.AgentConfigService, executorService *tasks.ExecutorService) *AgentController {
 	return &AgentController{
-		agentService:    services.NewAgentService(),
+		agentService:    agentService,
 		wsManager:       services.GetAgentWSManager(),
 		settingsService: settingsService,
+		rolloutService:  rolloutService,
+		remoteService:   remoteService,
+		syncService:     syncService,
+		configService:   configService,
+		executorService: executorService,
 	}
 }
 
 // List 获取 Agent 列表
 func (c *AgentController) List(ctx *gin.Context) {
 	agents := c.agentService.List()
-	utils.Success(ctx, vo.ToAgentVOListFromModels(agents))
+	vos := vo.ToAgentVOListFromModels(agents)
+	for _, v := range vos {
+		if sample, ok := telemetry.Latest(v.ID); ok {
+			v.Telemetry = &sample
+		}
+	}
+	utils.Success(ctx, vos)
+}
+
+// Telemetry 获取 Agent 最近一小时的资源使用历史
+func (c *AgentController) Telemetry(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		utils.BadRequest(ctx, "无效的 ID")
+		return
+	}
+	if c.agentService.GetByID(uint(id)) == nil {
+		utils.NotFound(ctx, "Agent 不存在")
+		return
+	}
+	utils.Success(ctx, telemetry.History(uint(id), time.Now().Add(-time.Hour)))
 }
 
 // Update 更新 Agent
@@ -58,6 +89,8 @@ func (c *AgentController) Update(ctx *gin.Context) {
 	var req struct {
 		Name        string `json:"name" binding:"required"`
 		Description string `json:"description"`
+		Group       string `json:"group"`
+		Labels      string `json:"labels"`
 		Enabled     bool   `json:"enabled"`
 	}
 
@@ -74,7 +107,7 @@ func (c *AgentController) Update(ctx *gin.Context) {
 	}
 	wasEnabled := oldAgent.Enabled
 
-	if err := c.agentService.Update(uint(id), req.Name, req.Description, req.Enabled); err != nil {
+	if err := c.agentService.Update(uint(id), req.Name, req.Description, req.Group, req.Labels, req.Enabled); err != nil {
 		utils.ServerError(ctx, err.Error())
 		return
 	}
@@ -94,6 +127,13 @@ func (c *AgentController) Update(ctx *gin.Context) {
 				"message": "Agent 已禁用",
 			})
 		}
+	} else if req.Enabled {
+		// 分组或标签可能变化，重新下发按标签匹配的任务
+		c.wsManager.BroadcastTasks(uint(id))
+	}
+	// 分组和标签属于 Agent 运行配置的一部分，变化时推送新版本
+	if oldAgent.Group != req.Group || oldAgent.Labels != req.Labels {
+		c.configService.Bump(uint(id))
 	}
 
 	utils.SuccessMsg(ctx, "更新成功")
@@ -111,6 +151,9 @@ func (c *AgentController) Delete(ctx *gin.Context) {
 		utils.BadRequest(ctx, err.Error())
 		return
 	}
+	telemetry.Remove(uint(id))
+	c.syncService.DeleteAgent(uint(id))
+	c.configService.DeleteAgent(uint(id))
 
 	utils.SuccessMsg(ctx, "删除成功")
 }
@@ -132,6 +175,77 @@ func (c *AgentController) RegenerateToken(ctx *gin.Context) {
 	utils.Success(ctx, gin.H{"token": token})
 }
 
+// ResetCert 作废 Agent 的客户端证书和签名密钥并断开连接，Agent 重连时重新申请
+// 用于 Agent 证书丢失或面板 CA 变化后无法续签的情况
+func (c *AgentController) ResetCert(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		utils.BadRequest(ctx, "无效的 ID")
+		return
+	}
+	if err := c.agentService.ResetEnrollment(uint(id)); err != nil {
+		utils.BadRequest(ctx, err.Error())
+		return
+	}
+	if ac := c.wsManager.GetConnection(uint(id)); ac != nil {
+		c.wsManager.Unregister(uint(id), ac)
+	}
+	logger.Infof("[Agent] 已重置 Agent #%d 的证书", id)
+	utils.SuccessMsg(ctx, "已重置，Agent 重连时将重新申请证书并记录新的公钥")
+}
+
+// Approve 批准待审批的 Agent
+func (c *AgentController) Approve(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		utils.BadRequest(ctx, "无效的 ID")
+		return
+	}
+	var req struct {
+		Group string `json:"group"`
+	}
+	ctx.ShouldBindJSON(&req)
+
+	if err := c.agentService.Approve(uint(id), req.Group); err != nil {
+		utils.BadRequest(ctx, err.Error())
+		return
+	}
+	utils.SuccessMsg(ctx, "已批准，Agent 下次重连后生效")
+}
+
+// Reject 拒绝待审批的 Agent，该机器不能再注册
+func (c *AgentController) Reject(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		utils.BadRequest(ctx, "无效的 ID")
+		return
+	}
+	if err := c.agentService.Reject(uint(id)); err != nil {
+		utils.BadRequest(ctx, err.Error())
+		return
+	}
+	utils.SuccessMsg(ctx, "已拒绝")
+}
+
+// ListBlocks 获取被拒绝的机器
+func (c *AgentController) ListBlocks(ctx *gin.Context) {
+	utils.Success(ctx, c.agentService.ListBlocks())
+}
+
+// DeleteBlock 解除拒绝
+func (c *AgentController) DeleteBlock(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		utils.BadRequest(ctx, "无效的 ID")
+		return
+	}
+	if err := c.agentService.DeleteBlock(uint(id)); err != nil {
+		utils.ServerError(ctx, err.Error())
+		return
+	}
+	utils.SuccessMsg(ctx, "已解除")
+}
+
 // ========== Agent API（供 Agent 调用）==========
 
 // Register Agent 注册（无需认证）
@@ -170,25 +284,39 @@ func (c *AgentController) Heartbeat(ctx *gin.Context) {
 	}
 
 	var req struct {
-		Version    string `json:"version"`
-		BuildTime  string `json:"build_time"`
-		Hostname   string `json:"hostname"`
-		OS         string `json:"os"`
-		Arch       string `json:"arch"`
-		AutoUpdate bool   `json:"auto_update"`
+		Version    string                 `json:"version"`
+		BuildTime  string                 `json:"build_time"`
+		Hostname   string                 `json:"hostname"`
+		OS         string                 `json:"os"`
+		Arch       string                 `json:"arch"`
+		AutoUpdate bool                   `json:"auto_update"`
+		Group      string                 `json:"group"`
+		Labels     string                 `json:"labels"`
+		Telemetry  *models.AgentTelemetry `json:"telemetry"`
 	}
 	ctx.ShouldBindJSON(&req)
 
+	machineID := ctx.GetHeader("X-Machine-ID")
+	if err := c.agentService.CheckClientCert(ctx.Request, c.agentService.FindAgent(token, machineID)); err != nil {
+		utils.Unauthorized(ctx, err.Error())
+		return
+	}
+
 	ip := ctx.ClientIP()
-	agent, err := c.agentService.Heartbeat(token, ip, req.Version, req.BuildTime, req.Hostname, req.OS, req.Arch)
+	agent, err := c.agentService.Heartbeat(token, machineID, ip, req.Version, req.BuildTime, req.Hostname, req.OS, req.Arch)
 	if err != nil {
 		utils.Unauthorized(ctx, err.Error())
 		return
 	}
+	c.agentService.UpdateConfigLabels(agent, req.Group, req.Labels)
+	if req.Telemetry != nil {
+		telemetry.Record(agent.ID, *req.Telemetry)
+	}
 
-	// 检查是否需要更新
+	// 检查是否需要更新，升级计划中尚未轮到的 Agent 暂不提示
+	c.rolloutService.OnHeartbeat(agent.ID, req.Version)
 	latestVersion := c.agentService.GetLatestVersion()
-	needUpdate := c.agentService.CheckNeedUpdate(req.Version, req.BuildTime)
+	needUpdate := c.agentService.CheckNeedUpdate(req.Version, req.BuildTime) && !c.rolloutService.HoldsUpdate(agent.ID)
 	forceUpdate := agent.ForceUpdate
 
 	// 如果强制更新已触发，重置标志
@@ -201,48 +329,92 @@ func (c *AgentController) Heartbeat(ctx *gin.Context) {
 		"name":           agent.Name,
 		"need_update":    needUpdate,
 		"force_update":   forceUpdate,
+		"rollback":       forceUpdate && agent.AllowRollback,
 		"latest_version": latestVersion,
 	})
 }
 
 // GetTasks Agent 获取任务列表
 func (c *AgentController) GetTasks(ctx *gin.Context) {
-	token := c.getAgentToken(ctx)
-	if token == "" {
-		utils.Unauthorized(ctx, "缺少认证 Token")
+	agent := c.requestAgent(ctx)
+	if agent == nil {
 		return
 	}
 
-	// 先尝试通过 token 查找 Agent
-	agent := c.agentService.GetByToken(token)
+	tasks := c.agentService.GetTasks(agent.ID)
+	utils.Success(ctx, gin.H{
+		"agent_id": agent.ID,
+		"tasks":    tasks,
+	})
+}
 
-	// 如果找不到，尝试验证令牌并通过 machine_id 查找
+// Check Agent 自检（agent doctor）：验证 Token 和证书，返回面板时间用于检查时钟偏差
+func (c *AgentController) Check(ctx *gin.Context) {
+	agent := c.requestAgent(ctx)
 	if agent == nil {
-		machineID := ctx.GetHeader("X-Machine-ID")
-		if machineID != "" {
-			// 验证令牌是否有效
-			if _, err := c.agentService.ValidateToken(token); err == nil {
-				// 令牌有效，尝试通过 machine_id 查找 Agent
-				agent = c.agentService.GetByMachineID(machineID)
-			}
-		}
+		return
 	}
 
+	utils.Success(ctx, gin.H{
+		"agent_id":    agent.ID,
+		"name":        agent.Name,
+		"online":      c.wsManager.IsOnline(agent.ID),
+		"server_time": time.Now().UnixMilli(),
+	})
+}
+
+// Execute Agent 命令行（agent exec）请求在本机执行任务，经面板调度以记录日志和通知
+func (c *AgentController) Execute(ctx *gin.Context) {
+	agent := c.requestAgent(ctx)
 	if agent == nil {
-		utils.Unauthorized(ctx, "无效的 Token")
 		return
 	}
 
-	if !agent.Enabled {
-		utils.Forbidden(ctx, "Agent 已禁用")
+	var req struct {
+		TaskID uint `json:"task_id" binding:"required"`
+	}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(ctx, "参数错误")
 		return
 	}
 
-	tasks := c.agentService.GetTasks(agent.ID)
-	utils.Success(ctx, gin.H{
-		"agent_id": agent.ID,
-		"tasks":    tasks,
-	})
+	result, err := c.executorService.ExecuteTaskOnAgent(req.TaskID, agent)
+	if err != nil {
+		utils.BadRequest(ctx, err.Error())
+		return
+	}
+	utils.Success(ctx, vo.ToExecutionResultVO(result))
+}
+
+// requestAgent 认证 Agent 的 HTTP 请求，失败时写入响应并返回 nil
+// 按 Token 和 X-Machine-ID 查找，提供了机器识别码时必须与 Agent 一致
+func (c *AgentController) requestAgent(ctx *gin.Context) *models.Agent {
+	token := c.getAgentToken(ctx)
+	if token == "" {
+		utils.Unauthorized(ctx, "缺少认证 Token")
+		return nil
+	}
+
+	agent := c.agentService.FindAgent(token, ctx.GetHeader("X-Machine-ID"))
+	if agent == nil {
+		utils.Unauthorized(ctx, "无效的 Token")
+		return nil
+	}
+
+	if err := c.agentService.CheckClientCert(ctx.Request, agent); err != nil {
+		utils.Unauthorized(ctx, err.Error())
+		return nil
+	}
+
+	if !agent.Enabled {
+		utils.Forbidden(ctx, "Agent 已禁用")
+		return nil
+	}
+	if agent.Pending() {
+		utils.Forbidden(ctx, "Agent 等待管理员审批")
+		return nil
+	}
+	return agent
 }
 
 // ReportResult Agent 上报执行结果
@@ -253,16 +425,25 @@ func (c *AgentController) ReportResult(ctx *gin.Context) {
 		return
 	}
 
-	agent := c.agentService.GetByToken(token)
+	agent := c.agentService.FindAgent(token, ctx.GetHeader("X-Machine-ID"))
 	if agent == nil {
 		utils.Unauthorized(ctx, "无效的 Token")
 		return
 	}
 
+	if err := c.agentService.CheckClientCert(ctx.Request, agent); err != nil {
+		utils.Unauthorized(ctx, err.Error())
+		return
+	}
+
 	if !agent.Enabled {
 		utils.Forbidden(ctx, "Agent 已禁用")
 		return
 	}
+	if agent.Pending() {
+		utils.Forbidden(ctx, "Agent 等待管理员审批")
+		return
+	}
 
 	var result models.AgentTaskResult
 	if err := ctx.ShouldBindJSON(&result); err != nil {
@@ -280,6 +461,72 @@ func (c *AgentController) ReportResult(ctx *gin.Context) {
 	utils.SuccessMsg(ctx, "上报成功")
 }
 
+// Enroll Agent 申请客户端证书和消息签名密钥
+// 首次申请使用认证 Token 或注册令牌（同时完成注册），不要求客户端证书，是启用 mTLS 后 Agent 的引导入口；
+// 续签必须通过 mTLS 端口出示当前证书
+func (c *AgentController) Enroll(ctx *gin.Context) {
+	token := c.getAgentToken(ctx)
+	if token == "" {
+		utils.Unauthorized(ctx, "缺少认证 Token")
+		return
+	}
+
+	var req struct {
+		MachineID string `json:"machine_id"`
+		CSR       string `json:"csr"` // PEM 格式的证书签名请求，为空时只返回签名密钥
+		Hostname  string `json:"hostname"`
+		OS        string `json:"os"`
+		Arch      string `json:"arch"`
+		Version   string `json:"version"`
+	}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(ctx, "参数错误")
+		return
+	}
+	if req.MachineID != "" && !utils.ValidMachineID(req.MachineID) {
+		utils.BadRequest(ctx, "机器识别码格式错误")
+		return
+	}
+
+	ip := ctx.ClientIP()
+	agent := c.agentService.FindAgent(token, req.MachineID)
+	if agent == nil || agent.Pending() {
+		var err error
+		agent, _, err = c.agentService.RegisterByToken(ctx.Request, token, services.AgentMachineInfo{
+			MachineID: req.MachineID, Hostname: req.Hostname, OS: req.OS, Arch: req.Arch, Version: req.Version,
+		}, ip)
+		if err != nil {
+			utils.Unauthorized(ctx, err.Error())
+			return
+		}
+	}
+
+	if !agent.Enabled {
+		utils.Forbidden(ctx, "Agent 已禁用")
+		return
+	}
+	if agent.Pending() {
+		utils.Forbidden(ctx, "Agent 等待管理员审批")
+		return
+	}
+
+	if err := c.agentService.CheckEnrollment(ctx.Request, agent); err != nil {
+		logger.Warnf("[Agent] 拒绝 Agent #%d 的证书申请 (%s): %v", agent.ID, ip, err)
+		utils.Forbidden(ctx, err.Error())
+		return
+	}
+
+	result, err := c.agentService.Enroll(agent, req.CSR)
+	if err != nil {
+		utils.BadRequest(ctx, err.Error())
+		return
+	}
+	if result.Certificate != "" {
+		logger.Infof("[Agent] Agent #%d 已签发客户端证书，有效期至 %s", agent.ID, time.Unix(result.ExpiresAt, 0).Format("2006-01-02"))
+	}
+	utils.Success(ctx, result)
+}
+
 // getAgentToken 从请求头获取 Agent Token
 func (c *AgentController) getAgentToken(ctx *gin.Context) string {
 	auth := ctx.GetHeader("Authorization")
@@ -305,6 +552,14 @@ func (c *AgentController) Download(ctx *gin.Context) {
 		return
 	}
 
+	// 下载校验和用于发现传输损坏；签名由 Agent 使用内置公钥校验，防止发布包被替换
+	sum := sha256.Sum256(data)
+	ctx.Header("X-Agent-Checksum", "sha256="+hex.EncodeToString(sum[:]))
+	if sig := c.agentService.GetAgentSignature(osType, arch); sig != nil {
+		sigJSON, _ := json.Marshal(sig)
+		ctx.Header("X-Agent-Signature", base64.StdEncoding.EncodeToString(sigJSON))
+	}
+
 	ctx.Header("Content-Disposition", "attachment; filename="+filename)
 	ctx.Header("Content-Type", "application/gzip")
 	ctx.Header("Content-Length", strconv.Itoa(len(data)))
@@ -330,11 +585,21 @@ func (c *AgentController) ForceUpdate(ctx *gin.Context) {
 		return
 	}
 
-	if err := c.agentService.SetForceUpdate(uint(id)); err != nil {
+	// rollback 为 true 时允许 Agent 降级到面板的版本，Agent 默认只接受更新的版本
+	var req struct {
+		Rollback bool `json:"rollback"`
+	}
+	ctx.ShouldBindJSON(&req)
+
+	if err := c.agentService.SetForceUpdate(uint(id), req.Rollback); err != nil {
 		utils.ServerError(ctx, err.Error())
 		return
 	}
 
+	if req.Rollback {
+		utils.SuccessMsg(ctx, "已标记回滚，Agent 下次心跳时将安装面板的版本")
+		return
+	}
 	utils.SuccessMsg(ctx, "已标记强制更新，Agent 下次心跳时将自动更新")
 }
 
@@ -371,22 +636,42 @@ func (c *AgentController) WSConnect(ctx *gin.Context) {
 	}
 
 	machineID := ctx.Query("machine_id")
-	logger.Infof("[AgentWS] Token: %s..., MachineID: %s...", token[:8], machineID[:16])
+	if machineID != "" && !utils.ValidMachineID(machineID) {
+		c.wsManager.RecordConnectFail(ip)
+		logger.Warnf("[AgentWS] 连接失败: 机器识别码格式错误, IP=%s", ip)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "机器识别码格式错误"})
+		return
+	}
+	logger.Infof("[AgentWS] Token: %s, MachineID: %s", utils.ShortID(token, 8), utils.ShortID(machineID, 16))
 
 	isNewAgent := false
 
 	// 先尝试用 token 查找已有 Agent
-	agent := c.agentService.GetByToken(token)
-	logger.Infof("[AgentWS] GetByToken 结果: agent=%v", agent != nil)
+	agent := c.agentService.FindAgent(token, machineID)
+	logger.Infof("[AgentWS] FindAgent 结果: agent=%v", agent != nil)
 
-	// 如果没找到，尝试用令牌注册（会检查 machine_id 是否已存在）
-	if agent == nil {
+	// 校验客户端证书；新 Agent 开启 require_mtls 后需要先通过 /api/agent/enroll 申请证书
+	if err := c.agentService.CheckClientCert(ctx.Request, agent); err != nil {
+		c.wsManager.RecordConnectFail(ip)
+		logger.Warnf("[AgentWS] 客户端证书校验失败: %v, IP=%s", err, ip)
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 如果没找到，尝试用令牌注册（会检查 machine_id 是否已存在）；待审批的机器每次连接都更新上报的信息
+	if agent == nil || agent.Pending() {
 		logger.Infof("[AgentWS] 尝试注册新 Agent")
 		var err error
-		agent, isNewAgent, err = c.agentService.RegisterByToken(token, machineID, ip)
+		agent, isNewAgent, err = c.agentService.RegisterByToken(ctx.Request, token, services.AgentMachineInfo{
+			MachineID: machineID,
+			Hostname:  ctx.Query("hostname"),
+			OS:        ctx.Query("os"),
+			Arch:      ctx.Query("arch"),
+			Version:   ctx.Query("version"),
+		}, ip)
 		if err != nil {
 			c.wsManager.RecordConnectFail(ip)
-			logger.Warnf("[AgentWS] 注册失败: %v, IP=%s, token=%s", err, ip, token[:8]+"...")
+			logger.Warnf("[AgentWS] 注册失败: %v, IP=%s, token=%s", err, ip, utils.ShortID(token, 8))
 			ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 			return
 		}
@@ -399,6 +684,19 @@ func (c *AgentController) WSConnect(ctx *gin.Context) {
 		ctx.JSON(http.StatusForbidden, gin.H{"error": "Agent 已禁用"})
 		return
 	}
+	if agent.Pending() {
+		logger.Infof("[AgentWS] Agent #%d 等待管理员审批, IP=%s", agent.ID, ip)
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "Agent 等待管理员审批"})
+		return
+	}
+
+	signKey, err := c.agentService.SigningKeyFor(agent)
+	if err != nil {
+		c.wsManager.RecordConnectFail(ip)
+		logger.Warnf("[AgentWS] Agent #%d %v, IP=%s", agent.ID, err, ip)
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
 
 	logger.Infof("[AgentWS] 准备升级连接: Agent #%d, IP=%s", agent.ID, ip)
 	conn, err := agentUpgrader.Upgrade(ctx.Writer, ctx.Request, nil)
@@ -410,16 +708,15 @@ func (c *AgentController) WSConnect(ctx *gin.Context) {
 	// 连接成功，重置失败计数
 	c.wsManager.RecordConnectSuccess(ip)
 
-	// 注册连接
-	ac := c.wsManager.Register(agent.ID, conn, ip)
+	// 注册连接，旧版 Agent 不带 proto 参数，使用 v1
+	protocol := utils.NegotiateWSProtocol(ctx.Query("proto"))
+	ac := c.wsManager.Register(agent.ID, conn, ip, signKey, protocol)
 
 	// 更新 Agent 状态
-	c.agentService.Heartbeat(token, ip, "", "", "", "", "")
+	c.agentService.Heartbeat(token, agent.MachineID, ip, "", "", "", "", "")
 
-	// 获取调度配置
-	workerCount := getIntSetting(c.settingsService, constant.SectionScheduler, constant.KeyWorkerCount, 4)
-	queueSize := getIntSetting(c.settingsService, constant.SectionScheduler, constant.KeyQueueSize, 100)
-	rateInterval := getIntSetting(c.settingsService, constant.SectionScheduler, constant.KeyRateInterval, 200)
+	// 获取运行配置，未单独配置的调度参数使用全局设置
+	cfg := c.configService.Payload(agent)
 
 	// 发送连接成功消息（包含注册状态和调度配置）
 	c.wsManager.SendToAgent(agent.ID, services.WSTypeConnected, map[string]interface{}{
@@ -427,22 +724,27 @@ func (c *AgentController) WSConnect(ctx *gin.Context) {
 		"name":         agent.Name,
 		"is_new_agent": isNewAgent,
 		"machine_id":   machineID,
+		"protocol":     protocol,
+		"session":      ac.Session(),
 		"scheduler_config": map[string]interface{}{
-			"worker_count":  workerCount,
-			"queue_size":    queueSize,
-			"rate_interval": rateInterval,
+			"worker_count":  cfg.WorkerCount,
+			"queue_size":    cfg.QueueSize,
+			"rate_interval": cfg.RateInterval,
 		},
 	})
+	// 新版 Agent 收到完整配置后应用并回报，旧版忽略该消息
+	c.wsManager.SendToAgent(agent.ID, services.WSTypeConfig, cfg)
 
-	logger.Infof("[AgentWS] Agent #%d 连接成功 (配置: workers=%d, queue=%d, rate=%d)",
-		agent.ID, workerCount, queueSize, rateInterval)
+	logger.Infof("[AgentWS] Agent #%d 连接成功 (协议: v%d, 配置: v%d, workers=%d, queue=%d, rate=%d)",
+		agent.ID, protocol, cfg.Version, cfg.WorkerCount, cfg.QueueSize, cfg.RateInterval)
 
 	// 启动读写协程
 	go c.wsWritePump(ac)
 	go c.wsReadPump(ac, agent)
 
-	// 主动推送任务列表
+	// 主动推送任务列表，并同步脚本目录
 	go c.wsManager.BroadcastTasks(agent.ID)
+	go c.syncService.Sync(agent.ID, "")
 }
 
 // wsReadPump 读取消息
@@ -453,6 +755,9 @@ func (c *AgentController) wsReadPump(ac *services.AgentConnection, agent *models
 		}
 		logger.Infof("[AgentWS] Agent #%d wsReadPump 退出", agent.ID)
 		c.wsManager.Unregister(agent.ID, ac)
+		// Agent 断线后会结束本端的终端，面板侧同步关闭
+		c.remoteService.CloseAgent(agent.ID)
+		c.syncService.CloseAgent(agent.ID)
 	}()
 
 	// 检查连接是否有效（可能是旧连接被新连接替换）
@@ -469,16 +774,37 @@ func (c *AgentController) wsReadPump(ac *services.AgentConnection, agent *models
 	})
 
 	for {
-		_, message, err := ac.ReadMessage()
+		mt, message, err := ac.ReadMessage()
 		if err != nil {
 			logger.Warnf("[AgentWS] Agent #%d 读取错误: %v", agent.ID, err)
 			break
 		}
 
+		// v2 Agent 发送二进制帧，协商完成前的消息仍是 JSON 文本
+		if mt == websocket.BinaryMessage {
+			frame, err := ac.DecodeFrame(message)
+			if err != nil {
+				logger.Warnf("[AgentWS] Agent #%d 丢弃帧: %v", agent.ID, err)
+				continue
+			}
+			if frame.Type == utils.FrameData {
+				c.handleStreamData(ac, agent, frame)
+				continue
+			}
+			if frame.Type != utils.FrameMessage {
+				continue
+			}
+			message = frame.Payload
+		}
+
 		var msg services.WSMessage
 		if err := json.Unmarshal(message, &msg); err != nil {
 			continue
 		}
+		if err := c.wsManager.VerifyMessage(ac, &msg); err != nil {
+			logger.Warnf("[AgentWS] Agent #%d 丢弃消息 %s: %v", agent.ID, msg.Type, err)
+			continue
+		}
 
 		c.handleWSMessage(ac, agent, &msg)
 	}
@@ -540,22 +866,103 @@ func (c *AgentController) handleWSMessage(ac *services.AgentConnection, agent *m
 
 	case services.WSTypeTaskHeartbeat: // 任务心跳
 		c.handleTaskHeartbeat(agent, msg.Data)
+
+	case services.WSTypeShellOpened, services.WSTypeShellOutput, services.WSTypeShellClose: // 远程终端
+		c.remoteService.HandleShellMessage(agent.ID, msg.Type, msg.Data)
+
+	case services.WSTypeFileResponse: // 远程文件操作结果
+		c.remoteService.HandleFileResponse(agent.ID, msg.Data)
+
+	case services.WSTypeSyncRequest: // 脚本同步
+		c.syncService.HandleRequest(agent.ID, msg.Data)
+
+	case services.WSTypeSyncResult:
+		c.syncService.HandleResult(agent.ID, msg.Data)
+
+	case services.WSTypeSyncPull:
+		c.syncService.HandlePull(agent.ID, msg.Data)
+
+	case services.WSTypeConfigAck: // 运行配置应用结果
+		c.configService.HandleAck(agent.ID, msg.Data)
+
+	case services.WSTypeSecretsRequest: // 执行任务前请求隐藏环境变量
+		c.handleSecretsRequest(agent, msg.Data)
+
+	case services.WSTypeStreamOpen: // 协议 v2 日志流
+		c.handleStreamOpen(ac, agent, msg.Data)
+
+	case services.WSTypeStreamClose:
+		var req struct {
+			Stream uint32 `json:"stream"`
+		}
+		if json.Unmarshal(msg.Data, &req) == nil {
+			ac.CloseStream(req.Stream)
+		}
 	}
 }
 
+// handleStreamOpen 打开日志流，执行标识只在打开时解析一次
+func (c *AgentController) handleStreamOpen(ac *services.AgentConnection, agent *models.Agent, data json.RawMessage) {
+	var req struct {
+		services.AgentRunRef
+		Stream uint32 `json:"stream"`
+	}
+	if err := json.Unmarshal(data, &req); err != nil {
+		logger.Errorf("[AgentWS] 解析日志流消息失败: %v", err)
+		return
+	}
+	logID := c.agentService.ResolveAgentRun(agent.ID, req.AgentRunRef, true)
+	ac.OpenStream(req.Stream, logID)
+}
+
+// handleStreamData 写入日志流数据并归还额度
+func (c *AgentController) handleStreamData(ac *services.AgentConnection, agent *models.Agent, frame *utils.Frame) {
+	logID, err := ac.StreamData(frame)
+	if err != nil {
+		logger.Warnf("[AgentWS] Agent #%d 丢弃数据帧: %v", agent.ID, err)
+		return
+	}
+	if tl := tasks.GetActiveLog(logID); tl != nil {
+		tl.Write(frame.Payload)
+	} else {
+		logger.Warnf("[AgentWS] 收到任务日志但未找到活跃 TinyLog: LogID=%d, ContentSize=%d", logID, len(frame.Payload))
+	}
+	ac.GrantCredit(frame.Stream, len(frame.Payload))
+}
+
+// handleSecretsRequest 加密任务的隐藏环境变量并返回，失败时返回原因
+func (c *AgentController) handleSecretsRequest(agent *models.Agent, data json.RawMessage) {
+	var req struct {
+		ID     string `json:"id"`
+		TaskID uint   `json:"task_id"`
+		Key    string `json:"key"`
+	}
+	if err := json.Unmarshal(data, &req); err != nil || req.ID == "" {
+		return
+	}
+	sealed, err := c.agentService.TaskSecrets(agent, req.TaskID, req.ID, req.Key)
+	if err != nil {
+		logger.Warnf("[AgentWS] Agent #%d 请求任务 #%d 的隐藏变量失败: %v", agent.ID, req.TaskID, err)
+		c.wsManager.SendToAgent(agent.ID, services.WSTypeSecrets, map[string]string{"id": req.ID, "error": err.Error()})
+		return
+	}
+	c.wsManager.SendToAgent(agent.ID, services.WSTypeSecrets, map[string]interface{}{"id": req.ID, "sealed": sealed})
+}
+
 // handleTaskHeartbeat 处理任务心跳
 func (c *AgentController) handleTaskHeartbeat(agent *models.Agent, data json.RawMessage) {
 	var req struct {
-		LogID    uint  `json:"log_id"`
+		services.AgentRunRef
 		Duration int64 `json:"duration"`
 	}
 	if err := json.Unmarshal(data, &req); err != nil {
 		logger.Errorf("[AgentWS] 解析心跳消息失败: %v", err)
 		return
 	}
-	if req.LogID > 0 {
-		logger.Infof("[AgentWS] 收到任务心跳: LogID=%d, Duration=%dms", req.LogID, req.Duration)
-		c.agentService.UpdateTaskDuration(req.LogID, req.Duration)
+	logID := c.agentService.ResolveAgentRun(agent.ID, req.AgentRunRef, true)
+	if logID > 0 {
+		logger.Infof("[AgentWS] 收到任务心跳: LogID=%d, Duration=%dms", logID, req.Duration)
+		c.agentService.UpdateTaskDuration(logID, req.Duration)
 	}
 }
 
@@ -563,7 +970,8 @@ func (c *AgentController) handleTaskHeartbeat(agent *models.Agent, data json.Raw
 func (c *AgentController) handleFetchTasks(agent *models.Agent) {
 	tasks := c.agentService.GetTasks(agent.ID)
 	c.wsManager.SendToAgent(agent.ID, services.WSTypeTasks, map[string]interface{}{
-		"tasks": tasks,
+		"tasks":           tasks,
+		"redaction_rules": c.agentService.GetRedactionRules(),
 	})
 	logger.Infof("[AgentWS] Agent #%d 请求任务列表，返回 %d 个任务", agent.ID, len(tasks))
 }
@@ -571,23 +979,35 @@ func (c *AgentController) handleFetchTasks(agent *models.Agent) {
 // handleHeartbeat 处理心跳
 func (c *AgentController) handleHeartbeat(ac *services.AgentConnection, agent *models.Agent, data json.RawMessage) {
 	var req struct {
-		Version    string `json:"version"`
-		BuildTime  string `json:"build_time"`
-		Hostname   string `json:"hostname"`
-		OS         string `json:"os"`
-		Arch       string `json:"arch"`
-		AutoUpdate bool   `json:"auto_update"`
+		Version    string                 `json:"version"`
+		BuildTime  string                 `json:"build_time"`
+		Hostname   string                 `json:"hostname"`
+		OS         string                 `json:"os"`
+		Arch       string                 `json:"arch"`
+		AutoUpdate bool                   `json:"auto_update"`
+		Group      string                 `json:"group"`
+		Labels     string                 `json:"labels"`
+		Telemetry  *models.AgentTelemetry `json:"telemetry"`
 	}
 	json.Unmarshal(data, &req)
 
 	ac.UpdatePing()
 
 	// 更新 Agent 信息（使用连接时保存的 IP）
-	c.agentService.Heartbeat(agent.Token, ac.IP, req.Version, req.BuildTime, req.Hostname, req.OS, req.Arch)
+	c.agentService.Heartbeat(agent.Token, agent.MachineID, ac.IP, req.Version, req.BuildTime, req.Hostname, req.OS, req.Arch)
+
+	// 配置文件中的分组或标签变化时，重新下发按标签匹配的任务
+	if c.agentService.UpdateConfigLabels(agent, req.Group, req.Labels) {
+		c.wsManager.BroadcastTasks(agent.ID)
+	}
+	if req.Telemetry != nil {
+		telemetry.Record(agent.ID, *req.Telemetry)
+	}
 
-	// 检查是否需要更新
+	// 检查是否需要更新，升级计划中尚未轮到的 Agent 暂不提示
+	c.rolloutService.OnHeartbeat(agent.ID, req.Version)
 	latestVersion := c.agentService.GetLatestVersion()
-	needUpdate := c.agentService.CheckNeedUpdate(req.Version, req.BuildTime)
+	needUpdate := c.agentService.CheckNeedUpdate(req.Version, req.BuildTime) && !c.rolloutService.HoldsUpdate(agent.ID)
 	forceUpdate := agent.ForceUpdate
 
 	if forceUpdate && needUpdate {
@@ -600,6 +1020,7 @@ func (c *AgentController) handleHeartbeat(ac *services.AgentConnection, agent *m
 		"name":           agent.Name,
 		"need_update":    needUpdate,
 		"force_update":   forceUpdate,
+		"rollback":       forceUpdate && agent.AllowRollback,
 		"latest_version": latestVersion,
 	}
 	c.wsManager.SendToAgent(agent.ID, services.WSTypeHeartbeatAck, response)
@@ -619,7 +1040,7 @@ func (c *AgentController) handleTaskResult(agent *models.Agent, data json.RawMes
 // handleTaskLog 处理 Agent 发送的实时日志
 func (c *AgentController) handleTaskLog(agent *models.Agent, data json.RawMessage) {
 	var logMsg struct {
-		LogID   uint   `json:"log_id"`
+		services.AgentRunRef
 		Content string `json:"content"`
 	}
 	if err := json.Unmarshal(data, &logMsg); err != nil {
@@ -627,11 +1048,12 @@ func (c *AgentController) handleTaskLog(agent *models.Agent, data json.RawMessag
 		return
 	}
 
-	tl := tasks.GetActiveLog(logMsg.LogID)
+	logID := c.agentService.ResolveAgentRun(agent.ID, logMsg.AgentRunRef, true)
+	tl := tasks.GetActiveLog(logID)
 	if tl != nil {
 		tl.Write([]byte(logMsg.Content))
 	} else {
-		logger.Warnf("[AgentWS] 收到任务日志但未找到活跃 TinyLog: LogID=%d, ContentSize=%d", logMsg.LogID, len(logMsg.Content))
+		logger.Warnf("[AgentWS] 收到任务日志但未找到活跃 TinyLog: LogID=%d, ContentSize=%d", logID, len(logMsg.Content))
 	}
 }
 
@@ -651,9 +1073,11 @@ func (c *AgentController) ListTokens(ctx *gin.Context) {
 // CreateToken 创建令牌
 func (c *AgentController) CreateToken(ctx *gin.Context) {
 	var req struct {
-		Remark    string `json:"remark"`
-		MaxUses   int    `json:"max_uses"`
-		ExpiresAt string `json:"expires_at"` // 格式: 2006-01-02 15:04:05
+		Remark      string `json:"remark"`
+		MaxUses     int    `json:"max_uses"`
+		ExpiresAt   string `json:"expires_at"`   // 格式: 2006-01-02 15:04:05
+		AutoApprove *bool  `json:"auto_approve"` // 为空时自动审批，兼容旧版前端
+		Group       string `json:"group"`
 	}
 
 	if err := ctx.ShouldBindJSON(&req); err != nil {
@@ -671,7 +1095,8 @@ func (c *AgentController) CreateToken(ctx *gin.Context) {
 		expiresAt = &t
 	}
 
-	token, err := c.agentService.CreateToken(req.Remark, req.MaxUses, expiresAt)
+	autoApprove := req.AutoApprove == nil || *req.AutoApprove
+	token, err := c.agentService.CreateToken(req.Remark, req.MaxUses, expiresAt, autoApprove, req.Group)
 	if err != nil {
 		utils.ServerError(ctx, err.Error())
 		return
@@ -695,16 +1120,3 @@ func (c *AgentController) DeleteToken(ctx *gin.Context) {
 
 	utils.SuccessMsg(ctx, "删除成功")
 }
-
-// getIntSetting 辅助方法
-func getIntSetting(s *services.SettingsService, section, key string, defaultVal int) int {
-	val := s.Get(section, key)
-
-	if val == "" {
-		return defaultVal
-	}
-	if result, err := strconv.Atoi(val); err == nil {
-		return result
-	}
-	return defaultVal
-}
@@ -0,0 +1,258 @@
+package controllers
+
+import (
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+
+	"github.com/engigu/baihu-panel/internal/constant"
+	"github.com/engigu/baihu-panel/internal/models"
+	"github.com/engigu/baihu-panel/internal/services"
+	"github.com/engigu/baihu-panel/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// isAgentRequest 终端和文件接口带 agent_id 参数时转发给对应 Agent
+func isAgentRequest(c *gin.Context) bool {
+	return c.Query("agent_id") != ""
+}
+
+// agentAccess 通过面板访问 Agent 的权限校验和审计
+type agentAccess struct {
+	remoteService *services.AgentRemoteService
+	agentService  *services.AgentService
+	userService   *services.UserService
+}
+
+func newAgentAccess(remoteService *services.AgentRemoteService, agentService *services.AgentService, userService *services.UserService) *agentAccess {
+	return &agentAccess{
+		remoteService: remoteService,
+		agentService:  agentService,
+		userService:   userService,
+	}
+}
+
+// userID 当前登录用户
+func (a *agentAccess) userID(c *gin.Context) uint {
+	if v, ok := c.Get("userID"); ok {
+		if id, ok := v.(uint); ok {
+			return id
+		}
+	}
+	return 0
+}
+
+// check 校验当前用户能否访问 agent_id 指定的 Agent，返回 Agent 或错误信息
+func (a *agentAccess) check(c *gin.Context) (*models.Agent, string) {
+	if !a.userService.IsAdmin(a.userID(c)) {
+		return nil, "仅管理员可以访问 Agent"
+	}
+	id, err := strconv.ParseUint(c.Query("agent_id"), 10, 32)
+	if err != nil {
+		return nil, "无效的 agent_id"
+	}
+	agent := a.agentService.GetByID(uint(id))
+	if agent == nil {
+		return nil, "Agent 不存在"
+	}
+	if !agent.Enabled {
+		return nil, "Agent 已禁用"
+	}
+	return agent, ""
+}
+
+// resolve 同 check，失败时直接写入错误响应并返回 nil
+func (a *agentAccess) resolve(c *gin.Context) *models.Agent {
+	agent, errMsg := a.check(c)
+	if errMsg != "" {
+		utils.Forbidden(c, errMsg)
+		return nil
+	}
+	return agent
+}
+
+// startSession 记录一次访问
+func (a *agentAccess) startSession(c *gin.Context, agent *models.Agent, kind, action, path string) *models.AgentSession {
+	return a.remoteService.StartSession(a.userID(c), c.GetString("username"), agent, kind, action, path, c.ClientIP())
+}
+
+// fileRequest 记录并执行文件操作，失败时写入错误响应并返回 nil
+func (a *agentAccess) fileRequest(c *gin.Context, agent *models.Agent, req *services.RemoteFileRequest) *services.RemoteFileResponse {
+	session := a.startSession(c, agent, constant.AgentSessionFile, req.Op, req.Path)
+	resp, err := a.remoteService.FileRequest(agent.ID, req)
+	if err != nil {
+		a.remoteService.EndSession(session, err.Error())
+		utils.ServerError(c, err.Error())
+		return nil
+	}
+	return resp
+}
+
+func (fc *FileController) remoteTree(c *gin.Context) {
+	agent := fc.access.resolve(c)
+	if agent == nil {
+		return
+	}
+	// 浏览文件树较频繁，不计入审计
+	resp, err := fc.access.remoteService.FileRequest(agent.ID, &services.RemoteFileRequest{Op: services.RemoteFileTree})
+	if err != nil {
+		utils.ServerError(c, err.Error())
+		return
+	}
+	utils.Success(c, resp.Tree)
+}
+
+func (fc *FileController) remoteContent(c *gin.Context) {
+	filePath := c.Query("path")
+	if filePath == "" {
+		utils.BadRequest(c, "path参数必填")
+		return
+	}
+	agent := fc.access.resolve(c)
+	if agent == nil {
+		return
+	}
+	resp := fc.access.fileRequest(c, agent, &services.RemoteFileRequest{Op: services.RemoteFileRead, Path: filePath})
+	if resp == nil {
+		return
+	}
+	utils.Success(c, gin.H{
+		"path":    filePath,
+		"content": string(resp.Content),
+	})
+}
+
+func (fc *FileController) remoteDownload(c *gin.Context) {
+	filePath := c.Query("path")
+	if filePath == "" {
+		utils.BadRequest(c, "path参数必填")
+		return
+	}
+	agent := fc.access.resolve(c)
+	if agent == nil {
+		return
+	}
+	resp := fc.access.fileRequest(c, agent, &services.RemoteFileRequest{Op: services.RemoteFileRead, Path: filePath})
+	if resp == nil {
+		return
+	}
+	c.Header("Content-Description", "File Transfer")
+	c.Header("Content-Transfer-Encoding", "binary")
+	c.Header("Content-Disposition", "attachment; filename="+filepath.Base(filePath))
+	c.Data(http.StatusOK, "application/octet-stream", resp.Content)
+}
+
+func (fc *FileController) remoteSave(c *gin.Context) {
+	var req struct {
+		Path    string `json:"path" binding:"required"`
+		Content string `json:"content"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+	if len(req.Content) > services.RemoteFileMaxSize {
+		utils.BadRequest(c, "文件过大")
+		return
+	}
+	agent := fc.access.resolve(c)
+	if agent == nil {
+		return
+	}
+	if fc.access.fileRequest(c, agent, &services.RemoteFileRequest{Op: services.RemoteFileWrite, Path: req.Path, Content: []byte(req.Content)}) == nil {
+		return
+	}
+	utils.SuccessMsg(c, "保存成功")
+}
+
+func (fc *FileController) remoteCreate(c *gin.Context) {
+	var req struct {
+		Path  string `json:"path" binding:"required"`
+		IsDir bool   `json:"isDir"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+	agent := fc.access.resolve(c)
+	if agent == nil {
+		return
+	}
+	if fc.access.fileRequest(c, agent, &services.RemoteFileRequest{Op: services.RemoteFileCreate, Path: req.Path, IsDir: req.IsDir}) == nil {
+		return
+	}
+	utils.SuccessMsg(c, "创建成功")
+}
+
+func (fc *FileController) remoteDelete(c *gin.Context) {
+	var req struct {
+		Path string `json:"path" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+	agent := fc.access.resolve(c)
+	if agent == nil {
+		return
+	}
+	if fc.access.fileRequest(c, agent, &services.RemoteFileRequest{Op: services.RemoteFileDelete, Path: req.Path}) == nil {
+		return
+	}
+	utils.SuccessMsg(c, "删除成功")
+}
+
+func (fc *FileController) remoteUpload(c *gin.Context) {
+	agent := fc.access.resolve(c)
+	if agent == nil {
+		return
+	}
+	form, err := c.MultipartForm()
+	if err != nil || len(form.File["files"]) == 0 {
+		utils.BadRequest(c, "请选择文件")
+		return
+	}
+	paths := form.Value["paths"] // 相对路径数组，用于保持文件夹结构
+
+	var files []services.RemoteFile
+	total := int64(0)
+	for i, file := range form.File["files"] {
+		total += file.Size
+		if total > services.RemoteFileMaxSize {
+			utils.BadRequest(c, "上传到 Agent 的文件总大小不能超过 8MB")
+			return
+		}
+		relPath := file.Filename
+		if i < len(paths) && paths[i] != "" {
+			relPath = paths[i]
+		}
+		f, err := file.Open()
+		if err != nil {
+			utils.ServerError(c, "读取文件失败: "+err.Error())
+			return
+		}
+		content, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			utils.ServerError(c, "读取文件失败: "+err.Error())
+			return
+		}
+		files = append(files, services.RemoteFile{Path: relPath, Content: content})
+	}
+
+	req := &services.RemoteFileRequest{Op: services.RemoteFileUpload, Path: c.PostForm("path"), Files: files}
+	if fc.access.fileRequest(c, agent, req) == nil {
+		return
+	}
+	utils.SuccessMsg(c, "上传成功")
+}
+
+// ListSessions 获取通过面板访问 Agent 的记录
+func (c *AgentController) ListSessions(ctx *gin.Context) {
+	p := utils.ParsePagination(ctx)
+	agentID, _ := strconv.ParseUint(ctx.Query("agent_id"), 10, 32)
+	sessions, total := c.remoteService.ListSessions(p.Page, p.PageSize, uint(agentID))
+	utils.PaginatedResponse(ctx, sessions, total, p)
+}
@@ -0,0 +1,83 @@
+package controllers
+
+import (
+	"strconv"
+
+	"github.com/engigu/baihu-panel/internal/services"
+	"github.com/engigu/baihu-panel/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListRollouts 获取升级计划列表
+func (c *AgentController) ListRollouts(ctx *gin.Context) {
+	utils.Success(ctx, c.rolloutService.List())
+}
+
+// CreateRollout 创建升级计划
+func (c *AgentController) CreateRollout(ctx *gin.Context) {
+	var req services.CreateRolloutRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(ctx, "参数错误")
+		return
+	}
+
+	rollout, err := c.rolloutService.Create(&req)
+	if err != nil {
+		utils.BadRequest(ctx, err.Error())
+		return
+	}
+	utils.Success(ctx, rollout)
+}
+
+// GetRollout 获取升级计划详情及每个 Agent 的进度
+func (c *AgentController) GetRollout(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		utils.BadRequest(ctx, "无效的 ID")
+		return
+	}
+
+	rollout, targets, err := c.rolloutService.Get(uint(id))
+	if err != nil {
+		utils.NotFound(ctx, err.Error())
+		return
+	}
+	utils.Success(ctx, gin.H{
+		"rollout": rollout,
+		"targets": targets,
+	})
+}
+
+// PauseRollout 暂停升级计划
+func (c *AgentController) PauseRollout(ctx *gin.Context) {
+	c.rolloutAction(ctx, c.rolloutService.Pause, "已暂停")
+}
+
+// ResumeRollout 恢复升级计划
+func (c *AgentController) ResumeRollout(ctx *gin.Context) {
+	c.rolloutAction(ctx, c.rolloutService.Resume, "已恢复")
+}
+
+// CancelRollout 取消升级计划
+func (c *AgentController) CancelRollout(ctx *gin.Context) {
+	c.rolloutAction(ctx, c.rolloutService.Cancel, "已取消")
+}
+
+// DeleteRollout 删除升级计划
+func (c *AgentController) DeleteRollout(ctx *gin.Context) {
+	c.rolloutAction(ctx, c.rolloutService.Delete, "删除成功")
+}
+
+func (c *AgentController) rolloutAction(ctx *gin.Context, action func(uint) error, msg string) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		utils.BadRequest(ctx, "无效的 ID")
+		return
+	}
+	if err := action(uint(id)); err != nil {
+		utils.BadRequest(ctx, err.Error())
+		return
+	}
+	utils.SuccessMsg(ctx, msg)
+}
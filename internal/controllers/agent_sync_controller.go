@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"strconv"
+
+	"github.com/engigu/baihu-panel/internal/models"
+	"github.com/engigu/baihu-panel/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type syncMappingRequest struct {
+	Source  string `json:"source"`
+	Target  string `json:"target" binding:"required"`
+	Delete  bool   `json:"delete"`
+	Enabled *bool  `json:"enabled"`
+}
+
+func (r *syncMappingRequest) apply(m *models.AgentSyncMapping) {
+	m.Source = r.Source
+	m.Target = r.Target
+	m.Delete = r.Delete
+	if r.Enabled != nil {
+		m.Enabled = *r.Enabled
+	}
+}
+
+// ListSyncMappings 获取 Agent 的脚本同步目录映射
+func (c *AgentController) ListSyncMappings(ctx *gin.Context) {
+	agent := c.getAgent(ctx)
+	if agent == nil {
+		return
+	}
+	utils.Success(ctx, c.syncService.ListMappings(agent.ID))
+}
+
+// CreateSyncMapping 创建目录映射
+func (c *AgentController) CreateSyncMapping(ctx *gin.Context) {
+	agent := c.getAgent(ctx)
+	if agent == nil {
+		return
+	}
+
+	var req syncMappingRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(ctx, "参数错误")
+		return
+	}
+
+	m := &models.AgentSyncMapping{AgentID: agent.ID, Enabled: true}
+	req.apply(m)
+	if err := c.syncService.SaveMapping(m); err != nil {
+		utils.BadRequest(ctx, err.Error())
+		return
+	}
+	utils.Success(ctx, m)
+}
+
+// UpdateSyncMapping 更新目录映射
+func (c *AgentController) UpdateSyncMapping(ctx *gin.Context) {
+	m := c.getSyncMapping(ctx)
+	if m == nil {
+		return
+	}
+
+	var req syncMappingRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(ctx, "参数错误")
+		return
+	}
+
+	req.apply(m)
+	if err := c.syncService.SaveMapping(m); err != nil {
+		utils.BadRequest(ctx, err.Error())
+		return
+	}
+	utils.Success(ctx, m)
+}
+
+// DeleteSyncMapping 删除目录映射，已同步到 Agent 的文件保留
+func (c *AgentController) DeleteSyncMapping(ctx *gin.Context) {
+	m := c.getSyncMapping(ctx)
+	if m == nil {
+		return
+	}
+	if err := c.syncService.DeleteMapping(m.ID); err != nil {
+		utils.ServerError(ctx, err.Error())
+		return
+	}
+	utils.SuccessMsg(ctx, "删除成功")
+}
+
+// RunSync 立即同步 Agent 的全部映射，结果记录在各映射上
+func (c *AgentController) RunSync(ctx *gin.Context) {
+	agent := c.getAgent(ctx)
+	if agent == nil {
+		return
+	}
+	if !c.wsManager.IsOnline(agent.ID) {
+		utils.BadRequest(ctx, "Agent 不在线")
+		return
+	}
+	go c.syncService.Sync(agent.ID, "")
+	utils.SuccessMsg(ctx, "已开始同步")
+}
+
+func (c *AgentController) getAgent(ctx *gin.Context) *models.Agent {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		utils.BadRequest(ctx, "无效的 ID")
+		return nil
+	}
+	agent := c.agentService.GetByID(uint(id))
+	if agent == nil {
+		utils.NotFound(ctx, "Agent 不存在")
+		return nil
+	}
+	return agent
+}
+
+func (c *AgentController) getSyncMapping(ctx *gin.Context) *models.AgentSyncMapping {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		utils.BadRequest(ctx, "无效的 ID")
+		return nil
+	}
+	m := c.syncService.GetMapping(uint(id))
+	if m == nil {
+		utils.NotFound(ctx, "目录映射不存在")
+		return nil
+	}
+	return m
+}
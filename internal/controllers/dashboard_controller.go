@@ -8,6 +8,7 @@ import (
 	"github.com/engigu/baihu-panel/internal/database"
 	"github.com/engigu/baihu-panel/internal/models"
 	"github.com/engigu/baihu-panel/internal/services/tasks"
+	"github.com/engigu/baihu-panel/internal/services/telemetry"
 	"github.com/engigu/baihu-panel/internal/utils"
 
 	"github.com/gin-gonic/gin"
@@ -55,9 +56,8 @@ func (dc *DashboardController) GetStats(c *gin.Context) {
 
 	totalScheduled := localScheduled + int(agentScheduled)
 
-	// 正在运行：目前只能统计本地运行的任务
-	// Agent 端的运行状态需要通过心跳上报（未来优化）
-	running := dc.executorService.GetRunningCount()
+	// 正在运行：本地运行的任务 + Agent 心跳上报的运行任务数
+	running := dc.executorService.GetRunningCount() + telemetry.TotalRunning()
 
 	stats := StatsResponse{
 		Tasks:      taskCount,
@@ -71,6 +71,96 @@ func (dc *DashboardController) GetStats(c *gin.Context) {
 	utils.Success(c, stats)
 }
 
+// TaskHealthItem 非健康任务信息
+type TaskHealthItem struct {
+	ID           uint              `json:"id"`
+	Name         string            `json:"name"`
+	Health       string            `json:"health"`
+	HealthReason string            `json:"health_reason"`
+	LastRun      *models.LocalTime `json:"last_run"`
+}
+
+// TaskHealthResponse 任务健康概览
+type TaskHealthResponse struct {
+	Healthy  int64            `json:"healthy"`
+	Degraded int64            `json:"degraded"`
+	Failing  int64            `json:"failing"`
+	Tasks    []TaskHealthItem `json:"tasks"` // 非健康任务，failing 在前
+}
+
+// GetTaskHealth 获取任务健康概览
+func (dc *DashboardController) GetTaskHealth(c *gin.Context) {
+	var resp TaskHealthResponse
+	database.DB.Model(&models.Task{}).Where("health = ?", constant.TaskHealthDegraded).Count(&resp.Degraded)
+	database.DB.Model(&models.Task{}).Where("health = ?", constant.TaskHealthFailing).Count(&resp.Failing)
+	var total int64
+	database.DB.Model(&models.Task{}).Count(&total)
+	resp.Healthy = total - resp.Degraded - resp.Failing
+
+	var list []models.Task
+	database.DB.Select("id", "name", "health", "health_reason", "last_run").
+		Where("health IN ?", []string{constant.TaskHealthFailing, constant.TaskHealthDegraded}).
+		Order("id DESC").Limit(50).Find(&list)
+	resp.Tasks = make([]TaskHealthItem, 0, len(list))
+	for _, t := range list {
+		resp.Tasks = append(resp.Tasks, TaskHealthItem{
+			ID: t.ID, Name: t.Name, Health: t.Health, HealthReason: t.HealthReason, LastRun: t.LastRun,
+		})
+	}
+	sort.SliceStable(resp.Tasks, func(i, j int) bool {
+		return resp.Tasks[i].Health == constant.TaskHealthFailing && resp.Tasks[j].Health != constant.TaskHealthFailing
+	})
+
+	utils.Success(c, resp)
+}
+
+// MissedRunItem 漏执行记录
+type MissedRunItem struct {
+	models.MissedRun
+	TaskName string `json:"task_name"`
+}
+
+// GetMissedRuns 获取最近的漏执行记录，可按 task_id 筛选
+func (dc *DashboardController) GetMissedRuns(c *gin.Context) {
+	limit := 20
+	if l := c.Query("limit"); l != "" {
+		if parsed, err := utils.ParseInt(l); err == nil && parsed > 0 && parsed <= 200 {
+			limit = parsed
+		}
+	}
+
+	query := database.DB.Order("id DESC").Limit(limit)
+	if taskID := c.Query("task_id"); taskID != "" {
+		id, err := utils.ParseInt(taskID)
+		if err != nil {
+			utils.BadRequest(c, "参数错误")
+			return
+		}
+		query = query.Where("task_id = ?", id)
+	}
+	var records []models.MissedRun
+	query.Find(&records)
+
+	ids := make([]uint, 0, len(records))
+	for _, r := range records {
+		ids = append(ids, r.TaskID)
+	}
+	var taskList []models.Task
+	if len(ids) > 0 {
+		database.DB.Unscoped().Select("id", "name").Where("id IN ?", ids).Find(&taskList)
+	}
+	names := make(map[uint]string, len(taskList))
+	for _, t := range taskList {
+		names[t.ID] = t.Name
+	}
+
+	items := make([]MissedRunItem, 0, len(records))
+	for _, r := range records {
+		items = append(items, MissedRunItem{MissedRun: r, TaskName: names[r.TaskID]})
+	}
+	utils.Success(c, items)
+}
+
 // GetSentence 获取随机古诗词
 func (dc *DashboardController) GetSentence(c *gin.Context) {
 	utils.Success(c, gin.H{
@@ -83,6 +173,7 @@ type DailyStats struct {
 	Day     string `json:"day"`
 	Total   int    `json:"total"`
 	Success int    `json:"success"`
+	Warning int    `json:"warning"`
 	Failed  int    `json:"failed"`
 }
 
@@ -113,6 +204,8 @@ func (dc *DashboardController) GetSendStats(c *gin.Context) {
 		ds.Total += s.Num
 		if s.Status == constant.TaskStatusSuccess {
 			ds.Success += s.Num
+		} else if s.Status == constant.TaskStatusWarning {
+			ds.Warning += s.Num
 		} else {
 			ds.Failed += s.Num
 		}
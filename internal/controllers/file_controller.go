@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/engigu/baihu-panel/internal/services"
 	"github.com/engigu/baihu-panel/internal/utils"
 
 	"github.com/gin-gonic/gin"
@@ -19,15 +20,19 @@ var (
 
 type FileController struct {
 	workDir string
+	access  *agentAccess
 }
 
-func NewFileController(workDir string) *FileController {
+func NewFileController(workDir string, remoteService *services.AgentRemoteService, agentService *services.AgentService, userService *services.UserService) *FileController {
 	os.MkdirAll(workDir, 0755)
 	absPath, err := filepath.Abs(workDir)
 	if err != nil {
 		absPath = workDir
 	}
-	return &FileController{workDir: absPath}
+	return &FileController{
+		workDir: absPath,
+		access:  newAgentAccess(remoteService, agentService, userService),
+	}
 }
 
 type FileNode struct {
@@ -60,6 +65,11 @@ func (fc *FileController) checkPath(path string, allowRoot bool) (string, bool)
 }
 
 func (fc *FileController) GetFileTree(c *gin.Context) {
+	if isAgentRequest(c) {
+		fc.remoteTree(c)
+		return
+	}
+
 	root := &FileNode{
 		Name:     filepath.Base(fc.workDir),
 		Path:     "",
@@ -120,6 +130,11 @@ func (fc *FileController) GetFileTree(c *gin.Context) {
 }
 
 func (fc *FileController) GetFileContent(c *gin.Context) {
+	if isAgentRequest(c) {
+		fc.remoteContent(c)
+		return
+	}
+
 	filePath := c.Query("path")
 	if filePath == "" {
 		utils.BadRequest(c, "path参数必填")
@@ -145,6 +160,11 @@ func (fc *FileController) GetFileContent(c *gin.Context) {
 }
 
 func (fc *FileController) SaveFileContent(c *gin.Context) {
+	if isAgentRequest(c) {
+		fc.remoteSave(c)
+		return
+	}
+
 	var req struct {
 		Path    string `json:"path" binding:"required"`
 		Content string `json:"content"`
@@ -172,6 +192,11 @@ func (fc *FileController) SaveFileContent(c *gin.Context) {
 }
 
 func (fc *FileController) CreateFile(c *gin.Context) {
+	if isAgentRequest(c) {
+		fc.remoteCreate(c)
+		return
+	}
+
 	var req struct {
 		Path  string `json:"path" binding:"required"`
 		IsDir bool   `json:"isDir"`
@@ -205,6 +230,11 @@ func (fc *FileController) CreateFile(c *gin.Context) {
 }
 
 func (fc *FileController) DeleteFile(c *gin.Context) {
+	if isAgentRequest(c) {
+		fc.remoteDelete(c)
+		return
+	}
+
 	var req struct {
 		Path string `json:"path" binding:"required"`
 	}
@@ -229,6 +259,11 @@ func (fc *FileController) DeleteFile(c *gin.Context) {
 }
 
 func (fc *FileController) MoveFile(c *gin.Context) {
+	if isAgentRequest(c) {
+		utils.BadRequest(c, "Agent 暂不支持该操作")
+		return
+	}
+
 	var req struct {
 		OldPath string `json:"oldPath" binding:"required"`
 		NewPath string `json:"newPath" binding:"required"`
@@ -270,6 +305,11 @@ func (fc *FileController) MoveFile(c *gin.Context) {
 }
 
 func (fc *FileController) RenameFile(c *gin.Context) {
+	if isAgentRequest(c) {
+		utils.BadRequest(c, "Agent 暂不支持该操作")
+		return
+	}
+
 	var req struct {
 		OldPath string `json:"oldPath" binding:"required"`
 		NewPath string `json:"newPath" binding:"required"`
@@ -315,6 +355,11 @@ func (fc *FileController) RenameFile(c *gin.Context) {
 
 // UploadArchive 处理归档文件的上传和解压
 func (fc *FileController) UploadArchive(c *gin.Context) {
+	if isAgentRequest(c) {
+		utils.BadRequest(c, "Agent 暂不支持该操作")
+		return
+	}
+
 	targetDir := c.PostForm("path")
 
 	file, err := c.FormFile("file")
@@ -367,6 +412,11 @@ func (fc *FileController) UploadArchive(c *gin.Context) {
 
 // UploadFiles 处理多个文件的上传
 func (fc *FileController) UploadFiles(c *gin.Context) {
+	if isAgentRequest(c) {
+		fc.remoteUpload(c)
+		return
+	}
+
 	targetDir := c.PostForm("path")
 
 	// 确定目标目录
@@ -418,6 +468,11 @@ func (fc *FileController) UploadFiles(c *gin.Context) {
 }
 
 func (fc *FileController) DownloadFile(c *gin.Context) {
+	if isAgentRequest(c) {
+		fc.remoteDownload(c)
+		return
+	}
+
 	filePath := c.Query("path")
 	if filePath == "" {
 		utils.BadRequest(c, "path参数必填")
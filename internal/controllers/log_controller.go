@@ -2,19 +2,28 @@ package controllers
 
 import (
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/engigu/baihu-panel/internal/database"
+	"github.com/engigu/baihu-panel/internal/logger"
 	"github.com/engigu/baihu-panel/internal/models"
 	"github.com/engigu/baihu-panel/internal/models/vo"
+	"github.com/engigu/baihu-panel/internal/services/tasks"
+	"github.com/engigu/baihu-panel/internal/systime"
 	"github.com/engigu/baihu-panel/internal/utils"
 
 	"github.com/gin-gonic/gin"
 )
 
-type LogController struct{}
+type LogController struct {
+	taskLogService *tasks.TaskLogService
+}
 
 func NewLogController() *LogController {
-	return &LogController{}
+	return &LogController{
+		taskLogService: tasks.NewTaskLogService(nil),
+	}
 }
 
 func (lc *LogController) GetLogs(c *gin.Context) {
@@ -65,17 +74,18 @@ func (lc *LogController) GetLogs(c *gin.Context) {
 			taskType = "task"
 		}
 		result[i] = vo.TaskLogVO{
-			ID:        log.ID,
-			TaskID:    log.TaskID,
-			TaskName:  task.Name,
-			TaskType:  taskType,
-			AgentID:   log.AgentID,
-			Command:   log.Command,
-			Status:    log.Status,
-			Duration:  log.Duration,
-			StartTime: log.StartTime,
-			EndTime:   log.EndTime,
-			CreatedAt: log.CreatedAt,
+			ID:           log.ID,
+			TaskID:       log.TaskID,
+			TaskName:     task.Name,
+			TaskType:     taskType,
+			AgentID:      log.AgentID,
+			DispatchNote: log.DispatchNote,
+			Command:      log.Command,
+			Status:       log.Status,
+			Duration:     log.Duration,
+			StartTime:    log.StartTime,
+			EndTime:      log.EndTime,
+			CreatedAt:    log.CreatedAt,
 		}
 	}
 
@@ -97,3 +107,129 @@ func (lc *LogController) GetLogDetail(c *gin.Context) {
 
 	utils.Success(c, vo.ToTaskLogVO(&log))
 }
+
+// GetLogLines 解析带时间戳和流标记的日志，返回每行的相对时间，可按 stream=stdout/stderr 过滤
+// 运行中的任务仅返回最近的部分内容
+func (lc *LogController) GetLogLines(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		utils.BadRequest(c, "无效的日志ID")
+		return
+	}
+
+	stream := c.Query("stream")
+	if stream != "" && stream != "stdout" && stream != "stderr" {
+		utils.BadRequest(c, "无效的输出流")
+		return
+	}
+
+	var log models.TaskLog
+	if err := database.DB.First(&log, id).Error; err != nil {
+		utils.NotFound(c, "日志不存在")
+		return
+	}
+	if log.LogFormat != tasks.LogFormatTagged {
+		utils.BadRequest(c, "该日志未开启行时间戳")
+		return
+	}
+
+	var content string
+	if tl := tasks.GetActiveLog(log.ID); tl != nil {
+		data, err := tl.ReadLastLines(1000)
+		if err != nil {
+			utils.ServerError(c, "读取日志失败")
+			return
+		}
+		content = string(data)
+	} else {
+		content, err = utils.DecompressFromBase64(log.Output)
+		if err != nil {
+			utils.ServerError(c, "解压日志失败")
+			return
+		}
+	}
+
+	var base time.Time
+	if log.StartTime != nil {
+		base = log.StartTime.Time()
+	}
+	utils.Success(c, tasks.ParseTaggedLog(content, base, stream))
+}
+
+// ExportLogs 按条件流式导出任务日志（ndjson / csv / tar.gz）
+func (lc *LogController) ExportLogs(c *gin.Context) {
+	format := c.DefaultQuery("format", tasks.ExportFormatNDJSON)
+	if !tasks.IsValidExportFormat(format) {
+		utils.BadRequest(c, "不支持的导出格式")
+		return
+	}
+
+	var filter tasks.ExportFilter
+	if v := c.Query("task_id"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			utils.BadRequest(c, "无效的任务ID")
+			return
+		}
+		filter.TaskID = uint(id)
+	}
+	if v := c.Query("agent_id"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			utils.BadRequest(c, "无效的 Agent ID")
+			return
+		}
+		agentID := uint(id)
+		filter.AgentID = &agentID
+	}
+	filter.Status = c.Query("status")
+	for _, item := range []struct {
+		key    string
+		target **time.Time
+		endDay bool
+	}{{"start", &filter.Start, false}, {"end", &filter.End, true}} {
+		v := c.Query(item.key)
+		if v == "" {
+			continue
+		}
+		t, err := parseExportTime(v, item.endDay)
+		if err != nil {
+			utils.BadRequest(c, "时间格式错误: "+v)
+			return
+		}
+		*item.target = &t
+	}
+
+	filename := "task_logs_" + systime.FormatDatetime(time.Now()) + "." + format
+	contentType := "application/x-ndjson"
+	switch format {
+	case tasks.ExportFormatCSV:
+		contentType = "text/csv; charset=utf-8"
+	case tasks.ExportFormatTarGz:
+		contentType = "application/gzip"
+	}
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	c.Header("Content-Type", contentType)
+	c.Status(200)
+
+	// 响应头已发送，后续错误只能记录日志
+	if err := lc.taskLogService.ExportLogs(c.Writer, filter, format); err != nil {
+		logger.Errorf("[Log] 导出日志失败: %v", err)
+	}
+}
+
+// parseExportTime 解析 "2006-01-02 15:04:05" 或 "2006-01-02"，仅日期的结束时间取当天末尾
+func parseExportTime(v string, endDay bool) (time.Time, error) {
+	v = strings.TrimSpace(v)
+	if t, err := time.ParseInLocation(models.TimeFormat, v, systime.CST); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", v, systime.CST)
+	if err != nil {
+		return t, err
+	}
+	if endDay {
+		t = t.AddDate(0, 0, 1).Add(-time.Second)
+	}
+	return t, nil
+}
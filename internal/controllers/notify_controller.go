@@ -0,0 +1,323 @@
+package controllers
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/engigu/baihu-panel/internal/constant"
+	"github.com/engigu/baihu-panel/internal/models"
+	"github.com/engigu/baihu-panel/internal/services"
+	"github.com/engigu/baihu-panel/internal/services/notify"
+	"github.com/engigu/baihu-panel/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type NotifyController struct {
+	notifyService   *notify.NotifyService
+	settingsService *services.SettingsService
+}
+
+func NewNotifyController(settingsService *services.SettingsService) *NotifyController {
+	return &NotifyController{
+		notifyService:   notify.GetNotifyService(),
+		settingsService: settingsService,
+	}
+}
+
+// GetTypes 获取支持的渠道类型及配置字段
+func (nc *NotifyController) GetTypes(c *gin.Context) {
+	utils.Success(c, gin.H{
+		"types":          notify.ChannelTypes(),
+		"events":         notify.AllEvents,
+		"title_template": notify.DefaultTitleTemplate,
+		"body_template":  notify.DefaultBodyTemplate,
+	})
+}
+
+type notifyChannelRequest struct {
+	Name          string `json:"name" binding:"required"`
+	Type          string `json:"type" binding:"required"`
+	Config        string `json:"config"`
+	TitleTemplate string `json:"title_template"`
+	BodyTemplate  string `json:"body_template"`
+	Enabled       *bool  `json:"enabled"`
+}
+
+// apply 将请求写入渠道，仍为占位值的密钥配置项沿用渠道已保存的值
+func (r *notifyChannelRequest) apply(channel *models.NotifyChannel) {
+	if r.Type == channel.Type {
+		channel.Config = notify.KeepSecrets(r.Type, r.Config, channel.Config)
+	} else {
+		channel.Config = r.Config
+	}
+	channel.Name = r.Name
+	channel.Type = r.Type
+	channel.TitleTemplate = r.TitleTemplate
+	channel.BodyTemplate = r.BodyTemplate
+	if r.Enabled != nil {
+		channel.Enabled = *r.Enabled
+	}
+}
+
+// ListChannels 获取通知渠道列表
+func (nc *NotifyController) ListChannels(c *gin.Context) {
+	utils.Success(c, notify.ListChannels())
+}
+
+// CreateChannel 创建通知渠道
+func (nc *NotifyController) CreateChannel(c *gin.Context) {
+	var req notifyChannelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+
+	channel := &models.NotifyChannel{Enabled: true}
+	req.apply(channel)
+	if err := notify.SaveChannel(channel); err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+	utils.Success(c, notify.MaskChannel(*channel))
+}
+
+// UpdateChannel 更新通知渠道
+func (nc *NotifyController) UpdateChannel(c *gin.Context) {
+	channel := nc.getChannel(c)
+	if channel == nil {
+		return
+	}
+
+	var req notifyChannelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+
+	req.apply(channel)
+	if err := notify.SaveChannel(channel); err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+	utils.Success(c, notify.MaskChannel(*channel))
+}
+
+// DeleteChannel 删除通知渠道（同时删除关联规则）
+func (nc *NotifyController) DeleteChannel(c *gin.Context) {
+	channel := nc.getChannel(c)
+	if channel == nil {
+		return
+	}
+	if err := notify.DeleteChannel(channel.ID); err != nil {
+		utils.ServerError(c, err.Error())
+		return
+	}
+	utils.SuccessMsg(c, "删除成功")
+}
+
+// TestChannel 发送测试通知
+// 请求体不为空时使用请求中的配置（便于保存前测试），否则使用已保存的配置
+func (nc *NotifyController) TestChannel(c *gin.Context) {
+	channel := nc.getChannel(c)
+	if channel == nil {
+		return
+	}
+
+	var req notifyChannelRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.BadRequest(c, err.Error())
+			return
+		}
+		req.apply(channel)
+	}
+	if err := notify.ValidateChannel(channel); err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+	if err := nc.notifyService.SendTest(channel); err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+	utils.SuccessMsg(c, "发送成功")
+}
+
+func (nc *NotifyController) getChannel(c *gin.Context) *models.NotifyChannel {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		utils.BadRequest(c, "无效的渠道ID")
+		return nil
+	}
+	channel := notify.GetChannel(uint(id))
+	if channel == nil {
+		utils.NotFound(c, "通知渠道不存在")
+		return nil
+	}
+	return channel
+}
+
+type notifyRuleRequest struct {
+	TaskID    uint   `json:"task_id"`
+	ChannelID uint   `json:"channel_id" binding:"required"`
+	Events    string `json:"events" binding:"required"`
+	Enabled   *bool  `json:"enabled"`
+}
+
+func (r *notifyRuleRequest) apply(rule *models.NotifyRule) {
+	rule.TaskID = r.TaskID
+	rule.ChannelID = r.ChannelID
+	rule.Events = r.Events
+	if r.Enabled != nil {
+		rule.Enabled = *r.Enabled
+	}
+}
+
+// ListRules 获取通知规则列表，支持 ?task_id= 过滤
+func (nc *NotifyController) ListRules(c *gin.Context) {
+	var taskID *uint
+	if v := c.Query("task_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			utils.BadRequest(c, "无效的任务ID")
+			return
+		}
+		tid := uint(id)
+		taskID = &tid
+	}
+	utils.Success(c, notify.ListRules(taskID))
+}
+
+// CreateRule 创建通知规则
+func (nc *NotifyController) CreateRule(c *gin.Context) {
+	var req notifyRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+
+	rule := &models.NotifyRule{Enabled: true}
+	req.apply(rule)
+	if err := notify.SaveRule(rule); err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+	utils.Success(c, rule)
+}
+
+// UpdateRule 更新通知规则
+func (nc *NotifyController) UpdateRule(c *gin.Context) {
+	rule := nc.getRule(c)
+	if rule == nil {
+		return
+	}
+
+	var req notifyRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+
+	req.apply(rule)
+	if err := notify.SaveRule(rule); err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+	utils.Success(c, rule)
+}
+
+// DeleteRule 删除通知规则
+func (nc *NotifyController) DeleteRule(c *gin.Context) {
+	rule := nc.getRule(c)
+	if rule == nil {
+		return
+	}
+	if err := notify.DeleteRule(rule.ID); err != nil {
+		utils.ServerError(c, err.Error())
+		return
+	}
+	utils.SuccessMsg(c, "删除成功")
+}
+
+func (nc *NotifyController) getRule(c *gin.Context) *models.NotifyRule {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		utils.BadRequest(c, "无效的规则ID")
+		return nil
+	}
+	rule := notify.GetRule(uint(id))
+	if rule == nil {
+		utils.NotFound(c, "通知规则不存在")
+		return nil
+	}
+	return rule
+}
+
+// SendScriptNotify 供任务脚本调用的通知接口（sendNotify），使用单次执行的令牌认证
+// 令牌只从 Authorization 请求头读取，避免出现在访问日志和代理日志的地址中
+func (nc *NotifyController) SendScriptNotify(c *gin.Context) {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		utils.Unauthorized(c, "缺少通知令牌")
+		return
+	}
+
+	var req struct {
+		Title   string `json:"title"`
+		Content string `json:"content"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+
+	if err := notify.SendScriptNotify(token, req.Title, req.Content); err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+	utils.SuccessMsg(c, "发送成功")
+}
+
+// GetScriptSettings 获取脚本通知的内置渠道配置，密钥配置项已隐藏
+func (nc *NotifyController) GetScriptSettings(c *gin.Context) {
+	utils.Success(c, gin.H{
+		"webhook": notify.MaskConfig(notify.ChannelWebhook, nc.settingsService.Get(constant.SectionScriptNotify, constant.KeyScriptNotifyWebhook)),
+		"smtp":    notify.MaskConfig(notify.ChannelSMTP, nc.settingsService.Get(constant.SectionScriptNotify, constant.KeyScriptNotifySMTP)),
+	})
+}
+
+// UpdateScriptSettings 更新脚本通知的内置渠道配置，空字符串表示不启用
+func (nc *NotifyController) UpdateScriptSettings(c *gin.Context) {
+	var req struct {
+		Webhook string `json:"webhook"`
+		SMTP    string `json:"smtp"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+	// 仍为占位值的密钥配置项沿用已保存的值
+	req.Webhook = notify.KeepSecrets(notify.ChannelWebhook, req.Webhook,
+		nc.settingsService.Get(constant.SectionScriptNotify, constant.KeyScriptNotifyWebhook))
+	req.SMTP = notify.KeepSecrets(notify.ChannelSMTP, req.SMTP,
+		nc.settingsService.Get(constant.SectionScriptNotify, constant.KeyScriptNotifySMTP))
+
+	if err := notify.ValidateScriptChannelConfig(notify.ChannelWebhook, req.Webhook); err != nil {
+		utils.BadRequest(c, "Webhook 配置错误: "+err.Error())
+		return
+	}
+	if err := notify.ValidateScriptChannelConfig(notify.ChannelSMTP, req.SMTP); err != nil {
+		utils.BadRequest(c, "SMTP 配置错误: "+err.Error())
+		return
+	}
+
+	if err := nc.settingsService.Set(constant.SectionScriptNotify, constant.KeyScriptNotifyWebhook, req.Webhook); err != nil {
+		utils.ServerError(c, err.Error())
+		return
+	}
+	if err := nc.settingsService.Set(constant.SectionScriptNotify, constant.KeyScriptNotifySMTP, req.SMTP); err != nil {
+		utils.ServerError(c, err.Error())
+		return
+	}
+	utils.SuccessMsg(c, "保存成功")
+}
@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"strconv"
 
@@ -22,20 +23,22 @@ import (
 )
 
 type SettingsController struct {
-	userService     *services.UserService
-	settingsService *services.SettingsService
-	loginLogService *services.LoginLogService
-	backupService   *services.BackupService
-	executorService *tasks.ExecutorService
+	userService      *services.UserService
+	settingsService  *services.SettingsService
+	loginLogService  *services.LoginLogService
+	backupService    *services.BackupService
+	executorService  *tasks.ExecutorService
+	retentionService *services.LogRetentionService
 }
 
-func NewSettingsController(userService *services.UserService, loginLogService *services.LoginLogService, executorService *tasks.ExecutorService) *SettingsController {
+func NewSettingsController(userService *services.UserService, loginLogService *services.LoginLogService, executorService *tasks.ExecutorService, retentionService *services.LogRetentionService) *SettingsController {
 	return &SettingsController{
-		userService:     userService,
-		settingsService: services.NewSettingsService(),
-		loginLogService: loginLogService,
-		backupService:   services.NewBackupService(),
-		executorService: executorService,
+		userService:      userService,
+		settingsService:  services.NewSettingsService(),
+		loginLogService:  loginLogService,
+		backupService:    services.NewBackupService(),
+		executorService:  executorService,
+		retentionService: retentionService,
 	}
 }
 
@@ -166,6 +169,152 @@ func (sc *SettingsController) UpdateSchedulerSettings(c *gin.Context) {
 	utils.SuccessMsg(c, "保存成功")
 }
 
+// GetRetentionSettings 获取日志保留策略及最近一次清理报告
+func (sc *SettingsController) GetRetentionSettings(c *gin.Context) {
+	utils.Success(c, gin.H{
+		"settings": sc.settingsService.GetSection(constant.SectionRetention),
+		"report":   sc.retentionService.GetLastReport(),
+	})
+}
+
+// UpdateRetentionSettings 更新日志保留策略
+func (sc *SettingsController) UpdateRetentionSettings(c *gin.Context) {
+	var req struct {
+		Enabled        string `json:"enabled"`
+		Interval       string `json:"interval"`
+		KeepDays       string `json:"keep_days"`
+		FailedKeepDays string `json:"failed_keep_days"`
+		KeepCount      string `json:"keep_count"`
+		TaskMaxSize    string `json:"task_max_size"`
+		DiskQuota      string `json:"disk_quota"`
+		LoginLogDays   string `json:"login_log_days"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(c, "参数错误")
+		return
+	}
+
+	values := map[string]string{
+		constant.KeyRetentionEnabled:    req.Enabled,
+		constant.KeyRetentionInterval:   req.Interval,
+		constant.KeyRetentionKeepDays:   req.KeepDays,
+		constant.KeyRetentionFailedDays: req.FailedKeepDays,
+		constant.KeyRetentionKeepCount:  req.KeepCount,
+		constant.KeyRetentionTaskSize:   req.TaskMaxSize,
+		constant.KeyRetentionDiskQuota:  req.DiskQuota,
+		constant.KeyRetentionLoginDays:  req.LoginLogDays,
+	}
+	for key, value := range values {
+		if key == constant.KeyRetentionEnabled {
+			continue
+		}
+		if n, err := strconv.Atoi(value); err != nil || n < 0 {
+			utils.BadRequest(c, "参数错误: "+key+" 必须为非负整数")
+			return
+		}
+	}
+
+	if err := sc.settingsService.SetSection(constant.SectionRetention, values); err != nil {
+		utils.ServerError(c, "保存失败")
+		return
+	}
+
+	utils.SuccessMsg(c, "保存成功")
+}
+
+// RunRetention 立即按当前策略执行一次日志清理
+func (sc *SettingsController) RunRetention(c *gin.Context) {
+	report := sc.retentionService.Run(sc.retentionService.GetPolicy())
+	utils.Success(c, report)
+}
+
+// GetHealthSettings 获取任务健康状态阈值
+func (sc *SettingsController) GetHealthSettings(c *gin.Context) {
+	utils.Success(c, sc.settingsService.GetSection(constant.SectionHealth))
+}
+
+// UpdateHealthSettings 更新任务健康状态阈值，并按新阈值重新计算所有任务的健康状态
+func (sc *SettingsController) UpdateHealthSettings(c *gin.Context) {
+	var req struct {
+		FailStreak   string `json:"fail_streak"`
+		WindowHours  string `json:"window_hours"`
+		FailRate     string `json:"fail_rate"`
+		DegradedRate string `json:"degraded_rate"`
+		MinRuns      string `json:"min_runs"`
+		MissedGrace  string `json:"missed_grace"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(c, "参数错误")
+		return
+	}
+
+	values := map[string]string{
+		constant.KeyHealthFailStreak:   req.FailStreak,
+		constant.KeyHealthWindow:       req.WindowHours,
+		constant.KeyHealthFailRate:     req.FailRate,
+		constant.KeyHealthDegradedRate: req.DegradedRate,
+		constant.KeyHealthMinRuns:      req.MinRuns,
+		constant.KeyHealthMissedGrace:  req.MissedGrace,
+	}
+	for key, value := range values {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			utils.BadRequest(c, "参数错误: "+key+" 必须为非负整数")
+			return
+		}
+		if (key == constant.KeyHealthFailRate || key == constant.KeyHealthDegradedRate) && n > 100 {
+			utils.BadRequest(c, "参数错误: "+key+" 不能超过 100")
+			return
+		}
+		if key == constant.KeyHealthWindow && n == 0 {
+			utils.BadRequest(c, "参数错误: "+key+" 必须大于 0")
+			return
+		}
+	}
+
+	if err := sc.settingsService.SetSection(constant.SectionHealth, values); err != nil {
+		utils.ServerError(c, "保存失败")
+		return
+	}
+
+	go tasks.RefreshAllTaskHealth()
+	utils.SuccessMsg(c, "保存成功")
+}
+
+// GetRedactionSettings 获取日志脱敏规则
+func (sc *SettingsController) GetRedactionSettings(c *gin.Context) {
+	utils.Success(c, sc.settingsService.GetSection(constant.SectionRedaction))
+}
+
+// UpdateRedactionSettings 更新日志脱敏规则（每行一条正则），并同步给在线 Agent
+func (sc *SettingsController) UpdateRedactionSettings(c *gin.Context) {
+	var req struct {
+		Rules string `json:"rules"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.BadRequest(c, "参数错误")
+		return
+	}
+
+	for _, rule := range utils.ParseRedactionRules(req.Rules) {
+		if _, err := regexp.Compile(rule); err != nil {
+			utils.BadRequest(c, "无效的正则规则: "+rule)
+			return
+		}
+	}
+
+	if err := sc.settingsService.Set(constant.SectionRedaction, constant.KeyRedactionRules, req.Rules); err != nil {
+		utils.ServerError(c, "保存失败")
+		return
+	}
+
+	services.GetAgentWSManager().BroadcastTasksAll()
+	utils.SuccessMsg(c, "保存成功")
+}
+
 // GetPaths 获取系统路径信息
 func (sc *SettingsController) GetPaths(c *gin.Context) {
 	absScriptsDir, _ := filepath.Abs(constant.ScriptsWorkDir)
@@ -5,6 +5,7 @@ import (
 	"strconv"
 
 	"github.com/engigu/baihu-panel/internal/constant"
+	"github.com/engigu/baihu-panel/internal/models"
 	"github.com/engigu/baihu-panel/internal/models/vo"
 	"github.com/engigu/baihu-panel/internal/services"
 	"github.com/engigu/baihu-panel/internal/services/tasks"
@@ -50,18 +51,53 @@ func resolveWorkDir(workDir string) string {
 	return absPath
 }
 
+// syncTaskSchedule 按任务的执行位置更新面板调度，并通知变更前后相关的 Agent
+func (tc *TaskController) syncTaskSchedule(task, oldTask *models.Task) {
+	// 本地任务及 any / sticky 模式的 Agent 任务由面板调度，其余由 Agent 按计划执行
+	if task.Enabled && !task.ScheduledByAgent() {
+		tc.executorService.AddCronTask(task)
+	} else {
+		tc.executorService.RemoveCronTask(task.ID)
+	}
+
+	if oldTask != nil && (oldTask.AgentSelector != "" || oldTask.FallbackAgents != "") {
+		tc.agentWSManager.BroadcastTasksAll()
+		return
+	}
+	tc.notifyTaskAgents(task)
+	// 如果 agent 变更了，也通知旧 agent
+	if task.AgentSelector == "" && oldTask != nil && oldTask.AgentID != nil && *oldTask.AgentID > 0 &&
+		(task.AgentID == nil || *task.AgentID != *oldTask.AgentID) {
+		tc.agentWSManager.BroadcastTasks(*oldTask.AgentID)
+	}
+}
+
+// notifyTaskAgents 向任务涉及的 Agent 重新下发任务列表
+func (tc *TaskController) notifyTaskAgents(task *models.Task) {
+	if task.AgentSelector != "" || task.FallbackAgents != "" {
+		// 按标签选择或配置了备用 Agent 的任务涉及多个 Agent
+		tc.agentWSManager.BroadcastTasksAll()
+	} else if task.AgentID != nil && *task.AgentID > 0 {
+		tc.agentWSManager.BroadcastTasks(*task.AgentID)
+	}
+}
+
 func (tc *TaskController) CreateTask(c *gin.Context) {
 	var req struct {
-		Name        string `json:"name" binding:"required"`
-		Command     string `json:"command"`
-		Type        string `json:"type"`
-		Config      string `json:"config"`
-		Schedule    string `json:"schedule" binding:"required"`
-		Timeout     int    `json:"timeout"`
-		WorkDir     string `json:"work_dir"`
-		CleanConfig string `json:"clean_config"`
-		Envs        string `json:"envs"`
-		AgentID     *uint  `json:"agent_id"`
+		Name          string `json:"name" binding:"required"`
+		Command       string `json:"command"`
+		Type          string `json:"type"`
+		Config        string `json:"config"`
+		Schedule      string `json:"schedule" binding:"required"`
+		Timeout       int    `json:"timeout"`
+		WorkDir       string `json:"work_dir"`
+		CleanConfig   string `json:"clean_config"`
+		Envs          string `json:"envs"`
+		AgentID       *uint  `json:"agent_id"`
+		Selector      string `json:"agent_selector"`
+		Dispatch      string `json:"dispatch_mode"`
+		Fallbacks     string `json:"fallback_agents"`
+		FallbackLocal bool   `json:"fallback_local"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -80,20 +116,26 @@ func (tc *TaskController) CreateTask(c *gin.Context) {
 		return
 	}
 
+	if err := tasks.ValidateResultRules(tasks.ParseResultRules(req.Config)); err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+
+	agentID, selector, dispatch, err := tasks.NormalizeAgentTarget(req.AgentID, req.Selector, req.Dispatch)
+	if err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+	fallbacks, fallbackLocal := tasks.NormalizeFallback(agentID, selector, dispatch, req.Fallbacks, req.FallbackLocal)
+
 	// 转换为绝对路径（Agent 任务保持原样）
 	workDir := req.WorkDir
-	if req.AgentID == nil || *req.AgentID == 0 {
+	if (agentID == nil || *agentID == 0) && selector == "" {
 		workDir = resolveWorkDir(req.WorkDir)
 	}
 
-	task := tc.taskService.CreateTask(req.Name, req.Command, req.Schedule, req.Timeout, workDir, req.CleanConfig, req.Envs, req.Type, req.Config, req.AgentID)
-
-	// 如果是 Agent 任务，通知 Agent；否则添加到本地 cron
-	if task.AgentID != nil && *task.AgentID > 0 {
-		tc.agentWSManager.BroadcastTasks(*task.AgentID)
-	} else {
-		tc.executorService.AddCronTask(task)
-	}
+	task := tc.taskService.CreateTask(req.Name, req.Command, req.Schedule, req.Timeout, workDir, req.CleanConfig, req.Envs, req.Type, req.Config, agentID, selector, dispatch, fallbacks, fallbackLocal)
+	tc.syncTaskSchedule(task, nil)
 
 	utils.Success(c, vo.ToTaskVO(task))
 }
@@ -140,23 +182,23 @@ func (tc *TaskController) UpdateTask(c *gin.Context) {
 
 	// 获取旧任务信息（用于判断 agent 变更）
 	oldTask := tc.taskService.GetTaskByID(id)
-	var oldAgentID *uint
-	if oldTask != nil {
-		oldAgentID = oldTask.AgentID
-	}
 
 	var req struct {
-		Name        string `json:"name"`
-		Command     string `json:"command"`
-		Type        string `json:"type"`
-		Config      string `json:"config"`
-		Schedule    string `json:"schedule"`
-		Timeout     int    `json:"timeout"`
-		WorkDir     string `json:"work_dir"`
-		CleanConfig string `json:"clean_config"`
-		Envs        string `json:"envs"`
-		Enabled     bool   `json:"enabled"`
-		AgentID     *uint  `json:"agent_id"`
+		Name          string `json:"name"`
+		Command       string `json:"command"`
+		Type          string `json:"type"`
+		Config        string `json:"config"`
+		Schedule      string `json:"schedule"`
+		Timeout       int    `json:"timeout"`
+		WorkDir       string `json:"work_dir"`
+		CleanConfig   string `json:"clean_config"`
+		Envs          string `json:"envs"`
+		Enabled       bool   `json:"enabled"`
+		AgentID       *uint  `json:"agent_id"`
+		Selector      string `json:"agent_selector"`
+		Dispatch      string `json:"dispatch_mode"`
+		Fallbacks     string `json:"fallback_agents"`
+		FallbackLocal bool   `json:"fallback_local"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -171,39 +213,30 @@ func (tc *TaskController) UpdateTask(c *gin.Context) {
 		}
 	}
 
+	if err := tasks.ValidateResultRules(tasks.ParseResultRules(req.Config)); err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+
+	agentID, selector, dispatch, err := tasks.NormalizeAgentTarget(req.AgentID, req.Selector, req.Dispatch)
+	if err != nil {
+		utils.BadRequest(c, err.Error())
+		return
+	}
+	fallbacks, fallbackLocal := tasks.NormalizeFallback(agentID, selector, dispatch, req.Fallbacks, req.FallbackLocal)
+
 	// 转换为绝对路径（Agent 任务保持原样）
 	workDir := req.WorkDir
-	if req.AgentID == nil || *req.AgentID == 0 {
+	if (agentID == nil || *agentID == 0) && selector == "" {
 		workDir = resolveWorkDir(req.WorkDir)
 	}
 
-	task := tc.taskService.UpdateTask(id, req.Name, req.Command, req.Schedule, req.Timeout, workDir, req.CleanConfig, req.Envs, req.Enabled, req.Type, req.Config, req.AgentID)
+	task := tc.taskService.UpdateTask(id, req.Name, req.Command, req.Schedule, req.Timeout, workDir, req.CleanConfig, req.Envs, req.Enabled, req.Type, req.Config, agentID, selector, dispatch, fallbacks, fallbackLocal)
 	if task == nil {
 		utils.NotFound(c, "任务不存在")
 		return
 	}
-
-	// 处理任务调度
-	if task.AgentID != nil && *task.AgentID > 0 {
-		// Agent 任务：从本地 cron 移除，通知 Agent
-		tc.executorService.RemoveCronTask(task.ID)
-		tc.agentWSManager.BroadcastTasks(*task.AgentID)
-		// 如果 agent 变更了，也通知旧 agent
-		if oldAgentID != nil && *oldAgentID > 0 && *oldAgentID != *task.AgentID {
-			tc.agentWSManager.BroadcastTasks(*oldAgentID)
-		}
-	} else {
-		// 本地任务
-		if task.Enabled {
-			tc.executorService.AddCronTask(task)
-		} else {
-			tc.executorService.RemoveCronTask(task.ID)
-		}
-		// 如果之前是 agent 任务，通知旧 agent 移除
-		if oldAgentID != nil && *oldAgentID > 0 {
-			tc.agentWSManager.BroadcastTasks(*oldAgentID)
-		}
-	}
+	tc.syncTaskSchedule(task, oldTask)
 
 	utils.Success(c, vo.ToTaskVO(task))
 }
@@ -217,10 +250,6 @@ func (tc *TaskController) DeleteTask(c *gin.Context) {
 
 	// 获取任务信息（用于通知 agent）
 	task := tc.taskService.GetTaskByID(id)
-	var agentID *uint
-	if task != nil {
-		agentID = task.AgentID
-	}
 
 	tc.executorService.RemoveCronTask(uint(id))
 
@@ -231,8 +260,8 @@ func (tc *TaskController) DeleteTask(c *gin.Context) {
 	}
 
 	// 如果是 agent 任务，通知 agent
-	if agentID != nil && *agentID > 0 {
-		tc.agentWSManager.BroadcastTasks(*agentID)
+	if task != nil {
+		tc.notifyTaskAgents(task)
 	}
 
 	utils.SuccessMsg(c, "删除成功")
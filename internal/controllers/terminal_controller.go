@@ -24,11 +24,13 @@ import (
 
 type TerminalController struct {
 	envService *services.EnvService
+	access     *agentAccess
 }
 
-func NewTerminalController(envService *services.EnvService) *TerminalController {
+func NewTerminalController(envService *services.EnvService, remoteService *services.AgentRemoteService, agentService *services.AgentService, userService *services.UserService) *TerminalController {
 	return &TerminalController{
 		envService: envService,
+		access:     newAgentAccess(remoteService, agentService, userService),
 	}
 }
 
@@ -84,6 +86,11 @@ func (tc *TerminalController) HandleWebSocket(c *gin.Context) {
 		return
 	}
 
+	if isAgentRequest(c) {
+		tc.handleAgentShell(c, conn)
+		return
+	}
+
 	// Windows 使用 pipe 模式，Unix 使用 PTY 模式
 	userID := 1
 	if v, exists := c.Get("userID"); exists {
@@ -253,6 +260,65 @@ func (tc *TerminalController) handlePipeMode(conn *websocket.Conn, userID int) {
 	wg.Wait()
 }
 
+// handleAgentShell 通过 Agent 的 WebSocket 转发终端，Agent 上的模式决定前端输入方式
+func (tc *TerminalController) handleAgentShell(c *gin.Context, conn *websocket.Conn) {
+	agent, errMsg := tc.access.check(c)
+	if errMsg != "" {
+		conn.WriteMessage(websocket.TextMessage, []byte("\r\n\033[1;31m"+errMsg+"\033[0m\r\n"))
+		return
+	}
+
+	session := tc.access.startSession(c, agent, constant.AgentSessionShell, "", "")
+	shell, err := tc.access.remoteService.OpenShell(agent.ID, 80, 24)
+	if err != nil {
+		tc.access.remoteService.EndSession(session, err.Error())
+		conn.WriteMessage(websocket.TextMessage, []byte("\r\n\033[1;31m打开终端失败: "+err.Error()+"\033[0m\r\n"))
+		return
+	}
+	defer func() {
+		shell.Close()
+		tc.access.remoteService.EndSession(session, shell.Err())
+	}()
+
+	if shell.Mode == "pty" {
+		conn.WriteMessage(websocket.TextMessage, []byte("__PTY_MODE__"))
+	} else {
+		conn.WriteMessage(websocket.TextMessage, []byte("__PIPE_MODE__"))
+	}
+
+	go func() {
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				shell.Close()
+				return
+			}
+			if err := shell.Write(message); err != nil {
+				shell.Close()
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case data := <-shell.Output:
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(toUTF8(data))); err != nil {
+				return
+			}
+		case <-shell.Done:
+			// 输出剩余内容，如 shell 退出前打印的信息
+			for len(shell.Output) > 0 {
+				conn.WriteMessage(websocket.TextMessage, []byte(toUTF8(<-shell.Output)))
+			}
+			if msg := shell.Err(); msg != "" {
+				conn.WriteMessage(websocket.TextMessage, []byte("\r\n\033[1;33m"+msg+"\033[0m\r\n"))
+			}
+			return
+		}
+	}
+}
+
 // ExecuteShellCommand 执行单个命令并返回结果
 func (tc *TerminalController) ExecuteShellCommand(c *gin.Context) {
 	var req struct {
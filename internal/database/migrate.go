@@ -23,6 +23,15 @@ func Migrate() error {
 		&models.Dependency{},
 		&models.Agent{},
 		&models.AgentToken{},
+		&models.AgentBlock{},
+		&models.NotifyChannel{},
+		&models.NotifyRule{},
+		&models.MissedRun{},
+		&models.AgentRollout{},
+		&models.AgentRolloutTarget{},
+		&models.AgentSession{},
+		&models.AgentSyncMapping{},
+		&models.AgentConfig{},
 	)
 }
 
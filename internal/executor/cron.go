@@ -2,6 +2,7 @@ package executor
 
 import (
 	"sync"
+	"time"
 
 	"github.com/engigu/baihu-panel/internal/systime"
 
@@ -16,6 +17,7 @@ type CronManager struct {
 	cron      *cron.Cron
 	scheduler *Scheduler
 	entryMap  map[string]cron.EntryID // task ID -> cron entry ID
+	lastFired map[string]time.Time    // task ID -> 最近一次触发时间
 	mu        sync.RWMutex
 	logger    SchedulerLogger
 }
@@ -29,6 +31,7 @@ func NewCronManager(scheduler *Scheduler) *CronManager {
 		cron:      c,
 		scheduler: scheduler,
 		entryMap:  make(map[string]cron.EntryID),
+		lastFired: make(map[string]time.Time),
 		logger:    &DefaultLogger{},
 	}
 
@@ -86,6 +89,9 @@ func (m *CronManager) AddTask(task CronTask) error {
 			}
 		}()
 		m.logger.Infof("[CronManager] 触发计划任务 #%s (%s)", taskID, name)
+		m.mu.Lock()
+		m.lastFired[taskID] = time.Now()
+		m.mu.Unlock()
 
 		req := &ExecutionRequest{
 			TaskID:  taskID,
@@ -131,6 +137,7 @@ func (m *CronManager) RemoveTask(taskID string) {
 	if entryID, exists := m.entryMap[taskID]; exists {
 		m.cron.Remove(entryID)
 		delete(m.entryMap, taskID)
+		delete(m.lastFired, taskID)
 		m.logger.Infof("[CronManager] 任务已移除 #%s", taskID)
 	}
 }
@@ -153,11 +160,21 @@ func (m *CronManager) triggerNextRunEvent(taskID string, req *ExecutionRequest)
 
 // ValidateCron 校验 Cron 表达式
 func (m *CronManager) ValidateCron(expression string) error {
-	parser := cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
-	_, err := parser.Parse(expression)
+	_, err := ParseSchedule(expression)
 	return err
 }
 
+// ParseSchedule 按调度器相同的规则（秒级精度）解析 Cron 表达式
+func ParseSchedule(expression string) (cron.Schedule, error) {
+	parser := cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+	return parser.Parse(expression)
+}
+
+// Location 返回调度器使用的时区
+func Location() *time.Location {
+	return defaultLocation
+}
+
 // GetEntry 获取任务详情
 func (m *CronManager) GetEntry(taskID string) (cron.Entry, bool) {
 	m.mu.RLock()
@@ -171,9 +188,28 @@ func (m *CronManager) GetEntry(taskID string) (cron.Entry, bool) {
 	return m.cron.Entry(entryID), true
 }
 
+// LastFired 获取任务最近一次被触发的时间
+func (m *CronManager) LastFired(taskID string) (time.Time, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	t, ok := m.lastFired[taskID]
+	return t, ok
+}
+
 // GetScheduledCount 获取已调度任务总数
 func (m *CronManager) GetScheduledCount() int {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	return len(m.entryMap)
 }
+
+// ScheduledTaskIDs 获取所有已调度的任务 ID
+func (m *CronManager) ScheduledTaskIDs() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	ids := make([]string, 0, len(m.entryMap))
+	for id := range m.entryMap {
+		ids = append(ids, id)
+	}
+	return ids
+}
@@ -172,8 +172,10 @@ type Scheduler struct {
 	wg           sync.WaitGroup
 	mu           sync.RWMutex
 	logger       SchedulerLogger
-	runningTasks map[string]context.CancelFunc // 记录运行中的任务，用于停止 (TaskID -> CancelFunc)
-	runningExecs map[uint]context.CancelFunc   // 记录运行中的执行，用于停止 (LogID -> CancelFunc)
+	runningTasks map[string]context.CancelFunc            // 记录运行中的任务，用于停止 (TaskID -> CancelFunc)
+	runningExecs map[uint]context.CancelFunc              // 记录运行中的执行，用于停止 (LogID -> CancelFunc)
+	runningReqs  map[*ExecutionRequest]context.CancelFunc // 记录运行中的请求，用于停止没有 LogID 的执行
+	queued       map[string]int                           // 队列中等待执行的任务数 (TaskID -> count)
 }
 
 // NewScheduler 创建调度器
@@ -206,6 +208,8 @@ func NewScheduler(config SchedulerConfig, handler SchedulerEventHandler) *Schedu
 		logger:       &DefaultLogger{},
 		runningTasks: make(map[string]context.CancelFunc),
 		runningExecs: make(map[uint]context.CancelFunc),
+		runningReqs:  make(map[*ExecutionRequest]context.CancelFunc),
+		queued:       make(map[string]int),
 	}
 
 	return s
@@ -243,6 +247,7 @@ func (s *Scheduler) Stop() {
 
 // Enqueue 将任务加入队列
 func (s *Scheduler) Enqueue(req *ExecutionRequest) error {
+	s.markQueued(req.TaskID, 1)
 	select {
 	case s.taskQueue <- req:
 		if s.handler != nil {
@@ -251,12 +256,14 @@ func (s *Scheduler) Enqueue(req *ExecutionRequest) error {
 		return nil
 	default:
 		// 队列满，返回错误
+		s.markQueued(req.TaskID, -1)
 		return fmt.Errorf("任务队列已满")
 	}
 }
 
 // EnqueueOrExecute 将任务加入队列，如果队列满则直接执行
 func (s *Scheduler) EnqueueOrExecute(req *ExecutionRequest) {
+	s.markQueued(req.TaskID, 1)
 	select {
 	case s.taskQueue <- req:
 		// 成功入队
@@ -265,11 +272,34 @@ func (s *Scheduler) EnqueueOrExecute(req *ExecutionRequest) {
 		}
 	default:
 		// 队列满，直接执行（降级处理）
+		s.markQueued(req.TaskID, -1)
 		s.logger.Warnf("[Scheduler] 任务队列已满，直接执行任务 %s", req.TaskID)
 		go s.executeTask(req)
 	}
 }
 
+// markQueued 更新任务在队列中的等待数
+func (s *Scheduler) markQueued(taskID string, delta int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queued[taskID] += delta
+	if s.queued[taskID] <= 0 {
+		delete(s.queued, taskID)
+	}
+}
+
+// QueuedCount 获取任务在队列中等待执行的数量
+func (s *Scheduler) QueuedCount(taskID string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.queued[taskID]
+}
+
+// QueueCapacity 获取队列容量
+func (s *Scheduler) QueueCapacity() int {
+	return s.config.QueueSize
+}
+
 // ExecuteSync 同步执行任务（不经过队列）
 func (s *Scheduler) ExecuteSync(req *ExecutionRequest) (*ExecutionResult, error) {
 	return s.executeTask(req)
@@ -284,6 +314,7 @@ func (s *Scheduler) worker(id int) {
 		case <-s.stopCh:
 			return
 		case req := <-s.taskQueue:
+			s.markQueued(req.TaskID, -1)
 			func() {
 				defer func() {
 					if r := recover(); r != nil {
@@ -384,6 +415,7 @@ func (s *Scheduler) executeTask(req *ExecutionRequest) (*ExecutionResult, error)
 	if req.LogID > 0 {
 		s.runningExecs[req.LogID] = cancel
 	}
+	s.runningReqs[req] = cancel
 	s.mu.Unlock()
 
 	defer func() {
@@ -392,6 +424,7 @@ func (s *Scheduler) executeTask(req *ExecutionRequest) (*ExecutionResult, error)
 		if req.LogID > 0 {
 			delete(s.runningExecs, req.LogID)
 		}
+		delete(s.runningReqs, req)
 		s.mu.Unlock()
 	}()
 
@@ -478,6 +511,20 @@ func (s *Scheduler) StopLog(logID uint) bool {
 	return false
 }
 
+// StopRequest 停止指定的执行请求，用于 Agent 计划任务等没有 LogID 的执行
+func (s *Scheduler) StopRequest(req *ExecutionRequest) bool {
+	s.mu.RLock()
+	cancel, exists := s.runningReqs[req]
+	s.mu.RUnlock()
+
+	if exists && cancel != nil {
+		cancel()
+		s.logger.Infof("[Scheduler] 已尝试停止任务 %s 的执行", req.TaskID)
+		return true
+	}
+	return false
+}
+
 // GetRunningTaskCount 获取正在运行的任务数量
 func (s *Scheduler) GetRunningTaskCount() int {
 	s.mu.RLock()
@@ -508,6 +555,7 @@ func (s *Scheduler) Reload(config SchedulerConfig) {
 	s.mu.Lock()
 	s.config = config
 	s.taskQueue = make(chan *ExecutionRequest, config.QueueSize)
+	s.queued = make(map[string]int)
 	s.rateLimiter = time.Tick(config.RateInterval)
 	s.stopCh = make(chan struct{})
 	s.mu.Unlock()
@@ -8,62 +8,128 @@ import (
 
 // Agent 远程执行代理
 type Agent struct {
-	ID          uint           `json:"id" gorm:"primaryKey"`
-	Name        string         `json:"name" gorm:"size:100;not null"`                 // Agent 名称
-	Token       string         `json:"token" gorm:"size:64;index"`                    // 认证 Token（可重复使用）
-	MachineID   string         `json:"machine_id" gorm:"size:64;uniqueIndex"`         // 机器识别码（唯一）
-	Description string         `json:"description" gorm:"size:255"`                   // 描述
-	Status      string         `json:"status" gorm:"size:20;default:'pending';index"` // 状态: constant.AgentStatusOnline, constant.AgentStatusOffline
-	LastSeen    *LocalTime     `json:"last_seen"`                                     // 最后心跳时间
-	IP          string         `json:"ip" gorm:"size:45"`                             // Agent IP 地址
-	Version     string         `json:"version" gorm:"size:50"`                        // Agent 版本
-	BuildTime   string         `json:"build_time" gorm:"size:30"`                     // Agent 构建时间
-	Hostname    string         `json:"hostname" gorm:"size:100"`                      // Agent 主机名
-	OS          string         `json:"os" gorm:"size:20"`                             // 操作系统
-	Arch        string         `json:"arch" gorm:"size:20"`                           // 架构
-	ForceUpdate bool           `json:"force_update" gorm:"default:false"`             // 强制更新标志
-	Enabled     bool           `json:"enabled" gorm:"default:true"`                   // 是否启用
-	CreatedAt   LocalTime      `json:"created_at"`
-	UpdatedAt   LocalTime      `json:"updated_at"`
-	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
+	ID            uint           `json:"id" gorm:"primaryKey"`
+	Name          string         `json:"name" gorm:"size:100;not null"`                      // Agent 名称
+	Token         string         `json:"token" gorm:"size:64;index"`                         // 认证 Token（可重复使用）
+	MachineID     string         `json:"machine_id" gorm:"size:64;uniqueIndex"`              // 机器识别码（唯一）
+	Description   string         `json:"description" gorm:"size:255"`                        // 描述
+	Group         string         `json:"group" gorm:"column:group_name;size:100;default:''"` // 分组（面板设置，优先于配置文件）
+	Labels        string         `json:"labels" gorm:"size:500;default:''"`                  // 标签（面板设置），如 region=hk,env=prod
+	ConfigGroup   string         `json:"config_group" gorm:"size:100;default:''"`            // Agent 配置文件中的分组
+	ConfigLabels  string         `json:"config_labels" gorm:"size:500;default:''"`           // Agent 配置文件中的标签
+	Status        string         `json:"status" gorm:"size:20;default:'pending';index"`      // 状态: constant.AgentStatusOnline, constant.AgentStatusOffline
+	LastSeen      *LocalTime     `json:"last_seen"`                                          // 最后心跳时间
+	IP            string         `json:"ip" gorm:"size:45"`                                  // Agent IP 地址
+	Version       string         `json:"version" gorm:"size:50"`                             // Agent 版本
+	BuildTime     string         `json:"build_time" gorm:"size:30"`                          // Agent 构建时间
+	Hostname      string         `json:"hostname" gorm:"size:100"`                           // Agent 主机名
+	OS            string         `json:"os" gorm:"size:20"`                                  // 操作系统
+	Arch          string         `json:"arch" gorm:"size:20"`                                // 架构
+	ForceUpdate   bool           `json:"force_update" gorm:"default:false"`                  // 强制更新标志
+	AllowRollback bool           `json:"allow_rollback"`                                     // 强制更新允许安装比 Agent 当前旧的版本（管理员发起的回滚）
+	CertSerial    string         `json:"cert_serial" gorm:"size:64;default:''"`              // 当前有效的客户端证书序列号，重新签发后旧证书失效
+	CertExpiresAt *LocalTime     `json:"cert_expires_at"`                                    // 客户端证书到期时间
+	SigningKey    string         `json:"-" gorm:"size:64;default:''"`                        // WebSocket 消息签名密钥
+	SecretsKey    string         `json:"-" gorm:"size:64;default:''"`                        // Agent 接收隐藏环境变量的公钥
+	Enabled       bool           `json:"enabled" gorm:"default:true"`                        // 是否启用
+	Approval      string         `json:"approval" gorm:"size:20;default:'approved';index"`   // 审批状态: constant.AgentApprovalPending, constant.AgentApprovalApproved
+	CreatedAt     LocalTime      `json:"created_at"`
+	UpdatedAt     LocalTime      `json:"updated_at"`
+	DeletedAt     gorm.DeletedAt `json:"-" gorm:"index"`
 }
 
 func (Agent) TableName() string {
 	return constant.TablePrefix + "agents"
 }
 
+// Pending 是否等待管理员审批
+func (a *Agent) Pending() bool {
+	return a.Approval == constant.AgentApprovalPending
+}
+
+// EffectiveLabels 合并配置文件与面板设置的标签（面板优先），分组以 group 标签的形式出现
+func (a *Agent) EffectiveLabels() map[string]string {
+	labels := ParseLabels(a.ConfigLabels)
+	for k, v := range ParseLabels(a.Labels) {
+		labels[k] = v
+	}
+	if a.ConfigGroup != "" {
+		labels[constant.LabelGroup] = a.ConfigGroup
+	}
+	if a.Group != "" {
+		labels[constant.LabelGroup] = a.Group
+	}
+	return labels
+}
+
 // AgentToken Agent 令牌
 type AgentToken struct {
-	ID        uint           `json:"id" gorm:"primaryKey"`
-	Token     string         `json:"token" gorm:"size:64;uniqueIndex;not null"` // 令牌
-	Remark    string         `json:"remark" gorm:"size:255"`                    // 备注
-	MaxUses   int            `json:"max_uses" gorm:"default:0"`                 // 最大使用次数，0 表示无限制
-	UsedCount int            `json:"used_count" gorm:"default:0"`               // 已使用次数
-	ExpiresAt *LocalTime     `json:"expires_at"`                                // 过期时间，null 表示永不过期
-	Enabled   bool           `json:"enabled" gorm:"default:true"`               // 是否启用
-	CreatedAt LocalTime      `json:"created_at"`
-	UpdatedAt LocalTime      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+	ID          uint           `json:"id" gorm:"primaryKey"`
+	Token       string         `json:"token" gorm:"size:64;uniqueIndex;not null"` // 令牌
+	Remark      string         `json:"remark" gorm:"size:255"`                    // 备注
+	MaxUses     int            `json:"max_uses" gorm:"default:0"`                 // 最大使用次数，0 表示无限制
+	UsedCount   int            `json:"used_count" gorm:"default:0"`               // 已使用次数
+	ExpiresAt   *LocalTime     `json:"expires_at"`                                // 过期时间，null 表示永不过期
+	AutoApprove bool           `json:"auto_approve"`                              // 新机器自动通过审批，否则需管理员批准
+	Group       string         `json:"group" gorm:"size:100;default:''"`          // 通过该令牌注册的 Agent 所属分组
+	Enabled     bool           `json:"enabled" gorm:"default:true"`               // 是否启用
+	CreatedAt   LocalTime      `json:"created_at"`
+	UpdatedAt   LocalTime      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
 }
 
 func (AgentToken) TableName() string {
 	return constant.TablePrefix + "tokens"
 }
 
+// AgentBlock 被拒绝接入的机器，使用任何令牌都不能再注册
+type AgentBlock struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	MachineID string    `json:"machine_id" gorm:"size:64;uniqueIndex"`
+	Hostname  string    `json:"hostname" gorm:"size:100"`
+	IP        string    `json:"ip" gorm:"size:45"`
+	CreatedAt LocalTime `json:"created_at"`
+}
+
+func (AgentBlock) TableName() string {
+	return constant.TablePrefix + "agent_blocks"
+}
+
 // AgentTask Agent 任务配置（用于下发给 Agent）
 type AgentTask struct {
-	ID       uint   `json:"id"`
-	Name     string `json:"name"`
-	Command  string `json:"command"`
-	Schedule string `json:"schedule"`
-	Timeout  int    `json:"timeout"`
-	WorkDir  string `json:"work_dir"`
-	Envs     string `json:"envs"`
-	Enabled  bool   `json:"enabled"`
+	ID         uint   `json:"id"`
+	Name       string `json:"name"`
+	Command    string `json:"command"`
+	Schedule   string `json:"schedule"`
+	Timeout    int    `json:"timeout"`
+	WorkDir    string `json:"work_dir"`
+	Envs       string `json:"envs"`
+	HiddenEnvs string `json:"hidden_envs"` // 隐藏环境变量名称，逗号分隔，其值不在 Envs 中，执行时加密下发
+	Enabled    bool   `json:"enabled"`
+	Dispatched bool   `json:"dispatched"` // 由面板调度下发执行，Agent 不按计划自行执行
+}
+
+// AgentTelemetry Agent 心跳上报的资源使用情况
+type AgentTelemetry struct {
+	Time        int64   `json:"time"` // 采集时间（Unix 时间戳）
+	NumCPU      int     `json:"num_cpu"`
+	CPU         float64 `json:"cpu"` // CPU 使用率（%）
+	MemUsed     uint64  `json:"mem_used"`
+	MemTotal    uint64  `json:"mem_total"`
+	MemPercent  float64 `json:"mem_percent"`
+	Load1       float64 `json:"load1"` // 系统负载，Windows 上为 0
+	Load5       float64 `json:"load5"`
+	Load15      float64 `json:"load15"`
+	DiskUsed    uint64  `json:"disk_used"` // Agent 数据目录所在磁盘
+	DiskTotal   uint64  `json:"disk_total"`
+	DiskPercent float64 `json:"disk_percent"`
+	Running     int     `json:"running"` // 正在执行的任务数
+	Queued      int     `json:"queued"`  // 队列中等待执行的任务数
 }
 
 // AgentTaskResult Agent 上报的任务执行结果
 type AgentTaskResult struct {
+	RunID     string `json:"run_id"` // Agent 生成的执行标识
 	TaskID    uint   `json:"task_id"`
 	LogID     uint   `json:"log_id"`
 	AgentID   uint   `json:"agent_id"`
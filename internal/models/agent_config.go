@@ -0,0 +1,32 @@
+package models
+
+import (
+	"github.com/engigu/baihu-panel/internal/constant"
+)
+
+// AgentConfig 面板管理的 Agent 运行配置，每次保存版本号加一并推送给 Agent，Agent 应用后回报版本
+// 数值为 0、字符串为空表示使用默认值（调度参数使用面板的全局调度设置）
+type AgentConfig struct {
+	ID              uint       `json:"id" gorm:"primaryKey"`
+	AgentID         uint       `json:"agent_id" gorm:"uniqueIndex"`
+	Version         uint       `json:"version" gorm:"default:0"`                      // 配置版本，每次保存递增
+	WorkerCount     int        `json:"worker_count" gorm:"default:0"`                 // 并发执行数
+	QueueSize       int        `json:"queue_size" gorm:"default:0"`                   // 队列大小
+	LogLevel        string     `json:"log_level" gorm:"size:10;default:''"`           // debug / info / warn / error
+	AllowedWorkDirs string     `json:"allowed_work_dirs" gorm:"size:2000;default:''"` // 允许的任务工作目录，每行一个，为空表示不限制
+	Proxy           string     `json:"proxy" gorm:"size:500;default:''"`              // 任务使用的代理，注入 HTTP_PROXY / HTTPS_PROXY
+	NoProxy         string     `json:"no_proxy" gorm:"size:500;default:''"`           // 不使用代理的地址，注入 NO_PROXY
+	MemoryLimit     int        `json:"memory_limit" gorm:"default:0"`                 // 单次执行的虚拟内存上限（MB）
+	CPULimit        int        `json:"cpu_limit" gorm:"default:0"`                    // 单次执行的 CPU 时间上限（秒）
+	OpenFilesLimit  int        `json:"open_files_limit" gorm:"default:0"`             // 单次执行的打开文件数上限
+	AppliedVersion  uint       `json:"applied_version" gorm:"default:0"`              // Agent 最近确认应用的版本
+	AppliedAt       *LocalTime `json:"applied_at"`
+	ApplyError      string     `json:"apply_error" gorm:"size:500;default:''"` // Agent 应用配置失败的原因
+	Overrides       string     `json:"-" gorm:"type:text"`                     // Agent 配置文件中覆盖的项（JSON，键为配置项，值为本地值）
+	CreatedAt       LocalTime  `json:"created_at"`
+	UpdatedAt       LocalTime  `json:"updated_at"`
+}
+
+func (AgentConfig) TableName() string {
+	return constant.TablePrefix + "agent_configs"
+}
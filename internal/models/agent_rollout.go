@@ -0,0 +1,44 @@
+package models
+
+import (
+	"github.com/engigu/baihu-panel/internal/constant"
+)
+
+// AgentRollout Agent 分批升级计划
+type AgentRollout struct {
+	ID            uint       `json:"id" gorm:"primaryKey"`
+	Name          string     `json:"name" gorm:"size:100"`
+	TargetVersion string     `json:"target_version" gorm:"size:50"`                 // 目标版本，即创建时面板提供的 Agent 版本
+	Selector      string     `json:"selector" gorm:"size:500;default:''"`           // 参与升级的 Agent 标签选择器，为空表示全部
+	Waves         string     `json:"waves" gorm:"size:1000"`                        // 批次定义，分号分隔，每批为百分比（如 10%）或标签选择器
+	CurrentWave   int        `json:"current_wave" gorm:"default:0"`                 // 当前批次（从 0 开始）
+	WaveTimeout   int        `json:"wave_timeout" gorm:"default:600"`               // 下发更新后需在多少秒内上报新版本
+	MaxFailures   int        `json:"max_failures" gorm:"default:0"`                 // 允许的失败数，超过后暂停
+	Status        string     `json:"status" gorm:"size:20;default:'running';index"` // constant.RolloutStatus*
+	PauseReason   string     `json:"pause_reason" gorm:"size:255;default:''"`       // 自动暂停的原因
+	WaveStartedAt *LocalTime `json:"wave_started_at"`                               // 当前批次开始时间
+	FinishedAt    *LocalTime `json:"finished_at"`                                   // 完成或取消时间
+	CreatedAt     LocalTime  `json:"created_at"`
+	UpdatedAt     LocalTime  `json:"updated_at"`
+}
+
+func (AgentRollout) TableName() string {
+	return constant.TablePrefix + "agent_rollouts"
+}
+
+// AgentRolloutTarget 升级计划中单个 Agent 的进度
+type AgentRolloutTarget struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	RolloutID   uint       `json:"rollout_id" gorm:"index"`
+	AgentID     uint       `json:"agent_id" gorm:"index"`
+	Wave        int        `json:"wave"`                                          // 所属批次
+	FromVersion string     `json:"from_version" gorm:"size:50"`                   // 加入计划时的版本
+	Status      string     `json:"status" gorm:"size:20;default:'pending';index"` // constant.RolloutTarget*
+	Error       string     `json:"error" gorm:"size:255;default:''"`
+	StartedAt   *LocalTime `json:"started_at"`  // 下发更新的时间
+	FinishedAt  *LocalTime `json:"finished_at"` // 上报新版本或判定失败的时间
+}
+
+func (AgentRolloutTarget) TableName() string {
+	return constant.TablePrefix + "agent_rollout_targets"
+}
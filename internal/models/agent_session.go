@@ -0,0 +1,25 @@
+package models
+
+import (
+	"github.com/engigu/baihu-panel/internal/constant"
+)
+
+// AgentSession 通过面板访问 Agent 的审计记录，终端每个会话一条，文件每次操作一条
+type AgentSession struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	UserID    uint       `json:"user_id" gorm:"index"`
+	Username  string     `json:"username" gorm:"size:100"`
+	AgentID   uint       `json:"agent_id" gorm:"index"`
+	AgentName string     `json:"agent_name" gorm:"size:100"`
+	Kind      string     `json:"kind" gorm:"size:20;index"` // constant.AgentSession*
+	Action    string     `json:"action" gorm:"size:20"`     // 文件操作类型，如 read、write、upload
+	Path      string     `json:"path" gorm:"size:500"`
+	IP        string     `json:"ip" gorm:"size:50"`
+	Error     string     `json:"error" gorm:"size:500"`
+	EndedAt   *LocalTime `json:"ended_at"`
+	CreatedAt LocalTime  `json:"created_at" gorm:"index"`
+}
+
+func (AgentSession) TableName() string {
+	return constant.TablePrefix + "agent_sessions"
+}
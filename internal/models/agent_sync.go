@@ -0,0 +1,25 @@
+package models
+
+import (
+	"github.com/engigu/baihu-panel/internal/constant"
+)
+
+// AgentSyncMapping 脚本同步目录映射，把面板脚本目录下的 Source 同步到 Agent 同步根目录下的 Target
+type AgentSyncMapping struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	AgentID     uint       `json:"agent_id" gorm:"index"`
+	Source      string     `json:"source" gorm:"size:500"`      // 面板脚本目录下的相对路径，为空表示整个脚本目录
+	Target      string     `json:"target" gorm:"size:500"`      // Agent sync_root 下的相对路径
+	Delete      bool       `json:"delete" gorm:"default:false"` // 删除 Agent 上面板已不存在的文件
+	Enabled     bool       `json:"enabled"`
+	LastSyncAt  *LocalTime `json:"last_sync_at"`
+	LastError   string     `json:"last_error" gorm:"size:500"`
+	LastUpdated int        `json:"last_updated"` // 上次同步写入的文件数
+	LastDeleted int        `json:"last_deleted"` // 上次同步删除的文件数
+	CreatedAt   LocalTime  `json:"created_at"`
+	UpdatedAt   LocalTime  `json:"updated_at"`
+}
+
+func (AgentSyncMapping) TableName() string {
+	return constant.TablePrefix + "agent_sync_mappings"
+}
@@ -0,0 +1,110 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ParseLabels 解析 "k=v,k2=v2" 格式的标签，只有键的项值为空字符串
+func ParseLabels(s string) map[string]string {
+	labels := make(map[string]string)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(item, "=")
+		if k = strings.TrimSpace(k); k != "" {
+			labels[k] = strings.TrimSpace(v)
+		}
+	}
+	return labels
+}
+
+// FormatLabels 将标签格式化为 "k=v,k2=v2"，按键排序
+func FormatLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	items := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if labels[k] == "" {
+			items = append(items, k)
+		} else {
+			items = append(items, k+"="+labels[k])
+		}
+	}
+	return strings.Join(items, ",")
+}
+
+// labelRequirement 选择器中的单个条件
+type labelRequirement struct {
+	key   string
+	op    string // "=", "!=", "exists", "!exists"
+	value string
+}
+
+// LabelSelector 标签选择器，多个条件以逗号分隔且需全部满足
+// 支持 k=v、k!=v、k（存在）、!k（不存在）
+type LabelSelector []labelRequirement
+
+// ParseLabelSelector 解析标签选择器
+func ParseLabelSelector(s string) (LabelSelector, error) {
+	var sel LabelSelector
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		var req labelRequirement
+		switch {
+		case strings.Contains(item, "!="):
+			k, v, _ := strings.Cut(item, "!=")
+			req = labelRequirement{key: strings.TrimSpace(k), op: "!=", value: strings.TrimSpace(v)}
+		case strings.Contains(item, "="):
+			k, v, _ := strings.Cut(item, "=")
+			req = labelRequirement{key: strings.TrimSpace(k), op: "=", value: strings.TrimSpace(v)}
+		case strings.HasPrefix(item, "!"):
+			req = labelRequirement{key: strings.TrimSpace(item[1:]), op: "!exists"}
+		default:
+			req = labelRequirement{key: item, op: "exists"}
+		}
+		if req.key == "" || strings.ContainsAny(req.key, "=! ") {
+			return nil, fmt.Errorf("无效的标签条件: %s", item)
+		}
+		sel = append(sel, req)
+	}
+	if len(sel) == 0 {
+		return nil, fmt.Errorf("标签选择器为空")
+	}
+	return sel, nil
+}
+
+// Matches 判断标签是否满足选择器
+func (sel LabelSelector) Matches(labels map[string]string) bool {
+	for _, req := range sel {
+		v, ok := labels[req.key]
+		switch req.op {
+		case "=":
+			if !ok || v != req.value {
+				return false
+			}
+		case "!=":
+			if ok && v == req.value {
+				return false
+			}
+		case "exists":
+			if !ok {
+				return false
+			}
+		case "!exists":
+			if ok {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"github.com/engigu/baihu-panel/internal/constant"
+)
+
+// MissedRun 计划任务未按时执行的记录
+type MissedRun struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	TaskID     uint      `json:"task_id" gorm:"index"`
+	ExpectedAt LocalTime `json:"expected_at" gorm:"index"` // 预期执行时间
+	Count      int       `json:"count" gorm:"default:1"`   // 同一检测周期内合并的漏执行次数
+	Reason     string    `json:"reason" gorm:"size:255"`   // 可能原因
+	CreatedAt  LocalTime `json:"created_at"`
+}
+
+func (MissedRun) TableName() string {
+	return constant.TablePrefix + "missed_runs"
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"encoding/json"
+
+	"github.com/engigu/baihu-panel/internal/constant"
+)
+
+// NotifyChannel 通知渠道
+type NotifyChannel struct {
+	ID            uint      `json:"id" gorm:"primaryKey"`
+	Name          string    `json:"name" gorm:"size:100;not null"`
+	Type          string    `json:"type" gorm:"size:20;not null"` // webhook, smtp, telegram, dingtalk, wecom, feishu, bark, serverchan
+	Config        string    `json:"config" gorm:"type:text"`      // 渠道配置（JSON 对象）
+	TitleTemplate string    `json:"title_template" gorm:"type:text"`
+	BodyTemplate  string    `json:"body_template" gorm:"type:text"`
+	Enabled       bool      `json:"enabled"`
+	CreatedAt     LocalTime `json:"created_at"`
+	UpdatedAt     LocalTime `json:"updated_at"`
+}
+
+func (NotifyChannel) TableName() string {
+	return constant.TablePrefix + "notify_channels"
+}
+
+// NotifyRule 通知规则，TaskID 为 0 表示对所有任务生效
+type NotifyRule struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	TaskID    uint      `json:"task_id" gorm:"index"`
+	ChannelID uint      `json:"channel_id" gorm:"index"`
+	Events    string    `json:"events" gorm:"size:100"` // 逗号分隔: failure, success, timeout, recovery, warning
+	Enabled   bool      `json:"enabled"`
+	CreatedAt LocalTime `json:"created_at"`
+	UpdatedAt LocalTime `json:"updated_at"`
+}
+
+func (NotifyRule) TableName() string {
+	return constant.TablePrefix + "notify_rules"
+}
+
+// ScriptNotification 脚本通过 sendNotify 发送的通知，记录在 TaskLog.Notifications 中
+type ScriptNotification struct {
+	Time    string `json:"time"`
+	Title   string `json:"title"`
+	Content string `json:"content"`
+}
+
+// ParseScriptNotifications 解析 TaskLog.Notifications
+func ParseScriptNotifications(data string) []ScriptNotification {
+	if data == "" {
+		return nil
+	}
+	var list []ScriptNotification
+	if err := json.Unmarshal([]byte(data), &list); err != nil {
+		return nil
+	}
+	return list
+}
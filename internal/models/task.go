@@ -2,6 +2,8 @@ package models
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/engigu/baihu-panel/internal/constant"
 
@@ -29,29 +31,50 @@ type RepoConfig struct {
 
 // TaskConfig  任务配置  RepoConfig+TaskConfig=task.config
 type TaskConfig struct {
-	Concurrency int `json:"$task_concurrency"` // 0: disable concurrency, 1: enable concurrency
+	Concurrency   int         `json:"$task_concurrency"` // 0: disable concurrency, 1: enable concurrency
+	LogTimestamps int         `json:"$log_timestamps"`   // 0: 合并输出, 1: 分离 stdout/stderr 并记录每行时间戳（Pipe 模式，仅本地任务）
+	ResultRules   ResultRules `json:"$result_rules"`     // 基于日志内容的结果判定规则
+}
+
+// ResultRules 基于日志内容的结果判定规则，每项为按行匹配的正则表达式
+// 仅对退出码判定为成功的执行生效，优先级：失败 > 缺少必需内容 > 警告
+type ResultRules struct {
+	Fail    []string `json:"fail"`    // 匹配任一即判定为失败
+	Warn    []string `json:"warn"`    // 匹配任一即判定为警告
+	Require []string `json:"require"` // 需全部出现才判定为成功，缺少任一判定为失败
+}
+
+// Empty 是否未配置任何规则
+func (r ResultRules) Empty() bool {
+	return len(r.Fail) == 0 && len(r.Warn) == 0 && len(r.Require) == 0
 }
 
 // Task 代表一个计划任务
 type Task struct {
-	ID          uint           `json:"id" gorm:"primaryKey"`
-	Name        string         `json:"name" gorm:"size:255;not null"`
-	Command     string         `json:"command" gorm:"type:text"`                // 普通任务的命令
-	Type        string         `json:"type" gorm:"size:20;default:'task'"`      // 任务类型: constant.TaskTypeNormal, constant.TaskTypeRepo
-	Config      string         `json:"config" gorm:"type:text"`                 // 配置 JSON（仓库同步配置等）
-	Schedule    string         `json:"schedule" gorm:"size:100"`                // cron 表达式
-	Timeout     int            `json:"timeout" gorm:"default:30"`               // 超时时间（分钟），默认30分钟
-	WorkDir     string         `json:"work_dir" gorm:"size:255;default:''"`     // 工作目录，为空则使用 scripts 目录
-	CleanConfig string         `json:"clean_config" gorm:"size:255;default:''"` // 清理配置 JSON
-	Envs        string         `json:"envs" gorm:"size:255;default:''"`         // 环境变量ID列表，逗号分隔
-	AgentID     *uint          `json:"agent_id" gorm:"index"`                   // Agent ID，为空表示本地执行
-	Enabled     bool           `json:"enabled" gorm:"default:true"`
-	RunningGo   string         `json:"running_go" gorm:"type:text"` // 正在运行的 go routine id 数组 (JSON)
-	LastRun     *LocalTime     `json:"last_run"`
-	NextRun     *LocalTime     `json:"next_run"`
-	CreatedAt   LocalTime      `json:"created_at"`
-	UpdatedAt   LocalTime      `json:"updated_at"`
-	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
+	ID             uint           `json:"id" gorm:"primaryKey"`
+	Name           string         `json:"name" gorm:"size:255;not null"`
+	Command        string         `json:"command" gorm:"type:text"`                   // 普通任务的命令
+	Type           string         `json:"type" gorm:"size:20;default:'task'"`         // 任务类型: constant.TaskTypeNormal, constant.TaskTypeRepo
+	Config         string         `json:"config" gorm:"type:text"`                    // 配置 JSON（仓库同步配置等）
+	Schedule       string         `json:"schedule" gorm:"size:100"`                   // cron 表达式
+	Timeout        int            `json:"timeout" gorm:"default:30"`                  // 超时时间（分钟），默认30分钟
+	WorkDir        string         `json:"work_dir" gorm:"size:255;default:''"`        // 工作目录，为空则使用 scripts 目录
+	CleanConfig    string         `json:"clean_config" gorm:"size:255;default:''"`    // 清理配置 JSON
+	Envs           string         `json:"envs" gorm:"size:255;default:''"`            // 环境变量ID列表，逗号分隔
+	AgentID        *uint          `json:"agent_id" gorm:"index"`                      // Agent ID，为空表示本地执行；sticky 模式下为优先 Agent
+	AgentSelector  string         `json:"agent_selector" gorm:"size:255;default:''"`  // Agent 标签选择器，如 region=hk,group=crawlers
+	DispatchMode   string         `json:"dispatch_mode" gorm:"size:20;default:''"`    // 分发模式: constant.DispatchAny, constant.DispatchAll, constant.DispatchSticky
+	FallbackAgents string         `json:"fallback_agents" gorm:"size:255;default:''"` // 备用 Agent ID，逗号分隔，首选 Agent 不可用时按顺序尝试
+	FallbackLocal  bool           `json:"fallback_local" gorm:"default:false"`        // 没有可用 Agent 时在本地执行
+	Enabled        bool           `json:"enabled" gorm:"default:true"`
+	RunningGo      string         `json:"running_go" gorm:"type:text"`              // 正在运行的 go routine id 数组 (JSON)
+	Health         string         `json:"health" gorm:"size:20;default:'healthy'"`  // 健康状态: healthy, degraded, failing
+	HealthReason   string         `json:"health_reason" gorm:"size:255;default:''"` // 健康状态判定原因
+	LastRun        *LocalTime     `json:"last_run"`
+	NextRun        *LocalTime     `json:"next_run"`
+	CreatedAt      LocalTime      `json:"created_at"`
+	UpdatedAt      LocalTime      `json:"updated_at"`
+	DeletedAt      gorm.DeletedAt `json:"-" gorm:"index"`
 }
 
 func (Task) TableName() string {
@@ -86,20 +109,54 @@ func (t *Task) GetSchedule() string {
 	return t.Schedule
 }
 
+// IsRemote 是否在 Agent 上执行
+func (t *Task) IsRemote() bool {
+	return t.AgentSelector != "" || (t.AgentID != nil && *t.AgentID > 0)
+}
+
+// ScheduledByAgent 是否由 Agent 按本地计划执行
+// 指定单个 Agent 和 all 模式由 Agent 自行调度；any / sticky 模式及配置了故障转移的任务由面板调度后选择 Agent 下发
+func (t *Task) ScheduledByAgent() bool {
+	if t.AgentSelector == "" {
+		return t.IsRemote() && !t.HasFailover()
+	}
+	return t.DispatchMode == constant.DispatchAll
+}
+
+// HasFailover 是否配置了故障转移（备用 Agent 或本地执行）
+func (t *Task) HasFailover() bool {
+	return t.FallbackAgents != "" || t.FallbackLocal
+}
+
+// FallbackAgentIDs 解析备用 Agent ID 列表
+func (t *Task) FallbackAgentIDs() []uint {
+	var ids []uint
+	for _, s := range strings.Split(t.FallbackAgents, ",") {
+		if id, err := strconv.ParseUint(strings.TrimSpace(s), 10, 64); err == nil && id > 0 {
+			ids = append(ids, uint(id))
+		}
+	}
+	return ids
+}
+
 // TaskLog 代表任务执行的日志记录
 type TaskLog struct {
-	ID        uint       `json:"id" gorm:"primaryKey"`
-	TaskID    uint       `json:"task_id" gorm:"index"`
-	AgentID   *uint      `json:"agent_id" gorm:"index"` // Agent ID，为空表示本地执行
-	Command   string     `json:"command" gorm:"type:text"`
-	Output    string     `json:"-" gorm:"type:longtext"`      // gzip+base64 压缩后的日志
-	Error     string     `json:"error" gorm:"type:text"`      // 额外的系统错误信息
-	Status    string     `json:"status" gorm:"size:20;index"` // success, failed
-	Duration  int64      `json:"duration"`                    // 执行耗时（毫秒）
-	ExitCode  int        `json:"exit_code"`
-	StartTime *LocalTime `json:"start_time"`
-	EndTime   *LocalTime `json:"end_time"`
-	CreatedAt LocalTime  `json:"created_at"`
+	ID            uint       `json:"id" gorm:"primaryKey"`
+	TaskID        uint       `json:"task_id" gorm:"index"`
+	AgentID       *uint      `json:"agent_id" gorm:"index"`         // Agent ID，为空表示本地执行
+	RunID         string     `json:"run_id" gorm:"size:64;index"`   // Agent 生成的执行标识，用于关联日志、心跳和结果
+	DispatchNote  string     `json:"dispatch_note" gorm:"size:255"` // 未在首选节点执行时的说明（故障转移原因）
+	Command       string     `json:"command" gorm:"type:text"`
+	Output        string     `json:"-" gorm:"type:longtext"`      // gzip+base64 压缩后的日志
+	Error         string     `json:"error" gorm:"type:text"`      // 额外的系统错误信息
+	Status        string     `json:"status" gorm:"size:20;index"` // success, failed
+	Duration      int64      `json:"duration"`                    // 执行耗时（毫秒）
+	ExitCode      int        `json:"exit_code"`
+	LogFormat     string     `json:"log_format" gorm:"size:20"` // 日志格式: 空为原始输出, tagged 为带时间戳和流标记
+	Notifications string     `json:"-" gorm:"type:text"`        // 脚本发送的通知（JSON 数组）
+	StartTime     *LocalTime `json:"start_time"`
+	EndTime       *LocalTime `json:"end_time"`
+	CreatedAt     LocalTime  `json:"created_at"`
 }
 
 func (TaskLog) TableName() string {
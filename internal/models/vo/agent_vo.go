@@ -1,27 +1,40 @@
 package vo
 
 import (
+	"sort"
+
 	"github.com/engigu/baihu-panel/internal/models"
 )
 
 // AgentVO 代理视图对象
 type AgentVO struct {
-	ID          uint              `json:"id"`
-	Name        string            `json:"name"`
-	Description string            `json:"description"`
-	Status      string            `json:"status"`
-	LastSeen    *models.LocalTime `json:"last_seen"`
-	IP          string            `json:"ip"`
-	Version     string            `json:"version"`
-	BuildTime   string            `json:"build_time"`
-	Hostname    string            `json:"hostname"`
-	OS          string            `json:"os"`
-	Arch        string            `json:"arch"`
-	ForceUpdate bool              `json:"force_update"`
-	Enabled     bool              `json:"enabled"`
-	CreatedAt   models.LocalTime  `json:"created_at"`
-	UpdatedAt   models.LocalTime  `json:"updated_at"`
-	// 隐藏 Token 和 MachineID
+	ID              uint                   `json:"id"`
+	Name            string                 `json:"name"`
+	Description     string                 `json:"description"`
+	Group           string                 `json:"group"`
+	Labels          string                 `json:"labels"`
+	ConfigGroup     string                 `json:"config_group"`
+	ConfigLabels    string                 `json:"config_labels"`
+	EffectiveLabels map[string]string      `json:"effective_labels"` // 合并后的标签（含 group）
+	Status          string                 `json:"status"`
+	LastSeen        *models.LocalTime      `json:"last_seen"`
+	IP              string                 `json:"ip"`
+	Version         string                 `json:"version"`
+	BuildTime       string                 `json:"build_time"`
+	Hostname        string                 `json:"hostname"`
+	OS              string                 `json:"os"`
+	Arch            string                 `json:"arch"`
+	ForceUpdate     bool                   `json:"force_update"`
+	CertExpiresAt   *models.LocalTime      `json:"cert_expires_at"` // 客户端证书到期时间，为空表示未申请
+	SignedMessages  bool                   `json:"signed_messages"` // 是否已申请消息签名密钥
+	SecretsPinned   bool                   `json:"secrets_pinned"`  // 是否已记录接收隐藏变量的公钥
+	Enabled         bool                   `json:"enabled"`
+	Approval        string                 `json:"approval"`   // 审批状态
+	MachineID       string                 `json:"machine_id"` // 机器识别码，审批时用于识别机器
+	Telemetry       *models.AgentTelemetry `json:"telemetry"`  // 最近一次上报的资源使用情况
+	CreatedAt       models.LocalTime       `json:"created_at"`
+	UpdatedAt       models.LocalTime       `json:"updated_at"`
+	// 隐藏 Token
 }
 
 // ToAgentVO 将 Agent 模型转换为 AgentVO
@@ -30,21 +43,31 @@ func ToAgentVO(agent *models.Agent) *AgentVO {
 		return nil
 	}
 	return &AgentVO{
-		ID:          agent.ID,
-		Name:        agent.Name,
-		Description: agent.Description,
-		Status:      agent.Status,
-		LastSeen:    agent.LastSeen,
-		IP:          agent.IP,
-		Version:     agent.Version,
-		BuildTime:   agent.BuildTime,
-		Hostname:    agent.Hostname,
-		OS:          agent.OS,
-		Arch:        agent.Arch,
-		ForceUpdate: agent.ForceUpdate,
-		Enabled:     agent.Enabled,
-		CreatedAt:   agent.CreatedAt,
-		UpdatedAt:   agent.UpdatedAt,
+		ID:              agent.ID,
+		Name:            agent.Name,
+		Description:     agent.Description,
+		Group:           agent.Group,
+		Labels:          agent.Labels,
+		ConfigGroup:     agent.ConfigGroup,
+		ConfigLabels:    agent.ConfigLabels,
+		EffectiveLabels: agent.EffectiveLabels(),
+		Status:          agent.Status,
+		LastSeen:        agent.LastSeen,
+		IP:              agent.IP,
+		Version:         agent.Version,
+		BuildTime:       agent.BuildTime,
+		Hostname:        agent.Hostname,
+		OS:              agent.OS,
+		Arch:            agent.Arch,
+		ForceUpdate:     agent.ForceUpdate,
+		CertExpiresAt:   agent.CertExpiresAt,
+		SignedMessages:  agent.SigningKey != "",
+		SecretsPinned:   agent.SecretsKey != "",
+		Enabled:         agent.Enabled,
+		Approval:        agent.Approval,
+		MachineID:       agent.MachineID,
+		CreatedAt:       agent.CreatedAt,
+		UpdatedAt:       agent.UpdatedAt,
 	}
 }
 
@@ -71,14 +94,16 @@ func ToAgentVOListFromModels(agents []models.Agent) []*AgentVO {
 
 // AgentTokenVO 代理令牌视图对象
 type AgentTokenVO struct {
-	ID        uint              `json:"id"`
-	Token     string            `json:"token"`
-	Remark    string            `json:"remark"`
-	MaxUses   int               `json:"max_uses"`
-	UsedCount int               `json:"used_count"`
-	ExpiresAt *models.LocalTime `json:"expires_at"`
-	Enabled   bool              `json:"enabled"`
-	CreatedAt models.LocalTime  `json:"created_at"`
+	ID          uint              `json:"id"`
+	Token       string            `json:"token"`
+	Remark      string            `json:"remark"`
+	MaxUses     int               `json:"max_uses"`
+	UsedCount   int               `json:"used_count"`
+	ExpiresAt   *models.LocalTime `json:"expires_at"`
+	AutoApprove bool              `json:"auto_approve"`
+	Group       string            `json:"group"`
+	Enabled     bool              `json:"enabled"`
+	CreatedAt   models.LocalTime  `json:"created_at"`
 }
 
 // ToAgentTokenVO 将 AgentToken 模型转换为 AgentTokenVO
@@ -87,14 +112,16 @@ func ToAgentTokenVO(token *models.AgentToken) *AgentTokenVO {
 		return nil
 	}
 	return &AgentTokenVO{
-		ID:        token.ID,
-		Token:     token.Token,
-		Remark:    token.Remark,
-		MaxUses:   token.MaxUses,
-		UsedCount: token.UsedCount,
-		ExpiresAt: token.ExpiresAt,
-		Enabled:   token.Enabled,
-		CreatedAt: token.CreatedAt,
+		ID:          token.ID,
+		Token:       token.Token,
+		Remark:      token.Remark,
+		MaxUses:     token.MaxUses,
+		UsedCount:   token.UsedCount,
+		ExpiresAt:   token.ExpiresAt,
+		AutoApprove: token.AutoApprove,
+		Group:       token.Group,
+		Enabled:     token.Enabled,
+		CreatedAt:   token.CreatedAt,
 	}
 }
 
@@ -118,3 +145,67 @@ func ToAgentTokenVOListFromModels(tokens []models.AgentToken) []*AgentTokenVO {
 	}
 	return vos
 }
+
+// AgentConfigOverrideVO Agent 配置文件覆盖面板配置的项
+type AgentConfigOverrideVO struct {
+	Key   string `json:"key"`
+	Local string `json:"local"` // Agent 配置文件中的值
+	Panel string `json:"panel"` // 面板下发的值
+}
+
+// AgentConfigVO Agent 运行配置视图对象
+type AgentConfigVO struct {
+	Version         uint                     `json:"version"`
+	WorkerCount     int                      `json:"worker_count"`
+	QueueSize       int                      `json:"queue_size"`
+	LogLevel        string                   `json:"log_level"`
+	AllowedWorkDirs string                   `json:"allowed_work_dirs"`
+	Proxy           string                   `json:"proxy"`
+	NoProxy         string                   `json:"no_proxy"`
+	MemoryLimit     int                      `json:"memory_limit"`
+	CPULimit        int                      `json:"cpu_limit"`
+	OpenFilesLimit  int                      `json:"open_files_limit"`
+	Group           string                   `json:"group"`
+	Labels          string                   `json:"labels"`
+	Effective       map[string]string        `json:"effective"` // 面板下发的实际值（默认值已替换）
+	AppliedVersion  uint                     `json:"applied_version"`
+	AppliedAt       *models.LocalTime        `json:"applied_at"`
+	ApplyError      string                   `json:"apply_error"`
+	Online          bool                     `json:"online"`
+	Synced          bool                     `json:"synced"` // Agent 已应用最新版本
+	Overrides       []*AgentConfigOverrideVO `json:"overrides"`
+}
+
+// ToAgentConfigVO 转换 Agent 运行配置，effective 为面板下发的值，overrides 为 Agent 回报的本地覆盖
+func ToAgentConfigVO(cfg *models.AgentConfig, agent *models.Agent, effective, overrides map[string]string, online bool) *AgentConfigVO {
+	v := &AgentConfigVO{
+		Version:         cfg.Version,
+		WorkerCount:     cfg.WorkerCount,
+		QueueSize:       cfg.QueueSize,
+		LogLevel:        cfg.LogLevel,
+		AllowedWorkDirs: cfg.AllowedWorkDirs,
+		Proxy:           cfg.Proxy,
+		NoProxy:         cfg.NoProxy,
+		MemoryLimit:     cfg.MemoryLimit,
+		CPULimit:        cfg.CPULimit,
+		OpenFilesLimit:  cfg.OpenFilesLimit,
+		Group:           agent.Group,
+		Labels:          agent.Labels,
+		Effective:       effective,
+		AppliedVersion:  cfg.AppliedVersion,
+		AppliedAt:       cfg.AppliedAt,
+		ApplyError:      cfg.ApplyError,
+		Online:          online,
+		Synced:          cfg.AppliedVersion == cfg.Version && cfg.ApplyError == "",
+		Overrides:       make([]*AgentConfigOverrideVO, 0, len(overrides)),
+	}
+	keys := make([]string, 0, len(overrides))
+	for k := range overrides {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v.Overrides = append(v.Overrides, &AgentConfigOverrideVO{Key: k, Local: overrides[k], Panel: effective[k]})
+	}
+	return v
+}
@@ -7,22 +7,28 @@ import (
 
 // TaskVO 任务视图对象
 type TaskVO struct {
-	ID          uint              `json:"id"`
-	Name        string            `json:"name"`
-	Command     string            `json:"command"`
-	Type        string            `json:"type"`
-	Config      string            `json:"config"`
-	Schedule    string            `json:"schedule"`
-	Timeout     int               `json:"timeout"`
-	WorkDir     string            `json:"work_dir"`
-	CleanConfig string            `json:"clean_config"`
-	Envs        string            `json:"envs"`
-	AgentID     *uint             `json:"agent_id"`
-	Enabled     bool              `json:"enabled"`
-	LastRun     *models.LocalTime `json:"last_run"`
-	NextRun     *models.LocalTime `json:"next_run"`
-	CreatedAt   models.LocalTime  `json:"created_at"`
-	UpdatedAt   models.LocalTime  `json:"updated_at"`
+	ID             uint              `json:"id"`
+	Name           string            `json:"name"`
+	Command        string            `json:"command"`
+	Type           string            `json:"type"`
+	Config         string            `json:"config"`
+	Schedule       string            `json:"schedule"`
+	Timeout        int               `json:"timeout"`
+	WorkDir        string            `json:"work_dir"`
+	CleanConfig    string            `json:"clean_config"`
+	Envs           string            `json:"envs"`
+	AgentID        *uint             `json:"agent_id"`
+	AgentSelector  string            `json:"agent_selector"`
+	DispatchMode   string            `json:"dispatch_mode"`
+	FallbackAgents string            `json:"fallback_agents"`
+	FallbackLocal  bool              `json:"fallback_local"`
+	Enabled        bool              `json:"enabled"`
+	Health         string            `json:"health"`
+	HealthReason   string            `json:"health_reason"`
+	LastRun        *models.LocalTime `json:"last_run"`
+	NextRun        *models.LocalTime `json:"next_run"`
+	CreatedAt      models.LocalTime  `json:"created_at"`
+	UpdatedAt      models.LocalTime  `json:"updated_at"`
 }
 
 // ToTaskVO 将 Task 模型转换为 TaskVO
@@ -31,22 +37,28 @@ func ToTaskVO(task *models.Task) *TaskVO {
 		return nil
 	}
 	return &TaskVO{
-		ID:          task.ID,
-		Name:        task.Name,
-		Command:     task.Command,
-		Type:        task.Type,
-		Config:      task.Config,
-		Schedule:    task.Schedule,
-		Timeout:     task.Timeout,
-		WorkDir:     task.WorkDir,
-		CleanConfig: task.CleanConfig,
-		Envs:        task.Envs,
-		AgentID:     task.AgentID,
-		Enabled:     task.Enabled,
-		LastRun:     task.LastRun,
-		NextRun:     task.NextRun,
-		CreatedAt:   task.CreatedAt,
-		UpdatedAt:   task.UpdatedAt,
+		ID:             task.ID,
+		Name:           task.Name,
+		Command:        task.Command,
+		Type:           task.Type,
+		Config:         task.Config,
+		Schedule:       task.Schedule,
+		Timeout:        task.Timeout,
+		WorkDir:        task.WorkDir,
+		CleanConfig:    task.CleanConfig,
+		Envs:           task.Envs,
+		AgentID:        task.AgentID,
+		AgentSelector:  task.AgentSelector,
+		DispatchMode:   task.DispatchMode,
+		FallbackAgents: task.FallbackAgents,
+		FallbackLocal:  task.FallbackLocal,
+		Enabled:        task.Enabled,
+		Health:         task.Health,
+		HealthReason:   task.HealthReason,
+		LastRun:        task.LastRun,
+		NextRun:        task.NextRun,
+		CreatedAt:      task.CreatedAt,
+		UpdatedAt:      task.UpdatedAt,
 	}
 }
 
@@ -73,20 +85,23 @@ func ToTaskVOListFromModels(tasks []models.Task) []*TaskVO {
 
 // TaskLogVO 任务历史视图对象
 type TaskLogVO struct {
-	ID        uint              `json:"id"`
-	TaskID    uint              `json:"task_id"`
-	TaskName  string            `json:"task_name"`
-	TaskType  string            `json:"task_type"`
-	AgentID   *uint             `json:"agent_id"`
-	Command   string            `json:"command"`
-	Error     string            `json:"error"`
-	Status    string            `json:"status"`
-	Duration  int64             `json:"duration"`
-	ExitCode  int               `json:"exit_code"`
-	StartTime *models.LocalTime `json:"start_time"`
-	EndTime   *models.LocalTime `json:"end_time"`
-	CreatedAt models.LocalTime  `json:"created_at"`
-	Output    string            `json:"output,omitempty"`
+	ID            uint                        `json:"id"`
+	TaskID        uint                        `json:"task_id"`
+	TaskName      string                      `json:"task_name"`
+	TaskType      string                      `json:"task_type"`
+	AgentID       *uint                       `json:"agent_id"`
+	DispatchNote  string                      `json:"dispatch_note"`
+	Command       string                      `json:"command"`
+	Error         string                      `json:"error"`
+	Status        string                      `json:"status"`
+	Duration      int64                       `json:"duration"`
+	ExitCode      int                         `json:"exit_code"`
+	LogFormat     string                      `json:"log_format"`
+	Notifications []models.ScriptNotification `json:"notifications,omitempty"`
+	StartTime     *models.LocalTime           `json:"start_time"`
+	EndTime       *models.LocalTime           `json:"end_time"`
+	CreatedAt     models.LocalTime            `json:"created_at"`
+	Output        string                      `json:"output,omitempty"`
 }
 
 // ToTaskLogVO 将 TaskLog 模型转换为 TaskLogVO
@@ -97,18 +112,21 @@ func ToTaskLogVO(log *models.TaskLog) *TaskLogVO {
 		return nil
 	}
 	return &TaskLogVO{
-		ID:        log.ID,
-		TaskID:    log.TaskID,
-		AgentID:   log.AgentID,
-		Command:   log.Command,
-		Error:     log.Error,
-		Status:    log.Status,
-		Duration:  log.Duration,
-		ExitCode:  log.ExitCode,
-		StartTime: log.StartTime,
-		EndTime:   log.EndTime,
-		CreatedAt: log.CreatedAt,
-		Output:    log.Output,
+		ID:            log.ID,
+		TaskID:        log.TaskID,
+		AgentID:       log.AgentID,
+		DispatchNote:  log.DispatchNote,
+		Command:       log.Command,
+		Error:         log.Error,
+		Status:        log.Status,
+		Duration:      log.Duration,
+		ExitCode:      log.ExitCode,
+		LogFormat:     log.LogFormat,
+		Notifications: models.ParseScriptNotifications(log.Notifications),
+		StartTime:     log.StartTime,
+		EndTime:       log.EndTime,
+		CreatedAt:     log.CreatedAt,
+		Output:        log.Output,
 	}
 }
 
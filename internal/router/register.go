@@ -1,9 +1,15 @@
 package router
 
 import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
 	"github.com/engigu/baihu-panel/internal/constant"
 	"github.com/engigu/baihu-panel/internal/controllers"
 	"github.com/engigu/baihu-panel/internal/services"
+	"github.com/engigu/baihu-panel/internal/services/notify"
 	"github.com/engigu/baihu-panel/internal/services/tasks"
 )
 
@@ -25,6 +31,7 @@ func RegisterControllers() *Controllers {
 	agentWSManager := services.GetAgentWSManager()
 
 	taskLogService := tasks.NewTaskLogService(sendStatsService)
+	tasks.SetHealthSettings(settingsService)
 	// 创建任务执行服务（需要依赖注入）
 
 	// 清理 task 运行状态的任务可以直接由 executorService 承担或在此处通过 Database 直接清理
@@ -36,6 +43,26 @@ func RegisterControllers() *Controllers {
 
 	// 启动计划任务
 	executorService.StartCron()
+	executorService.StartMissedRunMonitor()
+
+	// 启动 Agent 分批升级
+	agentService := services.NewAgentService()
+	rolloutService := services.NewAgentRolloutService(agentService, agentWSManager)
+	rolloutService.Start()
+	remoteService := services.NewAgentRemoteService(agentWSManager)
+	syncService := services.NewAgentSyncService(agentWSManager, constant.ScriptsWorkDir)
+	syncService.Start()
+	configService := services.NewAgentConfigService(agentWSManager, settingsService)
+
+	// 启动全局日志清理
+	retentionService := services.NewLogRetentionService(settingsService, loginLogService)
+	retentionService.Start()
+
+	// 启动通知发送服务，并配置脚本通知（sendNotify）
+	notify.SetSettingsService(settingsService)
+	notify.SetScriptNotifyURL(scriptNotifyURL())
+	notify.WriteScriptHelpers(constant.ScriptsWorkDir)
+	notify.GetNotifyService().Start()
 
 	// 初始化并返回控制器
 	return &Controllers{
@@ -44,15 +71,27 @@ func RegisterControllers() *Controllers {
 		Env:        controllers.NewEnvController(envService),
 		Script:     controllers.NewScriptController(scriptService),
 		Executor:   controllers.NewExecutorController(executorService),
-		File:       controllers.NewFileController(constant.ScriptsWorkDir),
+		File:       controllers.NewFileController(constant.ScriptsWorkDir, remoteService, agentService, userService),
 		Dashboard:  controllers.NewDashboardController(executorService),
 		Log:        controllers.NewLogController(),
 		LogWS:      controllers.NewLogWSController(),
-		Terminal:   controllers.NewTerminalController(envService),
-		Settings:   controllers.NewSettingsController(userService, loginLogService, executorService),
+		Terminal:   controllers.NewTerminalController(envService, remoteService, agentService, userService),
+		Settings:   controllers.NewSettingsController(userService, loginLogService, executorService, retentionService),
 		Dependency: controllers.NewDependencyController(),
-		Agent:      controllers.NewAgentController(settingsService),
+		Agent:      controllers.NewAgentController(agentService, settingsService, rolloutService, remoteService, syncService, configService, executorService),
+		Notify:     controllers.NewNotifyController(settingsService),
+	}
+}
+
+// scriptNotifyURL 返回本地任务访问脚本通知接口的地址
+func scriptNotifyURL() string {
+	cfg := services.GetConfig()
+	host := cfg.Server.Host
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
 	}
+	prefix := strings.TrimSuffix(cfg.Server.URLPrefix, "/")
+	return fmt.Sprintf("http://%s%s/api/notify/send", net.JoinHostPort(host, strconv.Itoa(cfg.Server.Port)), prefix)
 }
 
 // StopCron 停止计划任务服务
@@ -60,4 +99,5 @@ func StopCron() {
 	if executorService != nil {
 		executorService.Stop()
 	}
+	notify.GetNotifyService().Stop()
 }
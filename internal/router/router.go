@@ -27,6 +27,7 @@ type Controllers struct {
 	Settings   *controllers.SettingsController
 	Dependency *controllers.DependencyController
 	Agent      *controllers.AgentController
+	Notify     *controllers.NotifyController
 }
 
 func mustSubFS(fsys fs.FS, dir string) fs.FS {
@@ -110,6 +111,8 @@ func Setup(c *Controllers) *gin.Engine {
 			authorized.GET("/sentence", c.Dashboard.GetSentence)
 			authorized.GET("/sendstats", c.Dashboard.GetSendStats)
 			authorized.GET("/taskstats", c.Dashboard.GetTaskStats)
+			authorized.GET("/taskhealth", c.Dashboard.GetTaskHealth)
+			authorized.GET("/missedruns", c.Dashboard.GetMissedRuns)
 
 			// 任务模块
 			tasks := authorized.Group("/tasks")
@@ -171,7 +174,9 @@ func Setup(c *Controllers) *gin.Engine {
 			{
 				logs.GET("", c.Log.GetLogs)
 				logs.GET("/ws", c.LogWS.StreamLog)
+				logs.GET("/export", c.Log.ExportLogs)
 				logs.GET("/:id", c.Log.GetLogDetail)
+				logs.GET("/:id/lines", c.Log.GetLogLines)
 			}
 
 			// 终端模块
@@ -187,6 +192,13 @@ func Setup(c *Controllers) *gin.Engine {
 				settings.GET("/paths", c.Settings.GetPaths)
 				settings.GET("/scheduler", c.Settings.GetSchedulerSettings)
 				settings.PUT("/scheduler", c.Settings.UpdateSchedulerSettings)
+				settings.GET("/retention", c.Settings.GetRetentionSettings)
+				settings.PUT("/retention", c.Settings.UpdateRetentionSettings)
+				settings.POST("/retention/run", c.Settings.RunRetention)
+				settings.GET("/health", c.Settings.GetHealthSettings)
+				settings.PUT("/health", c.Settings.UpdateHealthSettings)
+				settings.GET("/redaction", c.Settings.GetRedactionSettings)
+				settings.PUT("/redaction", c.Settings.UpdateRedactionSettings)
 				settings.GET("/about", c.Settings.GetAbout)
 				settings.GET("/loginlogs", c.Settings.GetLoginLogs)
 				settings.POST("/backup", c.Settings.CreateBackup)
@@ -208,6 +220,23 @@ func Setup(c *Controllers) *gin.Engine {
 				deps.GET("/installed", c.Dependency.GetInstalled)
 			}
 
+			// 通知模块
+			notifyGroup := authorized.Group("/notify")
+			{
+				notifyGroup.GET("/types", c.Notify.GetTypes)
+				notifyGroup.GET("/channels", c.Notify.ListChannels)
+				notifyGroup.POST("/channels", c.Notify.CreateChannel)
+				notifyGroup.PUT("/channels/:id", c.Notify.UpdateChannel)
+				notifyGroup.DELETE("/channels/:id", c.Notify.DeleteChannel)
+				notifyGroup.POST("/channels/:id/test", c.Notify.TestChannel)
+				notifyGroup.GET("/rules", c.Notify.ListRules)
+				notifyGroup.POST("/rules", c.Notify.CreateRule)
+				notifyGroup.PUT("/rules/:id", c.Notify.UpdateRule)
+				notifyGroup.DELETE("/rules/:id", c.Notify.DeleteRule)
+				notifyGroup.GET("/script", c.Notify.GetScriptSettings)
+				notifyGroup.PUT("/script", c.Notify.UpdateScriptSettings)
+			}
+
 			// Agent routes (Agent 管理)
 			agents := authorized.Group("/agents")
 			{
@@ -216,11 +245,37 @@ func Setup(c *Controllers) *gin.Engine {
 				agents.PUT("/:id", c.Agent.Update)
 				agents.DELETE("/:id", c.Agent.Delete)
 				agents.POST("/:id/token", c.Agent.RegenerateToken)
+				agents.POST("/:id/approve", c.Agent.Approve)
+				agents.POST("/:id/reject", c.Agent.Reject)
 				agents.POST("/:id/update", c.Agent.ForceUpdate)
+				agents.POST("/:id/reset-cert", c.Agent.ResetCert)
+				agents.GET("/:id/telemetry", c.Agent.Telemetry)
+				// 脚本同步
+				agents.GET("/:id/sync", c.Agent.ListSyncMappings)
+				agents.POST("/:id/sync", c.Agent.CreateSyncMapping)
+				agents.POST("/:id/sync/run", c.Agent.RunSync)
+				agents.PUT("/sync/:id", c.Agent.UpdateSyncMapping)
+				agents.DELETE("/sync/:id", c.Agent.DeleteSyncMapping)
+				// 运行配置
+				agents.GET("/:id/config", c.Agent.GetConfig)
+				agents.PUT("/:id/config", c.Agent.SaveConfig)
 				// 令牌管理
 				agents.GET("/tokens", c.Agent.ListTokens)
 				agents.POST("/tokens", c.Agent.CreateToken)
 				agents.DELETE("/tokens/:id", c.Agent.DeleteToken)
+
+				agents.GET("/blocks", c.Agent.ListBlocks)
+				agents.DELETE("/blocks/:id", c.Agent.DeleteBlock)
+				// 远程访问记录
+				agents.GET("/sessions", c.Agent.ListSessions)
+				// 分批升级
+				agents.GET("/rollouts", c.Agent.ListRollouts)
+				agents.POST("/rollouts", c.Agent.CreateRollout)
+				agents.GET("/rollouts/:id", c.Agent.GetRollout)
+				agents.POST("/rollouts/:id/pause", c.Agent.PauseRollout)
+				agents.POST("/rollouts/:id/resume", c.Agent.ResumeRollout)
+				agents.POST("/rollouts/:id/cancel", c.Agent.CancelRollout)
+				agents.DELETE("/rollouts/:id", c.Agent.DeleteRollout)
 			}
 
 			// Agent API（供前端调用，保持在 v1 下）
@@ -234,13 +289,19 @@ func Setup(c *Controllers) *gin.Engine {
 	// Agent API（供远程 Agent 调用，不使用 /v1 版本号）
 	agentAPI := root.Group("/api/agent")
 	{
+		agentAPI.POST("/enroll", c.Agent.Enroll) // 申请客户端证书和签名密钥，不要求客户端证书
 		agentAPI.POST("/heartbeat", c.Agent.Heartbeat)
 		agentAPI.GET("/tasks", c.Agent.GetTasks)
 		agentAPI.POST("/report", c.Agent.ReportResult)
+		agentAPI.GET("/check", c.Agent.Check)       // agent doctor 自检
+		agentAPI.POST("/execute", c.Agent.Execute)  // agent exec 手动执行
 		agentAPI.GET("/download", c.Agent.Download) // 也在这里注册，兼容 Agent 调用
 		agentAPI.GET("/ws", c.Agent.WSConnect)      // WebSocket 连接
 	}
 
+	// 脚本通知接口（供本地任务中的 sendNotify 调用，使用单次执行令牌认证）
+	root.POST("/api/notify/send", c.Notify.SendScriptNotify)
+
 	// SPA 兜底路由 - 返回 index.html（HTML禁用缓存以保证实时同步）
 	// 必须在最后注册，作为兜底路由
 	router.NoRoute(func(ctx *gin.Context) {
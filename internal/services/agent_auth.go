@@ -0,0 +1,150 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"github.com/engigu/baihu-panel/internal/database"
+	"github.com/engigu/baihu-panel/internal/models"
+	"github.com/engigu/baihu-panel/internal/services/agentpki"
+)
+
+// EnrollResult Agent 申请证书和签名密钥的结果
+type EnrollResult struct {
+	AgentID          uint   `json:"agent_id"`
+	Certificate      string `json:"certificate,omitempty"` // 客户端证书（PEM），未提交 CSR 时为空
+	CACertificate    string `json:"ca_certificate"`        // 面板 CA 证书，用于校验 mTLS 端口的服务端证书
+	ExpiresAt        int64  `json:"expires_at,omitempty"`  // 客户端证书到期时间（Unix 时间戳）
+	SigningKey       string `json:"signing_key"`           // WebSocket 消息签名密钥（hex）
+	MTLSPort         int    `json:"mtls_port"`             // mTLS 端口，0 表示未启用
+	RequireMTLS      bool   `json:"require_mtls"`
+	RequireSignature bool   `json:"require_signature"`
+}
+
+// agentAuthConfig 当前的 Agent 认证配置
+func agentAuthConfig() AgentAuthConfig {
+	if Config == nil {
+		return AgentAuthConfig{}
+	}
+	return Config.AgentAuth
+}
+
+// Enroll 为 Agent 签发客户端证书（提交了 CSR 时），并返回消息签名密钥
+// 签名密钥只在首次申请时生成，续签证书不会使已建立连接的签名失效；重新签发证书后旧证书立即失效
+func (s *AgentService) Enroll(agent *models.Agent, csrPEM string) (*EnrollResult, error) {
+	ca, err := agentpki.Load()
+	if err != nil {
+		return nil, fmt.Errorf("加载 Agent CA 失败: %v", err)
+	}
+
+	cfg := agentAuthConfig()
+	result := &EnrollResult{
+		AgentID:          agent.ID,
+		CACertificate:    string(ca.CertPEM()),
+		MTLSPort:         cfg.MTLSPort,
+		RequireMTLS:      cfg.RequireMTLS,
+		RequireSignature: cfg.RequireSignature,
+	}
+	updates := map[string]interface{}{}
+
+	if csrPEM != "" {
+		certPEM, serial, notAfter, err := ca.SignAgentCSR(agent.ID, []byte(csrPEM))
+		if err != nil {
+			return nil, err
+		}
+		expiresAt := models.LocalTime(notAfter)
+		updates["cert_serial"] = serial
+		updates["cert_expires_at"] = &expiresAt
+		result.Certificate = string(certPEM)
+		result.ExpiresAt = notAfter.Unix()
+	}
+
+	if agent.SigningKey == "" {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		agent.SigningKey = hex.EncodeToString(key)
+		updates["signing_key"] = agent.SigningKey
+	}
+	result.SigningKey = agent.SigningKey
+
+	if len(updates) > 0 {
+		if err := database.DB.Model(&models.Agent{}).Where("id = ?", agent.ID).Updates(updates).Error; err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// CheckEnrollment 校验证书申请请求
+// 尚未申请过的 Agent 只需令牌即可首次申请；已申请过的必须通过 mTLS 端口出示当前有效的证书才能续签，
+// 证书丢失或面板 CA 变化时需管理员在面板中重置后重新申请，持有令牌不足以替换已签发的证书和签名密钥
+func (s *AgentService) CheckEnrollment(r *http.Request, agent *models.Agent) error {
+	if agent.CertSerial == "" && agent.SigningKey == "" {
+		return nil
+	}
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return &ServiceError{Message: "该 Agent 已申请过证书，续签需通过 mTLS 端口使用当前证书；证书已丢失时请在面板中重置证书"}
+	}
+	if err := s.CheckClientCert(r, agent); err != nil {
+		return &ServiceError{Message: err.Error() + "；证书已丢失时请在面板中重置证书"}
+	}
+	return nil
+}
+
+// ResetEnrollment 作废 Agent 的客户端证书和签名密钥，并清除记录的隐藏变量公钥，
+// 之后 Agent 可以只凭令牌重新申请，下次请求隐藏变量时重新记录公钥
+func (s *AgentService) ResetEnrollment(id uint) error {
+	result := database.DB.Model(&models.Agent{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"cert_serial":     "",
+		"cert_expires_at": nil,
+		"signing_key":     "",
+		"secrets_key":     "",
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return &ServiceError{Message: "Agent 不存在"}
+	}
+	return nil
+}
+
+// CheckClientCert 校验请求携带的客户端证书
+// 携带证书时必须是该 Agent 当前有效的证书；未携带时只有开启 require_mtls 才拒绝
+func (s *AgentService) CheckClientCert(r *http.Request, agent *models.Agent) error {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		if agentAuthConfig().RequireMTLS {
+			return &ServiceError{Message: "需要客户端证书，请通过 mTLS 端口连接"}
+		}
+		return nil
+	}
+
+	cert := r.TLS.VerifiedChains[0][0]
+	id, ok := agentpki.AgentIDFromCert(cert)
+	if !ok || agent == nil || id != agent.ID {
+		return &ServiceError{Message: "客户端证书与 Agent 不匹配"}
+	}
+	if agent.CertSerial != agentpki.Serial(cert) {
+		return &ServiceError{Message: "客户端证书已失效，请重新申请"}
+	}
+	return nil
+}
+
+// SigningKeyFor 返回 Agent 的消息签名密钥，开启 require_signature 时未申请密钥的 Agent 不允许连接
+func (s *AgentService) SigningKeyFor(agent *models.Agent) ([]byte, error) {
+	if agent.SigningKey == "" {
+		if agentAuthConfig().RequireSignature {
+			return nil, &ServiceError{Message: "需要消息签名密钥，请先通过 /api/agent/enroll 申请"}
+		}
+		return nil, nil
+	}
+	key, err := hex.DecodeString(agent.SigningKey)
+	if err != nil {
+		return nil, fmt.Errorf("签名密钥格式错误: %v", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,288 @@
+package services
+
+import (
+	"encoding/json"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/engigu/baihu-panel/internal/constant"
+	"github.com/engigu/baihu-panel/internal/database"
+	"github.com/engigu/baihu-panel/internal/logger"
+	"github.com/engigu/baihu-panel/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// Agent 运行配置：面板保存后版本号加一并推送给在线的 Agent，Agent 连上面板时也会推送一次；
+// Agent 应用后回报版本和配置文件中覆盖的项，面板据此显示是否已同步以及与本地配置的差异
+
+// AgentConfigPayload 推送给 Agent 的配置，调度参数为 0 时已替换为全局调度设置
+type AgentConfigPayload struct {
+	AgentID         uint     `json:"agent_id"` // Agent 重新注册后 ID 变化，版本号从头计数
+	Version         uint     `json:"version"`
+	WorkerCount     int      `json:"worker_count"`
+	QueueSize       int      `json:"queue_size"`
+	RateInterval    int      `json:"rate_interval"` // 毫秒
+	LogLevel        string   `json:"log_level"`
+	AllowedWorkDirs []string `json:"allowed_work_dirs"`
+	Proxy           string   `json:"proxy"`
+	NoProxy         string   `json:"no_proxy"`
+	MemoryLimit     int      `json:"memory_limit"`
+	CPULimit        int      `json:"cpu_limit"`
+	OpenFilesLimit  int      `json:"open_files_limit"`
+	Group           string   `json:"group"`
+	Labels          string   `json:"labels"`
+}
+
+// Values 可被 Agent 配置文件覆盖的项，值的格式与 Agent 回报的本地值一致
+func (p *AgentConfigPayload) Values() map[string]string {
+	return map[string]string{
+		"worker_count":      strconv.Itoa(p.WorkerCount),
+		"queue_size":        strconv.Itoa(p.QueueSize),
+		"log_level":         p.LogLevel,
+		"allowed_work_dirs": strings.Join(p.AllowedWorkDirs, ","),
+		"proxy":             p.Proxy,
+		"no_proxy":          p.NoProxy,
+		"memory_limit":      strconv.Itoa(p.MemoryLimit),
+		"cpu_limit":         strconv.Itoa(p.CPULimit),
+		"open_files_limit":  strconv.Itoa(p.OpenFilesLimit),
+	}
+}
+
+var windowsAbsPath = regexp.MustCompile(`^[A-Za-z]:[\\/]`)
+
+// AgentConfigService Agent 运行配置服务
+type AgentConfigService struct {
+	wsManager       *AgentWSManager
+	settingsService *SettingsService
+}
+
+// NewAgentConfigService 创建 Agent 运行配置服务
+func NewAgentConfigService(wsManager *AgentWSManager, settingsService *SettingsService) *AgentConfigService {
+	return &AgentConfigService{wsManager: wsManager, settingsService: settingsService}
+}
+
+// Get 获取 Agent 的配置，尚未保存过时返回版本为 0 的默认配置
+func (s *AgentConfigService) Get(agentID uint) *models.AgentConfig {
+	var cfg models.AgentConfig
+	if err := database.DB.Where("agent_id = ?", agentID).First(&cfg).Error; err != nil {
+		return &models.AgentConfig{AgentID: agentID}
+	}
+	return &cfg
+}
+
+// Save 校验并保存配置，版本号加一后推送给 Agent
+func (s *AgentConfigService) Save(cfg *models.AgentConfig) error {
+	if err := normalizeAgentConfig(cfg); err != nil {
+		return err
+	}
+	err := bumpVersion(cfg, map[string]interface{}{
+		"worker_count":      cfg.WorkerCount,
+		"queue_size":        cfg.QueueSize,
+		"log_level":         cfg.LogLevel,
+		"allowed_work_dirs": cfg.AllowedWorkDirs,
+		"proxy":             cfg.Proxy,
+		"no_proxy":          cfg.NoProxy,
+		"memory_limit":      cfg.MemoryLimit,
+		"cpu_limit":         cfg.CPULimit,
+		"open_files_limit":  cfg.OpenFilesLimit,
+	})
+	if err != nil {
+		return err
+	}
+	s.Push(cfg.AgentID)
+	return nil
+}
+
+// Bump 配置之外的内容（如面板设置的分组和标签）变化时，版本号加一并重新推送
+func (s *AgentConfigService) Bump(agentID uint) {
+	if err := bumpVersion(&models.AgentConfig{AgentID: agentID}, nil); err != nil {
+		logger.Warnf("[AgentConfig] 更新 Agent #%d 的配置版本失败: %v", agentID, err)
+		return
+	}
+	s.Push(agentID)
+}
+
+// bumpVersion 在事务中写入 fields 并把版本号原子地加一，并发保存时版本号不会重复；
+// 尚无配置时以版本 1 创建。成功后 cfg 为保存后的配置
+func bumpVersion(cfg *models.AgentConfig, fields map[string]interface{}) error {
+	var err error
+	// 两个请求同时创建时 agent_id 唯一索引冲突，重试一次即改为更新
+	for attempt := 0; attempt < 2; attempt++ {
+		err = database.DB.Transaction(func(tx *gorm.DB) error {
+			updates := map[string]interface{}{"version": gorm.Expr("version + 1")}
+			for k, v := range fields {
+				updates[k] = v
+			}
+			result := tx.Model(&models.AgentConfig{}).Where("agent_id = ?", cfg.AgentID).Updates(updates)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				cfg.ID, cfg.Version = 0, 1
+				return tx.Create(cfg).Error
+			}
+			return tx.Where("agent_id = ?", cfg.AgentID).First(cfg).Error
+		})
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// normalizeAgentConfig 校验配置并整理格式
+func normalizeAgentConfig(cfg *models.AgentConfig) error {
+	if cfg.WorkerCount < 0 || cfg.WorkerCount > 128 {
+		return &ServiceError{Message: "并发执行数需在 0 ~ 128 之间"}
+	}
+	if cfg.QueueSize < 0 || cfg.QueueSize > 100000 {
+		return &ServiceError{Message: "队列大小需在 0 ~ 100000 之间"}
+	}
+	if cfg.MemoryLimit < 0 || cfg.CPULimit < 0 || cfg.OpenFilesLimit < 0 {
+		return &ServiceError{Message: "资源限制不能为负数"}
+	}
+
+	cfg.LogLevel = strings.ToLower(strings.TrimSpace(cfg.LogLevel))
+	switch cfg.LogLevel {
+	case "", "debug", "info", "warn", "error":
+	default:
+		return &ServiceError{Message: "日志级别只能是 debug、info、warn 或 error"}
+	}
+
+	var dirs []string
+	for _, dir := range strings.FieldsFunc(cfg.AllowedWorkDirs, func(r rune) bool { return r == '\n' || r == ',' }) {
+		dir = strings.TrimSpace(dir)
+		if dir == "" {
+			continue
+		}
+		// Agent 可能运行在与面板不同的系统上，两种绝对路径都接受
+		if !strings.HasPrefix(dir, "/") && !windowsAbsPath.MatchString(dir) {
+			return &ServiceError{Message: "允许的工作目录必须是绝对路径: " + dir}
+		}
+		dirs = append(dirs, dir)
+	}
+	cfg.AllowedWorkDirs = strings.Join(dirs, "\n")
+
+	cfg.Proxy = strings.TrimSpace(cfg.Proxy)
+	if cfg.Proxy != "" {
+		u, err := url.Parse(cfg.Proxy)
+		if err != nil || u.Host == "" {
+			return &ServiceError{Message: "代理地址格式错误，如 http://127.0.0.1:7890"}
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return &ServiceError{Message: "代理只支持 http、https 和 socks5"}
+		}
+	}
+	cfg.NoProxy = strings.TrimSpace(cfg.NoProxy)
+	return nil
+}
+
+// Payload 生成推送给 Agent 的配置
+func (s *AgentConfigService) Payload(agent *models.Agent) *AgentConfigPayload {
+	cfg := s.Get(agent.ID)
+	p := &AgentConfigPayload{
+		AgentID:        agent.ID,
+		Version:        cfg.Version,
+		WorkerCount:    cfg.WorkerCount,
+		QueueSize:      cfg.QueueSize,
+		RateInterval:   s.intSetting(constant.KeyRateInterval, 200),
+		LogLevel:       cfg.LogLevel,
+		Proxy:          cfg.Proxy,
+		NoProxy:        cfg.NoProxy,
+		MemoryLimit:    cfg.MemoryLimit,
+		CPULimit:       cfg.CPULimit,
+		OpenFilesLimit: cfg.OpenFilesLimit,
+		Group:          agent.Group,
+		Labels:         agent.Labels,
+	}
+	if p.WorkerCount == 0 {
+		p.WorkerCount = s.intSetting(constant.KeyWorkerCount, 4)
+	}
+	if p.QueueSize == 0 {
+		p.QueueSize = s.intSetting(constant.KeyQueueSize, 100)
+	}
+	if p.LogLevel == "" {
+		p.LogLevel = "info"
+	}
+	p.AllowedWorkDirs = []string{}
+	for _, dir := range strings.Split(cfg.AllowedWorkDirs, "\n") {
+		if dir != "" {
+			p.AllowedWorkDirs = append(p.AllowedWorkDirs, dir)
+		}
+	}
+	return p
+}
+
+func (s *AgentConfigService) intSetting(key string, defaultVal int) int {
+	if v, err := strconv.Atoi(s.settingsService.Get(constant.SectionScheduler, key)); err == nil {
+		return v
+	}
+	return defaultVal
+}
+
+// Push 推送配置给在线的 Agent
+func (s *AgentConfigService) Push(agentID uint) {
+	if !s.wsManager.IsOnline(agentID) {
+		return
+	}
+	var agent models.Agent
+	if err := database.DB.First(&agent, agentID).Error; err != nil {
+		return
+	}
+	if err := s.wsManager.SendToAgent(agentID, WSTypeConfig, s.Payload(&agent)); err != nil {
+		logger.Warnf("[AgentConfig] 推送配置到 Agent #%d 失败: %v", agentID, err)
+	}
+}
+
+// HandleAck 记录 Agent 应用配置的结果
+func (s *AgentConfigService) HandleAck(agentID uint, data json.RawMessage) {
+	var ack struct {
+		Version   uint              `json:"version"`
+		Error     string            `json:"error"`
+		Overrides map[string]string `json:"overrides"`
+	}
+	if err := json.Unmarshal(data, &ack); err != nil {
+		return
+	}
+	overrides, _ := json.Marshal(ack.Overrides)
+	if len(ack.Overrides) == 0 {
+		overrides = nil
+	}
+	if len(ack.Error) > 500 {
+		ack.Error = ack.Error[:500]
+	}
+
+	cfg := s.Get(agentID)
+	if cfg.ID == 0 {
+		database.DB.Create(cfg)
+	}
+	now := models.Now()
+	database.DB.Model(cfg).Updates(map[string]interface{}{
+		"applied_version": ack.Version,
+		"applied_at":      &now,
+		"apply_error":     ack.Error,
+		"overrides":       string(overrides),
+	})
+	if ack.Error != "" {
+		logger.Warnf("[AgentConfig] Agent #%d 应用配置 v%d 失败: %s", agentID, ack.Version, ack.Error)
+	}
+}
+
+// Overrides Agent 回报的配置文件覆盖项
+func (s *AgentConfigService) Overrides(cfg *models.AgentConfig) map[string]string {
+	overrides := make(map[string]string)
+	if cfg.Overrides != "" {
+		json.Unmarshal([]byte(cfg.Overrides), &overrides)
+	}
+	return overrides
+}
+
+// DeleteAgent 删除 Agent 的配置
+func (s *AgentConfigService) DeleteAgent(agentID uint) {
+	database.DB.Where("agent_id = ?", agentID).Delete(&models.AgentConfig{})
+}
@@ -0,0 +1,323 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/engigu/baihu-panel/internal/constant"
+	"github.com/engigu/baihu-panel/internal/database"
+	"github.com/engigu/baihu-panel/internal/models"
+	"github.com/engigu/baihu-panel/internal/utils"
+)
+
+// 远程终端和文件操作复用 Agent 的 WebSocket 连接，每个终端会话和文件请求以 ID 区分
+
+const (
+	remoteShellOpenTimeout = 15 * time.Second
+	remoteFileTimeout      = 30 * time.Second
+	// RemoteFileMaxSize 单次读写或上传的文件总大小上限
+	RemoteFileMaxSize = 8 << 20
+)
+
+// 远程文件操作类型
+const (
+	RemoteFileTree   = "tree"
+	RemoteFileRead   = "read"
+	RemoteFileWrite  = "write"
+	RemoteFileCreate = "create"
+	RemoteFileDelete = "delete"
+	RemoteFileUpload = "upload"
+)
+
+// RemoteFile 上传的单个文件
+type RemoteFile struct {
+	Path    string `json:"path"`
+	Content []byte `json:"content"`
+}
+
+// RemoteFileRequest 发给 Agent 的文件操作请求，路径相对 Agent 的文件根目录
+type RemoteFileRequest struct {
+	ID      string       `json:"id"`
+	Op      string       `json:"op"`
+	Path    string       `json:"path"`
+	IsDir   bool         `json:"is_dir,omitempty"`  // create
+	Content []byte       `json:"content,omitempty"` // write
+	Files   []RemoteFile `json:"files,omitempty"`   // upload
+}
+
+// RemoteFileResponse Agent 返回的文件操作结果
+type RemoteFileResponse struct {
+	ID      string          `json:"id"`
+	Error   string          `json:"error,omitempty"`
+	Tree    json.RawMessage `json:"tree,omitempty"`    // 与本地文件树结构相同，直接返回给前端
+	Content []byte          `json:"content,omitempty"` // read
+}
+
+// remoteShellMessage 终端消息，打开、输入、输出和关闭共用
+type remoteShellMessage struct {
+	Session string `json:"session"`
+	Cols    uint16 `json:"cols,omitempty"`
+	Rows    uint16 `json:"rows,omitempty"`
+	Mode    string `json:"mode,omitempty"` // shell_opened：pty 或 pipe
+	Data    []byte `json:"data,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// RemoteShell 一个 Agent 终端会话
+type RemoteShell struct {
+	ID      string
+	AgentID uint
+	Mode    string      // pty 或 pipe，决定前端的输入方式
+	Output  chan []byte // 终端输出，会话结束后不再写入
+	Done    chan struct{}
+
+	opened    chan remoteShellMessage
+	err       string
+	closeOnce sync.Once
+	service   *AgentRemoteService
+}
+
+// Err 会话结束的原因，正常退出为空
+func (s *RemoteShell) Err() string {
+	<-s.Done
+	return s.err
+}
+
+// Write 发送终端输入
+func (s *RemoteShell) Write(data []byte) error {
+	return s.service.wsManager.TrySend(s.AgentID, WSTypeShellInput, remoteShellMessage{Session: s.ID, Data: data})
+}
+
+// Close 关闭会话并通知 Agent 结束 shell
+func (s *RemoteShell) Close() {
+	if s.finish("") {
+		s.service.wsManager.SendToAgent(s.AgentID, WSTypeShellClose, remoteShellMessage{Session: s.ID})
+	}
+}
+
+// finish 结束会话，返回是否由本次调用结束
+func (s *RemoteShell) finish(errMsg string) bool {
+	done := false
+	s.closeOnce.Do(func() {
+		s.err = errMsg
+		s.service.mu.Lock()
+		delete(s.service.shells, s.ID)
+		s.service.mu.Unlock()
+		close(s.Done)
+		done = true
+	})
+	return done
+}
+
+// pendingFileRequest 等待 Agent 返回的文件请求
+type pendingFileRequest struct {
+	agentID uint
+	ch      chan *RemoteFileResponse
+}
+
+// AgentRemoteService Agent 远程终端和文件访问
+type AgentRemoteService struct {
+	wsManager *AgentWSManager
+	shells    map[string]*RemoteShell
+	files     map[string]*pendingFileRequest
+	mu        sync.Mutex
+}
+
+// NewAgentRemoteService 创建 Agent 远程访问服务
+func NewAgentRemoteService(wsManager *AgentWSManager) *AgentRemoteService {
+	return &AgentRemoteService{
+		wsManager: wsManager,
+		shells:    make(map[string]*RemoteShell),
+		files:     make(map[string]*pendingFileRequest),
+	}
+}
+
+// OpenShell 在 Agent 上打开终端，等待 Agent 确认后返回
+func (s *AgentRemoteService) OpenShell(agentID uint, cols, rows uint16) (*RemoteShell, error) {
+	shell := &RemoteShell{
+		ID:      utils.RandomString(16),
+		AgentID: agentID,
+		Output:  make(chan []byte, 256),
+		Done:    make(chan struct{}),
+		opened:  make(chan remoteShellMessage, 1),
+		service: s,
+	}
+	s.mu.Lock()
+	s.shells[shell.ID] = shell
+	s.mu.Unlock()
+
+	if err := s.wsManager.TrySend(agentID, WSTypeShellOpen, remoteShellMessage{Session: shell.ID, Cols: cols, Rows: rows}); err != nil {
+		shell.finish(err.Error())
+		return nil, err
+	}
+
+	select {
+	case msg := <-shell.opened:
+		if msg.Error != "" {
+			shell.finish(msg.Error)
+			return nil, fmt.Errorf("%s", msg.Error)
+		}
+		shell.Mode = msg.Mode
+		return shell, nil
+	case <-shell.Done:
+		return nil, fmt.Errorf("%s", shell.err)
+	case <-time.After(remoteShellOpenTimeout):
+		shell.Close()
+		return nil, fmt.Errorf("等待 Agent 打开终端超时，请确认 Agent 版本支持远程终端")
+	}
+}
+
+// HandleShellMessage 处理 Agent 发来的终端消息
+func (s *AgentRemoteService) HandleShellMessage(agentID uint, msgType string, data json.RawMessage) {
+	var msg remoteShellMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return
+	}
+	s.mu.Lock()
+	shell, ok := s.shells[msg.Session]
+	s.mu.Unlock()
+	if !ok || shell.AgentID != agentID {
+		return
+	}
+
+	switch msgType {
+	case WSTypeShellOpened:
+		select {
+		case shell.opened <- msg:
+		default:
+		}
+	case WSTypeShellOutput:
+		select {
+		case shell.Output <- msg.Data:
+		case <-shell.Done:
+		default:
+			// 浏览器消费过慢时结束会话，避免阻塞 Agent 的消息读取
+			if shell.finish("终端输出积压，会话已关闭") {
+				s.wsManager.SendToAgent(agentID, WSTypeShellClose, remoteShellMessage{Session: shell.ID})
+			}
+		}
+	case WSTypeShellClose:
+		shell.finish(msg.Error)
+	}
+}
+
+// FileRequest 向 Agent 发送文件操作请求并等待结果
+func (s *AgentRemoteService) FileRequest(agentID uint, req *RemoteFileRequest) (*RemoteFileResponse, error) {
+	req.ID = utils.RandomString(16)
+	pending := &pendingFileRequest{agentID: agentID, ch: make(chan *RemoteFileResponse, 1)}
+	s.mu.Lock()
+	s.files[req.ID] = pending
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.files, req.ID)
+		s.mu.Unlock()
+	}()
+
+	if err := s.wsManager.TrySend(agentID, WSTypeFileRequest, req); err != nil {
+		return nil, err
+	}
+
+	select {
+	case resp := <-pending.ch:
+		if resp == nil {
+			return nil, fmt.Errorf("Agent 已断开")
+		}
+		if resp.Error != "" {
+			return nil, fmt.Errorf("%s", resp.Error)
+		}
+		return resp, nil
+	case <-time.After(remoteFileTimeout):
+		return nil, fmt.Errorf("等待 Agent 响应超时，请确认 Agent 版本支持远程文件访问")
+	}
+}
+
+// HandleFileResponse 处理 Agent 返回的文件操作结果
+func (s *AgentRemoteService) HandleFileResponse(agentID uint, data json.RawMessage) {
+	var resp RemoteFileResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return
+	}
+	s.mu.Lock()
+	pending, ok := s.files[resp.ID]
+	s.mu.Unlock()
+	if !ok || pending.agentID != agentID {
+		return
+	}
+	select {
+	case pending.ch <- &resp:
+	default:
+	}
+}
+
+// CloseAgent Agent 断开时结束它的所有终端会话和等待中的文件请求
+func (s *AgentRemoteService) CloseAgent(agentID uint) {
+	s.mu.Lock()
+	var shells []*RemoteShell
+	for _, shell := range s.shells {
+		if shell.AgentID == agentID {
+			shells = append(shells, shell)
+		}
+	}
+	for _, pending := range s.files {
+		if pending.agentID == agentID {
+			select {
+			case pending.ch <- nil:
+			default:
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	for _, shell := range shells {
+		shell.finish("Agent 已断开")
+	}
+}
+
+// StartSession 记录一次远程访问
+func (s *AgentRemoteService) StartSession(userID uint, username string, agent *models.Agent, kind, action, path, ip string) *models.AgentSession {
+	session := &models.AgentSession{
+		UserID:    userID,
+		Username:  username,
+		AgentID:   agent.ID,
+		AgentName: agent.Name,
+		Kind:      kind,
+		Action:    action,
+		Path:      path,
+		IP:        ip,
+	}
+	if kind == constant.AgentSessionFile {
+		now := models.Now()
+		session.EndedAt = &now
+	}
+	database.DB.Create(session)
+	return session
+}
+
+// EndSession 终端会话结束，或文件操作失败时补充结果
+func (s *AgentRemoteService) EndSession(session *models.AgentSession, errMsg string) {
+	now := models.Now()
+	if len(errMsg) > 500 {
+		errMsg = errMsg[:500]
+	}
+	database.DB.Model(session).Updates(map[string]interface{}{
+		"ended_at": &now,
+		"error":    errMsg,
+	})
+}
+
+// ListSessions 分页查询远程访问记录
+func (s *AgentRemoteService) ListSessions(page, pageSize int, agentID uint) ([]models.AgentSession, int64) {
+	var sessions []models.AgentSession
+	var total int64
+
+	query := database.DB.Model(&models.AgentSession{})
+	if agentID > 0 {
+		query = query.Where("agent_id = ?", agentID)
+	}
+	query.Count(&total)
+	query.Order("id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&sessions)
+	return sessions, total
+}
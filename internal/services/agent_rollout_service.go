@@ -0,0 +1,549 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/engigu/baihu-panel/internal/constant"
+	"github.com/engigu/baihu-panel/internal/database"
+	"github.com/engigu/baihu-panel/internal/logger"
+	"github.com/engigu/baihu-panel/internal/models"
+)
+
+// rolloutCheckInterval 检查升级计划进度的间隔
+const rolloutCheckInterval = 15 * time.Second
+
+// RolloutWave 解析后的批次定义
+type RolloutWave struct {
+	Percent  int                  // 累计百分比，为 0 时按标签选择
+	Selector models.LabelSelector // 标签选择器
+	Raw      string
+}
+
+// ParseRolloutWaves 解析批次定义，如 "env=staging;10%;100%"
+// 百分比为累计值，按计划内 Agent 总数计算；标签批次包含剩余 Agent 中所有匹配的
+func ParseRolloutWaves(s string) ([]RolloutWave, error) {
+	var waves []RolloutWave
+	lastPercent := 0
+	for _, item := range strings.Split(s, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if p, ok := strings.CutSuffix(item, "%"); ok {
+			percent, err := strconv.Atoi(strings.TrimSpace(p))
+			if err != nil || percent <= 0 || percent > 100 {
+				return nil, fmt.Errorf("无效的批次百分比: %s", item)
+			}
+			if percent <= lastPercent {
+				return nil, fmt.Errorf("批次百分比需递增: %s", item)
+			}
+			lastPercent = percent
+			waves = append(waves, RolloutWave{Percent: percent, Raw: item})
+			continue
+		}
+		sel, err := models.ParseLabelSelector(item)
+		if err != nil {
+			return nil, err
+		}
+		waves = append(waves, RolloutWave{Selector: sel, Raw: item})
+	}
+	if len(waves) == 0 {
+		return nil, fmt.Errorf("至少需要一个批次")
+	}
+	return waves, nil
+}
+
+// CreateRolloutRequest 创建升级计划的参数
+type CreateRolloutRequest struct {
+	Name          string `json:"name"`
+	TargetVersion string `json:"target_version"` // 为空时使用面板当前提供的版本
+	Selector      string `json:"selector"`
+	Waves         string `json:"waves"`
+	WaveTimeout   int    `json:"wave_timeout"`
+	MaxFailures   int    `json:"max_failures"`
+}
+
+// RolloutSummary 升级计划及各状态的 Agent 数量
+type RolloutSummary struct {
+	models.AgentRollout
+	WaveCount int            `json:"wave_count"`
+	Total     int            `json:"total"`
+	Counts    map[string]int `json:"counts"` // 按 constant.RolloutTarget* 统计
+}
+
+// RolloutTargetDetail 升级计划中单个 Agent 的进度及当前版本
+type RolloutTargetDetail struct {
+	models.AgentRolloutTarget
+	AgentName string `json:"agent_name"`
+	Online    bool   `json:"online"`
+	Version   string `json:"version"` // Agent 当前上报的版本
+	BuildTime string `json:"build_time"`
+}
+
+// AgentRolloutService Agent 分批升级
+// 计划内尚未轮到的 Agent 不会收到自动更新提示；轮到的 Agent 通过强制更新标志升级，
+// 在 wave_timeout 内上报目标版本视为成功，失败数超过 max_failures 时暂停计划
+type AgentRolloutService struct {
+	agentService *AgentService
+	wsManager    *AgentWSManager
+	mu           sync.Mutex // 保证同一时间只有一次推进
+	stopCh       chan struct{}
+	startOnce    sync.Once
+}
+
+// NewAgentRolloutService 创建升级计划服务
+func NewAgentRolloutService(agentService *AgentService, wsManager *AgentWSManager) *AgentRolloutService {
+	return &AgentRolloutService{
+		agentService: agentService,
+		wsManager:    wsManager,
+		stopCh:       make(chan struct{}),
+	}
+}
+
+// Start 启动后台推进协程
+func (s *AgentRolloutService) Start() {
+	s.startOnce.Do(func() {
+		go s.loop()
+	})
+}
+
+// Stop 停止后台推进协程
+func (s *AgentRolloutService) Stop() {
+	close(s.stopCh)
+}
+
+func (s *AgentRolloutService) loop() {
+	ticker := time.NewTicker(rolloutCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						logger.Errorf("[Rollout] 推进升级计划时发生 Panic: %v", r)
+					}
+				}()
+				s.Advance()
+			}()
+		}
+	}
+}
+
+// Create 创建并启动升级计划，按批次定义为每个需要升级的 Agent 分配批次
+func (s *AgentRolloutService) Create(req *CreateRolloutRequest) (*models.AgentRollout, error) {
+	latest := s.agentService.GetLatestVersion()
+	if latest == "" {
+		return nil, &ServiceError{Message: "面板未提供 Agent 发布包"}
+	}
+	if req.TargetVersion != "" && req.TargetVersion != latest {
+		return nil, &ServiceError{Message: fmt.Sprintf("面板当前提供的版本为 %s，无法升级到 %s", latest, req.TargetVersion)}
+	}
+	waves, err := ParseRolloutWaves(req.Waves)
+	if err != nil {
+		return nil, &ServiceError{Message: err.Error()}
+	}
+	var selector models.LabelSelector
+	if strings.TrimSpace(req.Selector) != "" {
+		if selector, err = models.ParseLabelSelector(req.Selector); err != nil {
+			return nil, &ServiceError{Message: err.Error()}
+		}
+	}
+	if req.WaveTimeout <= 0 {
+		req.WaveTimeout = 600
+	}
+	if req.MaxFailures < 0 {
+		req.MaxFailures = 0
+	}
+
+	var active int64
+	database.DB.Model(&models.AgentRollout{}).
+		Where("status IN ?", []string{constant.RolloutStatusRunning, constant.RolloutStatusPaused}).
+		Count(&active)
+	if active > 0 {
+		return nil, &ServiceError{Message: "已有进行中的升级计划，请先完成或取消"}
+	}
+
+	// 参与升级的 Agent：启用、匹配选择器且版本不是目标版本；在线的优先进入靠前的批次
+	var candidates []models.Agent
+	for _, agent := range s.agentService.List() {
+		if !agent.Enabled || agent.Version == latest {
+			continue
+		}
+		if selector != nil && !selector.Matches(agent.EffectiveLabels()) {
+			continue
+		}
+		candidates = append(candidates, agent)
+	}
+	if len(candidates) == 0 {
+		return nil, &ServiceError{Message: "没有需要升级的 Agent"}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		oi := s.wsManager.IsOnline(candidates[i].ID)
+		oj := s.wsManager.IsOnline(candidates[j].ID)
+		if oi != oj {
+			return oi
+		}
+		return candidates[i].ID < candidates[j].ID
+	})
+
+	targets := assignRolloutWaves(candidates, waves)
+	if len(targets) == 0 {
+		return nil, &ServiceError{Message: "批次定义未覆盖任何需要升级的 Agent"}
+	}
+
+	now := models.Now()
+	rollout := &models.AgentRollout{
+		Name:          req.Name,
+		TargetVersion: latest,
+		Selector:      strings.TrimSpace(req.Selector),
+		Waves:         req.Waves,
+		WaveTimeout:   req.WaveTimeout,
+		MaxFailures:   req.MaxFailures,
+		Status:        constant.RolloutStatusRunning,
+		WaveStartedAt: &now,
+	}
+	if rollout.Name == "" {
+		rollout.Name = "升级到 " + latest
+	}
+	if err := database.DB.Create(rollout).Error; err != nil {
+		return nil, err
+	}
+	for i := range targets {
+		targets[i].RolloutID = rollout.ID
+	}
+	if err := database.DB.CreateInBatches(targets, 100).Error; err != nil {
+		return nil, err
+	}
+
+	logger.Infof("[Rollout] 创建升级计划 #%d: %s，%d 个 Agent，%d 个批次", rollout.ID, rollout.Name, len(targets), len(waves))
+	s.Advance()
+	return rollout, nil
+}
+
+// assignRolloutWaves 按批次定义依次分配 Agent，未被任何批次覆盖的 Agent 不参与本次升级
+func assignRolloutWaves(candidates []models.Agent, waves []RolloutWave) []models.AgentRolloutTarget {
+	assigned := make([]bool, len(candidates))
+	count := 0
+	var targets []models.AgentRolloutTarget
+	add := func(i, wave int) {
+		assigned[i] = true
+		count++
+		targets = append(targets, models.AgentRolloutTarget{
+			AgentID:     candidates[i].ID,
+			Wave:        wave,
+			FromVersion: candidates[i].Version,
+			Status:      constant.RolloutTargetPending,
+		})
+	}
+
+	for w, wave := range waves {
+		if wave.Selector != nil {
+			for i := range candidates {
+				if !assigned[i] && wave.Selector.Matches(candidates[i].EffectiveLabels()) {
+					add(i, w)
+				}
+			}
+			continue
+		}
+		want := int(math.Ceil(float64(len(candidates)) * float64(wave.Percent) / 100))
+		for i := range candidates {
+			if count >= want {
+				break
+			}
+			if !assigned[i] {
+				add(i, w)
+			}
+		}
+	}
+	return targets
+}
+
+// Advance 推进进行中的升级计划：下发当前批次、判定超时、暂停或进入下一批次
+func (s *AgentRolloutService) Advance() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var rollouts []models.AgentRollout
+	database.DB.Where("status = ?", constant.RolloutStatusRunning).Find(&rollouts)
+	for i := range rollouts {
+		s.advance(&rollouts[i])
+	}
+}
+
+func (s *AgentRolloutService) advance(r *models.AgentRollout) {
+	if latest := s.agentService.GetLatestVersion(); latest != r.TargetVersion {
+		s.pause(r, fmt.Sprintf("面板提供的版本已变为 %s", latest))
+		return
+	}
+
+	var targets []models.AgentRolloutTarget
+	database.DB.Where("rollout_id = ? AND wave <= ?", r.ID, r.CurrentWave).Find(&targets)
+
+	now := models.Now()
+	deadline := time.Now().Add(-time.Duration(r.WaveTimeout) * time.Second)
+	for i := range targets {
+		t := &targets[i]
+		switch t.Status {
+		case constant.RolloutTargetPending:
+			s.release(t)
+		case constant.RolloutTargetUpdating:
+			if t.StartedAt != nil && t.StartedAt.Time().Before(deadline) {
+				version := ""
+				if agent := s.agentService.GetByID(t.AgentID); agent != nil {
+					version = agent.Version
+				}
+				t.Status = constant.RolloutTargetFailed
+				t.Error = fmt.Sprintf("%d 秒内未上报新版本（当前 %s）", r.WaveTimeout, version)
+				t.FinishedAt = &now
+				database.DB.Model(t).Updates(map[string]interface{}{
+					"status": t.Status, "error": t.Error, "finished_at": t.FinishedAt,
+				})
+				s.agentService.ClearForceUpdate(t.AgentID)
+				logger.Warnf("[Rollout] 计划 #%d: Agent #%d 升级失败: %s", r.ID, t.AgentID, t.Error)
+			}
+		}
+	}
+
+	var failed int64
+	database.DB.Model(&models.AgentRolloutTarget{}).
+		Where("rollout_id = ? AND status = ?", r.ID, constant.RolloutTargetFailed).
+		Count(&failed)
+	if int(failed) > r.MaxFailures {
+		s.pause(r, fmt.Sprintf("%d 个 Agent 升级失败，超过允许的 %d 个", failed, r.MaxFailures))
+		return
+	}
+
+	for _, t := range targets {
+		if t.Status == constant.RolloutTargetPending || t.Status == constant.RolloutTargetUpdating {
+			return
+		}
+	}
+
+	// 当前批次已结束，进入下一个有 Agent 的批次
+	var next models.AgentRolloutTarget
+	if err := database.DB.Where("rollout_id = ? AND wave > ?", r.ID, r.CurrentWave).Order("wave").First(&next).Error; err != nil {
+		database.DB.Model(r).Updates(map[string]interface{}{
+			"status": constant.RolloutStatusCompleted, "finished_at": &now,
+		})
+		logger.Infof("[Rollout] 升级计划 #%d 已完成", r.ID)
+		return
+	}
+	r.CurrentWave = next.Wave
+	database.DB.Model(r).Updates(map[string]interface{}{
+		"current_wave": r.CurrentWave, "wave_started_at": &now,
+	})
+	logger.Infof("[Rollout] 升级计划 #%d 进入第 %d 批", r.ID, r.CurrentWave+1)
+	s.advance(r)
+}
+
+// release 向 Agent 下发更新：设置强制更新标志（离线的 Agent 上线后心跳时生效），在线时立即通知
+func (s *AgentRolloutService) release(t *models.AgentRolloutTarget) {
+	now := models.Now()
+	t.Status = constant.RolloutTargetUpdating
+	t.StartedAt = &now
+	database.DB.Model(t).Updates(map[string]interface{}{"status": t.Status, "started_at": t.StartedAt})
+
+	s.agentService.SetForceUpdate(t.AgentID, false)
+	if s.wsManager.IsOnline(t.AgentID) {
+		s.wsManager.SendToAgent(t.AgentID, WSTypeUpdate, nil)
+	}
+}
+
+func (s *AgentRolloutService) pause(r *models.AgentRollout, reason string) {
+	database.DB.Model(r).Updates(map[string]interface{}{
+		"status": constant.RolloutStatusPaused, "pause_reason": reason,
+	})
+	logger.Warnf("[Rollout] 升级计划 #%d 已暂停: %s", r.ID, reason)
+}
+
+// OnHeartbeat Agent 上报版本时更新其在进行中计划里的进度
+func (s *AgentRolloutService) OnHeartbeat(agentID uint, version string) {
+	var targets []models.AgentRolloutTarget
+	database.DB.Table(models.AgentRolloutTarget{}.TableName()+" AS t").
+		Select("t.*").
+		Joins("JOIN "+models.AgentRollout{}.TableName()+" AS r ON r.id = t.rollout_id").
+		Where("t.agent_id = ? AND t.status IN ? AND r.status IN ? AND r.target_version = ?", agentID,
+			[]string{constant.RolloutTargetUpdating, constant.RolloutTargetFailed, constant.RolloutTargetSkipped},
+			[]string{constant.RolloutStatusRunning, constant.RolloutStatusPaused}, version).
+		Find(&targets)
+	if len(targets) == 0 {
+		return
+	}
+
+	// 超时后才完成升级的 Agent 也视为成功，不再计入失败数
+	now := models.Now()
+	for i := range targets {
+		database.DB.Model(&targets[i]).Updates(map[string]interface{}{
+			"status": constant.RolloutTargetSuccess, "error": "", "finished_at": &now,
+		})
+	}
+	s.agentService.ClearForceUpdate(agentID)
+}
+
+// HoldsUpdate Agent 在进行中的计划里尚未轮到时返回 true，此时不提示自动更新
+func (s *AgentRolloutService) HoldsUpdate(agentID uint) bool {
+	var count int64
+	database.DB.Table(models.AgentRolloutTarget{}.TableName()+" AS t").
+		Joins("JOIN "+models.AgentRollout{}.TableName()+" AS r ON r.id = t.rollout_id").
+		Where("t.agent_id = ? AND t.status IN ? AND r.status IN ?", agentID,
+			[]string{constant.RolloutTargetPending, constant.RolloutTargetFailed, constant.RolloutTargetSkipped},
+			[]string{constant.RolloutStatusRunning, constant.RolloutStatusPaused}).
+		Count(&count)
+	return count > 0
+}
+
+// List 返回升级计划列表及进度统计
+func (s *AgentRolloutService) List() []RolloutSummary {
+	var rollouts []models.AgentRollout
+	database.DB.Order("id DESC").Limit(50).Find(&rollouts)
+
+	summaries := make([]RolloutSummary, len(rollouts))
+	for i, r := range rollouts {
+		summaries[i] = s.summarize(r)
+	}
+	return summaries
+}
+
+func (s *AgentRolloutService) summarize(r models.AgentRollout) RolloutSummary {
+	summary := RolloutSummary{AgentRollout: r, Counts: map[string]int{}}
+	if waves, err := ParseRolloutWaves(r.Waves); err == nil {
+		summary.WaveCount = len(waves)
+	}
+
+	var rows []struct {
+		Status string
+		Count  int
+	}
+	database.DB.Model(&models.AgentRolloutTarget{}).
+		Select("status, COUNT(*) AS count").
+		Where("rollout_id = ?", r.ID).
+		Group("status").
+		Scan(&rows)
+	for _, row := range rows {
+		summary.Counts[row.Status] = row.Count
+		summary.Total += row.Count
+	}
+	return summary
+}
+
+// Get 返回升级计划及每个 Agent 的进度
+func (s *AgentRolloutService) Get(id uint) (*RolloutSummary, []RolloutTargetDetail, error) {
+	var r models.AgentRollout
+	if err := database.DB.First(&r, id).Error; err != nil {
+		return nil, nil, &ServiceError{Message: "升级计划不存在"}
+	}
+	summary := s.summarize(r)
+
+	var targets []models.AgentRolloutTarget
+	database.DB.Where("rollout_id = ?", id).Order("wave, id").Find(&targets)
+
+	agents := make(map[uint]models.Agent)
+	for _, agent := range s.agentService.List() {
+		agents[agent.ID] = agent
+	}
+	details := make([]RolloutTargetDetail, len(targets))
+	for i, t := range targets {
+		details[i] = RolloutTargetDetail{AgentRolloutTarget: t, Online: s.wsManager.IsOnline(t.AgentID)}
+		if agent, ok := agents[t.AgentID]; ok {
+			details[i].AgentName = agent.Name
+			details[i].Version = agent.Version
+			details[i].BuildTime = agent.BuildTime
+		}
+	}
+	return &summary, details, nil
+}
+
+// Pause 手动暂停
+func (s *AgentRolloutService) Pause(id uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, err := s.getWithStatus(id, constant.RolloutStatusRunning)
+	if err != nil {
+		return err
+	}
+	s.pause(r, "手动暂停")
+	return nil
+}
+
+// Resume 恢复暂停的计划，已失败的 Agent 标记为跳过，不再计入失败数
+func (s *AgentRolloutService) Resume(id uint) error {
+	s.mu.Lock()
+	r, err := s.getWithStatus(id, constant.RolloutStatusPaused)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	if latest := s.agentService.GetLatestVersion(); latest != r.TargetVersion {
+		s.mu.Unlock()
+		return &ServiceError{Message: fmt.Sprintf("面板提供的版本已变为 %s，请取消后重新创建计划", latest)}
+	}
+	database.DB.Model(&models.AgentRolloutTarget{}).
+		Where("rollout_id = ? AND status = ?", id, constant.RolloutTargetFailed).
+		Update("status", constant.RolloutTargetSkipped)
+	database.DB.Model(r).Updates(map[string]interface{}{
+		"status": constant.RolloutStatusRunning, "pause_reason": "",
+	})
+	s.mu.Unlock()
+
+	logger.Infof("[Rollout] 升级计划 #%d 已恢复", id)
+	s.Advance()
+	return nil
+}
+
+// Cancel 取消计划，尚未轮到的 Agent 恢复按自动更新设置升级
+func (s *AgentRolloutService) Cancel(id uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var r models.AgentRollout
+	if err := database.DB.First(&r, id).Error; err != nil {
+		return &ServiceError{Message: "升级计划不存在"}
+	}
+	if r.Status != constant.RolloutStatusRunning && r.Status != constant.RolloutStatusPaused {
+		return &ServiceError{Message: "升级计划已结束"}
+	}
+
+	var updating []models.AgentRolloutTarget
+	database.DB.Where("rollout_id = ? AND status = ?", id, constant.RolloutTargetUpdating).Find(&updating)
+	for _, t := range updating {
+		s.agentService.ClearForceUpdate(t.AgentID)
+	}
+	now := models.Now()
+	database.DB.Model(&r).Updates(map[string]interface{}{
+		"status": constant.RolloutStatusCancelled, "finished_at": &now,
+	})
+	logger.Infof("[Rollout] 升级计划 #%d 已取消", id)
+	return nil
+}
+
+// Delete 删除已结束的计划
+func (s *AgentRolloutService) Delete(id uint) error {
+	var r models.AgentRollout
+	if err := database.DB.First(&r, id).Error; err != nil {
+		return &ServiceError{Message: "升级计划不存在"}
+	}
+	if r.Status == constant.RolloutStatusRunning || r.Status == constant.RolloutStatusPaused {
+		return &ServiceError{Message: "请先取消进行中的升级计划"}
+	}
+	database.DB.Where("rollout_id = ?", id).Delete(&models.AgentRolloutTarget{})
+	return database.DB.Delete(&r).Error
+}
+
+func (s *AgentRolloutService) getWithStatus(id uint, status string) (*models.AgentRollout, error) {
+	var r models.AgentRollout
+	if err := database.DB.First(&r, id).Error; err != nil {
+		return nil, &ServiceError{Message: "升级计划不存在"}
+	}
+	if r.Status != status {
+		return nil, &ServiceError{Message: "升级计划当前状态不允许该操作"}
+	}
+	return &r, nil
+}
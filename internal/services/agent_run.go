@@ -0,0 +1,187 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"github.com/engigu/baihu-panel/internal/constant"
+	"github.com/engigu/baihu-panel/internal/database"
+	"github.com/engigu/baihu-panel/internal/logger"
+	"github.com/engigu/baihu-panel/internal/models"
+	"github.com/engigu/baihu-panel/internal/services/tasks"
+	"github.com/engigu/baihu-panel/internal/utils"
+)
+
+// AgentRunLostError Agent 执行长时间没有任何消息时写入日志的错误信息
+const AgentRunLostError = "Agent 长时间未发送日志或心跳，执行状态未知"
+
+// agentRunIdleTimeout Agent 执行无消息超过该时长视为中断
+const agentRunIdleTimeout = 10 * time.Minute
+
+// AgentRunRef Agent 消息中的执行标识
+// run_id 由 Agent 在执行开始时生成，是 task_log / task_heartbeat / task_result 的关联主键；
+// log_id 仅在服务端下发的手动执行中存在
+type AgentRunRef struct {
+	RunID     string `json:"run_id"`
+	TaskID    uint   `json:"task_id"`
+	LogID     uint   `json:"log_id"`
+	StartTime int64  `json:"start_time"` // Unix 时间戳
+}
+
+// agentRun 进行中的 Agent 执行
+type agentRun struct {
+	logID    uint
+	agentID  uint
+	lazy     bool // 日志由服务端在首次收到消息时创建（计划任务）
+	lastSeen time.Time
+}
+
+var (
+	agentRuns   = make(map[string]*agentRun) // run_id -> 执行
+	agentRunsMu sync.Mutex
+)
+
+// ResolveAgentRun 返回执行对应的日志 ID
+// 首次收到某个 run_id 的消息时：手动执行沿用服务端已创建的日志，计划任务则创建运行中的日志；
+// live 为 true 时同时创建 TinyLog，使 Agent 计划任务的实时日志与本地任务一致
+func (s *AgentService) ResolveAgentRun(agentID uint, ref AgentRunRef, live bool) uint {
+	if ref.RunID == "" {
+		return ref.LogID
+	}
+
+	agentRunsMu.Lock()
+	defer agentRunsMu.Unlock()
+
+	if run, ok := agentRuns[ref.RunID]; ok && run.agentID == agentID {
+		run.lastSeen = time.Now()
+		return run.logID
+	}
+
+	// 服务重启或已完成的执行
+	var taskLog models.TaskLog
+	if err := database.DB.Select("id", "status").Where("run_id = ? AND agent_id = ?", ref.RunID, agentID).
+		First(&taskLog).Error; err == nil {
+		if taskLog.Status == constant.TaskStatusRunning {
+			agentRuns[ref.RunID] = &agentRun{logID: taskLog.ID, agentID: agentID, lazy: ref.LogID == 0, lastSeen: time.Now()}
+			if live && tasks.GetActiveLog(taskLog.ID) == nil {
+				openAgentRunLog(taskLog.ID, ref.TaskID)
+			}
+		}
+		return taskLog.ID
+	}
+
+	// 手动执行：日志已由服务端创建并指定了执行的 Agent，补充 run_id
+	// 只接受下发给本 Agent、仍在运行且尚未关联执行的日志，避免覆盖其他执行的记录
+	if ref.LogID > 0 {
+		result := database.DB.Model(&models.TaskLog{}).
+			Where("id = ? AND agent_id = ? AND status = ? AND (run_id IS NULL OR run_id = '')",
+				ref.LogID, agentID, constant.TaskStatusRunning).
+			Update("run_id", ref.RunID)
+		if result.Error != nil || result.RowsAffected == 0 {
+			logger.Warnf("[AgentRun] Agent #%d 上报的日志 #%d 不属于它或已结束，已忽略", agentID, ref.LogID)
+			return 0
+		}
+		agentRuns[ref.RunID] = &agentRun{logID: ref.LogID, agentID: agentID, lastSeen: time.Now()}
+		return ref.LogID
+	}
+
+	// 计划任务：首次收到消息时创建日志
+	var task models.Task
+	if err := database.DB.Select("id", "command", "envs", "agent_id", "agent_selector", "dispatch_mode").First(&task, ref.TaskID).Error; err != nil {
+		return 0
+	}
+	if agent := s.GetByID(agentID); agent == nil || !tasks.TaskTargetsAgent(&task, agent) {
+		logger.Warnf("[AgentRun] Agent #%d 上报了不属于它的任务 #%d，已忽略", agentID, ref.TaskID)
+		return 0
+	}
+
+	startTime := models.Now()
+	if ref.StartTime > 0 {
+		startTime = models.LocalTime(time.Unix(ref.StartTime, 0))
+	}
+	taskLog = models.TaskLog{
+		TaskID:    task.ID,
+		AgentID:   &agentID,
+		RunID:     ref.RunID,
+		Command:   task.Command,
+		Status:    constant.TaskStatusRunning,
+		StartTime: &startTime,
+	}
+	if err := database.DB.Create(&taskLog).Error; err != nil {
+		logger.Errorf("[AgentRun] 创建任务 #%d 日志失败: %v", task.ID, err)
+		return 0
+	}
+	agentRuns[ref.RunID] = &agentRun{logID: taskLog.ID, agentID: agentID, lazy: true, lastSeen: time.Now()}
+	if live {
+		openAgentRunLog(taskLog.ID, task.ID)
+	}
+	return taskLog.ID
+}
+
+// openAgentRunLog 为 Agent 执行创建实时日志收集器
+func openAgentRunLog(logID, taskID uint) {
+	tl, err := tasks.NewTinyLog(logID)
+	if err != nil {
+		logger.Errorf("[AgentRun] 创建日志 #%d 收集器失败: %v", logID, err)
+		return
+	}
+	var task models.Task
+	if err := database.DB.Select("id", "envs").First(&task, taskID).Error; err == nil {
+		tl.SetRedactor(tasks.NewTaskRedactor(NewSettingsService(), task.Envs))
+	}
+}
+
+// finishAgentRun 执行结束后释放实时日志收集器
+func finishAgentRun(runID string, logID uint) {
+	if runID != "" {
+		agentRunsMu.Lock()
+		delete(agentRuns, runID)
+		agentRunsMu.Unlock()
+	}
+	if tl := tasks.GetActiveLog(logID); tl != nil {
+		// 最终日志以 Agent 上报的完整输出为准，这里只清理临时文件
+		tl.CompressAndCleanup()
+	}
+}
+
+// SweepAgentRuns 将长时间没有消息的计划任务执行标记为失败
+// Agent 重连后补发的结果仍会覆盖该记录（见 findReportedTaskLog）
+func SweepAgentRuns() {
+	cutoff := time.Now().Add(-agentRunIdleTimeout)
+
+	agentRunsMu.Lock()
+	var lost []uint
+	for runID, run := range agentRuns {
+		if run.lastSeen.Before(cutoff) {
+			delete(agentRuns, runID)
+			if run.lazy {
+				lost = append(lost, run.logID)
+			}
+		}
+	}
+	agentRunsMu.Unlock()
+
+	for _, logID := range lost {
+		var taskLog models.TaskLog
+		if err := database.DB.First(&taskLog, logID).Error; err != nil || taskLog.Status != constant.TaskStatusRunning {
+			continue
+		}
+		if tl := tasks.GetActiveLog(logID); tl != nil {
+			if output, err := tl.CompressAndCleanup(); err == nil {
+				taskLog.Output = output
+			}
+		} else {
+			taskLog.Output, _ = utils.CompressToBase64("")
+		}
+		end := models.Now()
+		taskLog.Status = constant.TaskStatusFailed
+		taskLog.Error = AgentRunLostError
+		taskLog.ExitCode = -1
+		taskLog.EndTime = &end
+		if taskLog.StartTime != nil {
+			taskLog.Duration = end.Time().Sub(taskLog.StartTime.Time()).Milliseconds()
+		}
+		logger.Warnf("[AgentRun] 日志 #%d (任务 #%d) 超过 %v 未收到 Agent 消息，标记为失败", logID, taskLog.TaskID, agentRunIdleTimeout)
+		tasks.NewTaskLogService(NewSendStatsService()).ProcessTaskCompletion(&taskLog)
+	}
+}
@@ -3,7 +3,9 @@ package services
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
@@ -14,6 +16,7 @@ import (
 	"github.com/engigu/baihu-panel/internal/logger"
 	"github.com/engigu/baihu-panel/internal/models"
 	"github.com/engigu/baihu-panel/internal/services/tasks"
+	"github.com/engigu/baihu-panel/internal/utils"
 
 	"gorm.io/gorm"
 )
@@ -36,7 +39,7 @@ func generateToken() string {
 // ========== 令牌管理 ==========
 
 // CreateToken 创建令牌
-func (s *AgentService) CreateToken(remark string, maxUses int, expiresAt *time.Time) (*models.AgentToken, error) {
+func (s *AgentService) CreateToken(remark string, maxUses int, expiresAt *time.Time, autoApprove bool, group string) (*models.AgentToken, error) {
 	var expires *models.LocalTime
 	if expiresAt != nil {
 		t := models.LocalTime(*expiresAt)
@@ -46,18 +49,20 @@ func (s *AgentService) CreateToken(remark string, maxUses int, expiresAt *time.T
 	token := generateToken()
 
 	agentToken := &models.AgentToken{
-		Token:     token,
-		Remark:    remark,
-		MaxUses:   maxUses,
-		ExpiresAt: expires,
-		Enabled:   true,
+		Token:       token,
+		Remark:      remark,
+		MaxUses:     maxUses,
+		ExpiresAt:   expires,
+		AutoApprove: autoApprove,
+		Group:       strings.TrimSpace(group),
+		Enabled:     true,
 	}
 
 	if err := database.DB.Create(agentToken).Error; err != nil {
 		return nil, err
 	}
 
-	logger.Infof("[Agent] 创建令牌: %s (max_uses=%d)", token[:8]+"...", maxUses)
+	logger.Infof("[Agent] 创建令牌: %s (max_uses=%d)", utils.ShortID(token, 8), maxUses)
 	return agentToken, nil
 }
 
@@ -104,54 +109,213 @@ func (s *AgentService) UseToken(id uint) {
 
 // ========== Agent 注册 ==========
 
+// AgentMachineInfo Agent 注册时上报的机器信息，供管理员审批时识别
+type AgentMachineInfo struct {
+	MachineID string
+	Hostname  string
+	OS        string
+	Arch      string
+	Version   string
+}
+
 // RegisterByToken 通过令牌注册 Agent（首次 WebSocket 连接时调用）
+// 令牌不自动审批时新机器处于待审批状态，调用方需检查 Pending；被拒绝过的机器不能注册
+// machine_id 由 Agent 自报，复用已批准的 Agent 时必须出示该 Agent 的 Token 或客户端证书
 // 返回: agent, isNewAgent, error
-func (s *AgentService) RegisterByToken(token string, machineID string, ip string) (*models.Agent, bool, error) {
+func (s *AgentService) RegisterByToken(r *http.Request, token string, info AgentMachineInfo, ip string) (*models.Agent, bool, error) {
 	// 验证令牌
 	agentToken, err := s.ValidateToken(token)
 	if err != nil {
 		return nil, false, err
 	}
 
+	machineID := info.MachineID
+	if machineID != "" && !utils.ValidMachineID(machineID) {
+		return nil, false, &ServiceError{Message: "机器识别码格式错误"}
+	}
+	if machineID != "" && s.IsBlocked(machineID) {
+		return nil, false, &ServiceError{Message: "该机器已被拒绝接入"}
+	}
+
 	// 如果提供了 machine_id，先检查是否已存在
 	if machineID != "" {
 		var existing models.Agent
 		if err := database.DB.Where("machine_id = ?", machineID).First(&existing).Error; err == nil {
-			// 已存在，更新 token 和状态，复用已有 Agent
-			now := models.LocalTime(time.Now())
-			database.DB.Model(&existing).Updates(map[string]interface{}{
-				"token":     token,
-				"ip":        ip,
-				"status":    constant.AgentStatusOnline,
-				"last_seen": now,
-			})
-			s.UseToken(agentToken.ID)
-			logger.Infof("[Agent] Agent #%d 通过 machine_id 复用 (%s)", existing.ID, machineID[:8]+"...")
+			if !existing.Pending() && !s.ownsAgent(r, &existing, token) {
+				logger.Warnf("[Agent] 拒绝复用 Agent #%d：请求未出示该 Agent 的凭据 (%s)", existing.ID, ip)
+				return nil, false, &ServiceError{Message: fmt.Sprintf("该机器已注册为 Agent #%d，需使用其 Token 或客户端证书连接；如已重装，请先在面板中删除该 Agent", existing.ID)}
+			}
+			// 已存在，更新 token 和状态，复用已有 Agent；待审批的机器只更新上报的信息
+			updates := map[string]interface{}{
+				"token": token,
+				"ip":    ip,
+			}
+			if existing.Pending() {
+				s.machineInfoUpdates(updates, info)
+			} else {
+				updates["status"] = constant.AgentStatusOnline
+				updates["last_seen"] = models.LocalTime(time.Now())
+			}
+			database.DB.Model(&existing).Updates(updates)
+			existing.Token = token
+			if !existing.Pending() {
+				s.UseToken(agentToken.ID)
+			}
+			logger.Infof("[Agent] Agent #%d 通过 machine_id 复用 (%s)", existing.ID, utils.ShortID(machineID, 8))
 			return &existing, false, nil
 		}
 	}
 
+	approval := constant.AgentApprovalApproved
+	if !agentToken.AutoApprove {
+		if machineID == "" {
+			return nil, false, &ServiceError{Message: "该令牌需要审批，Agent 必须提供机器识别码"}
+		}
+		approval = constant.AgentApprovalPending
+	}
+
 	// 创建 Agent，使用令牌作为认证 Token
 	now := models.LocalTime(time.Now())
 	agent := &models.Agent{
 		Name:      fmt.Sprintf("agent-%d", time.Now().Unix()),
 		Token:     token,
 		MachineID: machineID,
+		Group:     agentToken.Group,
 		IP:        ip,
-		Status:    constant.AgentStatusOnline,
-		LastSeen:  &now,
+		Hostname:  info.Hostname,
+		OS:        info.OS,
+		Arch:      info.Arch,
+		Version:   info.Version,
+		Status:    constant.AgentStatusOffline,
 		Enabled:   true,
+		Approval:  approval,
+	}
+	if approval == constant.AgentApprovalApproved {
+		agent.Status = constant.AgentStatusOnline
+		agent.LastSeen = &now
 	}
 
 	if err := database.DB.Create(agent).Error; err != nil {
 		return nil, false, err
 	}
 
+	if agent.Pending() {
+		logger.Infof("[Agent] 新机器等待审批: #%d %s (%s)", agent.ID, info.Hostname, ip)
+		return agent, true, nil
+	}
 	s.UseToken(agentToken.ID)
 	logger.Infof("[Agent] Agent 通过令牌注册: #%d (%s)", agent.ID, ip)
 	return agent, true, nil
 }
 
+// ownsAgent 请求是否出示了 Agent 的凭据：Agent 当前的 Token，或经过验证的该 Agent 的客户端证书
+func (s *AgentService) ownsAgent(r *http.Request, agent *models.Agent, token string) bool {
+	if agent.Token != "" && agent.Token == token {
+		return true
+	}
+	if agent.CertSerial == "" || r == nil || r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return false
+	}
+	return s.CheckClientCert(r, agent) == nil
+}
+
+// machineInfoUpdates 将上报的机器信息加入更新字段
+func (s *AgentService) machineInfoUpdates(updates map[string]interface{}, info AgentMachineInfo) {
+	if info.Hostname != "" {
+		updates["hostname"] = info.Hostname
+	}
+	if info.OS != "" {
+		updates["os"] = info.OS
+	}
+	if info.Arch != "" {
+		updates["arch"] = info.Arch
+	}
+	if info.Version != "" {
+		updates["version"] = info.Version
+	}
+}
+
+// FindAgent 按认证 Token 查找 Agent；提供了 machine_id 时必须是该机器，
+// 避免多台机器共用注册令牌时新机器冒用已有 Agent 而绕过审批
+func (s *AgentService) FindAgent(token, machineID string) *models.Agent {
+	if machineID == "" {
+		return s.GetByToken(token)
+	}
+	var agent models.Agent
+	if err := database.DB.Where("token = ? AND machine_id = ?", token, machineID).First(&agent).Error; err == nil {
+		return &agent
+	}
+	// 旧版注册接口创建的 Agent 没有 machine_id
+	if agent := s.GetByToken(token); agent != nil && agent.MachineID == "" {
+		return agent
+	}
+	return nil
+}
+
+// ========== 审批 ==========
+
+// Approve 批准待审批的 Agent，group 不为空时同时设置分组
+func (s *AgentService) Approve(id uint, group string) error {
+	agent := s.GetByID(id)
+	if agent == nil {
+		return &ServiceError{Message: "Agent 不存在"}
+	}
+	if !agent.Pending() {
+		return &ServiceError{Message: "Agent 不是待审批状态"}
+	}
+	updates := map[string]interface{}{"approval": constant.AgentApprovalApproved}
+	if group = strings.TrimSpace(group); group != "" {
+		updates["group_name"] = group
+	}
+	if err := database.DB.Model(&models.Agent{}).Where("id = ?", id).Updates(updates).Error; err != nil {
+		return err
+	}
+	var agentToken models.AgentToken
+	if database.DB.Where("token = ?", agent.Token).First(&agentToken).Error == nil {
+		s.UseToken(agentToken.ID)
+	}
+	logger.Infof("[Agent] 已批准 Agent #%d (%s)", id, agent.Hostname)
+	return nil
+}
+
+// Reject 拒绝待审批的 Agent：删除记录并阻止该机器再次注册
+func (s *AgentService) Reject(id uint) error {
+	agent := s.GetByID(id)
+	if agent == nil {
+		return &ServiceError{Message: "Agent 不存在"}
+	}
+	if !agent.Pending() {
+		return &ServiceError{Message: "Agent 不是待审批状态"}
+	}
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		block := &models.AgentBlock{MachineID: agent.MachineID, Hostname: agent.Hostname, IP: agent.IP}
+		if err := tx.Where("machine_id = ?", agent.MachineID).FirstOrCreate(block).Error; err != nil {
+			return err
+		}
+		logger.Infof("[Agent] 已拒绝 Agent #%d (%s)，该机器不能再注册", id, agent.Hostname)
+		return tx.Unscoped().Delete(&models.Agent{}, id).Error
+	})
+}
+
+// IsBlocked 机器是否已被拒绝
+func (s *AgentService) IsBlocked(machineID string) bool {
+	var count int64
+	database.DB.Model(&models.AgentBlock{}).Where("machine_id = ?", machineID).Count(&count)
+	return count > 0
+}
+
+// ListBlocks 获取被拒绝的机器
+func (s *AgentService) ListBlocks() []models.AgentBlock {
+	var blocks []models.AgentBlock
+	database.DB.Order("id DESC").Find(&blocks)
+	return blocks
+}
+
+// DeleteBlock 解除拒绝，该机器可以重新注册（仍需审批）
+func (s *AgentService) DeleteBlock(id uint) error {
+	return database.DB.Delete(&models.AgentBlock{}, id).Error
+}
+
 // Register Agent 注册（必须使用令牌）- 保留兼容旧版本
 func (s *AgentService) Register(req *models.AgentRegisterRequest, ip string) (*models.Agent, string, error) {
 	// 必须提供令牌
@@ -194,14 +358,32 @@ func (s *AgentService) Register(req *models.AgentRegisterRequest, ip string) (*m
 }
 
 // Update 更新 Agent
-func (s *AgentService) Update(id uint, name, description string, enabled bool) error {
+func (s *AgentService) Update(id uint, name, description, group, labels string, enabled bool) error {
 	return database.DB.Model(&models.Agent{}).Where("id = ?", id).Updates(map[string]interface{}{
 		"name":        name,
 		"description": description,
+		"group_name":  strings.TrimSpace(group),
+		"labels":      models.FormatLabels(models.ParseLabels(labels)),
 		"enabled":     enabled,
 	}).Error
 }
 
+// UpdateConfigLabels 更新 Agent 配置文件中的分组和标签，返回是否有变化
+func (s *AgentService) UpdateConfigLabels(agent *models.Agent, group, labels string) bool {
+	group = strings.TrimSpace(group)
+	labels = models.FormatLabels(models.ParseLabels(labels))
+	if agent.ConfigGroup == group && agent.ConfigLabels == labels {
+		return false
+	}
+	database.DB.Model(&models.Agent{}).Where("id = ?", agent.ID).Updates(map[string]interface{}{
+		"config_group":  group,
+		"config_labels": labels,
+	})
+	agent.ConfigGroup = group
+	agent.ConfigLabels = labels
+	return true
+}
+
 // Delete 删除 Agent（物理删除）
 func (s *AgentService) Delete(id uint) error {
 	// 检查是否有关联任务
@@ -253,9 +435,9 @@ func (s *AgentService) RegenerateToken(id uint) (string, error) {
 	return "", &ServiceError{Message: "此功能已禁用"}
 }
 
-// Heartbeat Agent 心跳
-func (s *AgentService) Heartbeat(token, ip, version, buildTime, hostname, osType, arch string) (*models.Agent, error) {
-	agent := s.GetByToken(token)
+// Heartbeat Agent 心跳，按 Token 和 machine_id 查找 Agent
+func (s *AgentService) Heartbeat(token, machineID, ip, version, buildTime, hostname, osType, arch string) (*models.Agent, error) {
+	agent := s.FindAgent(token, machineID)
 	if agent == nil {
 		return nil, &ServiceError{Message: "无效的 Token"}
 	}
@@ -263,6 +445,9 @@ func (s *AgentService) Heartbeat(token, ip, version, buildTime, hostname, osType
 	if !agent.Enabled {
 		return nil, &ServiceError{Message: "Agent 已禁用"}
 	}
+	if agent.Pending() {
+		return nil, &ServiceError{Message: "Agent 等待管理员审批"}
+	}
 
 	now := models.LocalTime(time.Now())
 	updates := map[string]interface{}{
@@ -302,33 +487,47 @@ func (s *AgentService) Heartbeat(token, ip, version, buildTime, hostname, osType
 
 // GetTasks 获取 Agent 的任务列表
 func (s *AgentService) GetTasks(agentID uint) []models.AgentTask {
-	var tasks []models.Task
-	database.DB.Where("agent_id = ? AND enabled = ?", agentID, true).Find(&tasks)
+	agent := s.GetByID(agentID)
+	if agent == nil || agent.Pending() {
+		return []models.AgentTask{}
+	}
+
+	// 指定该 Agent 的任务及所有按标签选择或配置了备用 Agent 的任务，后者再逐个过滤
+	var candidates []models.Task
+	database.DB.Where("enabled = ? AND (agent_id = ? OR agent_selector <> '' OR fallback_agents <> '')", true, agentID).Find(&candidates)
+	var targeted []models.Task
+	for i := range candidates {
+		if tasks.TaskTargetsAgent(&candidates[i], agent) {
+			targeted = append(targeted, candidates[i])
+		}
+	}
 
-	result := make([]models.AgentTask, len(tasks))
-	for i, task := range tasks {
+	result := make([]models.AgentTask, len(targeted))
+	for i, task := range targeted {
 		// 将环境变量 ID 转换为实际的环境变量键值对
-		envVarsStr := s.buildEnvVarsString(task.Envs)
+		envVarsStr, hiddenEnvs := s.buildEnvVarsString(task.Envs)
 
 		result[i] = models.AgentTask{
-			ID:       task.ID,
-			Name:     task.Name,
-			Command:  task.Command,
-			Schedule: task.Schedule,
-			Timeout:  task.Timeout,
-			WorkDir:  task.WorkDir,
-			Envs:     envVarsStr, // 传递 "KEY1=VALUE1,KEY2=VALUE2" 格式
-			Enabled:  task.Enabled,
+			ID:         task.ID,
+			Name:       task.Name,
+			Command:    task.Command,
+			Schedule:   task.Schedule,
+			Timeout:    task.Timeout,
+			WorkDir:    task.WorkDir,
+			Envs:       envVarsStr, // 传递 "KEY1=VALUE1,KEY2=VALUE2" 格式
+			HiddenEnvs: hiddenEnvs, // 需要在日志中脱敏的变量名
+			Enabled:    task.Enabled,
+			Dispatched: !task.ScheduledByAgent(),
 		}
 	}
 
 	return result
 }
 
-// buildEnvVarsString 将环境变量 ID 列表转换为键值对字符串
-func (s *AgentService) buildEnvVarsString(envIDs string) string {
+// buildEnvVarsString 将环境变量 ID 列表转换为键值对字符串，同时返回隐藏变量的名称列表
+func (s *AgentService) buildEnvVarsString(envIDs string) (string, string) {
 	if envIDs == "" {
-		return ""
+		return "", ""
 	}
 
 	var envVars []models.EnvironmentVariable
@@ -336,18 +535,84 @@ func (s *AgentService) buildEnvVarsString(envIDs string) string {
 	database.DB.Where("id IN ?", ids).Find(&envVars)
 
 	if len(envVars) == 0 {
-		return ""
+		return "", ""
 	}
 
-	// 构建 "KEY1=VALUE1,KEY2=VALUE2" 格式
+	// 构建 "KEY1=VALUE1,KEY2=VALUE2" 格式，隐藏变量的值不随任务列表下发，执行时由 Agent 单独请求
 	pairs := make([]string, 0, len(envVars))
+	hidden := make([]string, 0)
 	for _, env := range envVars {
+		if env.Hidden {
+			hidden = append(hidden, env.Name)
+			continue
+		}
 		// 对值进行转义，避免特殊字符问题
 		encodedValue := strings.ReplaceAll(env.Value, ",", "{{COMMA}}")
 		encodedValue = strings.ReplaceAll(encodedValue, "=", "{{EQUAL}}")
 		pairs = append(pairs, fmt.Sprintf("%s=%s", env.Name, encodedValue))
 	}
-	return strings.Join(pairs, ",")
+	return strings.Join(pairs, ","), strings.Join(hidden, ",")
+}
+
+// TaskSecrets 使用 Agent 的公钥加密任务的隐藏环境变量，只在 Agent 执行任务时请求
+// 任务必须下发给该 Agent，公钥必须与首次使用时记录的一致，明文为 "KEY=VALUE" 的 JSON 数组
+func (s *AgentService) TaskSecrets(agent *models.Agent, taskID uint, requestID, publicKey string) (*utils.SealedSecrets, error) {
+	pub, err := utils.ParseSecretsPublicKey(publicKey)
+	if err != nil {
+		return nil, &ServiceError{Message: "Agent 公钥无效"}
+	}
+	var task models.Task
+	if err := database.DB.First(&task, taskID).Error; err != nil || !task.Enabled || !tasks.TaskTargetsAgent(&task, agent) {
+		return nil, &ServiceError{Message: "任务不存在或未下发给该 Agent"}
+	}
+	if err := s.PinSecretsKey(agent, publicKey); err != nil {
+		return nil, err
+	}
+
+	var secrets []string
+	if task.Envs != "" {
+		var envVars []models.EnvironmentVariable
+		database.DB.Where("id IN ? AND hidden = ?", strings.Split(task.Envs, ","), true).Find(&envVars)
+		secrets = make([]string, 0, len(envVars))
+		for _, env := range envVars {
+			secrets = append(secrets, env.Name+"="+env.Value)
+		}
+	}
+	plaintext, _ := json.Marshal(secrets)
+	return utils.SealSecrets(pub, plaintext, utils.SecretsAAD(taskID, requestID))
+}
+
+// PinSecretsKey 首次使用时记录 Agent 用于接收隐藏变量的公钥，之后只接受该公钥；
+// 公钥变化（如重装 Agent）需管理员在面板中重置证书后才能重新记录
+func (s *AgentService) PinSecretsKey(agent *models.Agent, publicKey string) error {
+	if agent.SecretsKey == "" {
+		// 并发的首次请求只有一个能写入，其余按数据库中的公钥比较
+		result := database.DB.Model(&models.Agent{}).
+			Where("id = ? AND (secrets_key = '' OR secrets_key IS NULL)", agent.ID).
+			Update("secrets_key", publicKey)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			var current models.Agent
+			if err := database.DB.Select("secrets_key").First(&current, agent.ID).Error; err != nil {
+				return &ServiceError{Message: "Agent 不存在"}
+			}
+			agent.SecretsKey = current.SecretsKey
+		} else {
+			agent.SecretsKey = publicKey
+		}
+	}
+	if agent.SecretsKey != publicKey {
+		logger.Warnf("[Agent] Agent #%d 的隐藏变量公钥与记录的不一致，拒绝下发", agent.ID)
+		return &ServiceError{Message: "Agent 公钥与面板记录的不一致，如已重装 Agent，请在面板中重置证书"}
+	}
+	return nil
+}
+
+// GetRedactionRules 获取全局日志脱敏规则，随任务列表下发给 Agent
+func (s *AgentService) GetRedactionRules() []string {
+	return utils.ParseRedactionRules(NewSettingsService().Get(constant.SectionRedaction, constant.KeyRedactionRules))
 }
 
 // ReportResult Agent 上报执行结果
@@ -355,27 +620,95 @@ func (s *AgentService) ReportResult(result *models.AgentTaskResult) error {
 	// 获取依赖的服务
 	agentWSManager := GetAgentWSManager()
 
-	// 先尝试通知正在等待的 goroutine
+	// 按 run_id 关联执行，计划任务此前未收到过日志或心跳时在此创建日志
+	if result.RunID != "" {
+		result.LogID = s.ResolveAgentRun(result.AgentID, AgentRunRef{
+			RunID: result.RunID, TaskID: result.TaskID, LogID: result.LogID, StartTime: result.StartTime,
+		}, false)
+		if result.LogID == 0 {
+			return &ServiceError{Message: "执行记录不存在或不属于该 Agent"}
+		}
+	}
+
+	// 先尝试通知正在等待的 goroutine（手动执行，实时日志由调度器处理）
 	if agentWSManager.NotifyRemoteResult(result) {
+		finishAgentRun(result.RunID, 0)
 		logger.Infof("[Agent] 已通知正在等待任务 #%d 结果的 goroutine", result.TaskID)
 		return nil
 	}
+	defer finishAgentRun(result.RunID, result.LogID)
 
 	// 如果没有人在等待（例如服务重启后），则由本协程负责处理结果入库
 	// 如果没有人在等待（例如服务重启后），则由本协程负责处理结果入库（记录日志并清理）
 	logger.Infof("[Agent] 没有找到等待任务 #%d 结果的 goroutine，直接处理结果", result.TaskID)
+
+	// Agent 重连后会补发离线期间缓存的结果，需按日志 ID 去重
+	existing, duplicate := findReportedTaskLog(result)
+	if duplicate {
+		logger.Infof("[Agent] 忽略重复上报的任务结果: 任务 #%d, LogID=%d", result.TaskID, result.LogID)
+		return nil
+	}
+
 	sendStatsService := NewSendStatsService()
 	taskLogService := tasks.NewTaskLogService(sendStatsService)
 
+	// Agent 端已脱敏，这里按服务端规则再处理一次，并应用任务的结果判定规则
+	var task models.Task
+	if err := database.DB.Select("id", "envs", "config").First(&task, result.TaskID).Error; err == nil {
+		redactor := tasks.NewTaskRedactor(NewSettingsService(), task.Envs)
+		result.Output = redactor.Redact(result.Output)
+		result.Error = redactor.Redact(result.Error)
+		tasks.ApplyResultRules(tasks.ParseResultRules(task.Config), strings.NewReader(result.Output), &result.Status, &result.Error)
+	}
+
 	// 创建日志对象
 	taskLog, err := taskLogService.CreateTaskLogFromAgentResult(result)
 	if err != nil {
 		return err
 	}
+	if existing != nil {
+		// 已有的日志（手动执行或按 run_id 创建）仍处于运行中，直接补全该记录
+		taskLog.ID = existing.ID
+		if existing.Status == constant.TaskStatusFailed {
+			// 此前已被标记为中断，统计和通知已处理，只更新日志内容
+			database.DB.Model(&models.TaskLog{}).Where("id = ?", existing.ID).Updates(map[string]interface{}{
+				"error":     taskLog.Error,
+				"exit_code": taskLog.ExitCode,
+			})
+			return taskLogService.SaveTaskLog(taskLog)
+		}
+	}
 	// 处理完成逻辑（保存日志、更新统计、清理旧日志等）
 	return taskLogService.ProcessTaskCompletion(taskLog)
 }
 
+// findReportedTaskLog 查找结果对应的已有日志
+// 带日志 ID 的结果：日志仍在运行中（或因长时间无消息被标记为中断）则返回该日志用于补全，已结束则视为重复上报；
+// 旧版本 Agent 的计划任务结果没有日志 ID，按任务、Agent 及起止时间判断是否已入库
+func findReportedTaskLog(result *models.AgentTaskResult) (*models.TaskLog, bool) {
+	if result.LogID > 0 {
+		var taskLog models.TaskLog
+		if err := database.DB.Select("id", "status", "error").First(&taskLog, result.LogID).Error; err != nil {
+			return nil, false
+		}
+		if taskLog.Status == constant.TaskStatusRunning || taskLog.Status == constant.TaskStatusQueued ||
+			(taskLog.Status == constant.TaskStatusFailed && taskLog.Error == AgentRunLostError) {
+			return &taskLog, false
+		}
+		return nil, true
+	}
+
+	if result.StartTime <= 0 || result.EndTime <= 0 {
+		return nil, false
+	}
+	var count int64
+	database.DB.Model(&models.TaskLog{}).
+		Where("task_id = ? AND agent_id = ? AND start_time = ? AND end_time = ?",
+			result.TaskID, result.AgentID, time.Unix(result.StartTime, 0), time.Unix(result.EndTime, 0)).
+		Count(&count)
+	return nil, count > 0
+}
+
 // UpdateTaskDuration 更新任务耗时（心跳）
 func (s *AgentService) UpdateTaskDuration(logID uint, duration int64) error {
 	taskLogService := tasks.NewTaskLogService(nil)
@@ -501,14 +834,34 @@ func (s *AgentService) GetAgentBinary(osType, arch string) ([]byte, string, erro
 	return data, filename, nil
 }
 
-// SetForceUpdate 设置强制更新标志
-func (s *AgentService) SetForceUpdate(id uint) error {
-	return database.DB.Model(&models.Agent{}).Where("id = ?", id).Update("force_update", true).Error
+// GetAgentSignature 获取 Agent 发布包的签名（<文件名>.sig），未签名的发布包返回 nil
+func (s *AgentService) GetAgentSignature(osType, arch string) *utils.ReleaseSignature {
+	filename := fmt.Sprintf("baihu-agent-%s-%s.tar.gz.sig", osType, arch)
+	for _, dir := range []string{"/opt/agent", "data/agent"} {
+		data, err := os.ReadFile(filepath.Join(dir, filename))
+		if err != nil {
+			continue
+		}
+		var sig utils.ReleaseSignature
+		if err := json.Unmarshal(data, &sig); err != nil {
+			logger.Warnf("[Agent] 签名文件格式错误 %s: %v", filename, err)
+			return nil
+		}
+		return &sig
+	}
+	return nil
+}
+
+// SetForceUpdate 设置强制更新标志，rollback 为 true 时允许 Agent 降级到面板的版本
+func (s *AgentService) SetForceUpdate(id uint, rollback bool) error {
+	return database.DB.Model(&models.Agent{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"force_update": true, "allow_rollback": rollback}).Error
 }
 
 // ClearForceUpdate 清除强制更新标志
 func (s *AgentService) ClearForceUpdate(id uint) error {
-	return database.DB.Model(&models.Agent{}).Where("id = ?", id).Update("force_update", false).Error
+	return database.DB.Model(&models.Agent{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"force_update": false, "allow_rollback": false}).Error
 }
 
 // ServiceError 服务错误
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"fmt"
+
+	"github.com/engigu/baihu-panel/internal/utils"
+)
+
+// 协议 v2 的任务日志流：Agent 为每次执行打开一个流，日志以数据帧发送，
+// 面板处理完后按字节数归还额度，Agent 没有额度时在本地缓存，避免日志挤占心跳
+
+// agentStream 面板侧的流状态，只在连接的读协程中访问
+type agentStream struct {
+	logID    uint   // 打开流时解析出的任务日志 ID
+	recvSeq  uint32 // 下一个数据帧的序号
+	sendSeq  uint32 // 下一个额度帧的序号
+	consumed int    // 已处理但尚未归还的额度
+}
+
+// Protocol 连接协商的协议版本
+func (c *AgentConnection) Protocol() int {
+	return c.protocol
+}
+
+// Session v2 帧签名使用的连接随机数，随 connected 消息下发给 Agent
+func (c *AgentConnection) Session() string {
+	return string(c.session)
+}
+
+// DecodeFrame 解码 Agent 发来的二进制帧，开启 require_signature 时数据帧必须签名
+func (c *AgentConnection) DecodeFrame(data []byte) (*utils.Frame, error) {
+	frame, err := utils.DecodeFrame(data, c.signKey, c.session)
+	if err != nil {
+		return nil, err
+	}
+	if c.signKey == nil && frame.Type != utils.FrameMessage && agentAuthConfig().RequireSignature {
+		return nil, fmt.Errorf("数据帧缺少签名")
+	}
+	return frame, nil
+}
+
+// OpenStream 打开日志流
+func (c *AgentConnection) OpenStream(id uint32, logID uint) {
+	c.streams[id] = &agentStream{logID: logID}
+}
+
+// CloseStream 关闭日志流
+func (c *AgentConnection) CloseStream(id uint32) {
+	delete(c.streams, id)
+}
+
+// StreamData 校验数据帧的流和序号，返回所属的任务日志 ID
+func (c *AgentConnection) StreamData(frame *utils.Frame) (uint, error) {
+	s, ok := c.streams[frame.Stream]
+	if !ok {
+		return 0, fmt.Errorf("流 %d 未打开", frame.Stream)
+	}
+	if frame.Seq != s.recvSeq {
+		return 0, fmt.Errorf("流 %d 序号错误: 期望 %d，收到 %d", frame.Stream, s.recvSeq, frame.Seq)
+	}
+	s.recvSeq++
+	return s.logID, nil
+}
+
+// GrantCredit 记录已处理的字节数，累计到初始额度的一半时归还给 Agent
+// 发送队列满时保留累计值，下次处理数据时重试
+func (c *AgentConnection) GrantCredit(id uint32, n int) {
+	s, ok := c.streams[id]
+	if !ok {
+		return
+	}
+	s.consumed += n
+	if s.consumed < utils.StreamInitialWindow/2 {
+		return
+	}
+	frame := &utils.Frame{Type: utils.FrameCredit, Stream: id, Seq: s.sendSeq, Payload: utils.CreditPayload(s.consumed)}
+	if c.enqueue(utils.EncodeFrame(frame, c.signKey, c.session)) {
+		s.sendSeq++
+		s.consumed = 0
+	}
+}
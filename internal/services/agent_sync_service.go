@@ -0,0 +1,644 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/engigu/baihu-panel/internal/database"
+	"github.com/engigu/baihu-panel/internal/logger"
+	"github.com/engigu/baihu-panel/internal/models"
+	"github.com/engigu/baihu-panel/internal/utils"
+)
+
+// 脚本同步：按 Agent 的目录映射把面板脚本目录下的文件同步到 Agent
+// 面板下发各映射的内容哈希清单，Agent 对比本地文件后只请求缺少或已变化的文件；
+// 源目录内容变化、Agent 连上面板以及 Agent 执行任务前都会触发同步
+
+const (
+	syncCheckInterval = 10 * time.Second // 检查源目录变化的间隔
+	syncTimeout       = 2 * time.Minute  // 每一步等待 Agent 响应的最长时间
+	syncBatchSize     = 1 << 20          // 单条 sync_files 消息的文件内容上限
+	syncQueueLimit    = 8                // 发送队列积压超过该数量时暂停发送文件
+	// SyncMaxFileSize 单个文件的大小上限，超过的文件不同步
+	SyncMaxFileSize = 32 << 20
+)
+
+// SyncFileEntry 清单中的文件
+type SyncFileEntry struct {
+	Path string `json:"path"` // 相对映射源目录，使用 / 分隔
+	Hash string `json:"hash"` // 内容的 sha256
+	Size int64  `json:"size"`
+	Mode uint32 `json:"mode"` // 权限位
+}
+
+// SyncDir 一个映射的清单
+type SyncDir struct {
+	Mapping uint            `json:"mapping"`
+	Target  string          `json:"target"`
+	Delete  bool            `json:"delete"`
+	Files   []SyncFileEntry `json:"files"`
+	Skipped []string        `json:"skipped,omitempty"` // 超过大小上限未同步的文件，Agent 不删除本地同名文件
+}
+
+// syncManifest 面板下发的同步清单，ID 为 Agent 请求同步时由 Agent 指定
+type syncManifest struct {
+	ID   string    `json:"id"`
+	Dirs []SyncDir `json:"dirs"`
+}
+
+// syncRequest Agent 对比清单后请求的文件，按映射 ID 列出
+type syncRequest struct {
+	ID    string            `json:"id"`
+	Need  map[uint][]string `json:"need"`
+	Error string            `json:"error,omitempty"`
+}
+
+// syncFile 发送的单个文件
+type syncFile struct {
+	Path    string `json:"path"`
+	Content []byte `json:"content"`
+	Mode    uint32 `json:"mode"`
+}
+
+// syncFiles 一批文件，Done 表示本次同步的文件已全部发送
+type syncFiles struct {
+	ID      string     `json:"id"`
+	Mapping uint       `json:"mapping,omitempty"`
+	Files   []syncFile `json:"files,omitempty"`
+	Done    bool       `json:"done,omitempty"`
+}
+
+// SyncDirResult 单个映射的同步结果
+type SyncDirResult struct {
+	Mapping uint   `json:"mapping"`
+	Updated int    `json:"updated"`
+	Deleted int    `json:"deleted"`
+	Error   string `json:"error,omitempty"`
+}
+
+// syncResult Agent 返回的同步结果
+type syncResult struct {
+	ID      string          `json:"id"`
+	Error   string          `json:"error,omitempty"`
+	Results []SyncDirResult `json:"results"`
+}
+
+// syncJob 等待 Agent 响应的同步
+type syncJob struct {
+	agentID uint
+	request chan *syncRequest
+	result  chan *syncResult
+}
+
+// cachedHash 按文件大小和修改时间缓存的哈希，避免每次检查都读取全部文件
+type cachedHash struct {
+	size    int64
+	modTime time.Time
+	hash    string
+}
+
+// AgentSyncService Agent 脚本同步
+type AgentSyncService struct {
+	wsManager  *AgentWSManager
+	scriptsDir string
+	jobs       map[string]*syncJob
+	agentLocks map[uint]*sync.Mutex // 同一 Agent 的同步依次进行
+	digests    map[uint]string      // 各映射源目录上次检查时的清单摘要
+	mu         sync.Mutex
+	hashes     map[string]cachedHash
+	hashMu     sync.Mutex
+	stopCh     chan struct{}
+	startOnce  sync.Once
+}
+
+// NewAgentSyncService 创建脚本同步服务，scriptsDir 为面板脚本目录
+func NewAgentSyncService(wsManager *AgentWSManager, scriptsDir string) *AgentSyncService {
+	if abs, err := filepath.Abs(scriptsDir); err == nil {
+		scriptsDir = abs
+	}
+	return &AgentSyncService{
+		wsManager:  wsManager,
+		scriptsDir: scriptsDir,
+		jobs:       make(map[string]*syncJob),
+		agentLocks: make(map[uint]*sync.Mutex),
+		digests:    make(map[uint]string),
+		hashes:     make(map[string]cachedHash),
+		stopCh:     make(chan struct{}),
+	}
+}
+
+// Start 启动源目录变化检查
+func (s *AgentSyncService) Start() {
+	s.startOnce.Do(func() {
+		go s.loop()
+	})
+}
+
+// Stop 停止源目录变化检查
+func (s *AgentSyncService) Stop() {
+	close(s.stopCh)
+}
+
+func (s *AgentSyncService) loop() {
+	ticker := time.NewTicker(syncCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C:
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						logger.Errorf("[AgentSync] 检查脚本变化时发生 Panic: %v", r)
+					}
+				}()
+				s.checkChanges()
+			}()
+		}
+	}
+}
+
+// checkChanges 源目录内容变化时同步使用该目录的在线 Agent，首次检查只记录摘要
+func (s *AgentSyncService) checkChanges() {
+	var mappings []models.AgentSyncMapping
+	database.DB.Where("enabled = ?", true).Find(&mappings)
+
+	seen := make(map[string]bool)
+	digests := make(map[string]string) // 同一源目录只计算一次
+	changed := make(map[uint]bool)
+	for _, m := range mappings {
+		digest, ok := digests[m.Source]
+		if !ok {
+			files, _, err := s.buildManifest(m.Source, seen)
+			if err == nil {
+				digest = manifestDigest(files)
+			}
+			digests[m.Source] = digest
+		}
+
+		s.mu.Lock()
+		last, known := s.digests[m.ID]
+		s.digests[m.ID] = digest
+		s.mu.Unlock()
+		if known && last != digest {
+			changed[m.AgentID] = true
+		}
+	}
+
+	// 只保留仍在使用的文件哈希
+	s.hashMu.Lock()
+	for p := range s.hashes {
+		if !seen[p] {
+			delete(s.hashes, p)
+		}
+	}
+	s.hashMu.Unlock()
+
+	for agentID := range changed {
+		if s.wsManager.IsOnline(agentID) {
+			logger.Infof("[AgentSync] 脚本已变化，开始同步 Agent #%d", agentID)
+			go s.Sync(agentID, "")
+		}
+	}
+}
+
+// ListMappings 获取 Agent 的目录映射
+func (s *AgentSyncService) ListMappings(agentID uint) []models.AgentSyncMapping {
+	var mappings []models.AgentSyncMapping
+	database.DB.Where("agent_id = ?", agentID).Order("id ASC").Find(&mappings)
+	return mappings
+}
+
+// GetMapping 根据 ID 获取目录映射
+func (s *AgentSyncService) GetMapping(id uint) *models.AgentSyncMapping {
+	var m models.AgentSyncMapping
+	if err := database.DB.First(&m, id).Error; err != nil {
+		return nil
+	}
+	return &m
+}
+
+// SaveMapping 校验并保存目录映射（ID 为 0 时新建），保存后立即同步
+func (s *AgentSyncService) SaveMapping(m *models.AgentSyncMapping) error {
+	source, err := cleanSyncPath(m.Source)
+	if err != nil {
+		return &ServiceError{Message: "源目录" + err.Error()}
+	}
+	if info, err := os.Stat(filepath.Join(s.scriptsDir, filepath.FromSlash(source))); err != nil || !info.IsDir() {
+		return &ServiceError{Message: "源目录不存在: " + m.Source}
+	}
+	target, err := cleanSyncPath(m.Target)
+	if err != nil {
+		return &ServiceError{Message: "目标目录" + err.Error()}
+	}
+	if target == "" {
+		return &ServiceError{Message: "目标目录不能为空"}
+	}
+	m.Source, m.Target = source, target
+
+	if m.ID == 0 {
+		err = database.DB.Create(m).Error
+	} else {
+		err = database.DB.Model(m).Select("source", "target", "delete", "enabled").Updates(m).Error
+	}
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	delete(s.digests, m.ID)
+	s.mu.Unlock()
+	if m.Enabled && s.wsManager.IsOnline(m.AgentID) {
+		go s.Sync(m.AgentID, "")
+	}
+	return nil
+}
+
+// DeleteMapping 删除目录映射，已同步到 Agent 的文件保留
+func (s *AgentSyncService) DeleteMapping(id uint) error {
+	s.mu.Lock()
+	delete(s.digests, id)
+	s.mu.Unlock()
+	return database.DB.Delete(&models.AgentSyncMapping{}, id).Error
+}
+
+// DeleteAgent 删除 Agent 的全部目录映射
+func (s *AgentSyncService) DeleteAgent(agentID uint) {
+	database.DB.Where("agent_id = ?", agentID).Delete(&models.AgentSyncMapping{})
+}
+
+// Sync 同步 Agent 的全部启用映射，返回前等待 Agent 写入完成
+// id 为空表示由面板发起，Agent 没有映射时不发送；Agent 请求的同步即使没有映射也要回复，避免 Agent 等待
+func (s *AgentSyncService) Sync(agentID uint, id string) error {
+	var mappings []models.AgentSyncMapping
+	database.DB.Where("agent_id = ? AND enabled = ?", agentID, true).Order("id ASC").Find(&mappings)
+	if id == "" {
+		if len(mappings) == 0 {
+			return nil
+		}
+		id = utils.RandomString(16)
+	}
+
+	lock := s.agentLock(agentID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	manifest := syncManifest{ID: id, Dirs: []SyncDir{}}
+	dirs := make(map[uint]map[string]SyncFileEntry)
+	sources := make(map[uint]string)
+	skipped := make(map[uint][]string)
+	for _, m := range mappings {
+		files, oversize, err := s.buildManifest(m.Source, nil)
+		if err != nil {
+			s.recordResult(m.ID, SyncDirResult{Error: "读取源目录失败: " + err.Error()})
+			continue
+		}
+		manifest.Dirs = append(manifest.Dirs, SyncDir{Mapping: m.ID, Target: m.Target, Delete: m.Delete, Files: files, Skipped: oversize})
+		skipped[m.ID] = oversize
+		index := make(map[string]SyncFileEntry, len(files))
+		for _, f := range files {
+			index[f.Path] = f
+		}
+		dirs[m.ID] = index
+		sources[m.ID] = m.Source
+	}
+
+	job := &syncJob{agentID: agentID, request: make(chan *syncRequest, 1), result: make(chan *syncResult, 1)}
+	s.mu.Lock()
+	s.jobs[id] = job
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.jobs, id)
+		s.mu.Unlock()
+	}()
+
+	results, err := s.run(job, &manifest, dirs, sources)
+	if err != nil {
+		logger.Warnf("[AgentSync] Agent #%d 同步失败: %v", agentID, err)
+		for mappingID := range dirs {
+			s.recordResult(mappingID, SyncDirResult{Error: err.Error()})
+		}
+		return err
+	}
+	for _, r := range results {
+		// 过大的文件没有同步，即使 Agent 写入成功也要提示
+		if msg := oversizeError(skipped[r.Mapping]); msg != "" {
+			if r.Error != "" {
+				msg = r.Error + "; " + msg
+			}
+			r.Error = msg
+		}
+		s.recordResult(r.Mapping, r)
+	}
+	return nil
+}
+
+// oversizeError 过大未同步的文件的提示，最多列出 5 个
+func oversizeError(paths []string) string {
+	if len(paths) == 0 {
+		return ""
+	}
+	list := paths
+	if len(list) > 5 {
+		list = list[:5]
+	}
+	msg := fmt.Sprintf("%d 个文件超过 %dMB 未同步: %s", len(paths), SyncMaxFileSize>>20, strings.Join(list, ", "))
+	if len(paths) > len(list) {
+		msg += " 等"
+	}
+	return msg
+}
+
+// run 下发清单、发送 Agent 请求的文件，返回 Agent 回报的各映射结果
+func (s *AgentSyncService) run(job *syncJob, manifest *syncManifest, dirs map[uint]map[string]SyncFileEntry, sources map[uint]string) ([]SyncDirResult, error) {
+	if err := s.wsManager.TrySend(job.agentID, WSTypeSyncManifest, manifest); err != nil {
+		return nil, err
+	}
+
+	var req *syncRequest
+	select {
+	case req = <-job.request:
+	case <-time.After(syncTimeout):
+		return nil, fmt.Errorf("等待 Agent 响应超时，请确认 Agent 版本支持脚本同步")
+	}
+	if req == nil {
+		return nil, fmt.Errorf("Agent 已断开")
+	}
+	if req.Error != "" {
+		return nil, fmt.Errorf("%s", req.Error)
+	}
+
+	for mappingID, paths := range req.Need {
+		index, ok := dirs[mappingID]
+		if !ok {
+			continue
+		}
+		root := filepath.Join(s.scriptsDir, filepath.FromSlash(sources[mappingID]))
+		batch := syncFiles{ID: manifest.ID, Mapping: mappingID}
+		size := 0
+		for _, p := range paths {
+			// 只发送清单中的文件，Agent 不能借此读取脚本目录外的内容
+			if _, ok := index[p]; !ok {
+				continue
+			}
+			fullPath := filepath.Join(root, filepath.FromSlash(p))
+			content, err := os.ReadFile(fullPath)
+			if err != nil {
+				continue // 清单生成后被删除，下次检查时再同步
+			}
+			mode := uint32(0644)
+			if info, err := os.Stat(fullPath); err == nil {
+				mode = uint32(info.Mode().Perm())
+			}
+			if size > 0 && size+len(content) > syncBatchSize {
+				if err := s.sendFiles(job.agentID, &batch); err != nil {
+					return nil, err
+				}
+				batch.Files, size = nil, 0
+			}
+			batch.Files = append(batch.Files, syncFile{Path: p, Content: content, Mode: mode})
+			size += len(content)
+		}
+		if len(batch.Files) > 0 {
+			if err := s.sendFiles(job.agentID, &batch); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := s.sendFiles(job.agentID, &syncFiles{ID: manifest.ID, Done: true}); err != nil {
+		return nil, err
+	}
+
+	var result *syncResult
+	select {
+	case result = <-job.result:
+	case <-time.After(syncTimeout):
+		return nil, fmt.Errorf("等待 Agent 写入文件超时")
+	}
+	if result == nil {
+		return nil, fmt.Errorf("Agent 已断开")
+	}
+	if result.Error != "" {
+		return nil, fmt.Errorf("%s", result.Error)
+	}
+	var results []SyncDirResult
+	for _, r := range result.Results {
+		if _, ok := dirs[r.Mapping]; ok {
+			results = append(results, r)
+		}
+	}
+	return results, nil
+}
+
+// sendFiles 发送一批文件，发送队列积压时等待，避免占满队列影响其他消息
+func (s *AgentSyncService) sendFiles(agentID uint, batch *syncFiles) error {
+	deadline := time.Now().Add(syncTimeout)
+	for s.wsManager.QueueLen(agentID) > syncQueueLimit {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Agent 消息队列积压")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return s.wsManager.TrySend(agentID, WSTypeSyncFiles, batch)
+}
+
+// recordResult 记录映射的同步结果
+func (s *AgentSyncService) recordResult(mappingID uint, r SyncDirResult) {
+	now := models.Now()
+	errMsg := r.Error
+	if len(errMsg) > 500 {
+		errMsg = errMsg[:500]
+	}
+	database.DB.Model(&models.AgentSyncMapping{}).Where("id = ?", mappingID).Updates(map[string]interface{}{
+		"last_sync_at": &now,
+		"last_error":   errMsg,
+		"last_updated": r.Updated,
+		"last_deleted": r.Deleted,
+	})
+}
+
+// HandleRequest 处理 Agent 请求的文件列表
+func (s *AgentSyncService) HandleRequest(agentID uint, data json.RawMessage) {
+	var req syncRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return
+	}
+	if job := s.job(agentID, req.ID); job != nil {
+		select {
+		case job.request <- &req:
+		default:
+		}
+	}
+}
+
+// HandleResult 处理 Agent 返回的同步结果
+func (s *AgentSyncService) HandleResult(agentID uint, data json.RawMessage) {
+	var result syncResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return
+	}
+	if job := s.job(agentID, result.ID); job != nil {
+		select {
+		case job.result <- &result:
+		default:
+		}
+	}
+}
+
+// HandlePull 处理 Agent 执行任务前的同步请求，使用 Agent 指定的 ID 以便 Agent 等待结果
+func (s *AgentSyncService) HandlePull(agentID uint, data json.RawMessage) {
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(data, &req); err != nil || req.ID == "" {
+		return
+	}
+	s.mu.Lock()
+	_, exists := s.jobs[req.ID]
+	s.mu.Unlock()
+	if exists {
+		return
+	}
+	go s.Sync(agentID, req.ID)
+}
+
+// CloseAgent Agent 断开时结束它等待中的同步
+func (s *AgentSyncService) CloseAgent(agentID uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, job := range s.jobs {
+		if job.agentID != agentID {
+			continue
+		}
+		select {
+		case job.request <- nil:
+		default:
+		}
+		select {
+		case job.result <- nil:
+		default:
+		}
+	}
+}
+
+func (s *AgentSyncService) job(agentID uint, id string) *syncJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok || job.agentID != agentID {
+		return nil
+	}
+	return job
+}
+
+func (s *AgentSyncService) agentLock(agentID uint) *sync.Mutex {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lock, ok := s.agentLocks[agentID]
+	if !ok {
+		lock = &sync.Mutex{}
+		s.agentLocks[agentID] = lock
+	}
+	return lock
+}
+
+// buildManifest 生成源目录的清单，跳过符号链接，过大的文件列在 skipped 中；seen 不为空时记录用到的文件
+func (s *AgentSyncService) buildManifest(source string, seen map[string]bool) (files []SyncFileEntry, skipped []string, err error) {
+	root := filepath.Join(s.scriptsDir, filepath.FromSlash(source))
+	files = []SyncFileEntry{}
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if info.Size() > SyncMaxFileSize {
+			rel, _ := filepath.Rel(root, p)
+			skipped = append(skipped, filepath.ToSlash(rel))
+			return nil
+		}
+		hash, err := s.fileHash(p, info)
+		if err != nil {
+			return nil
+		}
+		if seen != nil {
+			seen[p] = true
+		}
+		rel, _ := filepath.Rel(root, p)
+		files = append(files, SyncFileEntry{
+			Path: filepath.ToSlash(rel),
+			Hash: hash,
+			Size: info.Size(),
+			Mode: uint32(info.Mode().Perm()),
+		})
+		return nil
+	})
+	return files, skipped, err
+}
+
+// fileHash 计算文件内容的 sha256，大小和修改时间未变时使用缓存
+func (s *AgentSyncService) fileHash(p string, info fs.FileInfo) (string, error) {
+	s.hashMu.Lock()
+	cached, ok := s.hashes[p]
+	s.hashMu.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.hash, nil
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+
+	s.hashMu.Lock()
+	s.hashes[p] = cachedHash{size: info.Size(), modTime: info.ModTime(), hash: hash}
+	s.hashMu.Unlock()
+	return hash, nil
+}
+
+// manifestDigest 清单摘要，任一文件的路径、内容或权限变化都会改变摘要
+func manifestDigest(files []SyncFileEntry) string {
+	sorted := append([]SyncFileEntry(nil), files...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Path < sorted[j].Path })
+	h := sha256.New()
+	for _, f := range sorted {
+		fmt.Fprintf(h, "%s\x00%s\x00%o\n", f.Path, f.Hash, f.Mode)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cleanSyncPath 规范化映射路径，只允许相对路径且不能跳出根目录，根目录返回空字符串
+func cleanSyncPath(p string) (string, error) {
+	p = path.Clean(filepath.ToSlash(strings.TrimSpace(p)))
+	if p == "." || p == "/" {
+		return "", nil
+	}
+	if path.IsAbs(p) || filepath.IsAbs(p) || p == ".." || strings.HasPrefix(p, "../") {
+		return "", fmt.Errorf("必须是相对路径且不能包含 ..")
+	}
+	return p, nil
+}
@@ -2,6 +2,7 @@ package services
 
 import (
 	"encoding/json"
+	"fmt"
 	"sync"
 	"time"
 
@@ -9,6 +10,7 @@ import (
 	"github.com/engigu/baihu-panel/internal/database"
 	"github.com/engigu/baihu-panel/internal/logger"
 	"github.com/engigu/baihu-panel/internal/models"
+	"github.com/engigu/baihu-panel/internal/utils"
 
 	"github.com/gorilla/websocket"
 )
@@ -20,6 +22,7 @@ type AgentWSManager struct {
 	ipLastAttempt map[string]time.Time                  // IP -> 最后连接尝试时间
 	ipFailCount   map[string]int                        // IP -> 连续失败次数
 	remoteWaiters map[uint]chan *models.AgentTaskResult // 日志 ID -> 结果通道
+	replayGuards  map[uint]*utils.ReplayGuard           // Agent ID -> 签名消息的 nonce 记录，跨连接生效
 	mu            sync.RWMutex
 }
 
@@ -33,36 +36,63 @@ const (
 
 // AgentConnection Agent WebSocket 连接
 type AgentConnection struct {
-	AgentID  uint
-	IP       string
-	Conn     *websocket.Conn
-	Send     chan []byte
-	LastPing time.Time
-	closed   bool
-	mu       sync.Mutex
+	AgentID    uint
+	IP         string
+	Conn       *websocket.Conn
+	Send       chan []byte
+	LastPing   time.Time
+	signKey    []byte // 消息签名密钥，为空表示 Agent 未申请
+	protocol   int    // 握手时协商的协议版本
+	session    []byte // v2 帧签名使用的连接随机数，防止帧被挪用到其他连接
+	streams    map[uint32]*agentStream
+	closed     bool
+	sendClosed bool
+	mu         sync.Mutex
+	sendMu     sync.Mutex // 保护 Send 的关闭，与写连接的 mu 分开，避免入队等待慢速写入
 }
 
 // WSMessage WebSocket 消息结构
 type WSMessage struct {
-	Type string          `json:"type"`
-	Data json.RawMessage `json:"data,omitempty"`
+	Type  string          `json:"type"`
+	Data  json.RawMessage `json:"data,omitempty"`
+	TS    int64           `json:"ts,omitempty"`    // 签名时间戳（Unix 秒）
+	Nonce string          `json:"nonce,omitempty"` // 随机串，防止重放
+	Sig   string          `json:"sig,omitempty"`   // HMAC-SHA256(type, ts, nonce, data)
 }
 
 // 消息类型常量
 const (
-	WSTypeHeartbeat     = constant.WSTypeHeartbeat
-	WSTypeHeartbeatAck  = constant.WSTypeHeartbeatAck
-	WSTypeTasks         = constant.WSTypeTasks
-	WSTypeTaskResult    = constant.WSTypeTaskResult
-	WSTypeUpdate        = constant.WSTypeUpdate
-	WSTypeDisconnect    = constant.WSTypeDisconnect
-	WSTypeConnected     = constant.WSTypeConnected
-	WSTypeDisabled      = constant.WSTypeDisabled
-	WSTypeEnabled       = constant.WSTypeEnabled
-	WSTypeFetchTasks    = constant.WSTypeFetchTasks
-	WSTypeTaskLog       = constant.WSTypeTaskLog
-	WSTypeExecute       = constant.WSTypeExecute
-	WSTypeTaskHeartbeat = constant.WSTypeTaskHeartbeat
+	WSTypeHeartbeat      = constant.WSTypeHeartbeat
+	WSTypeHeartbeatAck   = constant.WSTypeHeartbeatAck
+	WSTypeTasks          = constant.WSTypeTasks
+	WSTypeTaskResult     = constant.WSTypeTaskResult
+	WSTypeUpdate         = constant.WSTypeUpdate
+	WSTypeDisconnect     = constant.WSTypeDisconnect
+	WSTypeConnected      = constant.WSTypeConnected
+	WSTypeDisabled       = constant.WSTypeDisabled
+	WSTypeEnabled        = constant.WSTypeEnabled
+	WSTypeFetchTasks     = constant.WSTypeFetchTasks
+	WSTypeTaskLog        = constant.WSTypeTaskLog
+	WSTypeExecute        = constant.WSTypeExecute
+	WSTypeTaskHeartbeat  = constant.WSTypeTaskHeartbeat
+	WSTypeShellOpen      = constant.WSTypeShellOpen
+	WSTypeShellOpened    = constant.WSTypeShellOpened
+	WSTypeShellInput     = constant.WSTypeShellInput
+	WSTypeShellOutput    = constant.WSTypeShellOutput
+	WSTypeShellClose     = constant.WSTypeShellClose
+	WSTypeFileRequest    = constant.WSTypeFileRequest
+	WSTypeFileResponse   = constant.WSTypeFileResponse
+	WSTypeStreamOpen     = constant.WSTypeStreamOpen
+	WSTypeStreamClose    = constant.WSTypeStreamClose
+	WSTypeSyncManifest   = constant.WSTypeSyncManifest
+	WSTypeSyncRequest    = constant.WSTypeSyncRequest
+	WSTypeSyncFiles      = constant.WSTypeSyncFiles
+	WSTypeSyncResult     = constant.WSTypeSyncResult
+	WSTypeSyncPull       = constant.WSTypeSyncPull
+	WSTypeSecretsRequest = constant.WSTypeSecretsRequest
+	WSTypeSecrets        = constant.WSTypeSecrets
+	WSTypeConfig         = constant.WSTypeConfig
+	WSTypeConfigAck      = constant.WSTypeConfigAck
 )
 
 var agentWSManager *AgentWSManager
@@ -77,6 +107,7 @@ func GetAgentWSManager() *AgentWSManager {
 			ipLastAttempt: make(map[string]time.Time),
 			ipFailCount:   make(map[string]int),
 			remoteWaiters: make(map[uint]chan *models.AgentTaskResult),
+			replayGuards:  make(map[uint]*utils.ReplayGuard),
 		}
 		go agentWSManager.cleanupLoop()
 	})
@@ -136,8 +167,9 @@ func (m *AgentWSManager) RecordConnectSuccess(ip string) {
 	delete(m.ipFailCount, ip)
 }
 
-// Register 注册连接
-func (m *AgentWSManager) Register(agentID uint, conn *websocket.Conn, ip string) *AgentConnection {
+// Register 注册连接，signKey 为 Agent 的消息签名密钥，设置后发送的消息都会签名
+// protocol 为握手时协商的协议版本，v2 起发送二进制帧
+func (m *AgentWSManager) Register(agentID uint, conn *websocket.Conn, ip string, signKey []byte, protocol int) *AgentConnection {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -158,6 +190,12 @@ func (m *AgentWSManager) Register(agentID uint, conn *websocket.Conn, ip string)
 		Conn:     conn,
 		Send:     make(chan []byte, 256),
 		LastPing: time.Now(),
+		signKey:  signKey,
+		protocol: protocol,
+		streams:  make(map[uint32]*agentStream),
+	}
+	if protocol >= utils.WSProtocolV2 {
+		ac.session = []byte(utils.RandomString(24))
 	}
 	m.connections[agentID] = ac
 
@@ -194,6 +232,21 @@ func (m *AgentWSManager) GetConnection(agentID uint) *AgentConnection {
 	return m.connections[agentID]
 }
 
+// IsOnline Agent 是否保持 WebSocket 连接
+func (m *AgentWSManager) IsOnline(agentID uint) bool {
+	conn := m.GetConnection(agentID)
+	return conn != nil && !conn.IsClosed()
+}
+
+// QueueLen 发送队列中积压的消息数，Agent 不在线时为 0
+func (m *AgentWSManager) QueueLen(agentID uint) int {
+	conn := m.GetConnection(agentID)
+	if conn == nil {
+		return 0
+	}
+	return len(conn.Send)
+}
+
 // SendToAgent 发送消息给指定 Agent
 func (m *AgentWSManager) SendToAgent(agentID uint, msgType string, data interface{}) error {
 	conn := m.GetConnection(agentID)
@@ -201,16 +254,62 @@ func (m *AgentWSManager) SendToAgent(agentID uint, msgType string, data interfac
 		return nil // Agent 不在线
 	}
 
+	conn.enqueue(conn.encode(msgType, data)) // 缓冲区满时丢弃
+	return nil
+}
+
+// TrySend 发送消息给指定 Agent，不在线或缓冲区满时返回错误
+func (m *AgentWSManager) TrySend(agentID uint, msgType string, data interface{}) error {
+	conn := m.GetConnection(agentID)
+	if conn == nil || conn.IsClosed() {
+		return fmt.Errorf("Agent 不在线")
+	}
+	if !conn.enqueue(conn.encode(msgType, data)) {
+		return fmt.Errorf("Agent 消息队列已满")
+	}
+	return nil
+}
+
+// encode 编码消息，申请了签名密钥的连接附带签名，v2 连接封装为消息帧
+func (c *AgentConnection) encode(msgType string, data interface{}) []byte {
 	dataBytes, _ := json.Marshal(data)
 	msg := WSMessage{Type: msgType, Data: dataBytes}
+	if c.signKey != nil {
+		msg.TS = time.Now().Unix()
+		msg.Nonce = utils.RandomString(24)
+		msg.Sig = utils.SignMessage(c.signKey, msg.Type, msg.TS, msg.Nonce, msg.Data)
+	}
 	msgBytes, _ := json.Marshal(msg)
+	if c.protocol >= utils.WSProtocolV2 {
+		return utils.EncodeFrame(&utils.Frame{Type: utils.FrameMessage, Payload: msgBytes}, nil, nil)
+	}
+	return msgBytes
+}
 
-	select {
-	case conn.Send <- msgBytes:
+// VerifyMessage 校验 Agent 发来的消息签名
+// 携带签名时必须验签通过且不是重放；未携带签名时只有开启 require_signature 才拒绝
+func (m *AgentWSManager) VerifyMessage(ac *AgentConnection, msg *WSMessage) error {
+	if msg.Sig == "" {
+		if agentAuthConfig().RequireSignature {
+			return fmt.Errorf("消息缺少签名")
+		}
 		return nil
-	default:
-		return nil // 缓冲区满，丢弃
 	}
+	if ac.signKey == nil {
+		return fmt.Errorf("Agent 未申请签名密钥")
+	}
+	if !utils.VerifyMessage(ac.signKey, msg.Type, msg.TS, msg.Nonce, msg.Data, msg.Sig) {
+		return fmt.Errorf("消息签名错误")
+	}
+
+	m.mu.Lock()
+	guard, ok := m.replayGuards[ac.AgentID]
+	if !ok {
+		guard = utils.NewReplayGuard()
+		m.replayGuards[ac.AgentID] = guard
+	}
+	m.mu.Unlock()
+	return guard.Check(msg.Nonce, msg.TS)
 }
 
 // BroadcastTasks 广播任务更新给指定 Agent
@@ -218,10 +317,25 @@ func (m *AgentWSManager) BroadcastTasks(agentID uint) {
 	agentService := NewAgentService()
 	tasks := agentService.GetTasks(agentID)
 	m.SendToAgent(agentID, WSTypeTasks, map[string]interface{}{
-		"tasks": tasks,
+		"tasks":           tasks,
+		"redaction_rules": agentService.GetRedactionRules(),
 	})
 }
 
+// BroadcastTasksAll 向所有在线 Agent 重新下发任务列表
+func (m *AgentWSManager) BroadcastTasksAll() {
+	m.mu.RLock()
+	ids := make([]uint, 0, len(m.connections))
+	for id := range m.connections {
+		ids = append(ids, id)
+	}
+	m.mu.RUnlock()
+
+	for _, id := range ids {
+		m.BroadcastTasks(id)
+	}
+}
+
 // RegisterRemoteWaiter 注册远程任务结果等待者
 func (m *AgentWSManager) RegisterRemoteWaiter(logID uint) chan *models.AgentTaskResult {
 	m.mu.Lock()
@@ -304,8 +418,6 @@ func (m *AgentWSManager) cleanupLoop() {
 				Where("status = ? AND last_seen < ?", constant.AgentStatusOnline, cutoff).
 				Update("status", constant.AgentStatusOffline)
 
-			// 清理过期的限流记录（超过 10 分钟未活动）
-
 			// 清理过期的限流记录（超过 10 分钟未活动）
 			for ip, lastAttempt := range m.ipLastAttempt {
 				if now.Sub(lastAttempt) > 10*time.Minute {
@@ -319,6 +431,9 @@ func (m *AgentWSManager) cleanupLoop() {
 			}
 
 			m.mu.Unlock()
+
+			// 将长时间无消息的 Agent 执行标记为中断
+			SweepAgentRuns()
 		}()
 	}
 }
@@ -334,11 +449,30 @@ func (c *AgentConnection) Close() {
 	if c.Conn != nil {
 		c.Conn.Close()
 	}
-	if c.Send != nil {
+
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	if c.Send != nil && !c.sendClosed {
+		c.sendClosed = true
 		close(c.Send)
 	}
 }
 
+// enqueue 放入发送队列，连接已关闭或缓冲区满时返回 false
+func (c *AgentConnection) enqueue(data []byte) bool {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	if c.sendClosed {
+		return false
+	}
+	select {
+	case c.Send <- data:
+		return true
+	default:
+		return false
+	}
+}
+
 // IsClosed 检查连接是否已关闭
 func (c *AgentConnection) IsClosed() bool {
 	c.mu.Lock()
@@ -354,6 +488,9 @@ func (c *AgentConnection) WriteMessage(data []byte) error {
 		return nil
 	}
 	c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if c.protocol >= utils.WSProtocolV2 {
+		return c.Conn.WriteMessage(websocket.BinaryMessage, data)
+	}
 	return c.Conn.WriteMessage(websocket.TextMessage, data)
 }
 
@@ -0,0 +1,222 @@
+// Package agentpki 面板管理的 Agent 证书颁发机构
+// CA 证书和私钥保存在数据目录，首次使用时自动生成；Agent 通过 CSR 申请客户端证书，私钥不离开 Agent
+package agentpki
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/engigu/baihu-panel/internal/constant"
+)
+
+const (
+	caValidity     = 10 * 365 * 24 * time.Hour
+	serverValidity = 365 * 24 * time.Hour
+	// ClientValidity Agent 客户端证书有效期，Agent 在到期前 RenewBefore 自动续签
+	ClientValidity = 90 * 24 * time.Hour
+	RenewBefore    = 30 * 24 * time.Hour
+
+	agentCNPrefix = "baihu-agent-"
+)
+
+// CA 证书颁发机构
+type CA struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+}
+
+var (
+	ca      *CA
+	caErr   error
+	caOnce  sync.Once
+	certDir = filepath.Join(constant.DataDir, "pki")
+)
+
+// Load 加载 CA，不存在时生成
+func Load() (*CA, error) {
+	caOnce.Do(func() {
+		ca, caErr = loadOrCreate(certDir)
+	})
+	return ca, caErr
+}
+
+func loadOrCreate(dir string) (*CA, error) {
+	certFile := filepath.Join(dir, "ca.crt")
+	keyFile := filepath.Join(dir, "ca.key")
+
+	certPEM, certErr := os.ReadFile(certFile)
+	keyPEM, keyErr := os.ReadFile(keyFile)
+	if certErr == nil && keyErr == nil {
+		return parseCA(certPEM, keyPEM)
+	}
+	if !os.IsNotExist(certErr) || !os.IsNotExist(keyErr) {
+		return nil, fmt.Errorf("CA 文件不完整，请检查 %s", dir)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          newSerial(),
+		Subject:               pkix.Name{CommonName: "Baihu Panel Agent CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(certFile, certPEM, 0644); err != nil {
+		return nil, err
+	}
+	return parseCA(certPEM, keyPEM)
+}
+
+func parseCA(certPEM, keyPEM []byte) (*CA, error) {
+	certBlock, _ := pem.Decode(certPEM)
+	keyBlock, _ := pem.Decode(keyPEM)
+	if certBlock == nil || keyBlock == nil {
+		return nil, errors.New("CA 证书或私钥格式错误")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return &CA{cert: cert, key: key, certPEM: certPEM}, nil
+}
+
+// CertPEM CA 证书（PEM），下发给 Agent 用于校验服务端证书
+func (c *CA) CertPEM() []byte {
+	return c.certPEM
+}
+
+// Pool 只包含本 CA 的证书池
+func (c *CA) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(c.cert)
+	return pool
+}
+
+// SignAgentCSR 为 Agent 签发客户端证书，证书 CN 固定为 baihu-agent-<id>，与 CSR 中的主题无关
+func (c *CA) SignAgentCSR(agentID uint, csrPEM []byte) (certPEM []byte, serial string, notAfter time.Time, err error) {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, "", time.Time{}, errors.New("无效的证书签名请求")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, "", time.Time{}, err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, "", time.Time{}, fmt.Errorf("证书签名请求校验失败: %v", err)
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: newSerial(),
+		Subject:      pkix.Name{CommonName: agentCNPrefix + strconv.FormatUint(uint64(agentID), 10)},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(ClientValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, c.cert, csr.PublicKey, c.key)
+	if err != nil {
+		return nil, "", time.Time{}, err
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return certPEM, Serial(tmpl), tmpl.NotAfter, nil
+}
+
+// ServerTLSConfig mTLS 端口的 TLS 配置
+// 服务端证书每次启动时重新签发，只用于 ServerAuth，Agent 校验证书链而不校验主机名，
+// 因此不需要配置面板的域名或 IP；客户端证书在校验通过后由控制器与 Agent 身份比对
+func (c *CA) ServerTLSConfig() (*tls.Config, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: newSerial(),
+		Subject:      pkix.Name{CommonName: "baihu-panel"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(serverValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"baihu-panel", "localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, c.cert, &key.PublicKey, c.key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{der, c.cert.Raw},
+			PrivateKey:  key,
+		}},
+		ClientAuth: tls.VerifyClientCertIfGiven,
+		ClientCAs:  c.Pool(),
+	}, nil
+}
+
+// AgentIDFromCert 从客户端证书解析 Agent ID
+func AgentIDFromCert(cert *x509.Certificate) (uint, bool) {
+	if !strings.HasPrefix(cert.Subject.CommonName, agentCNPrefix) {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(cert.Subject.CommonName, agentCNPrefix), 10, 64)
+	if err != nil || id == 0 {
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// Serial 证书序列号（十六进制）
+func Serial(cert *x509.Certificate) string {
+	return cert.SerialNumber.Text(16)
+}
+
+func newSerial() *big.Int {
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	return serial
+}
@@ -51,6 +51,8 @@ func (s *BackupService) getTableConfigs() []tableConfig {
 		{"login_logs.json", s.exportTable(&[]models.LoginLog{}, false), s.restoreTable(&[]models.LoginLog{}, false)},
 		{"agents.json", s.exportTable(&[]models.Agent{}, true), s.restoreTable(&[]models.Agent{}, true)},
 		{"tokens.json", s.exportTable(&[]models.AgentToken{}, true), s.restoreTable(&[]models.AgentToken{}, true)},
+		{"notify_channels.json", s.exportTable(&[]models.NotifyChannel{}, false), s.restoreTable(&[]models.NotifyChannel{}, false)},
+		{"notify_rules.json", s.exportTable(&[]models.NotifyRule{}, false), s.restoreTable(&[]models.NotifyRule{}, false)},
 	}
 }
 
@@ -192,6 +194,8 @@ func (s *BackupService) Restore(zipPath string) error {
 		tx.Unscoped().Where("1=1").Delete(&models.LoginLog{})
 		tx.Unscoped().Where("1=1").Delete(&models.Agent{})
 		tx.Unscoped().Where("1=1").Delete(&models.AgentToken{})
+		tx.Unscoped().Where("1=1").Delete(&models.NotifyChannel{})
+		tx.Unscoped().Where("1=1").Delete(&models.NotifyRule{})
 
 		// 2. 依次恢复每个表
 		for _, cfg := range configs {
@@ -258,6 +262,10 @@ func (s *BackupService) restoreFromZipFile(tx *gorm.DB, f *zip.File, filename st
 			return &models.Agent{}
 		case "tokens.json":
 			return &models.AgentToken{}
+		case "notify_channels.json":
+			return &models.NotifyChannel{}
+		case "notify_rules.json":
+			return &models.NotifyRule{}
 		default:
 			return nil
 		}
@@ -30,10 +30,18 @@ type SecurityConfig struct {
 	Secret string `ini:"secret"`
 }
 
+// AgentAuthConfig Agent 认证配置
+type AgentAuthConfig struct {
+	MTLSPort         int  `ini:"mtls_port"`         // mTLS 监听端口，0 表示不启用
+	RequireMTLS      bool `ini:"require_mtls"`      // /api/agent/* 必须使用客户端证书（申请证书和下载除外）
+	RequireSignature bool `ini:"require_signature"` // Agent WebSocket 消息必须携带 HMAC 签名
+}
+
 type AppConfig struct {
-	Server   ServerConfig   `ini:"server"`
-	Database DatabaseConfig `ini:"database"`
-	Security SecurityConfig `ini:"security"`
+	Server    ServerConfig    `ini:"server"`
+	Database  DatabaseConfig  `ini:"database"`
+	Security  SecurityConfig  `ini:"security"`
+	AgentAuth AgentAuthConfig `ini:"agent_auth"`
 }
 
 var Config *AppConfig
@@ -45,6 +53,13 @@ func getEnvStr(key string, target *string) {
 	}
 }
 
+// getEnvBool 获取环境变量布尔值
+func getEnvBool(key string, target *bool) {
+	if v := os.Getenv(key); v != "" {
+		*target = v == "true" || v == "1"
+	}
+}
+
 // getEnvInt 获取环境变量整数
 func getEnvInt(key string, target *int) {
 	if v := os.Getenv(key); v != "" {
@@ -117,6 +132,12 @@ func LoadConfig(path string) (*AppConfig, error) {
 	}
 	logger.Infof("[Config] 数据库: type=%s, host=%s, port=%d, dbname=%s",
 		Config.Database.Type, Config.Database.Host, Config.Database.Port, Config.Database.DBName)
+	if Config.AgentAuth.MTLSPort > 0 {
+		logger.Infof("[Config] Agent mTLS 端口: %d (强制客户端证书: %v)", Config.AgentAuth.MTLSPort, Config.AgentAuth.RequireMTLS)
+	}
+	if Config.AgentAuth.RequireSignature {
+		logger.Info("[Config] Agent WebSocket 消息签名已强制启用")
+	}
 
 	return Config, nil
 }
@@ -140,6 +161,11 @@ func applyEnvOverrides() {
 
 	// Security
 	getEnvStr("BH_SECRET", &Config.Security.Secret)
+
+	// Agent 认证
+	getEnvInt("BH_AGENT_MTLS_PORT", &Config.AgentAuth.MTLSPort)
+	getEnvBool("BH_AGENT_REQUIRE_MTLS", &Config.AgentAuth.RequireMTLS)
+	getEnvBool("BH_AGENT_REQUIRE_SIGNATURE", &Config.AgentAuth.RequireSignature)
 }
 
 func GetConfig() *AppConfig {
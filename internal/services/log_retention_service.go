@@ -0,0 +1,375 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/engigu/baihu-panel/internal/constant"
+	"github.com/engigu/baihu-panel/internal/database"
+	"github.com/engigu/baihu-panel/internal/logger"
+	"github.com/engigu/baihu-panel/internal/models"
+	"github.com/engigu/baihu-panel/internal/systime"
+
+	"gorm.io/gorm"
+)
+
+// retentionBatchSize 每批扫描/删除的日志条数
+const retentionBatchSize = 500
+
+// RetentionPolicy 全局日志保留策略（与任务级 CleanConfig 叠加生效）
+type RetentionPolicy struct {
+	Enabled        bool `json:"enabled"`
+	Interval       int  `json:"interval"`         // 清理间隔（分钟）
+	KeepDays       int  `json:"keep_days"`        // 成功日志保留天数
+	FailedKeepDays int  `json:"failed_keep_days"` // 失败日志保留天数
+	KeepCount      int  `json:"keep_count"`       // 每个任务最多保留条数
+	TaskMaxSize    int  `json:"task_max_size"`    // 每个任务日志总大小上限（MB）
+	DiskQuota      int  `json:"disk_quota"`       // 全部任务日志总大小上限（MB）
+	LoginLogDays   int  `json:"login_log_days"`   // 登录日志保留天数
+}
+
+// RetentionReport 单次清理报告
+type RetentionReport struct {
+	StartTime   models.LocalTime `json:"start_time"`
+	Duration    int64            `json:"duration"`      // 耗时（毫秒）
+	ByAge       int64            `json:"by_age"`        // 按天数清理的成功日志
+	ByFailedAge int64            `json:"by_failed_age"` // 按天数清理的失败日志
+	ByCount     int64            `json:"by_count"`      // 按条数清理
+	BySize      int64            `json:"by_size"`       // 按任务大小清理
+	ByQuota     int64            `json:"by_quota"`      // 按全局配额清理
+	LoginLogs   int64            `json:"login_logs"`    // 清理的登录日志
+	FreedBytes  int64            `json:"freed_bytes"`   // 释放的日志存储字节数
+	TotalBytes  int64            `json:"total_bytes"`   // 清理后剩余的日志存储字节数
+	Errors      []string         `json:"errors,omitempty"`
+}
+
+// Deleted 返回本次清理的任务日志总条数
+func (r *RetentionReport) Deleted() int64 {
+	return r.ByAge + r.ByFailedAge + r.ByCount + r.BySize + r.ByQuota
+}
+
+// LogRetentionService 全局日志清理服务（后台定期执行）
+type LogRetentionService struct {
+	settingsService *SettingsService
+	loginLogService *LoginLogService
+	lastReport      *RetentionReport
+	runMu           sync.Mutex // 保证同一时间只有一次清理
+	mu              sync.RWMutex
+	stopCh          chan struct{}
+	startOnce       sync.Once
+	stopOnce        sync.Once
+}
+
+// NewLogRetentionService 创建日志清理服务
+func NewLogRetentionService(settingsService *SettingsService, loginLogService *LoginLogService) *LogRetentionService {
+	return &LogRetentionService{
+		settingsService: settingsService,
+		loginLogService: loginLogService,
+		stopCh:          make(chan struct{}),
+	}
+}
+
+// GetPolicy 从设置中读取当前策略
+func (s *LogRetentionService) GetPolicy() RetentionPolicy {
+	values := s.settingsService.GetSection(constant.SectionRetention)
+	atoi := func(key string) int {
+		v, err := strconv.Atoi(values[key])
+		if err != nil || v < 0 {
+			return 0
+		}
+		return v
+	}
+	enabled := values[constant.KeyRetentionEnabled]
+	return RetentionPolicy{
+		Enabled:        enabled == "true" || enabled == "1",
+		Interval:       atoi(constant.KeyRetentionInterval),
+		KeepDays:       atoi(constant.KeyRetentionKeepDays),
+		FailedKeepDays: atoi(constant.KeyRetentionFailedDays),
+		KeepCount:      atoi(constant.KeyRetentionKeepCount),
+		TaskMaxSize:    atoi(constant.KeyRetentionTaskSize),
+		DiskQuota:      atoi(constant.KeyRetentionDiskQuota),
+		LoginLogDays:   atoi(constant.KeyRetentionLoginDays),
+	}
+}
+
+// GetLastReport 获取最近一次清理报告
+func (s *LogRetentionService) GetLastReport() *RetentionReport {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastReport
+}
+
+// Start 启动后台清理协程
+func (s *LogRetentionService) Start() {
+	s.startOnce.Do(func() {
+		go s.loop()
+	})
+}
+
+// Stop 停止后台清理协程
+func (s *LogRetentionService) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+	})
+}
+
+func (s *LogRetentionService) loop() {
+	// 启动后稍等片刻再执行首次清理，避免与启动流程争抢数据库
+	timer := time.NewTimer(time.Minute)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-timer.C:
+		}
+
+		policy := s.GetPolicy()
+		if policy.Enabled {
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						logger.Errorf("[Retention] 清理过程中发生 Panic: %v", r)
+					}
+				}()
+				s.Run(policy)
+			}()
+		}
+
+		interval := policy.Interval
+		if interval <= 0 {
+			interval = 60
+		}
+		timer.Reset(time.Duration(interval) * time.Minute)
+	}
+}
+
+// Run 按给定策略执行一次清理并返回报告
+func (s *LogRetentionService) Run(policy RetentionPolicy) *RetentionReport {
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+
+	start := systime.InCST(time.Now())
+	report := &RetentionReport{StartTime: models.LocalTime(start)}
+	collect := func(err error) {
+		if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+		}
+	}
+
+	// 1. 按天数清理（失败日志可单独设置更长的保留期）
+	if policy.KeepDays > 0 {
+		cutoff := start.AddDate(0, 0, -policy.KeepDays)
+		n, freed, err := deleteTaskLogsWhere(database.DB.Where("status = ? AND created_at < ?", constant.TaskStatusSuccess, cutoff))
+		report.ByAge, report.FreedBytes = n, report.FreedBytes+freed
+		collect(err)
+	}
+	failedDays := policy.FailedKeepDays
+	if failedDays == 0 {
+		failedDays = policy.KeepDays
+	}
+	if failedDays > 0 {
+		cutoff := start.AddDate(0, 0, -failedDays)
+		n, freed, err := deleteTaskLogsWhere(database.DB.Where("status NOT IN ? AND created_at < ?", retainedStatuses(), cutoff))
+		report.ByFailedAge, report.FreedBytes = n, report.FreedBytes+freed
+		collect(err)
+	}
+
+	// 2. 按任务维度清理（条数和大小）
+	if policy.KeepCount > 0 || policy.TaskMaxSize > 0 {
+		var taskIDs []uint
+		database.DB.Model(&models.TaskLog{}).Distinct("task_id").Pluck("task_id", &taskIDs)
+		for _, taskID := range taskIDs {
+			if policy.KeepCount > 0 {
+				n, freed, err := trimTaskLogsByCount(taskID, policy.KeepCount)
+				report.ByCount += n
+				report.FreedBytes += freed
+				collect(err)
+			}
+			if policy.TaskMaxSize > 0 {
+				n, freed, err := trimTaskLogsBySize(taskID, int64(policy.TaskMaxSize)*1024*1024)
+				report.BySize += n
+				report.FreedBytes += freed
+				collect(err)
+			}
+		}
+	}
+
+	// 3. 全局配额：先删最旧的成功日志，仍超出再删最旧的失败日志
+	if policy.DiskQuota > 0 {
+		quota := int64(policy.DiskQuota) * 1024 * 1024
+		for _, successOnly := range []bool{true, false} {
+			n, freed, err := trimTaskLogsByQuota(quota, successOnly)
+			report.ByQuota += n
+			report.FreedBytes += freed
+			collect(err)
+		}
+	}
+
+	// 4. 登录日志
+	if policy.LoginLogDays > 0 && s.loginLogService != nil {
+		n, err := s.loginLogService.CleanOldLogs(policy.LoginLogDays)
+		report.LoginLogs = n
+		collect(err)
+	}
+
+	report.TotalBytes = totalTaskLogBytes(database.DB.Model(&models.TaskLog{}))
+	report.Duration = time.Since(start).Milliseconds()
+
+	s.mu.Lock()
+	s.lastReport = report
+	s.mu.Unlock()
+
+	if report.Deleted() > 0 || report.LoginLogs > 0 {
+		logger.Infof("[Retention] 清理完成: 任务日志 %d 条 (天数 %d, 失败天数 %d, 条数 %d, 大小 %d, 配额 %d), 登录日志 %d 条, 释放 %s, 剩余 %s",
+			report.Deleted(), report.ByAge, report.ByFailedAge, report.ByCount, report.BySize, report.ByQuota,
+			report.LoginLogs, formatSize(report.FreedBytes), formatSize(report.TotalBytes))
+	}
+	for _, e := range report.Errors {
+		logger.Warnf("[Retention] 清理出错: %s", e)
+	}
+
+	return report
+}
+
+// retainedStatuses 不视为失败的状态（成功与仍在执行中的日志）
+func retainedStatuses() []string {
+	return []string{constant.TaskStatusSuccess, constant.TaskStatusRunning, constant.TaskStatusQueued, constant.TaskStatusPending}
+}
+
+// totalTaskLogBytes 统计查询范围内日志输出的总字节数
+func totalTaskLogBytes(query *gorm.DB) int64 {
+	var total int64
+	query.Select("COALESCE(SUM(LENGTH(output)), 0)").Scan(&total)
+	return total
+}
+
+// deleteTaskLogsWhere 删除满足条件的已结束日志，返回删除条数和释放字节数
+func deleteTaskLogsWhere(cond *gorm.DB) (int64, int64, error) {
+	cond = cond.Where("status <> ?", constant.TaskStatusRunning)
+	freed := totalTaskLogBytes(database.DB.Model(&models.TaskLog{}).Where(cond))
+	result := database.DB.Where(cond).Delete(&models.TaskLog{})
+	if result.Error != nil {
+		return 0, 0, result.Error
+	}
+	return result.RowsAffected, freed, nil
+}
+
+// deleteTaskLogsByIDs 按 ID 批量删除日志
+func deleteTaskLogsByIDs(ids []uint) (int64, int64, error) {
+	var deleted, freed int64
+	for i := 0; i < len(ids); i += retentionBatchSize {
+		end := i + retentionBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		n, f, err := deleteTaskLogsWhere(database.DB.Where("id IN ?", ids[i:end]))
+		deleted += n
+		freed += f
+		if err != nil {
+			return deleted, freed, err
+		}
+	}
+	return deleted, freed, nil
+}
+
+// trimTaskLogsByCount 每个任务仅保留最新的 keep 条日志
+func trimTaskLogsByCount(taskID uint, keep int) (int64, int64, error) {
+	var boundary models.TaskLog
+	err := database.DB.Select("id").Where("task_id = ?", taskID).Order("id DESC").Offset(keep - 1).Limit(1).First(&boundary).Error
+	if err != nil {
+		return 0, 0, nil
+	}
+	return deleteTaskLogsWhere(database.DB.Where("task_id = ? AND id < ?", taskID, boundary.ID))
+}
+
+// logSize 日志 ID 及其输出大小
+type logSize struct {
+	ID   uint
+	Size int64
+}
+
+// trimTaskLogsBySize 从最新日志开始累计大小，超出上限的旧日志全部删除
+func trimTaskLogsBySize(taskID uint, limit int64) (int64, int64, error) {
+	if totalTaskLogBytes(database.DB.Model(&models.TaskLog{}).Where("task_id = ?", taskID)) <= limit {
+		return 0, 0, nil
+	}
+
+	var sum int64
+	var lastID uint
+	var boundary uint
+	for boundary == 0 {
+		var rows []logSize
+		query := database.DB.Model(&models.TaskLog{}).Select("id, LENGTH(output) AS size").Where("task_id = ?", taskID)
+		if lastID > 0 {
+			query = query.Where("id < ?", lastID)
+		}
+		if err := query.Order("id DESC").Limit(retentionBatchSize).Scan(&rows).Error; err != nil {
+			return 0, 0, err
+		}
+		if len(rows) == 0 {
+			return 0, 0, nil
+		}
+		for _, r := range rows {
+			sum += r.Size
+			if sum > limit {
+				boundary = r.ID
+				break
+			}
+		}
+		lastID = rows[len(rows)-1].ID
+	}
+	return deleteTaskLogsWhere(database.DB.Where("task_id = ? AND id <= ?", taskID, boundary))
+}
+
+// trimTaskLogsByQuota 全部日志超出配额时，从最旧的日志开始删除直到低于配额
+func trimTaskLogsByQuota(quota int64, successOnly bool) (int64, int64, error) {
+	excess := totalTaskLogBytes(database.DB.Model(&models.TaskLog{})) - quota
+	if excess <= 0 {
+		return 0, 0, nil
+	}
+
+	var ids []uint
+	var lastID uint
+	var selected int64
+	for selected < excess {
+		var rows []logSize
+		query := database.DB.Model(&models.TaskLog{}).Select("id, LENGTH(output) AS size").
+			Where("id > ? AND status <> ?", lastID, constant.TaskStatusRunning)
+		if successOnly {
+			query = query.Where("status = ?", constant.TaskStatusSuccess)
+		}
+		if err := query.Order("id ASC").Limit(retentionBatchSize).Scan(&rows).Error; err != nil {
+			return 0, 0, err
+		}
+		if len(rows) == 0 {
+			break
+		}
+		for _, r := range rows {
+			ids = append(ids, r.ID)
+			selected += r.Size
+			if selected >= excess {
+				break
+			}
+		}
+		lastID = rows[len(rows)-1].ID
+	}
+	return deleteTaskLogsByIDs(ids)
+}
+
+// formatSize 格式化字节数
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
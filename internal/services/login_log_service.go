@@ -1,8 +1,11 @@
 package services
 
 import (
+	"time"
+
 	"github.com/engigu/baihu-panel/internal/database"
 	"github.com/engigu/baihu-panel/internal/models"
+	"github.com/engigu/baihu-panel/internal/systime"
 )
 
 type LoginLogService struct{}
@@ -47,6 +50,10 @@ func (s *LoginLogService) List(page, pageSize int, username string) ([]models.Lo
 
 // CleanOldLogs 清理指定天数前的日志
 func (s *LoginLogService) CleanOldLogs(days int) (int64, error) {
-	result := database.DB.Exec("DELETE FROM "+models.LoginLog{}.TableName()+" WHERE created_at < datetime('now', ?)", "-"+string(rune(days))+" days")
+	if days <= 0 {
+		return 0, nil
+	}
+	cutoff := systime.InCST(time.Now()).AddDate(0, 0, -days)
+	result := database.DB.Where("created_at < ?", cutoff).Delete(&models.LoginLog{})
 	return result.RowsAffected, result.Error
 }
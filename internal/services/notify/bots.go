@@ -0,0 +1,154 @@
+package notify
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register(ChannelTelegram, &telegramSender{})
+	Register(ChannelDingTalk, &dingTalkSender{})
+	Register(ChannelWeCom, &weComSender{})
+	Register(ChannelFeishu, &feishuSender{})
+}
+
+// joinMessage 将标题和内容合并为一段文本，用于只支持单段文本的渠道
+func joinMessage(msg Message) string {
+	if msg.Content == "" {
+		return msg.Title
+	}
+	return msg.Title + "\n\n" + msg.Content
+}
+
+// telegramSender Telegram 机器人
+type telegramSender struct{}
+
+func (s *telegramSender) Name() string { return "Telegram" }
+
+func (s *telegramSender) Fields() []Field {
+	return []Field{
+		{Key: "bot_token", Label: "Bot Token", Required: true, Secret: true},
+		{Key: "chat_id", Label: "Chat ID", Required: true},
+		{Key: "api", Label: "API 地址", Placeholder: "https://api.telegram.org"},
+	}
+}
+
+func (s *telegramSender) Send(ctx context.Context, cfg map[string]string, msg Message) error {
+	api := strings.TrimRight(valueOr(cfg, "api", "https://api.telegram.org"), "/")
+	data, err := postJSON(ctx, api+"/bot"+cfg["bot_token"]+"/sendMessage", map[string]interface{}{
+		"chat_id": cfg["chat_id"],
+		"text":    joinMessage(msg),
+	})
+	if err != nil {
+		return fmt.Errorf("Telegram 发送失败: %v", err)
+	}
+	if !strings.Contains(string(data), `"ok":true`) {
+		return fmt.Errorf("Telegram 发送失败: %s", truncate(string(data), 200))
+	}
+	return nil
+}
+
+// dingTalkSender 钉钉群机器人，配置 secret 时使用加签校验
+type dingTalkSender struct{}
+
+func (s *dingTalkSender) Name() string { return "钉钉" }
+
+func (s *dingTalkSender) Fields() []Field {
+	return []Field{
+		{Key: "webhook", Label: "Webhook 地址", Required: true, Placeholder: "https://oapi.dingtalk.com/robot/send?access_token=xxx"},
+		{Key: "secret", Label: "加签密钥", Secret: true},
+	}
+}
+
+func (s *dingTalkSender) Send(ctx context.Context, cfg map[string]string, msg Message) error {
+	target := cfg["webhook"]
+	if secret := cfg["secret"]; secret != "" {
+		ts := strconv.FormatInt(time.Now().UnixMilli(), 10)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(ts + "\n" + secret))
+		sign := url.QueryEscape(base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+		sep := "?"
+		if strings.Contains(target, "?") {
+			sep = "&"
+		}
+		target += sep + "timestamp=" + ts + "&sign=" + sign
+	}
+
+	data, err := postJSON(ctx, target, map[string]interface{}{
+		"msgtype": "text",
+		"text":    map[string]string{"content": joinMessage(msg)},
+	})
+	if err == nil {
+		err = checkCode(data, 0, "errcode")
+	}
+	if err != nil {
+		return fmt.Errorf("钉钉发送失败: %v", err)
+	}
+	return nil
+}
+
+// weComSender 企业微信群机器人
+type weComSender struct{}
+
+func (s *weComSender) Name() string { return "企业微信" }
+
+func (s *weComSender) Fields() []Field {
+	return []Field{
+		{Key: "webhook", Label: "Webhook 地址", Required: true, Placeholder: "https://qyapi.weixin.qq.com/cgi-bin/webhook/send?key=xxx"},
+	}
+}
+
+func (s *weComSender) Send(ctx context.Context, cfg map[string]string, msg Message) error {
+	data, err := postJSON(ctx, cfg["webhook"], map[string]interface{}{
+		"msgtype": "text",
+		"text":    map[string]string{"content": joinMessage(msg)},
+	})
+	if err == nil {
+		err = checkCode(data, 0, "errcode")
+	}
+	if err != nil {
+		return fmt.Errorf("企业微信发送失败: %v", err)
+	}
+	return nil
+}
+
+// feishuSender 飞书群机器人，配置 secret 时使用签名校验
+type feishuSender struct{}
+
+func (s *feishuSender) Name() string { return "飞书" }
+
+func (s *feishuSender) Fields() []Field {
+	return []Field{
+		{Key: "webhook", Label: "Webhook 地址", Required: true, Placeholder: "https://open.feishu.cn/open-apis/bot/v2/hook/xxx"},
+		{Key: "secret", Label: "签名密钥", Secret: true},
+	}
+}
+
+func (s *feishuSender) Send(ctx context.Context, cfg map[string]string, msg Message) error {
+	payload := map[string]interface{}{
+		"msg_type": "text",
+		"content":  map[string]string{"text": joinMessage(msg)},
+	}
+	if secret := cfg["secret"]; secret != "" {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(ts+"\n"+secret))
+		payload["timestamp"] = ts
+		payload["sign"] = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+
+	data, err := postJSON(ctx, cfg["webhook"], payload)
+	if err == nil {
+		err = checkCode(data, 0, "code", "StatusCode")
+	}
+	if err != nil {
+		return fmt.Errorf("飞书发送失败: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register(ChannelSMTP, &smtpSender{})
+}
+
+// smtpSender SMTP 邮件
+// ssl 为 true 时使用隐式 TLS（通常为 465 端口），否则在服务器支持时使用 STARTTLS
+type smtpSender struct{}
+
+func (s *smtpSender) Name() string { return "邮件 (SMTP)" }
+
+func (s *smtpSender) Fields() []Field {
+	return []Field{
+		{Key: "host", Label: "SMTP 服务器", Required: true, Placeholder: "smtp.qq.com"},
+		{Key: "port", Label: "端口", Placeholder: "465"},
+		{Key: "ssl", Label: "SSL", Placeholder: "true"},
+		{Key: "username", Label: "用户名"},
+		{Key: "password", Label: "密码/授权码", Secret: true},
+		{Key: "from", Label: "发件人", Placeholder: "默认为用户名"},
+		{Key: "to", Label: "收件人", Required: true, Placeholder: "多个以逗号分隔"},
+	}
+}
+
+func (s *smtpSender) Send(ctx context.Context, cfg map[string]string, msg Message) error {
+	return SendMail(ctx, cfg, msg)
+}
+
+// SendMail 按 SMTP 渠道配置发送邮件
+func SendMail(ctx context.Context, cfg map[string]string, msg Message) error {
+	host := strings.TrimSpace(cfg["host"])
+	useSSL := valueOr(cfg, "ssl", "true") == "true"
+	port := valueOr(cfg, "port", "465")
+	if !useSSL && cfg["port"] == "" {
+		port = "25"
+	}
+	from := valueOr(cfg, "from", cfg["username"])
+	to := make([]string, 0)
+	for _, addr := range strings.Split(cfg["to"], ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			to = append(to, addr)
+		}
+	}
+	if from == "" || len(to) == 0 {
+		return fmt.Errorf("发件人或收件人为空")
+	}
+
+	addr := net.JoinHostPort(host, port)
+	dialer := &net.Dialer{Timeout: 15 * time.Second}
+	var conn net.Conn
+	var err error
+	if useSSL {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: host})
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("连接 SMTP 服务器失败: %v", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("SMTP 握手失败: %v", err)
+	}
+	defer client.Close()
+
+	if !useSSL {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+				return fmt.Errorf("STARTTLS 失败: %v", err)
+			}
+		}
+	}
+	if cfg["username"] != "" {
+		if ok, _ := client.Extension("AUTH"); ok {
+			if err := client.Auth(smtp.PlainAuth("", cfg["username"], cfg["password"], host)); err != nil {
+				return fmt.Errorf("SMTP 认证失败: %v", err)
+			}
+		}
+	}
+
+	if err := client.Mail(from); err != nil {
+		return fmt.Errorf("设置发件人失败: %v", err)
+	}
+	for _, rcpt := range to {
+		if err := client.Rcpt(rcpt); err != nil {
+			return fmt.Errorf("设置收件人 %s 失败: %v", rcpt, err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(buildMail(from, to, msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("发送邮件失败: %v", err)
+	}
+	return client.Quit()
+}
+
+// buildMail 构造 UTF-8 纯文本邮件
+func buildMail(from string, to []string, msg Message) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + strings.Join(to, ", ") + "\r\n")
+	b.WriteString("Subject: " + mime.BEncoding.Encode("UTF-8", msg.Title) + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+
+	encoded := base64.StdEncoding.EncodeToString([]byte(msg.Content))
+	for len(encoded) > 76 {
+		b.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	b.WriteString(encoded + "\r\n")
+	return []byte(b.String())
+}
@@ -0,0 +1,93 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+func init() {
+	Register(ChannelBark, &barkSender{})
+	Register(ChannelServerChan, &serverChanSender{})
+}
+
+// barkSender Bark（iOS 推送）
+type barkSender struct{}
+
+func (s *barkSender) Name() string { return "Bark" }
+
+func (s *barkSender) Fields() []Field {
+	return []Field{
+		{Key: "key", Label: "Device Key", Required: true, Secret: true},
+		{Key: "server", Label: "服务器地址", Placeholder: "https://api.day.app"},
+		{Key: "group", Label: "分组"},
+		{Key: "sound", Label: "铃声"},
+	}
+}
+
+func (s *barkSender) Send(ctx context.Context, cfg map[string]string, msg Message) error {
+	server := strings.TrimRight(valueOr(cfg, "server", "https://api.day.app"), "/")
+	payload := map[string]interface{}{
+		"device_key": cfg["key"],
+		"title":      msg.Title,
+		"body":       msg.Content,
+	}
+	if cfg["group"] != "" {
+		payload["group"] = cfg["group"]
+	}
+	if cfg["sound"] != "" {
+		payload["sound"] = cfg["sound"]
+	}
+
+	data, err := postJSON(ctx, server+"/push", payload)
+	if err == nil {
+		err = checkCode(data, 200, "code")
+	}
+	if err != nil {
+		return fmt.Errorf("Bark 发送失败: %v", err)
+	}
+	return nil
+}
+
+// serverChanSender Server 酱（支持 Turbo 版及 Server 酱³ 的 sctp 密钥）
+type serverChanSender struct{}
+
+var sctpKeyPattern = regexp.MustCompile(`^sctp(\d+)t`)
+
+func (s *serverChanSender) Name() string { return "Server 酱" }
+
+func (s *serverChanSender) Fields() []Field {
+	return []Field{
+		{Key: "sendkey", Label: "SendKey", Required: true, Secret: true},
+		{Key: "api", Label: "API 地址", Placeholder: "https://sctapi.ftqq.com"},
+	}
+}
+
+func (s *serverChanSender) Send(ctx context.Context, cfg map[string]string, msg Message) error {
+	key := strings.TrimSpace(cfg["sendkey"])
+	var target string
+	if api := strings.TrimRight(cfg["api"], "/"); api != "" {
+		target = api + "/" + key + ".send"
+	} else if m := sctpKeyPattern.FindStringSubmatch(key); m != nil {
+		target = "https://" + m[1] + ".push.ft07.com/send/" + key + ".send"
+	} else {
+		target = "https://sctapi.ftqq.com/" + key + ".send"
+	}
+
+	form := url.Values{}
+	form.Set("title", msg.Title)
+	form.Set("desp", msg.Content)
+	data, err := doRequest(ctx, http.MethodPost, target, strings.NewReader(form.Encode()), map[string]string{
+		"Content-Type": "application/x-www-form-urlencoded",
+	})
+	if err == nil {
+		err = checkCode(data, 0, "code")
+	}
+	if err != nil {
+		return fmt.Errorf("Server 酱发送失败: %v", err)
+	}
+	return nil
+}
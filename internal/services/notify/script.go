@@ -0,0 +1,175 @@
+package notify
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/engigu/baihu-panel/internal/constant"
+	"github.com/engigu/baihu-panel/internal/database"
+	"github.com/engigu/baihu-panel/internal/logger"
+	"github.com/engigu/baihu-panel/internal/models"
+	"github.com/engigu/baihu-panel/internal/systime"
+)
+
+// 注入到本地任务中的环境变量
+const (
+	EnvNotifyURL   = "BAIHU_NOTIFY_URL"
+	EnvNotifyToken = "BAIHU_NOTIFY_TOKEN"
+)
+
+const (
+	maxScriptNotifies   = 20   // 单次执行最多发送的通知数
+	maxScriptTitleLen   = 200  // 标题最大长度
+	maxScriptContentLen = 4000 // 内容最大长度
+)
+
+// SettingsService 接口定义（避免循环依赖）
+type SettingsService interface {
+	Get(section, key string) string
+}
+
+// runToken 单次执行的通知令牌，只在执行期间有效
+type runToken struct {
+	taskID uint
+	logID  uint
+	sent   int
+}
+
+var (
+	runTokens   = make(map[string]*runToken)
+	runTokensMu sync.Mutex
+
+	scriptNotifyURL string
+	settings        SettingsService
+	recordMu        sync.Mutex
+)
+
+// SetSettingsService 注入设置服务，用于读取脚本通知的内置渠道配置
+func SetSettingsService(s SettingsService) {
+	settings = s
+}
+
+// SetScriptNotifyURL 设置任务中可访问的本地通知地址
+func SetScriptNotifyURL(url string) {
+	scriptNotifyURL = url
+}
+
+// IssueRunToken 为一次执行签发通知令牌，返回需注入到任务中的环境变量
+// 令牌在调用 RevokeRunToken 前一直有效，调用方须在执行结束时作废
+func IssueRunToken(taskID, logID uint) (token string, envs []string) {
+	if scriptNotifyURL == "" {
+		return "", nil
+	}
+
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		logger.Errorf("[Notify] 生成通知令牌失败: %v", err)
+		return "", nil
+	}
+	token = hex.EncodeToString(buf)
+
+	runTokensMu.Lock()
+	runTokens[token] = &runToken{taskID: taskID, logID: logID}
+	runTokensMu.Unlock()
+
+	return token, []string{EnvNotifyURL + "=" + scriptNotifyURL, EnvNotifyToken + "=" + token}
+}
+
+// RevokeRunToken 作废通知令牌
+func RevokeRunToken(token string) {
+	if token == "" {
+		return
+	}
+	runTokensMu.Lock()
+	delete(runTokens, token)
+	runTokensMu.Unlock()
+}
+
+// acquireRunToken 校验令牌并占用一次发送额度
+func acquireRunToken(token string) (*runToken, error) {
+	runTokensMu.Lock()
+	defer runTokensMu.Unlock()
+
+	t, ok := runTokens[token]
+	if !ok {
+		return nil, fmt.Errorf("无效的通知令牌，执行结束后令牌即失效")
+	}
+	if t.sent >= maxScriptNotifies {
+		return nil, fmt.Errorf("单次执行最多发送 %d 条通知", maxScriptNotifies)
+	}
+	t.sent++
+	return t, nil
+}
+
+// SendScriptNotify 处理脚本发来的通知：记录到对应的 TaskLog，并通过设置中的内置渠道发送
+func SendScriptNotify(token, title, content string) error {
+	title = truncate(strings.TrimSpace(title), maxScriptTitleLen)
+	content = truncate(content, maxScriptContentLen)
+	if title == "" && content == "" {
+		return fmt.Errorf("标题和内容不能同时为空")
+	}
+
+	t, err := acquireRunToken(token)
+	if err != nil {
+		return err
+	}
+
+	recordScriptNotify(t.logID, models.ScriptNotification{
+		Time:    systime.FormatTime(time.Now()),
+		Title:   title,
+		Content: content,
+	})
+
+	channels := ScriptChannels()
+	if len(channels) == 0 {
+		logger.Warnf("[Notify] 任务 #%d 发送了通知，但未配置脚本通知渠道: %s", t.taskID, title)
+		return nil
+	}
+	msg := Message{Title: title, Content: content}
+	for i := range channels {
+		GetNotifyService().SendMessage(&channels[i], msg)
+	}
+	return nil
+}
+
+// ScriptChannels 从设置中读取脚本通知使用的内置渠道（Webhook、SMTP）
+func ScriptChannels() []models.NotifyChannel {
+	if settings == nil {
+		return nil
+	}
+	var channels []models.NotifyChannel
+	if cfg := settings.Get(constant.SectionScriptNotify, constant.KeyScriptNotifyWebhook); cfg != "" {
+		channels = append(channels, models.NotifyChannel{Name: "脚本通知 Webhook", Type: ChannelWebhook, Config: cfg})
+	}
+	if cfg := settings.Get(constant.SectionScriptNotify, constant.KeyScriptNotifySMTP); cfg != "" {
+		channels = append(channels, models.NotifyChannel{Name: "脚本通知 SMTP", Type: ChannelSMTP, Config: cfg})
+	}
+	return channels
+}
+
+// ValidateScriptChannelConfig 校验脚本通知渠道配置，空字符串表示不启用
+func ValidateScriptChannelConfig(channelType, config string) error {
+	if config == "" {
+		return nil
+	}
+	return ValidateChannel(&models.NotifyChannel{Type: channelType, Config: config})
+}
+
+// recordScriptNotify 将通知追加到 TaskLog.Notifications
+func recordScriptNotify(logID uint, n models.ScriptNotification) {
+	recordMu.Lock()
+	defer recordMu.Unlock()
+
+	var log models.TaskLog
+	if err := database.DB.Select("id", "notifications").First(&log, logID).Error; err != nil {
+		return
+	}
+	list := append(models.ParseScriptNotifications(log.Notifications), n)
+	data, _ := json.Marshal(list)
+	database.DB.Model(&models.TaskLog{}).Where("id = ?", logID).Update("notifications", string(data))
+}
@@ -0,0 +1,136 @@
+package notify
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/engigu/baihu-panel/internal/logger"
+)
+
+// helperMarker 由面板生成的辅助模块首行标记，存在该标记的文件会在启动时更新
+const helperMarker = "baihu-panel sendNotify helper"
+
+// pythonHelper 兼容青龙 notify.py 的 send(title, content) 调用方式
+const pythonHelper = `# ` + helperMarker + `，由面板自动生成，请勿修改
+# 用法: from notify import send; send("标题", "内容")
+import json
+import os
+import urllib.request
+
+
+def send(title, content="", **kwargs):
+    url = os.environ.get("BAIHU_NOTIFY_URL")
+    token = os.environ.get("BAIHU_NOTIFY_TOKEN")
+    if not url or not token:
+        print("[sendNotify] 未检测到通知令牌，仅支持在面板本地任务中使用")
+        return False
+    data = json.dumps({"title": str(title), "content": str(content)}).encode("utf-8")
+    req = urllib.request.Request(url, data=data, method="POST", headers={
+        "Content-Type": "application/json",
+        "Authorization": "Bearer " + token,
+    })
+    try:
+        with urllib.request.urlopen(req, timeout=15) as resp:
+            result = json.loads(resp.read().decode("utf-8"))
+    except Exception as e:
+        print("[sendNotify] 发送失败: %s" % e)
+        return False
+    if result.get("code") != 200:
+        print("[sendNotify] 发送失败: %s" % result.get("msg"))
+        return False
+    print("[sendNotify] 通知已发送: %s" % title)
+    return True
+
+
+sendNotify = send
+`
+
+// nodeHelper 兼容青龙 sendNotify.js 的 sendNotify(title, content) 调用方式
+const nodeHelper = `// ` + helperMarker + `，由面板自动生成，请勿修改
+// 用法: const { sendNotify } = require('./sendNotify'); await sendNotify('标题', '内容')
+const http = require('http');
+const https = require('https');
+
+function sendNotify(title, content = '', params = {}) {
+  return new Promise((resolve) => {
+    const url = process.env.BAIHU_NOTIFY_URL;
+    const token = process.env.BAIHU_NOTIFY_TOKEN;
+    if (!url || !token) {
+      console.log('[sendNotify] 未检测到通知令牌，仅支持在面板本地任务中使用');
+      return resolve(false);
+    }
+    const body = JSON.stringify({ title: String(title), content: String(content) });
+    const client = url.startsWith('https') ? https : http;
+    const req = client.request(url, {
+      method: 'POST',
+      timeout: 15000,
+      headers: {
+        'Content-Type': 'application/json',
+        'Content-Length': Buffer.byteLength(body),
+        Authorization: 'Bearer ' + token,
+      },
+    }, (res) => {
+      let data = '';
+      res.on('data', (chunk) => (data += chunk));
+      res.on('end', () => {
+        try {
+          const result = JSON.parse(data);
+          if (result.code === 200) {
+            console.log('[sendNotify] 通知已发送: ' + title);
+            return resolve(true);
+          }
+          console.log('[sendNotify] 发送失败: ' + result.msg);
+        } catch (e) {
+          console.log('[sendNotify] 发送失败: ' + data);
+        }
+        resolve(false);
+      });
+    });
+    req.on('timeout', () => req.destroy(new Error('timeout')));
+    req.on('error', (e) => {
+      console.log('[sendNotify] 发送失败: ' + e.message);
+      resolve(false);
+    });
+    req.write(body);
+    req.end();
+  });
+}
+
+module.exports = { sendNotify };
+`
+
+// WriteScriptHelpers 将 Python 与 Node 辅助模块写入脚本目录
+// 已存在且不是由面板生成的同名文件（如从青龙迁移的 notify.py）不会被覆盖
+func WriteScriptHelpers(dir string) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logger.Errorf("[Notify] 创建脚本目录失败: %v", err)
+		return
+	}
+	files := map[string]string{
+		"notify.py":     pythonHelper,
+		"sendNotify.js": nodeHelper,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if data, err := os.ReadFile(path); err == nil {
+			if string(data) == content {
+				continue
+			}
+			if !strings.Contains(firstLine(string(data)), helperMarker) {
+				logger.Warnf("[Notify] %s 已存在且非面板生成，跳过写入", path)
+				continue
+			}
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			logger.Errorf("[Notify] 写入 %s 失败: %v", path, err)
+		}
+	}
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
@@ -0,0 +1,189 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 通知渠道类型
+const (
+	ChannelWebhook    = "webhook"
+	ChannelSMTP       = "smtp"
+	ChannelTelegram   = "telegram"
+	ChannelDingTalk   = "dingtalk"
+	ChannelWeCom      = "wecom"
+	ChannelFeishu     = "feishu"
+	ChannelBark       = "bark"
+	ChannelServerChan = "serverchan"
+)
+
+// Message 渲染后的通知内容
+type Message struct {
+	Title   string `json:"title"`
+	Content string `json:"content"`
+}
+
+// Field 渠道配置项描述，供前端生成表单
+type Field struct {
+	Key         string `json:"key"`
+	Label       string `json:"label"`
+	Required    bool   `json:"required"`
+	Secret      bool   `json:"secret"`
+	Placeholder string `json:"placeholder,omitempty"`
+}
+
+// Sender 通知渠道插件
+type Sender interface {
+	// Name 渠道显示名称
+	Name() string
+	// Fields 渠道配置项
+	Fields() []Field
+	// Send 发送一条通知
+	Send(ctx context.Context, cfg map[string]string, msg Message) error
+}
+
+// ChannelType 渠道类型信息
+type ChannelType struct {
+	Type   string  `json:"type"`
+	Name   string  `json:"name"`
+	Fields []Field `json:"fields"`
+}
+
+var (
+	senders     = make(map[string]Sender)
+	senderOrder = make([]string, 0)
+
+	// httpClient 各 HTTP 类渠道共用的客户端
+	httpClient = &http.Client{Timeout: 15 * time.Second}
+)
+
+// Register 注册通知渠道插件
+func Register(channelType string, s Sender) {
+	if _, exists := senders[channelType]; !exists {
+		senderOrder = append(senderOrder, channelType)
+	}
+	senders[channelType] = s
+}
+
+// GetSender 获取通知渠道插件
+func GetSender(channelType string) (Sender, bool) {
+	s, ok := senders[channelType]
+	return s, ok
+}
+
+// ChannelTypes 返回所有已注册的渠道类型
+func ChannelTypes() []ChannelType {
+	types := make([]ChannelType, 0, len(senderOrder))
+	for _, t := range senderOrder {
+		s := senders[t]
+		types = append(types, ChannelType{Type: t, Name: s.Name(), Fields: s.Fields()})
+	}
+	return types
+}
+
+// ValidateConfig 检查渠道配置是否包含所有必填项
+func ValidateConfig(s Sender, cfg map[string]string) error {
+	for _, f := range s.Fields() {
+		if f.Required && strings.TrimSpace(cfg[f.Key]) == "" {
+			return fmt.Errorf("缺少必填配置: %s", f.Label)
+		}
+	}
+	return nil
+}
+
+// ParseConfig 解析渠道配置 JSON，非字符串的值转换为字符串
+func ParseConfig(config string) (map[string]string, error) {
+	result := make(map[string]string)
+	if strings.TrimSpace(config) == "" {
+		return result, nil
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal([]byte(config), &raw); err != nil {
+		return nil, fmt.Errorf("渠道配置格式错误: %v", err)
+	}
+	for k, v := range raw {
+		switch val := v.(type) {
+		case nil:
+		case string:
+			result[k] = val
+		default:
+			result[k] = fmt.Sprint(val)
+		}
+	}
+	return result, nil
+}
+
+// valueOr 返回配置值，为空时返回默认值
+func valueOr(cfg map[string]string, key, def string) string {
+	if v := strings.TrimSpace(cfg[key]); v != "" {
+		return v
+	}
+	return def
+}
+
+// postJSON 以 JSON 格式提交请求并返回响应内容
+func postJSON(ctx context.Context, url string, payload interface{}) ([]byte, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return doRequest(ctx, http.MethodPost, url, bytes.NewReader(body), map[string]string{
+		"Content-Type": "application/json; charset=utf-8",
+	})
+}
+
+// doRequest 发送 HTTP 请求，非 2xx 响应视为失败
+func doRequest(ctx context.Context, method, url string, body io.Reader, headers map[string]string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return data, fmt.Errorf("HTTP %d: %s", resp.StatusCode, truncate(string(data), 200))
+	}
+	return data, nil
+}
+
+// checkCode 检查 JSON 响应中的状态码字段，keys 依次尝试，命中第一个存在的字段
+func checkCode(data []byte, okValue float64, keys ...string) error {
+	var resp map[string]interface{}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return fmt.Errorf("响应解析失败: %s", truncate(string(data), 200))
+	}
+	for _, key := range keys {
+		v, ok := resp[key]
+		if !ok {
+			continue
+		}
+		if code, ok := v.(float64); ok && code == okValue {
+			return nil
+		}
+		return fmt.Errorf("发送失败: %s", truncate(string(data), 200))
+	}
+	return nil
+}
+
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) > n {
+		return string(runes[:n]) + "..."
+	}
+	return s
+}
@@ -0,0 +1,315 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/engigu/baihu-panel/internal/models"
+)
+
+// stubRequest 本地桩服务收到的请求
+type stubRequest struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Body   string
+}
+
+// newStub 启动本地 HTTP 桩服务，记录收到的请求并返回固定的响应
+func newStub(t *testing.T, status int, response string) (*httptest.Server, <-chan stubRequest) {
+	t.Helper()
+	received := make(chan stubRequest, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- stubRequest{Method: r.Method, Path: r.URL.Path, Query: r.URL.Query(), Header: r.Header, Body: string(body)}
+		w.WriteHeader(status)
+		io.WriteString(w, response)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, received
+}
+
+func send(t *testing.T, channelType string, cfg map[string]string) error {
+	t.Helper()
+	s, ok := GetSender(channelType)
+	if !ok {
+		t.Fatalf("渠道 %s 未注册", channelType)
+	}
+	if err := ValidateConfig(s, cfg); err != nil {
+		t.Fatalf("配置校验失败: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.Send(ctx, cfg, Message{Title: "任务失败", Content: "退出码 1\n\"quoted\""})
+}
+
+func jsonBody(t *testing.T, body string) map[string]interface{} {
+	t.Helper()
+	var v map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &v); err != nil {
+		t.Fatalf("请求体不是 JSON: %q", body)
+	}
+	return v
+}
+
+func TestWebhookSender(t *testing.T) {
+	srv, received := newStub(t, http.StatusOK, "ok")
+	err := send(t, ChannelWebhook, map[string]string{
+		"url":     srv.URL + "/hook",
+		"headers": "Authorization: Bearer abc",
+		"body":    `{"text":"$title: $content"}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := <-received
+	if req.Method != http.MethodPost || req.Path != "/hook" {
+		t.Errorf("请求 %s %s", req.Method, req.Path)
+	}
+	if got := req.Header.Get("Authorization"); got != "Bearer abc" {
+		t.Errorf("Authorization = %q", got)
+	}
+	if got := jsonBody(t, req.Body)["text"]; got != "任务失败: 退出码 1\n\"quoted\"" {
+		t.Errorf("text = %q", got)
+	}
+}
+
+func TestWebhookSenderGet(t *testing.T) {
+	srv, received := newStub(t, http.StatusOK, "ok")
+	if err := send(t, ChannelWebhook, map[string]string{"url": srv.URL + "/push?t=$title", "method": "get"}); err != nil {
+		t.Fatal(err)
+	}
+	req := <-received
+	if req.Method != http.MethodGet || req.Query.Get("t") != "任务失败" || req.Body != "" {
+		t.Errorf("请求 %s %v %q", req.Method, req.Query, req.Body)
+	}
+}
+
+func TestWebhookSenderHTTPError(t *testing.T) {
+	srv, _ := newStub(t, http.StatusInternalServerError, "boom")
+	err := send(t, ChannelWebhook, map[string]string{"url": srv.URL})
+	if err == nil || !strings.Contains(err.Error(), "HTTP 500") {
+		t.Fatalf("期望 HTTP 500 错误，得到 %v", err)
+	}
+}
+
+func TestTelegramSender(t *testing.T) {
+	srv, received := newStub(t, http.StatusOK, `{"ok":true}`)
+	if err := send(t, ChannelTelegram, map[string]string{"bot_token": "123:abc", "chat_id": "42", "api": srv.URL + "/"}); err != nil {
+		t.Fatal(err)
+	}
+	req := <-received
+	if req.Path != "/bot123:abc/sendMessage" {
+		t.Errorf("path = %s", req.Path)
+	}
+	body := jsonBody(t, req.Body)
+	if body["chat_id"] != "42" || !strings.HasPrefix(body["text"].(string), "任务失败\n\n") {
+		t.Errorf("body = %v", body)
+	}
+
+	srv, _ = newStub(t, http.StatusOK, `{"ok":false,"description":"chat not found"}`)
+	if err := send(t, ChannelTelegram, map[string]string{"bot_token": "x", "chat_id": "1", "api": srv.URL}); err == nil {
+		t.Fatal("ok 为 false 时应返回错误")
+	}
+}
+
+func TestDingTalkSender(t *testing.T) {
+	srv, received := newStub(t, http.StatusOK, `{"errcode":0,"errmsg":"ok"}`)
+	if err := send(t, ChannelDingTalk, map[string]string{"webhook": srv.URL + "/robot/send?access_token=t", "secret": "SEC"}); err != nil {
+		t.Fatal(err)
+	}
+	req := <-received
+	if req.Query.Get("access_token") != "t" || req.Query.Get("timestamp") == "" || req.Query.Get("sign") == "" {
+		t.Errorf("query = %v", req.Query)
+	}
+	if body := jsonBody(t, req.Body); body["msgtype"] != "text" {
+		t.Errorf("body = %v", body)
+	}
+
+	srv, _ = newStub(t, http.StatusOK, `{"errcode":310000,"errmsg":"sign not match"}`)
+	if err := send(t, ChannelDingTalk, map[string]string{"webhook": srv.URL}); err == nil {
+		t.Fatal("errcode 不为 0 时应返回错误")
+	}
+}
+
+func TestWeComSender(t *testing.T) {
+	srv, received := newStub(t, http.StatusOK, `{"errcode":0}`)
+	if err := send(t, ChannelWeCom, map[string]string{"webhook": srv.URL + "/send?key=k"}); err != nil {
+		t.Fatal(err)
+	}
+	req := <-received
+	text, _ := jsonBody(t, req.Body)["text"].(map[string]interface{})
+	if !strings.Contains(text["content"].(string), "退出码 1") {
+		t.Errorf("body = %s", req.Body)
+	}
+
+	srv, _ = newStub(t, http.StatusOK, `{"errcode":93000}`)
+	if err := send(t, ChannelWeCom, map[string]string{"webhook": srv.URL}); err == nil {
+		t.Fatal("errcode 不为 0 时应返回错误")
+	}
+}
+
+func TestFeishuSender(t *testing.T) {
+	srv, received := newStub(t, http.StatusOK, `{"code":0,"msg":"success"}`)
+	if err := send(t, ChannelFeishu, map[string]string{"webhook": srv.URL, "secret": "SEC"}); err != nil {
+		t.Fatal(err)
+	}
+	body := jsonBody(t, (<-received).Body)
+	if body["msg_type"] != "text" || body["timestamp"] == nil || body["sign"] == nil {
+		t.Errorf("body = %v", body)
+	}
+
+	srv, _ = newStub(t, http.StatusOK, `{"code":19021,"msg":"sign match fail"}`)
+	if err := send(t, ChannelFeishu, map[string]string{"webhook": srv.URL}); err == nil {
+		t.Fatal("code 不为 0 时应返回错误")
+	}
+}
+
+func TestBarkSender(t *testing.T) {
+	srv, received := newStub(t, http.StatusOK, `{"code":200,"message":"success"}`)
+	if err := send(t, ChannelBark, map[string]string{"key": "dev", "server": srv.URL, "group": "baihu"}); err != nil {
+		t.Fatal(err)
+	}
+	req := <-received
+	body := jsonBody(t, req.Body)
+	if req.Path != "/push" || body["device_key"] != "dev" || body["title"] != "任务失败" || body["group"] != "baihu" {
+		t.Errorf("%s %v", req.Path, body)
+	}
+	if _, ok := body["sound"]; ok {
+		t.Error("未配置 sound 时不应提交")
+	}
+
+	srv, _ = newStub(t, http.StatusOK, `{"code":400,"message":"failed"}`)
+	if err := send(t, ChannelBark, map[string]string{"key": "dev", "server": srv.URL}); err == nil {
+		t.Fatal("code 不为 200 时应返回错误")
+	}
+}
+
+func TestServerChanSender(t *testing.T) {
+	srv, received := newStub(t, http.StatusOK, `{"code":0,"message":""}`)
+	if err := send(t, ChannelServerChan, map[string]string{"sendkey": "SCT1", "api": srv.URL}); err != nil {
+		t.Fatal(err)
+	}
+	req := <-received
+	form, _ := url.ParseQuery(req.Body)
+	if req.Path != "/SCT1.send" || form.Get("title") != "任务失败" || !strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		t.Errorf("%s %v", req.Path, form)
+	}
+
+	srv, _ = newStub(t, http.StatusOK, `{"code":40001,"message":"bad key"}`)
+	if err := send(t, ChannelServerChan, map[string]string{"sendkey": "x", "api": srv.URL}); err == nil {
+		t.Fatal("code 不为 0 时应返回错误")
+	}
+}
+
+// TestSMTPSender 使用不支持 STARTTLS 和认证的本地 SMTP 桩服务
+func TestSMTPSender(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		var lines []string
+		io.WriteString(conn, "220 stub ESMTP\r\n")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				break
+			}
+			cmd := strings.ToUpper(strings.TrimSpace(line))
+			lines = append(lines, strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"):
+				io.WriteString(conn, "250 stub\r\n")
+			case cmd == "DATA":
+				io.WriteString(conn, "354 go ahead\r\n")
+				for {
+					data, err := r.ReadString('\n')
+					if err != nil || data == ".\r\n" {
+						break
+					}
+					lines = append(lines, strings.TrimRight(data, "\r\n"))
+				}
+				io.WriteString(conn, "250 queued\r\n")
+			case cmd == "QUIT":
+				io.WriteString(conn, "221 bye\r\n")
+				received <- lines
+				return
+			default:
+				io.WriteString(conn, "250 ok\r\n")
+			}
+		}
+		received <- lines
+	}()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	err = send(t, ChannelSMTP, map[string]string{
+		"host": host, "port": port, "ssl": "false",
+		"from": "baihu@example.com", "to": "a@example.com, b@example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := strings.Join(<-received, "\n")
+	for _, want := range []string{"MAIL FROM:<baihu@example.com>", "RCPT TO:<a@example.com>", "RCPT TO:<b@example.com>", "Subject: =?UTF-8?b?"} {
+		if !strings.Contains(session, want) {
+			t.Errorf("会话中缺少 %q:\n%s", want, session)
+		}
+	}
+}
+
+func TestMaskChannel(t *testing.T) {
+	channel := models.NotifyChannel{Type: ChannelTelegram, Config: `{"bot_token":"123:abc","chat_id":"42"}`}
+	masked := MaskChannel(channel)
+	cfg, _ := ParseConfig(masked.Config)
+	if cfg["bot_token"] != SecretMask || cfg["chat_id"] != "42" {
+		t.Fatalf("masked = %s", masked.Config)
+	}
+
+	kept, _ := ParseConfig(KeepSecrets(ChannelTelegram, masked.Config, channel.Config))
+	if kept["bot_token"] != "123:abc" {
+		t.Fatalf("占位值应沿用已保存的密钥，得到 %v", kept)
+	}
+	changed, _ := ParseConfig(KeepSecrets(ChannelTelegram, `{"bot_token":"new","chat_id":"42"}`, channel.Config))
+	if changed["bot_token"] != "new" {
+		t.Fatalf("修改后的密钥应生效，得到 %v", changed)
+	}
+}
+
+func TestMaskConfig(t *testing.T) {
+	saved := `{"host":"smtp.example.com","password":"pw"}`
+	masked := MaskConfig(ChannelSMTP, saved)
+	if cfg, _ := ParseConfig(masked); cfg["password"] != SecretMask || cfg["host"] != "smtp.example.com" {
+		t.Fatalf("masked = %s", masked)
+	}
+	if kept, _ := ParseConfig(KeepSecrets(ChannelSMTP, masked, saved)); kept["password"] != "pw" {
+		t.Fatalf("占位值应沿用已保存的密码，得到 %v", kept)
+	}
+
+	hook := MaskConfig(ChannelWebhook, `{"url":"https://example.com","headers":"Authorization: Bearer abc"}`)
+	if cfg, _ := ParseConfig(hook); cfg["headers"] != SecretMask || cfg["url"] != "https://example.com" {
+		t.Fatalf("webhook 请求头应隐藏，得到 %s", hook)
+	}
+	if MaskConfig(ChannelSMTP, "") != "" {
+		t.Fatal("空配置应原样返回")
+	}
+}
@@ -0,0 +1,403 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/engigu/baihu-panel/internal/constant"
+	"github.com/engigu/baihu-panel/internal/database"
+	"github.com/engigu/baihu-panel/internal/logger"
+	"github.com/engigu/baihu-panel/internal/models"
+	"github.com/engigu/baihu-panel/internal/systime"
+	"github.com/engigu/baihu-panel/internal/utils"
+)
+
+// 通知事件
+const (
+	EventFailure  = "failure"
+	EventSuccess  = "success"
+	EventTimeout  = "timeout"
+	EventRecovery = "recovery"
+	EventWarning  = "warning"
+	EventHealth   = "health" // 健康状态变化
+	EventMissed   = "missed" // 未按时执行
+)
+
+// AllEvents 所有可配置的通知事件
+var AllEvents = []string{EventFailure, EventSuccess, EventTimeout, EventRecovery, EventWarning, EventHealth, EventMissed}
+
+const (
+	queueSize   = 256
+	workerCount = 2
+	sendTimeout = 30 * time.Second
+)
+
+// retryDelays 发送失败后的重试间隔（指数退避）
+var retryDelays = []time.Duration{5 * time.Second, 30 * time.Second, 2 * time.Minute}
+
+// job 待发送的通知
+type job struct {
+	channel models.NotifyChannel
+	msg     Message
+	attempt int
+}
+
+// NotifyService 通知分发服务
+type NotifyService struct {
+	queue   chan *job
+	stopCh  chan struct{}
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	started bool
+}
+
+var (
+	notifyService *NotifyService
+	notifyOnce    sync.Once
+)
+
+// GetNotifyService 获取单例
+func GetNotifyService() *NotifyService {
+	notifyOnce.Do(func() {
+		notifyService = &NotifyService{
+			queue:  make(chan *job, queueSize),
+			stopCh: make(chan struct{}),
+		}
+	})
+	return notifyService
+}
+
+// Start 启动发送协程
+func (s *NotifyService) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return
+	}
+	s.started = true
+	for i := 0; i < workerCount; i++ {
+		s.wg.Add(1)
+		go s.worker()
+	}
+	logger.Infof("[Notify] 通知服务已启动")
+}
+
+// Stop 停止发送协程，队列中未发送的通知将被丢弃
+func (s *NotifyService) Stop() {
+	s.mu.Lock()
+	if !s.started {
+		s.mu.Unlock()
+		return
+	}
+	s.started = false
+	close(s.stopCh)
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (s *NotifyService) worker() {
+	defer s.wg.Done()
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case j := <-s.queue:
+			s.process(j)
+		}
+	}
+}
+
+// process 发送一条通知，失败时按退避间隔重新入队
+func (s *NotifyService) process(j *job) {
+	err := s.send(&j.channel, j.msg)
+	if err == nil {
+		logger.Infof("[Notify] 渠道 %s 发送成功: %s", j.channel.Name, j.msg.Title)
+		return
+	}
+
+	if j.attempt >= len(retryDelays) {
+		logger.Errorf("[Notify] 渠道 %s 发送失败，已放弃 (共 %d 次): %v", j.channel.Name, j.attempt+1, err)
+		return
+	}
+	delay := retryDelays[j.attempt]
+	j.attempt++
+	logger.Warnf("[Notify] 渠道 %s 发送失败，%v 后第 %d 次重试: %v", j.channel.Name, delay, j.attempt, err)
+	time.AfterFunc(delay, func() { s.enqueue(j) })
+}
+
+func (s *NotifyService) enqueue(j *job) {
+	select {
+	case <-s.stopCh:
+	case s.queue <- j:
+	default:
+		logger.Warnf("[Notify] 通知队列已满，丢弃: %s", j.msg.Title)
+	}
+}
+
+// send 通过渠道插件发送
+func (s *NotifyService) send(channel *models.NotifyChannel, msg Message) error {
+	sender, ok := GetSender(channel.Type)
+	if !ok {
+		return fmt.Errorf("不支持的渠道类型: %s", channel.Type)
+	}
+	cfg, err := ParseConfig(channel.Config)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+	return sender.Send(ctx, cfg, msg)
+}
+
+// SendTest 立即通过渠道发送一条测试通知（不重试），返回发送结果
+func (s *NotifyService) SendTest(channel *models.NotifyChannel) error {
+	now := systime.FormatTime(time.Now())
+	msg, err := Render(channel.TitleTemplate, channel.BodyTemplate, &TemplateData{
+		Event:        EventSuccess,
+		EventName:    "测试通知",
+		TaskName:     "测试任务",
+		Status:       constant.TaskStatusSuccess,
+		DurationText: "1s",
+		Duration:     1000,
+		StartTime:    now,
+		EndTime:      now,
+		Output:       "这是一条来自白虎面板的测试通知",
+	})
+	if err != nil {
+		return err
+	}
+	return s.send(channel, msg)
+}
+
+// SendMessage 通过指定渠道异步发送一条已渲染的消息
+func (s *NotifyService) SendMessage(channel *models.NotifyChannel, msg Message) {
+	s.enqueue(&job{channel: *channel, msg: msg})
+}
+
+// NotifyTaskLog 任务执行完成后按规则发送通知（异步）
+func (s *NotifyService) NotifyTaskLog(taskLog *models.TaskLog) {
+	if taskLog == nil || taskLog.TaskID == 0 {
+		return
+	}
+	log := *taskLog
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				logger.Errorf("[Notify] 处理任务 #%d 通知异常: %v", log.TaskID, r)
+			}
+		}()
+		s.dispatchTaskLog(&log)
+	}()
+}
+
+func (s *NotifyService) dispatchTaskLog(taskLog *models.TaskLog) {
+	events := detectEvents(taskLog)
+	if len(events) == 0 {
+		return
+	}
+	s.dispatch(taskLog, events, nil)
+}
+
+// NotifyHealthChange 任务健康状态变化时发送通知（异步）
+func (s *NotifyService) NotifyHealthChange(taskLog *models.TaskLog, prev, health, reason string) {
+	if taskLog == nil || taskLog.TaskID == 0 {
+		return
+	}
+	log := *taskLog
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				logger.Errorf("[Notify] 处理任务 #%d 健康通知异常: %v", log.TaskID, r)
+			}
+		}()
+		s.dispatch(&log, []string{EventHealth}, func(d *TemplateData) {
+			d.PrevHealth = prev
+			d.Health = health
+			d.HealthReason = reason
+		})
+	}()
+}
+
+// NotifyMissedRun 计划任务未按时执行时发送通知（异步）
+func (s *NotifyService) NotifyMissedRun(taskID uint, expected time.Time, reason string) {
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				logger.Errorf("[Notify] 处理任务 #%d 漏执行通知异常: %v", taskID, r)
+			}
+		}()
+		s.dispatch(&models.TaskLog{TaskID: taskID}, []string{EventMissed}, func(d *TemplateData) {
+			d.Status = EventMissed
+			d.DurationText = "-"
+			d.ExpectedTime = systime.FormatTime(expected)
+			d.Reason = reason
+		})
+	}()
+}
+
+// dispatch 按规则将事件发送到匹配的渠道，events 按优先级排序
+func (s *NotifyService) dispatch(taskLog *models.TaskLog, events []string, fill func(*TemplateData)) {
+	var rules []models.NotifyRule
+	database.DB.Where("enabled = ? AND (task_id = ? OR task_id = 0)", true, taskLog.TaskID).Find(&rules)
+	if len(rules) == 0 {
+		return
+	}
+
+	// 每个渠道只发送一次，取优先级最高的事件（如恢复优先于成功）
+	channelEvents := make(map[uint]string)
+	for _, event := range events {
+		for _, rule := range rules {
+			if _, done := channelEvents[rule.ChannelID]; done {
+				continue
+			}
+			if hasEvent(rule.Events, event) {
+				channelEvents[rule.ChannelID] = event
+			}
+		}
+	}
+	if len(channelEvents) == 0 {
+		return
+	}
+
+	ids := make([]uint, 0, len(channelEvents))
+	for id := range channelEvents {
+		ids = append(ids, id)
+	}
+	var channels []models.NotifyChannel
+	database.DB.Where("id IN ? AND enabled = ?", ids, true).Find(&channels)
+
+	data := buildTemplateData(taskLog)
+	if fill != nil {
+		fill(data)
+	}
+	for i := range channels {
+		channel := channels[i]
+		d := *data
+		d.Event = channelEvents[channel.ID]
+		d.EventName = EventName(d.Event)
+		msg, err := Render(channel.TitleTemplate, channel.BodyTemplate, &d)
+		if err != nil {
+			logger.Errorf("[Notify] 渠道 %s 渲染模板失败: %v", channel.Name, err)
+			continue
+		}
+		s.enqueue(&job{channel: channel, msg: msg})
+	}
+}
+
+// detectEvents 根据执行状态判断触发的事件，按优先级排序
+func detectEvents(taskLog *models.TaskLog) []string {
+	switch taskLog.Status {
+	case constant.TaskStatusFailed:
+		return []string{EventFailure}
+	case constant.TaskStatusTimeout:
+		return []string{EventTimeout, EventFailure}
+	case constant.TaskStatusWarning:
+		return []string{EventWarning}
+	case constant.TaskStatusSuccess:
+		var prev models.TaskLog
+		err := database.DB.Select("id", "status").
+			Where("task_id = ? AND id < ? AND status NOT IN ?", taskLog.TaskID, taskLog.ID,
+				[]string{constant.TaskStatusRunning, constant.TaskStatusPending, constant.TaskStatusQueued}).
+			Order("id DESC").First(&prev).Error
+		if err == nil && (prev.Status == constant.TaskStatusFailed || prev.Status == constant.TaskStatusTimeout) {
+			return []string{EventRecovery, EventSuccess}
+		}
+		return []string{EventSuccess}
+	}
+	return nil
+}
+
+func hasEvent(events, event string) bool {
+	for _, e := range strings.Split(events, ",") {
+		if strings.TrimSpace(e) == event {
+			return true
+		}
+	}
+	return false
+}
+
+// buildTemplateData 从 TaskLog 构造模板数据
+func buildTemplateData(taskLog *models.TaskLog) *TemplateData {
+	var task models.Task
+	database.DB.Unscoped().Select("id", "name").First(&task, taskLog.TaskID)
+
+	data := &TemplateData{
+		TaskID:       taskLog.TaskID,
+		TaskName:     task.Name,
+		LogID:        taskLog.ID,
+		Command:      taskLog.Command,
+		Status:       taskLog.Status,
+		Error:        taskLog.Error,
+		ExitCode:     taskLog.ExitCode,
+		Duration:     taskLog.Duration,
+		DurationText: formatDuration(taskLog.Duration),
+	}
+	if taskLog.AgentID != nil {
+		data.AgentID = *taskLog.AgentID
+	}
+	data.DispatchNote = taskLog.DispatchNote
+	if data.DispatchNote == "" && taskLog.ID > 0 {
+		// 故障转移说明在分发时写入，完成时构造的日志中不包含
+		database.DB.Model(&models.TaskLog{}).Select("dispatch_note").Where("id = ?", taskLog.ID).Scan(&data.DispatchNote)
+	}
+	if taskLog.StartTime != nil {
+		data.StartTime = systime.FormatTime(taskLog.StartTime.Time())
+	}
+	if taskLog.EndTime != nil {
+		data.EndTime = systime.FormatTime(taskLog.EndTime.Time())
+	}
+	if taskLog.Output != "" {
+		if output, err := utils.DecompressFromBase64(taskLog.Output); err == nil {
+			data.Output = tailText(output, maxOutputTail)
+		}
+	}
+	return data
+}
+
+// ValidateEvents 检查事件列表是否有效
+func ValidateEvents(events string) error {
+	valid := make(map[string]bool)
+	for _, e := range AllEvents {
+		valid[e] = true
+	}
+	count := 0
+	for _, e := range strings.Split(events, ",") {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		if !valid[e] {
+			return fmt.Errorf("无效的通知事件: %s", e)
+		}
+		count++
+	}
+	if count == 0 {
+		return fmt.Errorf("至少选择一个通知事件")
+	}
+	return nil
+}
+
+// ValidateChannel 检查渠道类型、配置及模板
+func ValidateChannel(channel *models.NotifyChannel) error {
+	sender, ok := GetSender(channel.Type)
+	if !ok {
+		return fmt.Errorf("不支持的渠道类型: %s", channel.Type)
+	}
+	cfg, err := ParseConfig(channel.Config)
+	if err != nil {
+		return err
+	}
+	if err := ValidateConfig(sender, cfg); err != nil {
+		return err
+	}
+	if err := ValidateTemplate(channel.TitleTemplate); err != nil {
+		return fmt.Errorf("标题模板错误: %v", err)
+	}
+	if err := ValidateTemplate(channel.BodyTemplate); err != nil {
+		return fmt.Errorf("内容模板错误: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/engigu/baihu-panel/internal/database"
+	"github.com/engigu/baihu-panel/internal/models"
+)
+
+// SecretMask 返回给前端时代替密钥配置项的占位值，提交时仍为占位值表示不修改
+const SecretMask = "******"
+
+// ListChannels 获取全部通知渠道，密钥配置项已隐藏
+func ListChannels() []models.NotifyChannel {
+	var channels []models.NotifyChannel
+	database.DB.Order("id ASC").Find(&channels)
+	for i := range channels {
+		channels[i] = MaskChannel(channels[i])
+	}
+	return channels
+}
+
+// MaskChannel 返回隐藏了密钥配置项的渠道副本
+func MaskChannel(channel models.NotifyChannel) models.NotifyChannel {
+	channel.Config = MaskConfig(channel.Type, channel.Config)
+	return channel
+}
+
+// MaskConfig 用占位值代替配置中的密钥配置项，无法解析的配置原样返回
+func MaskConfig(channelType, config string) string {
+	s, ok := GetSender(channelType)
+	if !ok {
+		return config
+	}
+	cfg, err := ParseConfig(config)
+	if err != nil {
+		return config
+	}
+	masked := false
+	for _, f := range s.Fields() {
+		if f.Secret && cfg[f.Key] != "" {
+			cfg[f.Key] = SecretMask
+			masked = true
+		}
+	}
+	if !masked {
+		return config
+	}
+	data, _ := json.Marshal(cfg)
+	return string(data)
+}
+
+// KeepSecrets 提交的配置中仍为占位值的密钥配置项沿用已保存的值
+func KeepSecrets(channelType, config, saved string) string {
+	s, ok := GetSender(channelType)
+	if !ok {
+		return config
+	}
+	cfg, err := ParseConfig(config)
+	if err != nil {
+		return config
+	}
+	old, _ := ParseConfig(saved)
+	kept := false
+	for _, f := range s.Fields() {
+		if f.Secret && cfg[f.Key] == SecretMask {
+			cfg[f.Key] = old[f.Key]
+			kept = true
+		}
+	}
+	if !kept {
+		return config
+	}
+	data, _ := json.Marshal(cfg)
+	return string(data)
+}
+
+// GetChannel 根据 ID 获取通知渠道
+func GetChannel(id uint) *models.NotifyChannel {
+	var channel models.NotifyChannel
+	if err := database.DB.First(&channel, id).Error; err != nil {
+		return nil
+	}
+	return &channel
+}
+
+// SaveChannel 校验并保存通知渠道（ID 为 0 时新建）
+func SaveChannel(channel *models.NotifyChannel) error {
+	channel.Name = strings.TrimSpace(channel.Name)
+	if channel.Name == "" {
+		return fmt.Errorf("渠道名称不能为空")
+	}
+	if err := ValidateChannel(channel); err != nil {
+		return err
+	}
+	if channel.ID == 0 {
+		return database.DB.Create(channel).Error
+	}
+	return database.DB.Model(channel).
+		Select("name", "type", "config", "title_template", "body_template", "enabled").
+		Updates(channel).Error
+}
+
+// DeleteChannel 删除通知渠道及其关联的规则
+func DeleteChannel(id uint) error {
+	if err := database.DB.Where("channel_id = ?", id).Delete(&models.NotifyRule{}).Error; err != nil {
+		return err
+	}
+	return database.DB.Delete(&models.NotifyChannel{}, id).Error
+}
+
+// ListRules 获取通知规则，taskID 不为 nil 时只返回该任务的规则
+func ListRules(taskID *uint) []models.NotifyRule {
+	var rules []models.NotifyRule
+	query := database.DB.Order("id ASC")
+	if taskID != nil {
+		query = query.Where("task_id = ?", *taskID)
+	}
+	query.Find(&rules)
+	return rules
+}
+
+// GetRule 根据 ID 获取通知规则
+func GetRule(id uint) *models.NotifyRule {
+	var rule models.NotifyRule
+	if err := database.DB.First(&rule, id).Error; err != nil {
+		return nil
+	}
+	return &rule
+}
+
+// SaveRule 校验并保存通知规则（ID 为 0 时新建）
+func SaveRule(rule *models.NotifyRule) error {
+	if err := ValidateEvents(rule.Events); err != nil {
+		return err
+	}
+	rule.Events = normalizeEvents(rule.Events)
+	if GetChannel(rule.ChannelID) == nil {
+		return fmt.Errorf("通知渠道不存在")
+	}
+	if rule.TaskID != 0 {
+		var count int64
+		database.DB.Model(&models.Task{}).Where("id = ?", rule.TaskID).Count(&count)
+		if count == 0 {
+			return fmt.Errorf("任务不存在")
+		}
+	}
+	if rule.ID == 0 {
+		return database.DB.Create(rule).Error
+	}
+	return database.DB.Model(rule).
+		Select("task_id", "channel_id", "events", "enabled").
+		Updates(rule).Error
+}
+
+// DeleteRule 删除通知规则
+func DeleteRule(id uint) error {
+	return database.DB.Delete(&models.NotifyRule{}, id).Error
+}
+
+// DeleteTaskRules 删除任务专属的通知规则
+func DeleteTaskRules(taskID uint) {
+	if taskID == 0 {
+		return
+	}
+	database.DB.Where("task_id = ?", taskID).Delete(&models.NotifyRule{})
+}
+
+// normalizeEvents 去除空白和重复的事件
+func normalizeEvents(events string) string {
+	seen := make(map[string]bool)
+	var list []string
+	for _, e := range strings.Split(events, ",") {
+		e = strings.TrimSpace(e)
+		if e == "" || seen[e] {
+			continue
+		}
+		seen[e] = true
+		list = append(list, e)
+	}
+	return strings.Join(list, ",")
+}
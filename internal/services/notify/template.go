@@ -0,0 +1,147 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+// 默认通知模板
+const (
+	DefaultTitleTemplate = `[白虎面板] {{.TaskName}} {{.EventName}}`
+	DefaultBodyTemplate  = `任务: {{.TaskName}} (#{{.TaskID}})
+状态: {{.Status}}
+耗时: {{.DurationText}}
+开始: {{.StartTime}}
+结束: {{.EndTime}}
+{{- if .ExpectedTime}}
+预期执行: {{.ExpectedTime}}
+可能原因: {{.Reason}}
+{{- end}}
+{{- if .DispatchNote}}
+故障转移: {{.DispatchNote}}
+{{- end}}
+{{- if .Health}}
+健康: {{.PrevHealth}} -> {{.Health}}{{if .HealthReason}} ({{.HealthReason}}){{end}}
+{{- end}}
+{{- if .Error}}
+错误: {{.Error}}
+{{- end}}
+{{- if .Output}}
+
+{{.Output}}
+{{- end}}`
+)
+
+// maxOutputTail 模板中 Output 字段保留的日志尾部长度
+const maxOutputTail = 1500
+
+// TemplateData 通知模板可用的字段（来自 TaskLog）
+type TemplateData struct {
+	Event        string // 事件: failure, success, timeout, recovery, warning, health, missed
+	EventName    string // 事件中文名称
+	TaskID       uint
+	TaskName     string
+	LogID        uint
+	AgentID      uint
+	DispatchNote string // 未在首选节点执行时的说明（故障转移原因）
+	Command      string
+	Status       string
+	Error        string
+	ExitCode     int
+	Duration     int64  // 毫秒
+	DurationText string // 可读耗时
+	StartTime    string
+	EndTime      string
+	Output       string // 日志输出尾部
+	Health       string // 健康状态（仅 health 事件）: healthy, degraded, failing
+	PrevHealth   string // 变化前的健康状态（仅 health 事件）
+	HealthReason string // 健康状态判定原因（仅 health 事件）
+	ExpectedTime string // 预期执行时间（仅 missed 事件）
+	Reason       string // 漏执行的可能原因（仅 missed 事件）
+}
+
+// 事件显示名称
+var eventNames = map[string]string{
+	EventFailure:  "执行失败",
+	EventSuccess:  "执行成功",
+	EventTimeout:  "执行超时",
+	EventRecovery: "恢复正常",
+	EventWarning:  "执行警告",
+	EventHealth:   "健康状态变化",
+	EventMissed:   "未按时执行",
+}
+
+// EventName 返回事件的显示名称
+func EventName(event string) string {
+	if name, ok := eventNames[event]; ok {
+		return name
+	}
+	return event
+}
+
+// ValidateTemplate 检查模板语法
+func ValidateTemplate(text string) error {
+	if text == "" {
+		return nil
+	}
+	_, err := template.New("notify").Parse(text)
+	return err
+}
+
+// Render 渲染渠道的标题和内容模板，为空时使用默认模板
+func Render(titleTpl, bodyTpl string, data *TemplateData) (Message, error) {
+	if titleTpl == "" {
+		titleTpl = DefaultTitleTemplate
+	}
+	if bodyTpl == "" {
+		bodyTpl = DefaultBodyTemplate
+	}
+
+	title, err := renderText(titleTpl, data)
+	if err != nil {
+		return Message{}, fmt.Errorf("标题模板错误: %v", err)
+	}
+	body, err := renderText(bodyTpl, data)
+	if err != nil {
+		return Message{}, fmt.Errorf("内容模板错误: %v", err)
+	}
+	return Message{Title: strings.TrimSpace(title), Content: strings.TrimSpace(body)}, nil
+}
+
+func renderText(text string, data *TemplateData) (string, error) {
+	tpl, err := template.New("notify").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// tailText 截取文本尾部，保证不截断多字节字符
+func tailText(s string, n int) string {
+	s = strings.TrimSpace(s)
+	if len(s) <= n {
+		return s
+	}
+	start := len(s) - n
+	for start < len(s) && !utf8.RuneStart(s[start]) {
+		start++
+	}
+	return "..." + s[start:]
+}
+
+// formatDuration 格式化毫秒耗时
+func formatDuration(ms int64) string {
+	d := time.Duration(ms) * time.Millisecond
+	if d < time.Second {
+		return fmt.Sprintf("%dms", ms)
+	}
+	return d.Round(time.Second).String()
+}
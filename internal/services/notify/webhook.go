@@ -0,0 +1,74 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+func init() {
+	Register(ChannelWebhook, &webhookSender{})
+}
+
+// webhookSender 通用 Webhook
+// body 为空时提交 {"title": ..., "content": ...}；否则将其中的 $title / $content 替换后提交
+type webhookSender struct{}
+
+func (s *webhookSender) Name() string { return "Webhook" }
+
+func (s *webhookSender) Fields() []Field {
+	return []Field{
+		{Key: "url", Label: "请求地址", Required: true},
+		{Key: "method", Label: "请求方法", Placeholder: "POST"},
+		{Key: "content_type", Label: "Content-Type", Placeholder: "application/json"},
+		{Key: "headers", Label: "请求头", Placeholder: "每行一个，如 Authorization: Bearer xxx", Secret: true},
+		{Key: "body", Label: "请求体", Placeholder: `{"title":"$title","content":"$content"}`},
+	}
+}
+
+func (s *webhookSender) Send(ctx context.Context, cfg map[string]string, msg Message) error {
+	method := strings.ToUpper(valueOr(cfg, "method", http.MethodPost))
+	contentType := valueOr(cfg, "content_type", "application/json")
+	isJSON := strings.Contains(contentType, "json")
+
+	var body string
+	if tpl := cfg["body"]; tpl != "" {
+		title, content := msg.Title, msg.Content
+		if isJSON {
+			title, content = jsonEscape(title), jsonEscape(content)
+		}
+		body = strings.NewReplacer("$title", title, "$content", content).Replace(tpl)
+	} else {
+		data, _ := json.Marshal(msg)
+		body = string(data)
+	}
+
+	headers := map[string]string{"Content-Type": contentType}
+	for _, line := range strings.Split(cfg["headers"], "\n") {
+		if k, v, ok := strings.Cut(line, ":"); ok && strings.TrimSpace(k) != "" {
+			headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+
+	// GET 请求将标题和内容替换到地址中
+	target := cfg["url"]
+	reader := strings.NewReader(body)
+	if method == http.MethodGet {
+		target = strings.NewReplacer("$title", url.QueryEscape(msg.Title), "$content", url.QueryEscape(msg.Content)).Replace(target)
+		reader = strings.NewReader("")
+	}
+
+	if _, err := doRequest(ctx, method, target, reader, headers); err != nil {
+		return fmt.Errorf("Webhook 请求失败: %v", err)
+	}
+	return nil
+}
+
+// jsonEscape 转义字符串以便嵌入 JSON 字符串字面量
+func jsonEscape(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data[1 : len(data)-1])
+}
@@ -0,0 +1,292 @@
+package tasks
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/engigu/baihu-panel/internal/constant"
+	"github.com/engigu/baihu-panel/internal/database"
+	"github.com/engigu/baihu-panel/internal/executor"
+	"github.com/engigu/baihu-panel/internal/logger"
+	"github.com/engigu/baihu-panel/internal/models"
+	"github.com/engigu/baihu-panel/internal/services/telemetry"
+)
+
+// NormalizeAgentTarget 校验并规范化任务的执行位置
+// 未设置标签选择器时沿用 agent_id 指定单个 Agent；设置后分发模式默认为 any，只有 sticky 模式保留 agent_id 作为优先 Agent
+func NormalizeAgentTarget(agentID *uint, selector, mode string) (*uint, string, string, error) {
+	selector = strings.TrimSpace(selector)
+	if selector == "" {
+		return agentID, "", "", nil
+	}
+	if _, err := models.ParseLabelSelector(selector); err != nil {
+		return nil, "", "", err
+	}
+
+	switch mode {
+	case "":
+		mode = constant.DispatchAny
+	case constant.DispatchAny, constant.DispatchAll, constant.DispatchSticky:
+	default:
+		return nil, "", "", fmt.Errorf("无效的分发模式: %s", mode)
+	}
+
+	if mode != constant.DispatchSticky {
+		agentID = nil
+	} else if agentID == nil || *agentID == 0 {
+		return nil, "", "", fmt.Errorf("sticky 模式需要指定优先 Agent")
+	}
+	return agentID, selector, mode, nil
+}
+
+// NormalizeFallback 规范化故障转移配置：去重并移除首选 Agent，本地任务和 all 模式不支持故障转移
+func NormalizeFallback(agentID *uint, selector, mode, fallbackAgents string, fallbackLocal bool) (string, bool) {
+	local := selector == "" && (agentID == nil || *agentID == 0)
+	if local || mode == constant.DispatchAll {
+		return "", false
+	}
+
+	seen := make(map[uint]bool)
+	if selector == "" {
+		seen[*agentID] = true
+	}
+	var ids []string
+	for _, id := range (&models.Task{FallbackAgents: fallbackAgents}).FallbackAgentIDs() {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, fmt.Sprintf("%d", id))
+		}
+	}
+	return strings.Join(ids, ","), fallbackLocal
+}
+
+// TaskTargetsAgent 判断任务是否下发给指定 Agent
+func TaskTargetsAgent(task *models.Task, agent *models.Agent) bool {
+	// 备用 Agent 也需要任务配置，才能在故障转移时执行
+	if task.DispatchMode != constant.DispatchAll {
+		for _, id := range task.FallbackAgentIDs() {
+			if id == agent.ID {
+				return true
+			}
+		}
+	}
+	if task.AgentSelector == "" {
+		return task.AgentID != nil && *task.AgentID == agent.ID
+	}
+	if task.DispatchMode == constant.DispatchSticky && task.AgentID != nil && *task.AgentID == agent.ID {
+		return true
+	}
+	sel, err := models.ParseLabelSelector(task.AgentSelector)
+	if err != nil {
+		return false
+	}
+	return sel.Matches(agent.EffectiveLabels())
+}
+
+// MatchTaskAgents 返回任务下发的已启用且已审批的 Agent（按 ID 排序）
+func MatchTaskAgents(task *models.Task) []models.Agent {
+	var agents []models.Agent
+	query := database.DB.Where("enabled = ? AND approval = ?", true, constant.AgentApprovalApproved)
+	if task.AgentSelector == "" {
+		if task.AgentID == nil || *task.AgentID == 0 {
+			return nil
+		}
+		query = query.Where("id = ?", *task.AgentID)
+	}
+	query.Order("id ASC").Find(&agents)
+
+	matched := agents[:0]
+	for i := range agents {
+		if TaskTargetsAgent(task, &agents[i]) {
+			matched = append(matched, agents[i])
+		}
+	}
+	return matched
+}
+
+// onlineTaskAgents 返回任务下发的在线 Agent
+func (es *ExecutorService) onlineTaskAgents(task *models.Task) []models.Agent {
+	var online []models.Agent
+	for _, agent := range MatchTaskAgents(task) {
+		if es.agentWSManager.IsOnline(agent.ID) {
+			online = append(online, agent)
+		}
+	}
+	return online
+}
+
+// selectAgent 为面板调度的任务（any / sticky 模式）选择执行的 Agent
+// sticky 模式优先使用指定 Agent，不可用时与 any 模式一样在匹配的在线 Agent 中选择负载最低的一个：
+// 先比较运行和排队的任务数，再比较资源压力，都相同时轮流选择
+func (es *ExecutorService) selectAgent(task *models.Task) (uint, error) {
+	candidates := es.onlineTaskAgents(task)
+	if len(candidates) == 0 {
+		return 0, fmt.Errorf("没有匹配 %s 的在线 Agent", task.AgentSelector)
+	}
+
+	if task.DispatchMode == constant.DispatchSticky && task.AgentID != nil {
+		for _, agent := range candidates {
+			if agent.ID == *task.AgentID {
+				return agent.ID, nil
+			}
+		}
+		logger.Warnf("[Executor] 任务 #%d 的优先 Agent #%d 不可用，切换到其他匹配的 Agent", task.ID, *task.AgentID)
+	}
+
+	ids := make([]uint, len(candidates))
+	for i, agent := range candidates {
+		ids[i] = agent.ID
+	}
+	type runningCount struct {
+		AgentID uint
+		Count   int
+	}
+	var counts []runningCount
+	database.DB.Model(&models.TaskLog{}).Select("agent_id, COUNT(*) AS count").
+		Where("status = ? AND agent_id IN ?", constant.TaskStatusRunning, ids).
+		Group("agent_id").Scan(&counts)
+	busy := make(map[uint]int, len(counts))
+	for _, c := range counts {
+		busy[c.AgentID] = c.Count
+	}
+	// 有最新心跳上报的 Agent 使用其上报的运行和排队数，并按 10% 分档比较资源压力，避免微小波动打破轮流分发
+	pressure := make(map[uint]int, len(ids))
+	for _, id := range ids {
+		if sample, ok := telemetry.Fresh(id); ok {
+			busy[id] = sample.Running + sample.Queued
+			pressure[id] = int(telemetry.Pressure(sample) / 10)
+		}
+	}
+
+	// 从上次选择的下一个开始，保证负载相同时轮流分发
+	es.mu.Lock()
+	start := es.dispatchCursor[task.ID] % len(ids)
+	es.dispatchCursor[task.ID] = start + 1
+	es.mu.Unlock()
+	ids = append(ids[start:], ids[:start]...)
+	sort.SliceStable(ids, func(i, j int) bool {
+		if busy[ids[i]] != busy[ids[j]] {
+			return busy[ids[i]] < busy[ids[j]]
+		}
+		return pressure[ids[i]] < pressure[ids[j]]
+	})
+	return ids[0], nil
+}
+
+// resolveTargetAgent 确定本次远程执行的节点，并将节点和故障转移原因记录到执行日志
+// 返回 0 表示故障转移到本地执行
+func (es *ExecutorService) resolveTargetAgent(task *models.Task, req *executor.ExecutionRequest) (uint, error) {
+	// 手动执行 all 模式任务时，每个请求已指定 Agent，不做故障转移
+	if agentID, _ := req.Metadata["agent_id"].(uint); agentID > 0 {
+		if _, cause := es.checkAgent(agentID); cause != "" {
+			return 0, fmt.Errorf("%s", cause)
+		}
+		es.recordTarget(req, agentID, "")
+		return agentID, nil
+	}
+
+	var agentID uint
+	var cause, note string
+	switch {
+	case task.AgentSelector == "":
+		agentID = *task.AgentID
+	case task.DispatchMode == constant.DispatchAll:
+		return 0, fmt.Errorf("all 模式的任务由各 Agent 按计划执行")
+	default:
+		id, err := es.selectAgent(task)
+		if err != nil {
+			cause = err.Error()
+		} else {
+			agentID = id
+			if task.DispatchMode == constant.DispatchSticky && task.AgentID != nil && *task.AgentID != id {
+				_, why := es.checkAgent(*task.AgentID)
+				note = fmt.Sprintf("%s，切换到匹配的 Agent #%d", why, id)
+			}
+		}
+	}
+	if agentID > 0 {
+		if _, why := es.checkAgent(agentID); why != "" {
+			cause, agentID = why, 0
+		}
+	}
+
+	if agentID == 0 {
+		id, failoverNote, err := es.failoverTarget(task, cause)
+		if err != nil {
+			return 0, err
+		}
+		agentID, note = id, failoverNote
+		logger.Warnf("[Executor] 任务 #%d 故障转移: %s", task.ID, note)
+	}
+
+	es.recordTarget(req, agentID, note)
+	return agentID, nil
+}
+
+// checkAgent 检查 Agent 是否可以执行任务，不可用时返回原因
+func (es *ExecutorService) checkAgent(agentID uint) (*models.Agent, string) {
+	var agent models.Agent
+	if err := database.DB.First(&agent, agentID).Error; err != nil {
+		return nil, fmt.Sprintf("Agent #%d 不存在", agentID)
+	}
+	if !agent.Enabled {
+		return &agent, fmt.Sprintf("Agent %s 已禁用", agent.Name)
+	}
+	if agent.Pending() {
+		return &agent, fmt.Sprintf("Agent %s 等待审批", agent.Name)
+	}
+	if !es.agentWSManager.IsOnline(agentID) {
+		return &agent, fmt.Sprintf("Agent %s 离线", agent.Name)
+	}
+	return &agent, ""
+}
+
+// failoverTarget 首选节点不可用时，按顺序选择第一个可用的备用 Agent，都不可用时按配置回退到本地执行
+func (es *ExecutorService) failoverTarget(task *models.Task, cause string) (uint, string, error) {
+	var reasons []string
+	for _, id := range task.FallbackAgentIDs() {
+		agent, why := es.checkAgent(id)
+		if why == "" {
+			return id, fmt.Sprintf("%s，切换到备用 Agent %s", cause, agent.Name), nil
+		}
+		reasons = append(reasons, why)
+	}
+	if len(reasons) > 0 {
+		cause = fmt.Sprintf("%s；备用 Agent 均不可用（%s）", cause, strings.Join(reasons, "，"))
+	}
+	if task.FallbackLocal {
+		return 0, cause + "，切换到本地执行", nil
+	}
+	return 0, "", fmt.Errorf("%s", cause)
+}
+
+// recordTarget 记录本次执行的节点，agentID 为 0 表示本地执行
+func (es *ExecutorService) recordTarget(req *executor.ExecutionRequest, agentID uint, note string) {
+	req.Metadata["agent_id"] = agentID
+	if req.LogID == 0 {
+		return
+	}
+	updates := map[string]interface{}{"agent_id": nil, "dispatch_note": note}
+	if agentID > 0 {
+		updates["agent_id"] = agentID
+	}
+	database.DB.Model(&models.TaskLog{}).Where("id = ?", req.LogID).Updates(updates)
+}
+
+// requestAgentID 返回执行请求实际使用的 Agent
+func requestAgentID(req *executor.ExecutionRequest, task *models.Task) *uint {
+	if req.Metadata != nil {
+		if agentID, ok := req.Metadata["agent_id"].(uint); ok {
+			if agentID == 0 {
+				return nil // 故障转移到本地执行
+			}
+			return &agentID
+		}
+	}
+	if task != nil && task.AgentSelector == "" && task.AgentID != nil && *task.AgentID > 0 {
+		agentID := *task.AgentID
+		return &agentID
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"os"
 	"path/filepath"
 	"strings"
 	"sync"
@@ -15,6 +16,7 @@ import (
 	"github.com/engigu/baihu-panel/internal/executor"
 	"github.com/engigu/baihu-panel/internal/logger"
 	"github.com/engigu/baihu-panel/internal/models"
+	"github.com/engigu/baihu-panel/internal/services/notify"
 	"github.com/engigu/baihu-panel/internal/utils"
 
 	"gorm.io/gorm"
@@ -26,6 +28,7 @@ type AgentWSManager interface {
 	RegisterRemoteWaiter(logID uint) chan *models.AgentTaskResult
 	UnregisterRemoteWaiter(logID uint)
 	SendToAgent(agentID uint, msgType string, data interface{}) error
+	IsOnline(agentID uint) bool
 }
 
 // SettingsService 接口定义（避免循环依赖）
@@ -47,6 +50,8 @@ type ExecutorService struct {
 	envService      EnvService
 	scheduler       *executor.Scheduler
 	cronManager     *executor.CronManager
+	missedMonitor   *missedRunMonitor
+	dispatchCursor  map[uint]int // 按标签分发的任务下次轮询的起点
 	results         []executor.ExecutionResult
 	mu              sync.RWMutex
 	resultsMu       sync.RWMutex
@@ -72,6 +77,7 @@ func NewExecutorService(
 		settingsService: settingsService,
 		envService:      envService,
 		results:         make([]executor.ExecutionResult, 0, 100),
+		dispatchCursor:  make(map[uint]int),
 		stopCh:          make(chan struct{}),
 	}
 
@@ -81,6 +87,9 @@ func NewExecutorService(
 	// 2. 初始化计划任务管理器
 	es.cronManager = executor.NewCronManager(es.scheduler)
 
+	// 3. 初始化漏执行检测
+	es.missedMonitor = newMissedRunMonitor(es)
+
 	return es
 }
 
@@ -131,7 +140,9 @@ func (h *ServerSchedulerHandler) OnTaskExecuting(req *executor.ExecutionRequest)
 	req.LogID = taskLog.ID // 设置 LogID 供后续环节使用
 
 	// 2. 检查并记录运行状态（并发控制）
-	goid, err := h.es.AddRunningGo(task.ID)
+	// all 模式的手动执行会同时下发给多个 Agent，不受单实例限制
+	fanout, _ := req.Metadata["fanout"].(bool)
+	goid, err := h.es.addRunningGo(task.ID, !fanout)
 	if err != nil {
 		// 并发限制，更新日志状态为失败
 		taskLog.Status = constant.TaskStatusFailed
@@ -152,6 +163,19 @@ func (h *ServerSchedulerHandler) OnTaskExecuting(req *executor.ExecutionRequest)
 		return nil, nil, fmt.Errorf("创建日志收集器失败: %v", err)
 	}
 
+	// 本地任务开启行时间戳时，分别返回 stdout/stderr 写入器（执行器将使用 Pipe 模式）
+	if !task.IsRemote() {
+		var config models.TaskConfig
+		if task.Config != "" {
+			_ = json.Unmarshal([]byte(task.Config), &config)
+		}
+		if config.LogTimestamps == 1 {
+			database.DB.Model(&models.TaskLog{}).Where("id = ?", taskLog.ID).Update("log_format", LogFormatTagged)
+			stdout, stderr := tl.Streams()
+			return stdout, stderr, nil
+		}
+	}
+
 	// 对于本地任务，Scheduler 会通过返回的 Writer 写入日志
 	// 对于远程任务，Scheduler 不会写入任何内容（由 Agent 推送至此 TL）
 	return tl, tl, nil
@@ -188,6 +212,22 @@ func (h *ServerSchedulerHandler) OnTaskCompleted(req *executor.ExecutionRequest,
 
 	// 无论本地还是远程，都在此处处理日志压缩和落库
 	tl := GetActiveLog(req.LogID)
+
+	// 按任务配置的日志规则修正执行结果
+	status, errMsg := result.Status, result.Error
+	if rules := ParseResultRules(task.Config); !rules.Empty() {
+		if tl != nil {
+			if f, err := tl.OpenReader(); err == nil {
+				ApplyResultRules(rules, f, &status, &errMsg)
+				f.Close()
+			} else {
+				logger.Warnf("[Executor] 读取任务 #%d 日志失败，跳过结果规则: %v", task.ID, err)
+			}
+		} else {
+			ApplyResultRules(rules, strings.NewReader(result.Output), &status, &errMsg)
+		}
+	}
+
 	var output string
 	if tl != nil {
 		// 压缩并清理实时日志
@@ -199,7 +239,8 @@ func (h *ServerSchedulerHandler) OnTaskCompleted(req *executor.ExecutionRequest,
 		}
 	} else {
 		// 如果 TinyLog 已经丢失，尝试从 result.Output 中恢复一次（主要针对本地任务）
-		output, _ = utils.CompressToBase64(result.Output)
+		redactor, _ := req.Metadata["redactor"].(*utils.Redactor)
+		output, _ = utils.CompressToBase64(redactor.Redact(result.Output))
 	}
 
 	// 构造待保存的日志模型
@@ -211,8 +252,8 @@ func (h *ServerSchedulerHandler) OnTaskCompleted(req *executor.ExecutionRequest,
 		TaskID:    task.ID,
 		Command:   req.Command,
 		Output:    output,
-		Error:     result.Error,
-		Status:    result.Status,
+		Error:     errMsg,
+		Status:    status,
 		Duration:  result.Duration,
 		ExitCode:  result.ExitCode,
 		StartTime: &startTime,
@@ -220,10 +261,7 @@ func (h *ServerSchedulerHandler) OnTaskCompleted(req *executor.ExecutionRequest,
 	}
 
 	// 如果有 AgentID，也记录下来
-	if task.AgentID != nil && *task.AgentID > 0 {
-		agentID := *task.AgentID
-		taskLog.AgentID = &agentID
-	}
+	taskLog.AgentID = requestAgentID(req, task)
 
 	// 移除运行记录
 	if req.Metadata != nil {
@@ -276,11 +314,7 @@ func (h *ServerSchedulerHandler) OnTaskFailed(req *executor.ExecutionRequest, er
 	}
 
 	// 补充 AgentID
-	task := h.es.taskService.GetTaskByID(int(taskID))
-	if task != nil && task.AgentID != nil && *task.AgentID > 0 {
-		agentID := *task.AgentID
-		taskLog.AgentID = &agentID
-	}
+	taskLog.AgentID = requestAgentID(req, h.es.taskService.GetTaskByID(int(taskID)))
 
 	h.es.taskLogService.ProcessTaskCompletion(taskLog)
 }
@@ -343,12 +377,35 @@ func (es *ExecutorService) ExecuteDispatcher(ctx context.Context, req *executor.
 		req.Envs = append(req.Envs, es.loadEnvVars(task.Envs)...)
 	}
 
+	// 单次执行的脚本通知令牌，只注入本地任务，执行结束后作废；令牌本身也需要脱敏
+	notifyToken, notifyEnvs := notify.IssueRunToken(task.ID, req.LogID)
+	defer notify.RevokeRunToken(notifyToken)
+
+	// 配置日志脱敏（隐藏环境变量的值及自定义规则），本地与远程任务的日志都会经过 TinyLog
+	redactor := es.buildRedactor(task.Envs, notifyToken)
+	if tl := GetActiveLog(req.LogID); tl != nil {
+		tl.SetRedactor(redactor)
+	}
+	if req.Metadata != nil {
+		req.Metadata["redactor"] = redactor
+	}
+
 	// 远程任务
-	if task.AgentID != nil && *task.AgentID > 0 {
-		return es.ExecuteRemoteForScheduler(task, req.LogID)
+	if task.IsRemote() {
+		agentID, err := es.resolveTargetAgent(task, req)
+		if err != nil {
+			return nil, err
+		}
+		if agentID > 0 {
+			return es.ExecuteRemoteForScheduler(task, agentID, req.LogID)
+		}
+		// 故障转移到本地：Agent 上的工作目录在本机不一定存在
+		req.WorkDir = localFallbackWorkDir(req.WorkDir)
 	}
 
-	// 本地任务
+	// 本地任务：注入脚本通知地址和单次执行的令牌
+	req.Envs = append(req.Envs, notifyEnvs...)
+
 	hooks := &LocalTaskHooks{es: es, logID: req.LogID}
 	return executor.ExecuteWithHooks(ctx, executor.Request{
 		Command: req.Command,
@@ -358,6 +415,20 @@ func (es *ExecutorService) ExecuteDispatcher(ctx context.Context, req *executor.
 	}, stdout, stderr, hooks)
 }
 
+// localFallbackWorkDir 返回本地执行时可用的工作目录，相对路径基于 scripts 目录，不存在时使用 scripts 目录
+func localFallbackWorkDir(workDir string) string {
+	if workDir != "" && !filepath.IsAbs(workDir) {
+		workDir = filepath.Join(constant.ScriptsWorkDir, workDir)
+	}
+	if info, err := os.Stat(workDir); workDir == "" || err != nil || !info.IsDir() {
+		workDir = constant.ScriptsWorkDir
+	}
+	if abs, err := filepath.Abs(workDir); err == nil {
+		return abs
+	}
+	return workDir
+}
+
 // getIntSetting 从设置中获取整数值
 func getIntSetting(s SettingsService, section, key string, defaultVal int) int {
 	val := s.Get(section, key)
@@ -373,6 +444,7 @@ func getIntSetting(s SettingsService, section, key string, defaultVal int) int {
 
 // Stop 停止 executor service
 func (es *ExecutorService) Stop() {
+	es.missedMonitor.stop()
 	es.StopCron()
 	es.scheduler.Stop()
 }
@@ -415,8 +487,8 @@ func (es *ExecutorService) loadCronTasks() {
 	tasks := es.taskService.GetTasks()
 	count := 0
 	for _, task := range tasks {
-		// 只调度本地任务（agent_id 为空或 0）
-		if task.Enabled && (task.AgentID == nil || *task.AgentID == 0) {
+		// 只调度由面板调度的任务（本地任务及 any / sticky 模式的 Agent 任务）
+		if task.Enabled && !task.ScheduledByAgent() {
 			err := es.cronManager.AddTask(&task)
 			if err != nil {
 				continue
@@ -465,17 +537,38 @@ func (es *ExecutorService) ExecuteTask(taskID int, extraEnvs []string) *executor
 		envs = append(envs, extraEnvs...)
 	}
 
-	req := &executor.ExecutionRequest{
-		TaskID:  fmt.Sprintf("%d", task.ID),
-		Name:    task.Name,
-		Command: task.Command,
-		WorkDir: task.WorkDir,
-		Envs:    envs,
-		Timeout: task.Timeout,
-		Type:    executor.TaskTypeManual,
+	newRequest := func() *executor.ExecutionRequest {
+		return &executor.ExecutionRequest{
+			TaskID:  fmt.Sprintf("%d", task.ID),
+			Name:    task.Name,
+			Command: task.Command,
+			WorkDir: task.WorkDir,
+			Envs:    envs,
+			Timeout: task.Timeout,
+			Type:    executor.TaskTypeManual,
+		}
 	}
 
-	es.scheduler.EnqueueOrExecute(req)
+	// all 模式：每个匹配的在线 Agent 各执行一次
+	if task.AgentSelector != "" && task.DispatchMode == constant.DispatchAll {
+		agents := es.onlineTaskAgents(task)
+		if len(agents) == 0 {
+			return &executor.ExecutionResult{
+				TaskID:    fmt.Sprintf("%d", task.ID),
+				Success:   false,
+				Error:     fmt.Sprintf("没有匹配 %s 的在线 Agent", task.AgentSelector),
+				StartTime: time.Now(),
+				EndTime:   time.Now(),
+			}
+		}
+		for _, agent := range agents {
+			req := newRequest()
+			req.Metadata = map[string]interface{}{"agent_id": agent.ID, "fanout": true}
+			es.scheduler.EnqueueOrExecute(req)
+		}
+	} else {
+		es.scheduler.EnqueueOrExecute(newRequest())
+	}
 
 	return &executor.ExecutionResult{
 		TaskID:    fmt.Sprintf("%d", task.ID),
@@ -485,6 +578,47 @@ func (es *ExecutorService) ExecuteTask(taskID int, extraEnvs []string) *executor
 	}
 }
 
+// ExecuteTaskOnAgent 由 Agent 命令行发起的手动执行，只在发起的 Agent 上执行，不做故障转移
+func (es *ExecutorService) ExecuteTaskOnAgent(taskID uint, agent *models.Agent) (*executor.ExecutionResult, error) {
+	task := es.taskService.GetTaskByID(int(taskID))
+	if task == nil || !TaskTargetsAgent(task, agent) {
+		return nil, fmt.Errorf("任务 #%d 不存在或未下发给本 Agent", taskID)
+	}
+	if !task.Enabled {
+		return nil, fmt.Errorf("任务 #%d 已禁用", taskID)
+	}
+	if !es.agentWSManager.IsOnline(agent.ID) {
+		return nil, fmt.Errorf("Agent 未连接面板")
+	}
+
+	// all 模式下各 Agent 独立执行，不受单实例限制
+	fanout := task.AgentSelector != "" && task.DispatchMode == constant.DispatchAll
+	if !fanout {
+		if err := es.CheckConcurrency(task.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	es.scheduler.EnqueueOrExecute(&executor.ExecutionRequest{
+		TaskID:   fmt.Sprintf("%d", task.ID),
+		Name:     task.Name,
+		Command:  task.Command,
+		WorkDir:  task.WorkDir,
+		Envs:     es.loadEnvVars(task.Envs),
+		Timeout:  task.Timeout,
+		Type:     executor.TaskTypeManual,
+		Metadata: map[string]interface{}{"agent_id": agent.ID, "fanout": fanout},
+	})
+	logger.Infof("[Executor] Agent %s 请求执行任务 #%d", agent.Name, task.ID)
+
+	return &executor.ExecutionResult{
+		TaskID:    fmt.Sprintf("%d", task.ID),
+		Success:   true,
+		Status:    constant.TaskStatusQueued,
+		StartTime: time.Now(),
+	}, nil
+}
+
 // StopTaskExecution stops a running task execution by LogID
 func (es *ExecutorService) StopTaskExecution(logID uint) error {
 	var taskLog models.TaskLog
@@ -501,10 +635,14 @@ func (es *ExecutorService) StopTaskExecution(logID uint) error {
 		return fmt.Errorf("任务不存在")
 	}
 
-	// 远程任务：发送停止指令到 Agent
-	if task.AgentID != nil && *task.AgentID > 0 {
-		logger.Infof("[Executor] 请求停止远程任务 #%d (Agent #%d, LogID: %d)", task.ID, *task.AgentID, logID)
-		return es.agentWSManager.SendToAgent(*task.AgentID, constant.WSTypeStop, map[string]interface{}{
+	// 远程任务：发送停止指令到执行该日志的 Agent
+	agentID := taskLog.AgentID
+	if agentID == nil && task.AgentSelector == "" {
+		agentID = task.AgentID
+	}
+	if agentID != nil && *agentID > 0 {
+		logger.Infof("[Executor] 请求停止远程任务 #%d (Agent #%d, LogID: %d)", task.ID, *agentID, logID)
+		return es.agentWSManager.SendToAgent(*agentID, constant.WSTypeStop, map[string]interface{}{
 			"log_id": logID,
 		})
 	}
@@ -595,6 +733,11 @@ func (es *ExecutorService) CheckConcurrency(taskID uint) error {
 
 // AddRunningGo 添加当前 goroutine ID 到任务的 running_go 字段
 func (es *ExecutorService) AddRunningGo(taskID uint) (int64, error) {
+	return es.addRunningGo(taskID, true)
+}
+
+// addRunningGo exclusive 为 false 时忽略任务的并发限制
+func (es *ExecutorService) addRunningGo(taskID uint, exclusive bool) (int64, error) {
 	goid := utils.GetGoroutineID()
 	err := database.DB.Transaction(func(tx *gorm.DB) error {
 		var task models.Task
@@ -613,7 +756,7 @@ func (es *ExecutorService) AddRunningGo(taskID uint) (int64, error) {
 		}
 
 		// 如果并发为0(禁用)且已有执行中的任务，返回错误
-		if config.Concurrency == 0 && len(goids) > 0 {
+		if exclusive && config.Concurrency == 0 && len(goids) > 0 {
 			return fmt.Errorf("task is running")
 		}
 
@@ -647,8 +790,7 @@ func (es *ExecutorService) RemoveRunningGo(taskID uint, goid int64) {
 }
 
 // ExecuteRemoteForScheduler 供 Scheduler 调用，执行远程任务并等待结果
-func (es *ExecutorService) ExecuteRemoteForScheduler(task *models.Task, logID uint) (*executor.Result, error) {
-	agentID := *task.AgentID
+func (es *ExecutorService) ExecuteRemoteForScheduler(task *models.Task, agentID, logID uint) (*executor.Result, error) {
 	logger.Infof("[Executor] 远程执行任务 #%d: %s (Agent #%d, LogID: %d)", task.ID, task.Name, agentID, logID)
 
 	// 1. 检查 Agent 状态
@@ -759,6 +901,23 @@ func (es *ExecutorService) BuildRepoCommand(task *models.Task) (string, string)
 	return "python3 " + strings.Join(args, " "), "/opt"
 }
 
+// buildRedactor 根据任务引用的隐藏环境变量、执行期间的其他敏感值及全局规则构建日志脱敏器
+func (es *ExecutorService) buildRedactor(envIDs string, extra ...string) *utils.Redactor {
+	return NewTaskRedactor(es.settingsService, envIDs, extra...)
+}
+
+// NewTaskRedactor 根据环境变量 ID 列表中的隐藏变量、额外的敏感值及全局脱敏规则构建脱敏器
+func NewTaskRedactor(settingsService SettingsService, envIDs string, extra ...string) *utils.Redactor {
+	secrets := append([]string(nil), extra...)
+	if envIDs != "" {
+		database.DB.Model(&models.EnvironmentVariable{}).
+			Where("id IN ? AND hidden = ?", strings.Split(envIDs, ","), true).
+			Pluck("value", &secrets)
+	}
+	rules := utils.ParseRedactionRules(settingsService.Get(constant.SectionRedaction, constant.KeyRedactionRules))
+	return utils.NewRedactor(secrets, rules)
+}
+
 // loadEnvVars 加载环境变量
 func (es *ExecutorService) loadEnvVars(envIDs string) []string {
 	if envIDs == "" {
@@ -0,0 +1,190 @@
+package tasks
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/engigu/baihu-panel/internal/constant"
+	"github.com/engigu/baihu-panel/internal/database"
+	"github.com/engigu/baihu-panel/internal/logger"
+	"github.com/engigu/baihu-panel/internal/models"
+	"github.com/engigu/baihu-panel/internal/services/notify"
+	"github.com/engigu/baihu-panel/internal/systime"
+)
+
+// healthSettings 读取健康阈值的设置服务，未设置时使用默认阈值
+var healthSettings SettingsService
+
+// SetHealthSettings 注入设置服务
+func SetHealthSettings(s SettingsService) {
+	healthSettings = s
+}
+
+// HealthThresholds 健康状态判定阈值
+type HealthThresholds struct {
+	FailStreak   int // 连续失败次数阈值
+	WindowHours  int // 失败率统计窗口（小时）
+	FailRate     int // failing 失败率阈值（%）
+	DegradedRate int // degraded 失败率阈值（%）
+	MinRuns      int // 按失败率判定所需的最少执行次数
+}
+
+// LoadHealthThresholds 从设置中读取健康阈值
+func LoadHealthThresholds() HealthThresholds {
+	get := func(key string) int {
+		def := 0
+		fmt.Sscanf(constant.DefaultSettings[constant.SectionHealth][key], "%d", &def)
+		if healthSettings == nil {
+			return def
+		}
+		return getIntSetting(healthSettings, constant.SectionHealth, key, def)
+	}
+	th := HealthThresholds{
+		FailStreak:   get(constant.KeyHealthFailStreak),
+		WindowHours:  get(constant.KeyHealthWindow),
+		FailRate:     get(constant.KeyHealthFailRate),
+		DegradedRate: get(constant.KeyHealthDegradedRate),
+		MinRuns:      get(constant.KeyHealthMinRuns),
+	}
+	if th.WindowHours <= 0 {
+		th.WindowHours = 24
+	}
+	return th
+}
+
+// isFailureStatus 判断执行状态是否计为失败
+func isFailureStatus(status string) bool {
+	return status == constant.TaskStatusFailed || status == constant.TaskStatusTimeout
+}
+
+// completedStatuses 计入健康统计的执行状态
+var completedStatuses = []string{
+	constant.TaskStatusSuccess, constant.TaskStatusWarning,
+	constant.TaskStatusFailed, constant.TaskStatusTimeout,
+}
+
+// EvaluateTaskHealth 根据最近的执行记录计算任务健康状态
+// 连续失败次数取自 TaskLog；失败率优先取窗口内的 TaskLog，日志已被清理导致次数不足时使用 SendStats 按天估算
+func EvaluateTaskHealth(taskID uint, th HealthThresholds) (health, reason string) {
+	streak := failureStreak(taskID, th.FailStreak)
+	failed, total := failureRate(taskID, th.WindowHours, th.MinRuns)
+
+	rate := 0
+	if total > 0 {
+		rate = failed * 100 / total
+	}
+	rateValid := total > 0 && total >= th.MinRuns
+
+	switch {
+	case th.FailStreak > 0 && streak >= th.FailStreak:
+		return constant.TaskHealthFailing, fmt.Sprintf("连续失败 %d 次", streak)
+	case th.FailRate > 0 && rateValid && rate >= th.FailRate:
+		return constant.TaskHealthFailing, fmt.Sprintf("%d 小时内失败率 %d%% (%d/%d)", th.WindowHours, rate, failed, total)
+	case streak > 0:
+		return constant.TaskHealthDegraded, fmt.Sprintf("最近连续失败 %d 次", streak)
+	case th.DegradedRate > 0 && rateValid && rate >= th.DegradedRate:
+		return constant.TaskHealthDegraded, fmt.Sprintf("%d 小时内失败率 %d%% (%d/%d)", th.WindowHours, rate, failed, total)
+	}
+	return constant.TaskHealthHealthy, ""
+}
+
+// failureStreak 统计从最近一次执行开始的连续失败次数
+func failureStreak(taskID uint, limit int) int {
+	if limit < 1 {
+		limit = 1
+	}
+	var logs []models.TaskLog
+	database.DB.Select("id", "status").
+		Where("task_id = ? AND status IN ?", taskID, completedStatuses).
+		Order("id DESC").Limit(limit).Find(&logs)
+
+	streak := 0
+	for _, l := range logs {
+		if !isFailureStatus(l.Status) {
+			break
+		}
+		streak++
+	}
+	return streak
+}
+
+// failureRate 统计窗口内的失败次数和总执行次数
+func failureRate(taskID uint, windowHours, minRuns int) (failed, total int) {
+	since := time.Now().Add(-time.Duration(windowHours) * time.Hour)
+
+	type row struct {
+		Status string
+		Num    int
+	}
+	var rows []row
+	database.DB.Model(&models.TaskLog{}).Select("status, COUNT(*) AS num").
+		Where("task_id = ? AND status IN ? AND created_at >= ?", taskID, completedStatuses, since).
+		Group("status").Scan(&rows)
+	for _, r := range rows {
+		total += r.Num
+		if isFailureStatus(r.Status) {
+			failed += r.Num
+		}
+	}
+	if total >= minRuns {
+		return failed, total
+	}
+
+	// 日志不足时使用按天统计的 SendStats
+	var stats []models.SendStats
+	database.DB.Where("task_id = ? AND day >= ? AND status IN ?", taskID, systime.FormatDate(since), completedStatuses).Find(&stats)
+	sf, st := 0, 0
+	for _, s := range stats {
+		st += s.Num
+		if isFailureStatus(s.Status) {
+			sf += s.Num
+		}
+	}
+	if st > total {
+		return sf, st
+	}
+	return failed, total
+}
+
+// UpdateTaskHealth 重新计算任务健康状态，状态变化时保存并发送通知（同一变化只通知一次）
+func UpdateTaskHealth(taskLog *models.TaskLog) {
+	var task models.Task
+	if err := database.DB.Select("id", "name", "health", "health_reason").First(&task, taskLog.TaskID).Error; err != nil {
+		return
+	}
+
+	health, reason := EvaluateTaskHealth(task.ID, LoadHealthThresholds())
+	prev := task.Health
+	if prev == "" {
+		prev = constant.TaskHealthHealthy
+	}
+	if health == prev && reason == task.HealthReason {
+		return
+	}
+
+	// 以读取到的状态为条件更新，并发完成的多次执行中只有一次能完成同一状态变化并发送通知
+	result := database.DB.Model(&models.Task{}).Where("id = ? AND health = ?", task.ID, task.Health).Updates(map[string]interface{}{
+		"health":        health,
+		"health_reason": reason,
+	})
+	if result.Error != nil || result.RowsAffected != 1 || health == prev {
+		return
+	}
+
+	logger.Infof("[Health] 任务 #%d %s 健康状态变化: %s -> %s %s", task.ID, task.Name, prev, health, reason)
+	notify.GetNotifyService().NotifyHealthChange(taskLog, prev, health, reason)
+}
+
+// RefreshAllTaskHealth 按当前阈值重新计算所有任务的健康状态（阈值修改后调用，不发送通知）
+func RefreshAllTaskHealth() {
+	th := LoadHealthThresholds()
+	var tasks []models.Task
+	database.DB.Select("id").Find(&tasks)
+	for _, t := range tasks {
+		health, reason := EvaluateTaskHealth(t.ID, th)
+		database.DB.Model(&models.Task{}).Where("id = ?", t.ID).Updates(map[string]interface{}{
+			"health":        health,
+			"health_reason": reason,
+		})
+	}
+}
@@ -0,0 +1,293 @@
+package tasks
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/engigu/baihu-panel/internal/constant"
+	"github.com/engigu/baihu-panel/internal/database"
+	"github.com/engigu/baihu-panel/internal/executor"
+	"github.com/engigu/baihu-panel/internal/logger"
+	"github.com/engigu/baihu-panel/internal/models"
+	"github.com/engigu/baihu-panel/internal/services/notify"
+	"github.com/engigu/baihu-panel/internal/systime"
+)
+
+const (
+	missedCheckInterval  = time.Minute      // 检测周期
+	missedMaxExpected    = 100              // 单次检测每个任务最多检查的预期执行次数
+	missedEarlyTolerance = 5 * time.Second  // 执行记录早于预期时间的容差
+	missedAgentTimeout   = 60 * time.Minute // Agent 任务未设置超时时等待结果上报的时长
+	missedKeepDays       = 30               // 漏执行记录保留天数
+)
+
+// missedState 单个任务的检测进度
+type missedState struct {
+	key     string    // 调度表达式与执行位置，变化后重新开始检测
+	cursor  time.Time // 已检测到的预期执行时间
+	missing bool      // 是否处于连续漏执行状态（仅首次漏执行时通知）
+}
+
+// missedRunMonitor 根据任务的调度表达式检测应执行但未产生执行记录的情况
+type missedRunMonitor struct {
+	es        *ExecutorService
+	states    map[uint]*missedState
+	lastPrune time.Time
+	stopCh    chan struct{}
+	startOnce sync.Once
+	stopOnce  sync.Once
+}
+
+func newMissedRunMonitor(es *ExecutorService) *missedRunMonitor {
+	return &missedRunMonitor{
+		es:     es,
+		states: make(map[uint]*missedState),
+		stopCh: make(chan struct{}),
+	}
+}
+
+// StartMissedRunMonitor 启动漏执行检测
+func (es *ExecutorService) StartMissedRunMonitor() {
+	es.missedMonitor.startOnce.Do(func() {
+		go es.missedMonitor.loop()
+	})
+}
+
+func (m *missedRunMonitor) stop() {
+	m.stopOnce.Do(func() {
+		close(m.stopCh)
+	})
+}
+
+func (m *missedRunMonitor) loop() {
+	ticker := time.NewTicker(missedCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					logger.Errorf("[MissedRun] 检测过程中发生 Panic: %v", r)
+				}
+			}()
+			m.check(time.Now())
+		}()
+	}
+}
+
+// missedGrace 读取宽限时间，0 表示不检测
+func missedGrace() time.Duration {
+	def := 5
+	fmt.Sscanf(constant.DefaultSettings[constant.SectionHealth][constant.KeyHealthMissedGrace], "%d", &def)
+	if healthSettings != nil {
+		def = getIntSetting(healthSettings, constant.SectionHealth, constant.KeyHealthMissedGrace, def)
+	}
+	if def <= 0 {
+		return 0
+	}
+	return time.Duration(def) * time.Minute
+}
+
+// check 检测所有启用的计划任务
+func (m *missedRunMonitor) check(now time.Time) {
+	m.prune(now)
+
+	grace := missedGrace()
+	if grace <= 0 {
+		m.states = make(map[uint]*missedState)
+		return
+	}
+
+	var list []models.Task
+	database.DB.Select("id", "name", "schedule", "timeout", "agent_id", "agent_selector", "dispatch_mode", "fallback_agents", "fallback_local").
+		Where("enabled = ? AND schedule <> ''", true).Find(&list)
+
+	seen := make(map[uint]bool, len(list))
+	for i := range list {
+		seen[list[i].ID] = true
+		m.checkTask(&list[i], now, grace)
+	}
+	// 已禁用或删除的任务不再跟踪，重新启用后从启用时刻开始检测
+	for id := range m.states {
+		if !seen[id] {
+			delete(m.states, id)
+		}
+	}
+}
+
+// checkTask 检测单个任务在上次检测之后的预期执行时间是否都有执行记录
+func (m *missedRunMonitor) checkTask(task *models.Task, now time.Time, grace time.Duration) {
+	// any / sticky 模式及配置了故障转移的任务由面板调度，与本地任务一样在开始执行时创建日志
+	remote := task.ScheduledByAgent()
+	key := task.Schedule
+	if task.IsRemote() {
+		var agentID uint
+		if task.AgentID != nil {
+			agentID = *task.AgentID
+		}
+		key = fmt.Sprintf("%s@%d/%s/%s/%s/%t", task.Schedule, agentID, task.AgentSelector, task.DispatchMode,
+			task.FallbackAgents, task.FallbackLocal)
+	}
+
+	st, ok := m.states[task.ID]
+	if !ok || st.key != key {
+		m.states[task.ID] = &missedState{key: key, cursor: now}
+		return
+	}
+
+	sched, err := executor.ParseSchedule(task.Schedule)
+	if err != nil {
+		return
+	}
+
+	// 本地任务在开始执行时创建日志，Agent 任务在执行结束上报后才创建日志
+	wait, early := grace, missedEarlyTolerance
+	if remote {
+		timeout := time.Duration(task.Timeout) * time.Minute
+		if timeout <= 0 {
+			timeout = missedAgentTimeout
+		}
+		wait += timeout
+		early = grace // 允许 Agent 与面板存在时钟偏差
+	}
+	deadline := now.Add(-wait)
+
+	var expected []time.Time
+	for t := sched.Next(st.cursor.In(executor.Location())); !t.IsZero() && !t.After(deadline); t = sched.Next(t) {
+		expected = append(expected, t)
+		if len(expected) > missedMaxExpected {
+			expected = expected[1:]
+		}
+	}
+	if len(expected) == 0 {
+		return
+	}
+	st.cursor = expected[len(expected)-1]
+
+	runs := m.runTimes(task.ID, expected[0].Add(-early), st.cursor.Add(wait))
+
+	var missed []time.Time
+	startStreak := false
+	for _, t := range expected {
+		if hasRunBetween(runs, t.Add(-early), t.Add(wait)) {
+			st.missing = false
+			continue
+		}
+		missed = append(missed, t)
+		if !st.missing {
+			st.missing = true
+			startStreak = true
+		}
+	}
+	if len(missed) == 0 {
+		return
+	}
+
+	reason := m.probableCause(task, missed[0])
+	record := &models.MissedRun{
+		TaskID:     task.ID,
+		ExpectedAt: models.LocalTime(missed[0]),
+		Count:      len(missed),
+		Reason:     reason,
+	}
+	database.DB.Create(record)
+	logger.Warnf("[MissedRun] 任务 #%d %s 未按时执行 %d 次 (预期 %s): %s",
+		task.ID, task.Name, len(missed), systime.FormatTime(missed[0]), reason)
+
+	if startStreak {
+		notify.GetNotifyService().NotifyMissedRun(task.ID, missed[0], reason)
+	}
+}
+
+// runTimes 获取时间范围内的执行记录时间（开始时间和创建时间）
+func (m *missedRunMonitor) runTimes(taskID uint, from, to time.Time) []time.Time {
+	var logs []models.TaskLog
+	database.DB.Select("start_time", "created_at").
+		Where("task_id = ? AND ((start_time >= ? AND start_time <= ?) OR (created_at >= ? AND created_at <= ?))",
+			taskID, from, to, from, to).
+		Find(&logs)
+
+	times := make([]time.Time, 0, len(logs)*2)
+	for _, l := range logs {
+		if l.StartTime != nil {
+			times = append(times, l.StartTime.Time())
+		}
+		times = append(times, l.CreatedAt.Time())
+	}
+	return times
+}
+
+func hasRunBetween(runs []time.Time, from, to time.Time) bool {
+	for _, t := range runs {
+		if !t.Before(from) && !t.After(to) {
+			return true
+		}
+	}
+	return false
+}
+
+// probableCause 推断任务未执行的可能原因
+func (m *missedRunMonitor) probableCause(task *models.Task, expected time.Time) string {
+	if task.AgentSelector != "" && task.ScheduledByAgent() {
+		agents := MatchTaskAgents(task)
+		if len(agents) == 0 {
+			return fmt.Sprintf("没有匹配 %s 的已启用 Agent", task.AgentSelector)
+		}
+		for _, agent := range agents {
+			if agent.Status == constant.AgentStatusOnline {
+				return fmt.Sprintf("匹配的 Agent 在线但均未上报执行结果（共 %d 个）", len(agents))
+			}
+		}
+		return fmt.Sprintf("匹配 %s 的 %d 个 Agent 均离线", task.AgentSelector, len(agents))
+	}
+	if task.ScheduledByAgent() && task.AgentID != nil && *task.AgentID > 0 {
+		var agent models.Agent
+		if err := database.DB.Unscoped().First(&agent, *task.AgentID).Error; err != nil {
+			return fmt.Sprintf("Agent #%d 不存在", *task.AgentID)
+		}
+		switch {
+		case agent.DeletedAt.Valid:
+			return fmt.Sprintf("Agent %s 已删除", agent.Name)
+		case !agent.Enabled:
+			return fmt.Sprintf("Agent %s 已禁用", agent.Name)
+		case agent.Status != constant.AgentStatusOnline:
+			lastSeen := "无"
+			if agent.LastSeen != nil {
+				lastSeen = systime.FormatTime(agent.LastSeen.Time())
+			}
+			return fmt.Sprintf("Agent %s 离线（最后心跳 %s）", agent.Name, lastSeen)
+		case agent.LastSeen != nil && agent.LastSeen.Time().Before(expected):
+			return fmt.Sprintf("Agent %s 在预期执行时间后未发送心跳", agent.Name)
+		}
+		return fmt.Sprintf("Agent %s 在线但未上报执行结果（任务未同步或执行结果丢失）", agent.Name)
+	}
+
+	id := task.GetID()
+	if _, ok := m.es.cronManager.GetEntry(id); !ok {
+		return "任务未在调度器中注册（调度条目丢失）"
+	}
+	scheduler := m.es.scheduler
+	if n := scheduler.QueuedCount(id); n > 0 {
+		return fmt.Sprintf("任务仍在队列中等待执行（本任务 %d 个，队列 %d/%d）", n, scheduler.GetQueueSize(), scheduler.QueueCapacity())
+	}
+	if last, ok := m.es.cronManager.LastFired(id); ok && !last.Before(expected.Add(-missedEarlyTolerance)) {
+		return "调度已触发但未生成执行记录（执行前处理失败）"
+	}
+	return "调度器未按时触发（可能是系统时间跳变或调度器阻塞）"
+}
+
+// prune 定期清理过期的漏执行记录
+func (m *missedRunMonitor) prune(now time.Time) {
+	if now.Sub(m.lastPrune) < time.Hour {
+		return
+	}
+	m.lastPrune = now
+	database.DB.Where("created_at < ?", now.AddDate(0, 0, -missedKeepDays)).Delete(&models.MissedRun{})
+}
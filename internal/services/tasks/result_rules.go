@@ -0,0 +1,148 @@
+package tasks
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/engigu/baihu-panel/internal/constant"
+	"github.com/engigu/baihu-panel/internal/logger"
+	"github.com/engigu/baihu-panel/internal/models"
+)
+
+// maxRuleLineLen 判定原因中引用的日志行最大长度
+const maxRuleLineLen = 200
+
+// ParseResultRules 从任务配置中解析结果判定规则
+func ParseResultRules(config string) models.ResultRules {
+	var cfg models.TaskConfig
+	if config != "" {
+		_ = json.Unmarshal([]byte(config), &cfg)
+	}
+	return cfg.ResultRules
+}
+
+// ValidateResultRules 检查规则中的正则表达式是否有效
+func ValidateResultRules(rules models.ResultRules) error {
+	for _, group := range [][]string{rules.Fail, rules.Warn, rules.Require} {
+		for _, pattern := range group {
+			if _, err := regexp.Compile(pattern); err != nil {
+				return fmt.Errorf("无效的正则规则 %q: %v", pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// CheckResultRules 逐行扫描日志内容并按规则判定结果
+// 返回判定后的状态及原因，未命中任何规则时 status 为空
+func CheckResultRules(rules models.ResultRules, r io.Reader) (status string, reason string) {
+	if rules.Empty() {
+		return "", ""
+	}
+
+	fail := compileRules(rules.Fail)
+	warn := compileRules(rules.Warn)
+	require := compileRules(rules.Require)
+	found := make([]bool, len(require))
+
+	var failReason, warnReason string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		// 带标记格式的日志仅匹配正文部分
+		if _, _, body, ok := splitTaggedLine(line); ok {
+			line = body
+		}
+
+		if failReason == "" {
+			if re := matchAny(fail, line); re != nil {
+				failReason = fmt.Sprintf("日志匹配失败规则 %s: %s", re.String(), truncateRuleLine(line))
+			}
+		}
+		if warnReason == "" {
+			if re := matchAny(warn, line); re != nil {
+				warnReason = fmt.Sprintf("日志匹配警告规则 %s: %s", re.String(), truncateRuleLine(line))
+			}
+		}
+		for i, re := range require {
+			if !found[i] && re.MatchString(line) {
+				found[i] = true
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		logger.Warnf("[ResultRules] 扫描日志失败: %v", err)
+	}
+
+	if failReason != "" {
+		return constant.TaskStatusFailed, failReason
+	}
+	missing := make([]string, 0)
+	for i, ok := range found {
+		if !ok {
+			missing = append(missing, require[i].String())
+		}
+	}
+	if len(missing) > 0 {
+		return constant.TaskStatusFailed, "日志缺少必需内容: " + strings.Join(missing, ", ")
+	}
+	if warnReason != "" {
+		return constant.TaskStatusWarning, warnReason
+	}
+	return "", ""
+}
+
+// ApplyResultRules 对退出码判定为成功的结果应用日志规则，并将原因追加到错误信息
+func ApplyResultRules(rules models.ResultRules, r io.Reader, status, errMsg *string) {
+	if *status != constant.TaskStatusSuccess {
+		return
+	}
+	newStatus, reason := CheckResultRules(rules, r)
+	if newStatus == "" {
+		return
+	}
+	*status = newStatus
+	if *errMsg != "" {
+		*errMsg += "\n"
+	}
+	*errMsg += reason
+}
+
+func compileRules(patterns []string) []*regexp.Regexp {
+	result := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		if p == "" {
+			continue
+		}
+		re, err := regexp.Compile(p)
+		if err != nil {
+			logger.Warnf("[ResultRules] 忽略无效规则 %q: %v", p, err)
+			continue
+		}
+		result = append(result, re)
+	}
+	return result
+}
+
+func matchAny(patterns []*regexp.Regexp, line string) *regexp.Regexp {
+	for _, re := range patterns {
+		if re.MatchString(line) {
+			return re
+		}
+	}
+	return nil
+}
+
+func truncateRuleLine(line string) string {
+	line = strings.TrimSpace(line)
+	runes := []rune(line)
+	if len(runes) > maxRuleLineLen {
+		return string(runes[:maxRuleLineLen]) + "..."
+	}
+	return line
+}
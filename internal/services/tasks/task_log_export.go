@@ -0,0 +1,261 @@
+package tasks
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/engigu/baihu-panel/internal/database"
+	"github.com/engigu/baihu-panel/internal/models"
+	"github.com/engigu/baihu-panel/internal/systime"
+	"github.com/engigu/baihu-panel/internal/utils"
+
+	"gorm.io/gorm"
+)
+
+// 导出格式
+const (
+	ExportFormatNDJSON = "ndjson"
+	ExportFormatCSV    = "csv"
+	ExportFormatTarGz  = "tar.gz"
+)
+
+// exportBatchSize 每批读取的日志条数
+const exportBatchSize = 200
+
+// ExportFilter 日志导出过滤条件
+type ExportFilter struct {
+	TaskID  uint
+	AgentID *uint // 为 0 时表示仅导出本地执行的日志
+	Status  string
+	Start   *time.Time
+	End     *time.Time
+}
+
+// ExportRecord 导出的日志元数据
+type ExportRecord struct {
+	ID        uint   `json:"id"`
+	TaskID    uint   `json:"task_id"`
+	TaskName  string `json:"task_name"`
+	AgentID   *uint  `json:"agent_id"`
+	Command   string `json:"command"`
+	Status    string `json:"status"`
+	Error     string `json:"error"`
+	ExitCode  int    `json:"exit_code"`
+	Duration  int64  `json:"duration"`
+	StartTime string `json:"start_time"`
+	EndTime   string `json:"end_time"`
+	CreatedAt string `json:"created_at"`
+}
+
+var exportCSVHeader = []string{"id", "task_id", "task_name", "agent_id", "command", "status", "error", "exit_code", "duration", "start_time", "end_time", "created_at"}
+
+func (r *ExportRecord) csvRow() []string {
+	agentID := ""
+	if r.AgentID != nil {
+		agentID = strconv.FormatUint(uint64(*r.AgentID), 10)
+	}
+	return []string{
+		strconv.FormatUint(uint64(r.ID), 10),
+		strconv.FormatUint(uint64(r.TaskID), 10),
+		r.TaskName,
+		agentID,
+		r.Command,
+		r.Status,
+		r.Error,
+		strconv.Itoa(r.ExitCode),
+		strconv.FormatInt(r.Duration, 10),
+		r.StartTime,
+		r.EndTime,
+		r.CreatedAt,
+	}
+}
+
+// IsValidExportFormat 检查导出格式是否受支持
+func IsValidExportFormat(format string) bool {
+	return format == ExportFormatNDJSON || format == ExportFormatCSV || format == ExportFormatTarGz
+}
+
+// ExportLogs 按条件流式导出任务日志
+// ndjson/csv 仅导出元数据；tar.gz 包含 logs.ndjson 元数据及 outputs/<id>.log 解压后的输出
+func (s *TaskLogService) ExportLogs(w io.Writer, filter ExportFilter, format string) error {
+	switch format {
+	case ExportFormatNDJSON:
+		enc := json.NewEncoder(w)
+		return s.eachExportRecord(filter, false, func(rec *ExportRecord, _ *models.TaskLog) error {
+			return enc.Encode(rec)
+		})
+	case ExportFormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(exportCSVHeader); err != nil {
+			return err
+		}
+		err := s.eachExportRecord(filter, false, func(rec *ExportRecord, _ *models.TaskLog) error {
+			return cw.Write(rec.csvRow())
+		})
+		cw.Flush()
+		if err != nil {
+			return err
+		}
+		return cw.Error()
+	case ExportFormatTarGz:
+		return s.exportTarGz(w, filter)
+	}
+	return fmt.Errorf("不支持的导出格式: %s", format)
+}
+
+// exportTarGz 输出按日志逐条写入归档，元数据先写入临时文件，最后追加到归档末尾
+func (s *TaskLogService) exportTarGz(w io.Writer, filter ExportFilter) error {
+	meta, err := os.CreateTemp("", "task_log_export_*.ndjson")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		meta.Close()
+		os.Remove(meta.Name())
+	}()
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	enc := json.NewEncoder(meta)
+	now := time.Now()
+
+	err = s.eachExportRecord(filter, true, func(rec *ExportRecord, log *models.TaskLog) error {
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+		content, err := utils.DecompressFromBase64(log.Output)
+		if err != nil {
+			content = "[System Error] 解压日志失败: " + err.Error()
+		}
+		header := &tar.Header{
+			Name:    fmt.Sprintf("outputs/%d.log", log.ID),
+			Mode:    0644,
+			Size:    int64(len(content)),
+			ModTime: now,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err = io.WriteString(tw, content)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	stat, err := meta.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := meta.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: "logs.ndjson", Mode: 0644, Size: stat.Size(), ModTime: now}); err != nil {
+		return err
+	}
+	if _, err := io.Copy(tw, meta); err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// eachExportRecord 分批遍历满足条件的日志，避免一次性加载到内存
+func (s *TaskLogService) eachExportRecord(filter ExportFilter, withOutput bool, fn func(rec *ExportRecord, log *models.TaskLog) error) error {
+	taskNames := make(map[uint]string)
+	columns := []string{"id", "task_id", "agent_id", "command", "error", "status", "duration", "exit_code", "start_time", "end_time", "created_at"}
+	if withOutput {
+		columns = append(columns, "output")
+	}
+
+	var lastID uint
+	for {
+		var logs []models.TaskLog
+		query := applyExportFilter(database.DB.Model(&models.TaskLog{}), filter).
+			Select(columns).Where("id > ?", lastID).Order("id ASC").Limit(exportBatchSize)
+		if err := query.Find(&logs).Error; err != nil {
+			return err
+		}
+		if len(logs) == 0 {
+			return nil
+		}
+
+		// 补充本批次中未缓存的任务名称
+		missing := make([]uint, 0)
+		for _, l := range logs {
+			if _, ok := taskNames[l.TaskID]; !ok {
+				missing = append(missing, l.TaskID)
+				taskNames[l.TaskID] = ""
+			}
+		}
+		if len(missing) > 0 {
+			var tasks []models.Task
+			database.DB.Unscoped().Select("id", "name").Where("id IN ?", missing).Find(&tasks)
+			for _, t := range tasks {
+				taskNames[t.ID] = t.Name
+			}
+		}
+
+		for i := range logs {
+			l := &logs[i]
+			rec := &ExportRecord{
+				ID:        l.ID,
+				TaskID:    l.TaskID,
+				TaskName:  taskNames[l.TaskID],
+				AgentID:   l.AgentID,
+				Command:   l.Command,
+				Status:    l.Status,
+				Error:     l.Error,
+				ExitCode:  l.ExitCode,
+				Duration:  l.Duration,
+				StartTime: formatExportTime(l.StartTime),
+				EndTime:   formatExportTime(l.EndTime),
+				CreatedAt: formatExportTime(&l.CreatedAt),
+			}
+			if err := fn(rec, l); err != nil {
+				return err
+			}
+		}
+		lastID = logs[len(logs)-1].ID
+	}
+}
+
+func applyExportFilter(query *gorm.DB, filter ExportFilter) *gorm.DB {
+	if filter.TaskID > 0 {
+		query = query.Where("task_id = ?", filter.TaskID)
+	}
+	if filter.AgentID != nil {
+		if *filter.AgentID == 0 {
+			query = query.Where("agent_id IS NULL OR agent_id = 0")
+		} else {
+			query = query.Where("agent_id = ?", *filter.AgentID)
+		}
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.Start != nil {
+		query = query.Where("created_at >= ?", *filter.Start)
+	}
+	if filter.End != nil {
+		query = query.Where("created_at <= ?", *filter.End)
+	}
+	return query
+}
+
+func formatExportTime(t *models.LocalTime) string {
+	if t == nil || t.Time().IsZero() {
+		return ""
+	}
+	return systime.FormatTime(t.Time())
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/engigu/baihu-panel/internal/database"
 	"github.com/engigu/baihu-panel/internal/logger"
 	"github.com/engigu/baihu-panel/internal/models"
+	"github.com/engigu/baihu-panel/internal/services/notify"
 	"github.com/engigu/baihu-panel/internal/systime"
 	"github.com/engigu/baihu-panel/internal/utils"
 )
@@ -140,6 +141,12 @@ func (s *TaskLogService) ProcessTaskCompletion(taskLog *models.TaskLog) error {
 	// 3. 异步清理旧日志
 	go s.CleanTaskLogs(taskLog.TaskID)
 
+	// 4. 按通知规则发送通知
+	notify.GetNotifyService().NotifyTaskLog(taskLog)
+
+	// 5. 更新健康状态（仅在状态变化时通知）
+	UpdateTaskHealth(taskLog)
+
 	return nil
 }
 
@@ -155,6 +162,7 @@ func (s *TaskLogService) CreateTaskLogFromAgentResult(result *models.AgentTaskRe
 	taskLog := &models.TaskLog{
 		TaskID:   result.TaskID,
 		AgentID:  &result.AgentID,
+		RunID:    result.RunID,
 		Command:  result.Command,
 		Output:   compressed,
 		Error:    result.Error,
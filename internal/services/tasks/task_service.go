@@ -3,6 +3,7 @@ package tasks
 import (
 	"github.com/engigu/baihu-panel/internal/database"
 	"github.com/engigu/baihu-panel/internal/models"
+	"github.com/engigu/baihu-panel/internal/services/notify"
 )
 
 type TaskService struct{}
@@ -11,22 +12,26 @@ func NewTaskService() *TaskService {
 	return &TaskService{}
 }
 
-func (ts *TaskService) CreateTask(name, command, schedule string, timeout int, workDir, cleanConfig, envs, taskType, config string, agentID *uint) *models.Task {
+func (ts *TaskService) CreateTask(name, command, schedule string, timeout int, workDir, cleanConfig, envs, taskType, config string, agentID *uint, agentSelector, dispatchMode, fallbackAgents string, fallbackLocal bool) *models.Task {
 	if taskType == "" {
 		taskType = "task"
 	}
 	task := &models.Task{
-		Name:        name,
-		Command:     command,
-		Type:        taskType,
-		Config:      config,
-		Schedule:    schedule,
-		Timeout:     timeout,
-		WorkDir:     workDir,
-		CleanConfig: cleanConfig,
-		Envs:        envs,
-		AgentID:     agentID,
-		Enabled:     true,
+		Name:           name,
+		Command:        command,
+		Type:           taskType,
+		Config:         config,
+		Schedule:       schedule,
+		Timeout:        timeout,
+		WorkDir:        workDir,
+		CleanConfig:    cleanConfig,
+		Envs:           envs,
+		AgentID:        agentID,
+		AgentSelector:  agentSelector,
+		DispatchMode:   dispatchMode,
+		FallbackAgents: fallbackAgents,
+		FallbackLocal:  fallbackLocal,
+		Enabled:        true,
 	}
 	database.DB.Create(task)
 	return task
@@ -65,7 +70,7 @@ func (ts *TaskService) GetTaskByID(id int) *models.Task {
 	return &task
 }
 
-func (ts *TaskService) UpdateTask(id int, name, command, schedule string, timeout int, workDir, cleanConfig, envs string, enabled bool, taskType, config string, agentID *uint) *models.Task {
+func (ts *TaskService) UpdateTask(id int, name, command, schedule string, timeout int, workDir, cleanConfig, envs string, enabled bool, taskType, config string, agentID *uint, agentSelector, dispatchMode, fallbackAgents string, fallbackLocal bool) *models.Task {
 	var task models.Task
 	if err := database.DB.First(&task, id).Error; err != nil {
 		return nil
@@ -79,6 +84,10 @@ func (ts *TaskService) UpdateTask(id int, name, command, schedule string, timeou
 	task.Envs = envs
 	task.Enabled = enabled
 	task.AgentID = agentID
+	task.AgentSelector = agentSelector
+	task.DispatchMode = dispatchMode
+	task.FallbackAgents = fallbackAgents
+	task.FallbackLocal = fallbackLocal
 	if taskType != "" {
 		task.Type = taskType
 	}
@@ -89,5 +98,9 @@ func (ts *TaskService) UpdateTask(id int, name, command, schedule string, timeou
 
 func (ts *TaskService) DeleteTask(id int) bool {
 	result := database.DB.Delete(&models.Task{}, id)
+	if result.RowsAffected > 0 {
+		notify.DeleteTaskRules(uint(id))
+		database.DB.Where("task_id = ?", id).Delete(&models.MissedRun{})
+	}
 	return result.RowsAffected > 0
 }
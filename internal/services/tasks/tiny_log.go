@@ -8,6 +8,7 @@ import (
 	"io"
 	"os"
 	"sync"
+	"time"
 	"unicode/utf8"
 
 	"github.com/engigu/baihu-panel/internal/utils"
@@ -58,6 +59,17 @@ type TinyLog struct {
 	subscribers []chan []byte
 	remainder   []byte // Leftover bytes from previous write (partial multi-byte characters)
 	closed      bool
+	tagged      bool                     // 是否为带时间戳和输出流标记的格式
+	pending     map[byte]*tinyLogPending // 各输出流尚未遇到换行的内容
+	redactor    *utils.Redactor          // 敏感信息脱敏器，为空时不处理
+	redact      *utils.RedactStream      // 原始格式下的流式脱敏状态
+	idleTimer   *time.Timer              // 脱敏缓存中未结束的行等待输出的定时器
+}
+
+// tinyLogPending 某个输出流中尚未结束的一行
+type tinyLogPending struct {
+	buf []byte
+	at  time.Time // 该行首个字节到达的时间
 }
 
 // NewTinyLog 创建一个新的 TinyLog 实例（基于临时文件存储）并注册它
@@ -116,28 +128,65 @@ func (l *TinyLog) Write(p []byte) (n int, err error) {
 		return originalInputLen, nil
 	}
 
-	// 3. 仅将完整的部分转换为 UTF-8
+	// 3. 仅将完整的部分转换为 UTF-8，并进行脱敏
 	text := utils.ToUTF8(payload[:lastSafe])
+	if l.redact != nil {
+		text = l.redact.Push(text)
+		if l.redact.Pending() && l.idleTimer == nil {
+			l.idleTimer = time.AfterFunc(utils.RedactIdleDelay, l.flushIdle)
+		}
+		if text == "" {
+			return originalInputLen, nil
+		}
+	}
 	data := []byte(text)
 
-	// 4. 写入文件缓冲区
-	_, err = l.writer.Write(data)
-	if err != nil {
+	// 4. 写入文件缓冲区并广播给订阅者
+	if err := l.emitLocked(data); err != nil {
 		return 0, err
 	}
 
-	// 5. 广播给所有订阅者
-	if len(l.subscribers) > 0 {
-		for _, ch := range l.subscribers {
-			select {
-			case ch <- data:
-			default:
-				// 如果订阅者处理太慢，丢弃消息以避免阻塞写入
-			}
+	return originalInputLen, nil
+}
+
+// flushIdle 输出脱敏缓存中长时间未结束的行，避免进度条和输入提示迟迟不显示
+func (l *TinyLog) flushIdle() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.idleTimer = nil
+	if l.closed {
+		return
+	}
+	if text := l.redact.Idle(); text != "" {
+		_ = l.emitLocked([]byte(text))
+	}
+}
+
+// emitLocked 写入文件缓冲区并广播给所有订阅者，调用方需持有写锁
+func (l *TinyLog) emitLocked(data []byte) error {
+	if _, err := l.writer.Write(data); err != nil {
+		return err
+	}
+
+	for _, ch := range l.subscribers {
+		select {
+		case ch <- data:
+		default:
+			// 如果订阅者处理太慢，丢弃消息以避免阻塞写入
 		}
 	}
+	return nil
+}
 
-	return originalInputLen, nil
+// SetRedactor 设置脱敏器，需在写入任何内容之前调用
+func (l *TinyLog) SetRedactor(r *utils.Redactor) {
+	if r.Empty() {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.redactor = r
+	l.redact = r.NewStream()
 }
 
 // Subscribe 返回一个实时接收日志块的通道
@@ -173,20 +222,29 @@ func (l *TinyLog) Close() error {
 		return nil
 	}
 
-	// 处理剩余的字节
+	// 处理剩余的字节及脱敏缓存中的内容
+	var text string
 	if len(l.remainder) > 0 {
-		text := utils.ToUTF8(l.remainder)
-		data := []byte(text)
-		_, _ = l.writer.Write(data)
-
+		text = utils.ToUTF8(l.remainder)
+		l.remainder = nil
+	}
+	if l.redact != nil {
+		text = l.redact.Push(text) + l.redact.Flush()
+	}
+	if l.idleTimer != nil {
+		l.idleTimer.Stop()
+		l.idleTimer = nil
+	}
+	if text != "" {
 		// 通知订阅者最后一部分内容
-		for _, ch := range l.subscribers {
-			select {
-			case ch <- data:
-			default:
-			}
+		_ = l.emitLocked([]byte(text))
+	}
+
+	// 输出各流中未以换行结尾的最后一行
+	for _, stream := range []byte{LogStreamStdout, LogStreamStderr} {
+		if p := l.pending[stream]; p != nil && len(p.buf) > 0 {
+			_ = l.emitLineLocked(stream, p, len(p.buf))
 		}
-		l.remainder = nil
 	}
 
 	// 将缓冲区刷新到文件
@@ -205,6 +263,14 @@ func (l *TinyLog) Close() error {
 	return l.file.Close()
 }
 
+// OpenReader 完成写入并打开临时文件，用于读取完整日志
+func (l *TinyLog) OpenReader() (io.ReadCloser, error) {
+	if err := l.Close(); err != nil {
+		return nil, err
+	}
+	return os.Open(l.path)
+}
+
 // CompressAndCleanup 读取临时文件，进行压缩处理，返回结果并删除临时文件
 func (l *TinyLog) CompressAndCleanup() (string, error) {
 	// Ensure closed
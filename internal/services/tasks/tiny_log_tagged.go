@@ -0,0 +1,204 @@
+package tasks
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/engigu/baihu-panel/internal/systime"
+	"github.com/engigu/baihu-panel/internal/utils"
+)
+
+// LogFormatTagged 带时间戳和输出流标记的日志格式
+// 每行形如 "[2006-01-02 15:04:05.000][O] 内容"，O 表示 stdout，E 表示 stderr
+const LogFormatTagged = "tagged"
+
+// 输出流标记
+const (
+	LogStreamStdout byte = 'O'
+	LogStreamStderr byte = 'E'
+)
+
+// taggedTimeLayout 行时间戳格式（东八区，精确到毫秒）
+const taggedTimeLayout = "2006-01-02 15:04:05.000"
+
+// taggedPrefixLen 行前缀长度: "[" + 时间 + "][O] "
+const taggedPrefixLen = 1 + len(taggedTimeLayout) + 5
+
+// maxPendingLine 单行最大缓存长度，超过后即使没有换行也会强制输出，避免进度条类输出长期滞留
+const maxPendingLine = 8192
+
+// Streams 切换为带标记格式并返回 stdout/stderr 两个独立的写入器
+// 两个写入器不同，执行器会因此使用 Pipe 模式而非 PTY 模式
+func (l *TinyLog) Streams() (stdout, stderr io.Writer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.tagged = true
+	if l.pending == nil {
+		l.pending = map[byte]*tinyLogPending{
+			LogStreamStdout: {},
+			LogStreamStderr: {},
+		}
+	}
+	return &tinyLogStream{log: l, stream: LogStreamStdout}, &tinyLogStream{log: l, stream: LogStreamStderr}
+}
+
+// Tagged 返回日志是否为带标记格式
+func (l *TinyLog) Tagged() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.tagged
+}
+
+// tinyLogStream 某个输出流的写入器，按行添加时间戳和流标记
+type tinyLogStream struct {
+	log    *TinyLog
+	stream byte
+}
+
+func (w *tinyLogStream) Write(p []byte) (int, error) {
+	l := w.log
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return 0, os.ErrClosed
+	}
+
+	pending := l.pending[w.stream]
+	n := len(p)
+	for len(p) > 0 {
+		if len(pending.buf) == 0 {
+			pending.at = time.Now()
+		}
+		idx := bytes.IndexByte(p, '\n')
+		if idx < 0 {
+			pending.buf = append(pending.buf, p...)
+			if len(pending.buf) >= maxPendingLine {
+				if err := l.emitLineLocked(w.stream, pending, safeUTF8Cut(pending.buf)); err != nil {
+					return 0, err
+				}
+			}
+			break
+		}
+		pending.buf = append(pending.buf, p[:idx]...)
+		if err := l.emitLineLocked(w.stream, pending, len(pending.buf)); err != nil {
+			return 0, err
+		}
+		p = p[idx+1:]
+	}
+	return n, nil
+}
+
+// emitLineLocked 输出 pending 中前 size 个字节作为一行，剩余部分保留，调用方需持有写锁
+func (l *TinyLog) emitLineLocked(stream byte, pending *tinyLogPending, size int) error {
+	line := bytes.TrimSuffix(pending.buf[:size], []byte{'\r'})
+
+	var b strings.Builder
+	b.Grow(taggedPrefixLen + len(line) + 1)
+	b.WriteByte('[')
+	b.WriteString(systime.InCST(pending.at).Format(taggedTimeLayout))
+	b.WriteString("][")
+	b.WriteByte(stream)
+	b.WriteString("] ")
+	b.WriteString(l.redactor.Redact(utils.ToUTF8(line)))
+	b.WriteByte('\n')
+
+	rest := copy(pending.buf, pending.buf[size:])
+	pending.buf = pending.buf[:rest]
+	pending.at = time.Now()
+
+	return l.emitLocked([]byte(b.String()))
+}
+
+// safeUTF8Cut 返回不截断末尾多字节字符的切分位置
+func safeUTF8Cut(buf []byte) int {
+	for i := len(buf) - 1; i >= 0 && i >= len(buf)-4; i-- {
+		if utf8.RuneStart(buf[i]) {
+			if !utf8.FullRune(buf[i:]) && i > 0 {
+				return i
+			}
+			break
+		}
+	}
+	return len(buf)
+}
+
+// LogLine 解析后的一行带标记日志
+type LogLine struct {
+	Time   string `json:"time"`   // 行时间戳，非标记行为空
+	Offset int64  `json:"offset"` // 相对开始时间的毫秒数
+	Stream string `json:"stream"` // stdout / stderr，非标记行（如系统提示）为空
+	Text   string `json:"text"`
+}
+
+// ParseTaggedLog 解析带标记格式的日志内容
+// base 为计算相对时间的基准，为零值时以第一条带时间戳的行为基准
+// stream 不为空时仅返回对应输出流的行
+func ParseTaggedLog(content string, base time.Time, stream string) []LogLine {
+	lines := make([]LogLine, 0)
+	var last time.Time
+	if !base.IsZero() {
+		last = base
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		text := scanner.Text()
+		line := LogLine{Text: text}
+
+		if ts, tag, body, ok := splitTaggedLine(text); ok {
+			if base.IsZero() {
+				base = ts
+			}
+			last = ts
+			line.Time = systime.InCST(ts).Format(taggedTimeLayout)
+			line.Stream = tag
+			line.Text = body
+		}
+		if !last.IsZero() {
+			line.Offset = last.Sub(base).Milliseconds()
+		}
+
+		if stream != "" && line.Stream != stream {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// splitTaggedLine 拆分一行带标记日志，返回时间、输出流和正文
+func splitTaggedLine(text string) (time.Time, string, string, bool) {
+	if len(text) < taggedPrefixLen-1 || text[0] != '[' {
+		return time.Time{}, "", "", false
+	}
+	end := 1 + len(taggedTimeLayout)
+	if text[end:end+2] != "][" || text[end+3] != ']' {
+		return time.Time{}, "", "", false
+	}
+	ts, err := time.ParseInLocation(taggedTimeLayout, text[1:end], systime.CST)
+	if err != nil {
+		return time.Time{}, "", "", false
+	}
+
+	var tag string
+	switch text[end+2] {
+	case LogStreamStdout:
+		tag = "stdout"
+	case LogStreamStderr:
+		tag = "stderr"
+	default:
+		return time.Time{}, "", "", false
+	}
+
+	body := text[end+4:]
+	body = strings.TrimPrefix(body, " ")
+	return ts, tag, body, true
+}
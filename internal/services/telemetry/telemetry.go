@@ -0,0 +1,120 @@
+// Package telemetry 保存 Agent 心跳上报的资源使用情况
+// 只保留内存中的滚动历史，服务重启后由 Agent 的下一次心跳重新填充
+package telemetry
+
+import (
+	"sync"
+	"time"
+
+	"github.com/engigu/baihu-panel/internal/models"
+)
+
+const (
+	// historyWindow 保留的历史时长
+	historyWindow = time.Hour
+	// historyMax 每个 Agent 最多保留的采样数
+	historyMax = 720
+	// staleAfter 超过该时长未更新的采样不再用于调度
+	staleAfter = 2 * time.Minute
+)
+
+var (
+	history = make(map[uint][]models.AgentTelemetry) // agent_id -> 按时间升序的采样
+	mu      sync.RWMutex
+)
+
+// Record 记录一次采样，采集时间以服务端收到的时间为准，避免 Agent 时钟偏差
+func Record(agentID uint, sample models.AgentTelemetry) {
+	now := time.Now()
+	sample.Time = now.Unix()
+	cutoff := now.Add(-historyWindow).Unix()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	list := append(history[agentID], sample)
+	start := 0
+	for start < len(list) && list[start].Time < cutoff {
+		start++
+	}
+	if len(list)-start > historyMax {
+		start = len(list) - historyMax
+	}
+	history[agentID] = append([]models.AgentTelemetry(nil), list[start:]...)
+}
+
+// Latest 返回最近一次采样
+func Latest(agentID uint) (models.AgentTelemetry, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	list := history[agentID]
+	if len(list) == 0 {
+		return models.AgentTelemetry{}, false
+	}
+	return list[len(list)-1], true
+}
+
+// Fresh 返回最近一次采样，超过 staleAfter 未更新时视为没有
+func Fresh(agentID uint) (models.AgentTelemetry, bool) {
+	sample, ok := Latest(agentID)
+	if !ok || time.Since(time.Unix(sample.Time, 0)) > staleAfter {
+		return models.AgentTelemetry{}, false
+	}
+	return sample, true
+}
+
+// History 返回 since 之后的采样
+func History(agentID uint, since time.Time) []models.AgentTelemetry {
+	mu.RLock()
+	defer mu.RUnlock()
+	list := history[agentID]
+	result := make([]models.AgentTelemetry, 0, len(list))
+	for _, s := range list {
+		if s.Time >= since.Unix() {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// Remove 删除 Agent 的采样
+func Remove(agentID uint) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(history, agentID)
+}
+
+// TotalRunning 所有 Agent 正在执行的任务数（仅统计未过期的采样）
+func TotalRunning() int {
+	mu.RLock()
+	ids := make([]uint, 0, len(history))
+	for id := range history {
+		ids = append(ids, id)
+	}
+	mu.RUnlock()
+
+	total := 0
+	for _, id := range ids {
+		if sample, ok := Fresh(id); ok {
+			total += sample.Running
+		}
+	}
+	return total
+}
+
+// Pressure 资源压力（0-100），取 CPU、内存和单核负载中最高的一项
+func Pressure(sample models.AgentTelemetry) float64 {
+	p := sample.CPU
+	if sample.MemPercent > p {
+		p = sample.MemPercent
+	}
+	if sample.NumCPU > 0 {
+		if l := sample.Load1 / float64(sample.NumCPU) * 100; l > p {
+			p = l
+		}
+	}
+	if p > 100 {
+		p = 100
+	}
+	return p
+}
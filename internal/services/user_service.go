@@ -62,3 +62,12 @@ func (us *UserService) AuthenticateUser(username, password string) bool {
 func (us *UserService) UpdatePassword(userID uint, newPassword string) error {
 	return database.DB.Model(&models.User{}).Where("id = ?", userID).Update("password", us.hashPassword(newPassword)).Error
 }
+
+// IsAdmin 用户是否为管理员
+func (us *UserService) IsAdmin(userID uint) bool {
+	var user models.User
+	if err := database.DB.Select("role").Where("id = ?", userID).First(&user).Error; err != nil {
+		return false
+	}
+	return user.Role == "admin"
+}
@@ -49,3 +49,30 @@ func GenerateMachineID() string {
 	hash := sha256.Sum256([]byte(data))
 	return hex.EncodeToString(hash[:])
 }
+
+// 机器识别码长度范围，GenerateMachineID 生成 64 位十六进制串，与数据库字段长度一致
+const (
+	MachineIDMinLen = 16
+	MachineIDMaxLen = 64
+)
+
+// ValidMachineID 检查 Agent 上报的机器识别码：长度在范围内且只包含可见 ASCII 字符
+func ValidMachineID(id string) bool {
+	if len(id) < MachineIDMinLen || len(id) > MachineIDMaxLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// ShortID 截取标识的前 n 个字符用于日志，长度不足时原样返回
+func ShortID(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
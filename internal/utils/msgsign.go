@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MessageMaxSkew 签名消息允许的最大时间偏差，超出视为重放
+const MessageMaxSkew = 5 * time.Minute
+
+// SignMessage 计算 WebSocket 消息的 HMAC-SHA256 签名
+// 签名内容为 type、时间戳、nonce 和 data，任何一项被篡改都会导致验签失败
+func SignMessage(key []byte, msgType string, ts int64, nonce string, data []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(msgType))
+	mac.Write([]byte{'\n'})
+	mac.Write([]byte(strconv.FormatInt(ts, 10)))
+	mac.Write([]byte{'\n'})
+	mac.Write([]byte(nonce))
+	mac.Write([]byte{'\n'})
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyMessage 校验消息签名
+func VerifyMessage(key []byte, msgType string, ts int64, nonce string, data []byte, sig string) bool {
+	expected := SignMessage(key, msgType, ts, nonce, data)
+	return hmac.Equal([]byte(expected), []byte(sig))
+}
+
+// ReplayGuard 拒绝时间戳超出窗口或 nonce 重复的消息
+type ReplayGuard struct {
+	seen    map[string]int64 // nonce -> 消息时间戳
+	cleaned time.Time        // 上次清理过期 nonce 的时间
+	mu      sync.Mutex
+}
+
+// NewReplayGuard 创建重放检测器
+func NewReplayGuard() *ReplayGuard {
+	return &ReplayGuard{seen: make(map[string]int64)}
+}
+
+// Check 检查并记录 nonce，时间窗口外的 nonce 每分钟清理一次，内存占用与窗口内的消息量成正比
+func (g *ReplayGuard) Check(nonce string, ts int64) error {
+	if nonce == "" {
+		return errors.New("缺少 nonce")
+	}
+	now := time.Now()
+	msgTime := time.Unix(ts, 0)
+	if msgTime.Before(now.Add(-MessageMaxSkew)) || msgTime.After(now.Add(MessageMaxSkew)) {
+		return errors.New("消息时间戳超出允许范围")
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.seen[nonce]; ok {
+		return errors.New("重复的 nonce")
+	}
+	if now.Sub(g.cleaned) > time.Minute {
+		cutoff := now.Add(-MessageMaxSkew).Unix()
+		for n, t := range g.seen {
+			if t < cutoff {
+				delete(g.seen, n)
+			}
+		}
+		g.cleaned = now
+	}
+	g.seen[nonce] = ts
+	return nil
+}
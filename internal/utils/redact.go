@@ -0,0 +1,167 @@
+package utils
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// RedactMask 敏感内容的替换文本
+const RedactMask = "******"
+
+// minSecretLen 短于该长度的值不做脱敏，避免误伤普通输出
+const minSecretLen = 4
+
+// redactHoldMax 流式脱敏时未结束行的最大缓存长度，超过后强制输出
+const redactHoldMax = 4096
+
+// RedactIdleDelay 未结束的行（进度条、输入提示等）等待超过该时间后由调用方通过 Idle 输出
+const RedactIdleDelay = 300 * time.Millisecond
+
+// Redactor 日志脱敏器，替换敏感值及匹配正则规则的内容
+type Redactor struct {
+	secrets  []string
+	patterns []*regexp.Regexp
+	hold     int // 最长敏感值长度，用于跨写入边界的匹配
+}
+
+// NewRedactor 创建脱敏器，无效的正则规则会被忽略
+func NewRedactor(secrets []string, rules []string) *Redactor {
+	r := &Redactor{}
+	seen := make(map[string]bool)
+	for _, s := range secrets {
+		if len(strings.TrimSpace(s)) < minSecretLen || seen[s] {
+			continue
+		}
+		seen[s] = true
+		r.secrets = append(r.secrets, s)
+		if len(s) > r.hold {
+			r.hold = len(s)
+		}
+	}
+	// 优先替换较长的值，避免短值先替换导致长值残留
+	sort.Slice(r.secrets, func(i, j int) bool {
+		return len(r.secrets[i]) > len(r.secrets[j])
+	})
+
+	for _, rule := range rules {
+		re, err := regexp.Compile(rule)
+		if err != nil {
+			continue
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r
+}
+
+// ParseRedactionRules 解析按行配置的正则规则，忽略空行和 # 开头的注释
+func ParseRedactionRules(text string) []string {
+	rules := make([]string, 0)
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rules = append(rules, line)
+	}
+	return rules
+}
+
+// Empty 是否没有任何需要脱敏的内容
+func (r *Redactor) Empty() bool {
+	return r == nil || (len(r.secrets) == 0 && len(r.patterns) == 0)
+}
+
+// Redact 对一段完整文本进行脱敏
+func (r *Redactor) Redact(text string) string {
+	if r.Empty() || text == "" {
+		return text
+	}
+	for _, s := range r.secrets {
+		text = strings.ReplaceAll(text, s, RedactMask)
+	}
+	for _, re := range r.patterns {
+		text = re.ReplaceAllString(text, RedactMask)
+	}
+	return text
+}
+
+// NewStream 创建流式脱敏状态，用于逐块写入的日志
+func (r *Redactor) NewStream() *RedactStream {
+	if r.Empty() {
+		return nil
+	}
+	return &RedactStream{r: r}
+}
+
+// RedactStream 流式脱敏，按行输出，保证被拆分到多次写入中的敏感值也能被替换
+type RedactStream struct {
+	r       *Redactor
+	pending string
+}
+
+// Push 追加一段文本，返回可以安全输出的脱敏内容，其余部分留待后续写入
+func (s *RedactStream) Push(text string) string {
+	buf := s.pending + text
+	cut := strings.LastIndexAny(buf, "\n\r") + 1
+	if len(buf)-cut > redactHoldMax {
+		// 超长的未结束行（如进度条），保留可能是敏感值前缀的尾部后强制输出
+		cut = len(buf) - s.r.hold
+		for cut > 0 && cut < len(buf) && !utf8.RuneStart(buf[cut]) {
+			cut--
+		}
+	}
+	cut = s.safeCut(buf, cut)
+	if cut <= 0 {
+		s.pending = buf
+		return ""
+	}
+	s.pending = buf[cut:]
+	return s.r.Redact(buf[:cut])
+}
+
+// Pending 是否有留待后续写入的内容
+func (s *RedactStream) Pending() bool {
+	return s.pending != ""
+}
+
+// Idle 输出长时间未结束的行，只保留可能是敏感值前缀的尾部；
+// 正则规则只作用于已输出的部分，被拆开的匹配可能无法替换
+func (s *RedactStream) Idle() string {
+	cut := s.safeCut(s.pending, len(s.pending))
+	if cut <= 0 {
+		return ""
+	}
+	out := s.r.Redact(s.pending[:cut])
+	s.pending = s.pending[cut:]
+	return out
+}
+
+// Flush 输出剩余的全部内容
+func (s *RedactStream) Flush() string {
+	out := s.r.Redact(s.pending)
+	s.pending = ""
+	return out
+}
+
+// safeCut 若切分点之前的尾部是某个敏感值的前缀（如值本身包含换行），将切分点前移到该值的起始处
+func (s *RedactStream) safeCut(buf string, cut int) int {
+	start := cut - s.r.hold + 1
+	if start < 0 {
+		start = 0
+	}
+	for p := start; p < cut; p++ {
+		tail := buf[p:]
+		for _, secret := range s.r.secrets {
+			if len(secret) <= cut-p {
+				continue
+			}
+			if strings.HasPrefix(secret, tail) || strings.HasPrefix(tail, secret) {
+				return p
+			}
+		}
+	}
+	return cut
+}
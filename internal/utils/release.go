@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+)
+
+// ReleaseSignature Agent 发布包的签名，与 tar.gz 一起发布为 <文件名>.sig（JSON）
+// 签名覆盖平台、版本和可执行文件的 SHA-256，防止发布包被替换或跨平台、跨版本挪用
+type ReleaseSignature struct {
+	Version   string `json:"version"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+	SHA256    string `json:"sha256"`    // 可执行文件的 SHA-256（hex）
+	Signature string `json:"signature"` // ed25519 签名（base64）
+}
+
+func (s *ReleaseSignature) message() []byte {
+	return []byte("baihu-agent\n" + s.OS + "/" + s.Arch + "\n" + s.Version + "\n" + s.SHA256)
+}
+
+// SignRelease 使用发布私钥签名
+func SignRelease(key ed25519.PrivateKey, s *ReleaseSignature) {
+	s.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, s.message()))
+}
+
+// Verify 使用 Agent 内置的公钥校验签名
+func (s *ReleaseSignature) Verify(pub ed25519.PublicKey) error {
+	sig, err := base64.StdEncoding.DecodeString(s.Signature)
+	if err != nil {
+		return errors.New("签名格式错误")
+	}
+	if !ed25519.Verify(pub, s.message(), sig) {
+		return errors.New("签名校验失败")
+	}
+	return nil
+}
+
+// ParseReleasePublicKey 解析 base64 编码的 ed25519 公钥
+func ParseReleasePublicKey(s string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("无效的发布公钥")
+	}
+	return ed25519.PublicKey(key), nil
+}
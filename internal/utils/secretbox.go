@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// 隐藏环境变量只在执行时下发给 Agent，并使用 Agent 的 X25519 公钥加密：
+// 面板每次生成临时密钥对，与 Agent 公钥协商出 AES-256-GCM 密钥，只有持有私钥的 Agent 能解密。
+// 附加数据绑定任务和请求，密文不能被挪用到其他任务或请求
+
+// SealedSecrets 加密后的环境变量
+type SealedSecrets struct {
+	EphemeralKey string `json:"epk"` // 面板临时公钥
+	Nonce        string `json:"nonce"`
+	Ciphertext   string `json:"ct"`
+}
+
+// SecretsAAD 加密时绑定的附加数据
+func SecretsAAD(taskID uint, requestID string) []byte {
+	return []byte(fmt.Sprintf("task:%d;request:%s", taskID, requestID))
+}
+
+// GenerateSecretsKey 生成 Agent 的密钥对
+func GenerateSecretsKey() (*ecdh.PrivateKey, error) {
+	return ecdh.X25519().GenerateKey(rand.Reader)
+}
+
+// EncodeSecretsPublicKey 编码公钥，随 Agent 心跳上报
+func EncodeSecretsPublicKey(pub *ecdh.PublicKey) string {
+	return base64.StdEncoding.EncodeToString(pub.Bytes())
+}
+
+// ParseSecretsPublicKey 解析 Agent 上报的公钥
+func ParseSecretsPublicKey(s string) (*ecdh.PublicKey, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("公钥格式错误")
+	}
+	return ecdh.X25519().NewPublicKey(b)
+}
+
+// SealSecrets 使用 Agent 公钥加密
+func SealSecrets(pub *ecdh.PublicKey, plaintext, aad []byte) (*SealedSecrets, error) {
+	eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := secretsCipher(eph, pub, eph.PublicKey(), pub)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return &SealedSecrets{
+		EphemeralKey: base64.StdEncoding.EncodeToString(eph.PublicKey().Bytes()),
+		Nonce:        base64.StdEncoding.EncodeToString(nonce),
+		Ciphertext:   base64.StdEncoding.EncodeToString(gcm.Seal(nil, nonce, plaintext, aad)),
+	}, nil
+}
+
+// OpenSecrets 使用 Agent 私钥解密，aad 与加密时不一致时失败
+func OpenSecrets(priv *ecdh.PrivateKey, sealed *SealedSecrets, aad []byte) ([]byte, error) {
+	epk, err := ParseSecretsPublicKey(sealed.EphemeralKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := base64.StdEncoding.DecodeString(sealed.Nonce)
+	if err != nil {
+		return nil, errors.New("密文格式错误")
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(sealed.Ciphertext)
+	if err != nil {
+		return nil, errors.New("密文格式错误")
+	}
+	gcm, err := secretsCipher(priv, epk, epk, priv.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, errors.New("密文格式错误")
+	}
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, aad)
+	if err != nil {
+		return nil, errors.New("解密失败")
+	}
+	return plaintext, nil
+}
+
+// secretsCipher 由协商出的共享密钥和双方公钥派生 AES-256-GCM 密钥
+func secretsCipher(priv *ecdh.PrivateKey, peer, epk, agentPub *ecdh.PublicKey) (cipher.AEAD, error) {
+	shared, err := priv.ECDH(peer)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	h.Write([]byte("baihu-agent-secrets"))
+	h.Write(shared)
+	h.Write(epk.Bytes())
+	h.Write(agentPub.Bytes())
+	block, err := aes.NewCipher(h.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
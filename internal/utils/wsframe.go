@@ -0,0 +1,131 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+// Agent WebSocket 协议版本
+// v1 每条消息是一个 JSON 文本帧；v2 使用二进制帧，任务日志按流发送并由接收方授予发送额度，
+// 避免大量日志挤占心跳。双方在握手时协商，旧版本的一方始终使用 v1
+const (
+	WSProtocolV1     = 1
+	WSProtocolV2     = 2
+	WSProtocolLatest = WSProtocolV2
+)
+
+// v2 帧类型
+const (
+	FrameMessage byte = 1 // 载荷为 v1 的 JSON 消息（自带签名）
+	FrameData    byte = 2 // 流数据，载荷为日志内容
+	FrameCredit  byte = 3 // 授予流发送额度，载荷为 4 字节字节数
+)
+
+const (
+	frameHeaderSize = 11 // 版本 1 + 类型 1 + 标志 1 + 流 ID 4 + 序号 4
+	frameFlagSigned = 1  // 帧尾附带 HMAC-SHA256
+	frameSigSize    = sha256.Size
+
+	// StreamInitialWindow 每个流的初始发送额度，之后由接收方按已处理的字节数补充
+	StreamInitialWindow = 64 << 10
+	// StreamMaxFrameData 单个数据帧的最大载荷
+	StreamMaxFrameData = 16 << 10
+)
+
+// Frame v2 二进制帧
+// 数据帧和额度帧按流递增序号，签名覆盖连接的 session、帧头和载荷，防止篡改、重放和跨连接挪用
+type Frame struct {
+	Type    byte
+	Stream  uint32
+	Seq     uint32
+	Payload []byte
+}
+
+// NegotiateWSProtocol 根据 Agent 请求的版本选择双方都支持的版本，未请求视为 v1
+func NegotiateWSProtocol(requested string) int {
+	v, err := strconv.Atoi(requested)
+	if err != nil || v < WSProtocolV1 {
+		return WSProtocolV1
+	}
+	if v > WSProtocolLatest {
+		return WSProtocolLatest
+	}
+	return v
+}
+
+// EncodeFrame 编码帧，key 不为空时数据帧和额度帧附带签名；消息帧的 JSON 自带签名
+func EncodeFrame(f *Frame, key, session []byte) []byte {
+	signed := key != nil && f.Type != FrameMessage
+	size := frameHeaderSize + len(f.Payload)
+	if signed {
+		size += frameSigSize
+	}
+	b := make([]byte, frameHeaderSize, size)
+	b[0] = WSProtocolV2
+	b[1] = f.Type
+	if signed {
+		b[2] = frameFlagSigned
+	}
+	binary.BigEndian.PutUint32(b[3:7], f.Stream)
+	binary.BigEndian.PutUint32(b[7:11], f.Seq)
+	b = append(b, f.Payload...)
+	if signed {
+		b = append(b, frameMAC(key, session, b)...)
+	}
+	return b
+}
+
+// DecodeFrame 解码帧，key 不为空时数据帧和额度帧必须签名正确
+func DecodeFrame(b []byte, key, session []byte) (*Frame, error) {
+	if len(b) < frameHeaderSize {
+		return nil, errors.New("帧长度不足")
+	}
+	if b[0] != WSProtocolV2 {
+		return nil, errors.New("不支持的帧版本")
+	}
+	f := &Frame{
+		Type:   b[1],
+		Stream: binary.BigEndian.Uint32(b[3:7]),
+		Seq:    binary.BigEndian.Uint32(b[7:11]),
+	}
+	body := b
+	if b[2]&frameFlagSigned != 0 {
+		if len(b) < frameHeaderSize+frameSigSize {
+			return nil, errors.New("帧长度不足")
+		}
+		body = b[:len(b)-frameSigSize]
+		if key == nil {
+			return nil, errors.New("未申请签名密钥")
+		}
+		if !hmac.Equal(frameMAC(key, session, body), b[len(body):]) {
+			return nil, errors.New("帧签名错误")
+		}
+	} else if key != nil && f.Type != FrameMessage {
+		return nil, errors.New("帧缺少签名")
+	}
+	f.Payload = body[frameHeaderSize:]
+	return f, nil
+}
+
+// CreditPayload 额度帧的载荷
+func CreditPayload(n int) []byte {
+	return binary.BigEndian.AppendUint32(nil, uint32(n))
+}
+
+// ParseCredit 解析额度帧的载荷
+func ParseCredit(payload []byte) (int, error) {
+	if len(payload) != 4 {
+		return 0, errors.New("额度帧格式错误")
+	}
+	return int(binary.BigEndian.Uint32(payload)), nil
+}
+
+func frameMAC(key, session, body []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(session)
+	mac.Write(body)
+	return mac.Sum(nil)
+}
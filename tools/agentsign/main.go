@@ -0,0 +1,190 @@
+// agentsign Agent 发布包签名工具
+//
+//	go run ./tools/agentsign genkey -out agent-release.key
+//	go run ./tools/agentsign sign -key agent-release.key data/agent/*.tar.gz
+//
+// genkey 生成 ed25519 密钥对并输出公钥，构建 Agent 时通过 -X main.UpdatePublicKey=<公钥> 内置；
+// sign 为每个 baihu-agent-<os>-<arch>.tar.gz 生成同名 .sig 文件，面板下载接口会一并下发
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/engigu/baihu-panel/internal/utils"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(1)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "genkey":
+		err = genkey(os.Args[2:])
+	case "sign":
+		err = sign(os.Args[2:])
+	default:
+		usage()
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "错误:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `用法:
+  agentsign genkey -out <私钥文件>
+  agentsign sign -key <私钥文件> [-version <版本>] <baihu-agent-<os>-<arch>.tar.gz>...
+
+私钥也可以通过环境变量 AGENT_SIGN_KEY（base64）传入；未指定版本时读取同目录的 version.txt`)
+}
+
+func genkey(args []string) error {
+	fs := flag.NewFlagSet("genkey", flag.ExitOnError)
+	out := fs.String("out", "agent-release.key", "私钥输出文件")
+	fs.Parse(args)
+
+	if _, err := os.Stat(*out); err == nil {
+		return fmt.Errorf("%s 已存在，不会覆盖", *out)
+	}
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*out, []byte(base64.StdEncoding.EncodeToString(priv)+"\n"), 0600); err != nil {
+		return err
+	}
+	fmt.Printf("私钥已保存到 %s，请妥善保管\n", *out)
+	fmt.Printf("公钥: %s\n", base64.StdEncoding.EncodeToString(pub))
+	return nil
+}
+
+func sign(args []string) error {
+	fs := flag.NewFlagSet("sign", flag.ExitOnError)
+	keyFile := fs.String("key", "", "私钥文件")
+	version := fs.String("version", "", "版本号，默认读取同目录的 version.txt")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return errors.New("未指定发布包")
+	}
+
+	key, err := loadKey(*keyFile)
+	if err != nil {
+		return err
+	}
+
+	for _, pkg := range fs.Args() {
+		osType, arch, ok := parsePackageName(filepath.Base(pkg))
+		if !ok {
+			return fmt.Errorf("无法从文件名解析平台: %s", pkg)
+		}
+		ver := *version
+		if ver == "" {
+			data, err := os.ReadFile(filepath.Join(filepath.Dir(pkg), "version.txt"))
+			if err != nil {
+				return fmt.Errorf("未指定版本且读取 version.txt 失败: %v", err)
+			}
+			ver = strings.TrimSpace(string(data))
+		}
+		sum, err := binarySHA256(pkg, osType)
+		if err != nil {
+			return fmt.Errorf("%s: %v", pkg, err)
+		}
+
+		sig := &utils.ReleaseSignature{Version: ver, OS: osType, Arch: arch, SHA256: sum}
+		utils.SignRelease(key, sig)
+		data, _ := json.MarshalIndent(sig, "", "  ")
+		if err := os.WriteFile(pkg+".sig", data, 0644); err != nil {
+			return err
+		}
+		fmt.Printf("已签名 %s (%s %s/%s)\n", pkg, ver, osType, arch)
+	}
+	return nil
+}
+
+func loadKey(path string) (ed25519.PrivateKey, error) {
+	encoded := os.Getenv("AGENT_SIGN_KEY")
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		encoded = string(data)
+	}
+	if encoded == "" {
+		return nil, errors.New("未指定私钥，请使用 -key 或环境变量 AGENT_SIGN_KEY")
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(key) != ed25519.PrivateKeySize {
+		return nil, errors.New("私钥格式错误")
+	}
+	return ed25519.PrivateKey(key), nil
+}
+
+// parsePackageName 解析 baihu-agent-<os>-<arch>.tar.gz
+func parsePackageName(name string) (string, string, bool) {
+	name, ok := strings.CutSuffix(name, ".tar.gz")
+	if !ok {
+		return "", "", false
+	}
+	name, ok = strings.CutPrefix(name, "baihu-agent-")
+	if !ok {
+		return "", "", false
+	}
+	return strings.Cut(name, "-")
+}
+
+// binarySHA256 计算发布包中可执行文件的 SHA-256，Agent 更新时校验的是解压后的可执行文件
+func binarySHA256(pkg, osType string) (string, error) {
+	binaryName := "baihu-agent"
+	if osType == "windows" {
+		binaryName = "baihu-agent.exe"
+	}
+
+	f, err := os.Open(pkg)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return "", err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return "", fmt.Errorf("未找到 %s", binaryName)
+		}
+		if err != nil {
+			return "", err
+		}
+		if header.Typeflag == tar.TypeReg && header.Name == binaryName {
+			h := sha256.New()
+			if _, err := io.Copy(h, tr); err != nil {
+				return "", err
+			}
+			return hex.EncodeToString(h.Sum(nil)), nil
+		}
+	}
+}
@@ -111,7 +111,15 @@ export const api = {
     stats: () => request<Stats>('/stats'),
     sentence: () => request<{ sentence: string }>('/sentence'),
     sendStats: (days?: number) => request<DailyStats[]>(`/sendstats${days ? `?days=${days}` : ''}`),
-    taskStats: (days?: number) => request<TaskStatsItem[]>(`/taskstats${days ? `?days=${days}` : ''}`)
+    taskStats: (days?: number) => request<TaskStatsItem[]>(`/taskstats${days ? `?days=${days}` : ''}`),
+    taskHealth: () => request<TaskHealthOverview>('/taskhealth'),
+    missedRuns: (params?: { task_id?: number; limit?: number }) => {
+      const query = new URLSearchParams()
+      if (params?.task_id) query.set('task_id', String(params.task_id))
+      if (params?.limit) query.set('limit', String(params.limit))
+      const qs = query.toString()
+      return request<MissedRun[]>(`/missedruns${qs ? '?' + qs : ''}`)
+    }
   },
   settings: {
     changePassword: (data: { old_password: string; new_password: string }) =>
@@ -123,6 +131,12 @@ export const api = {
     getScheduler: () => request<SchedulerSettings>('/settings/scheduler'),
     updateScheduler: (data: SchedulerSettings) =>
       request('/settings/scheduler', { method: 'PUT', body: JSON.stringify(data) }),
+    getHealth: () => request<HealthSettings>('/settings/health'),
+    updateHealth: (data: HealthSettings) =>
+      request('/settings/health', { method: 'PUT', body: JSON.stringify(data) }),
+    getRedaction: () => request<{ rules: string }>('/settings/redaction'),
+    updateRedaction: (data: { rules: string }) =>
+      request('/settings/redaction', { method: 'PUT', body: JSON.stringify(data) }),
     getPaths: () => request<{ scripts_dir: string }>('/settings/paths'),
     getAbout: () => request<AboutInfo>('/settings/about'),
     getLoginLogs: (params?: { page?: number; page_size?: number; username?: string }) => {
@@ -219,16 +233,105 @@ export const api = {
   agents: {
     list: () => request<Agent[]>('/agents'),
     getVersion: () => request<{ version: string; platforms: { os: string; arch: string; filename: string }[] }>('/agents/version'),
-    update: (id: number, data: { name: string; description?: string; enabled: boolean }) =>
+    update: (id: number, data: { name: string; description?: string; group?: string; labels?: string; enabled: boolean }) =>
       request('/agents/' + id, { method: 'PUT', body: JSON.stringify(data) }),
     delete: (id: number) => request('/agents/' + id, { method: 'DELETE' }),
-    forceUpdate: (id: number) => request('/agents/' + id + '/update', { method: 'POST' }),
+    forceUpdate: (id: number, rollback = false) => request('/agents/' + id + '/update', { method: 'POST', body: JSON.stringify({ rollback }) }),
+    resetCert: (id: number) => request('/agents/' + id + '/reset-cert', { method: 'POST' }),
+    telemetry: (id: number) => request<AgentTelemetry[]>('/agents/' + id + '/telemetry'),
     downloadUrl: (os: string, arch: string) => `${API_BASE_URL}/agent/download?os=${os}&arch=${arch}`,
     // 令牌管理
     listTokens: () => request<AgentToken[]>('/agents/tokens'),
-    createToken: (data: { remark?: string; max_uses?: number; expires_at?: string }) =>
+    createToken: (data: { remark?: string; max_uses?: number; expires_at?: string; auto_approve?: boolean; group?: string }) =>
       request<AgentToken>('/agents/tokens', { method: 'POST', body: JSON.stringify(data) }),
-    deleteToken: (id: number) => request('/agents/tokens/' + id, { method: 'DELETE' })
+    deleteToken: (id: number) => request('/agents/tokens/' + id, { method: 'DELETE' }),
+    // 接入审批
+    approve: (id: number, group?: string) =>
+      request('/agents/' + id + '/approve', { method: 'POST', body: JSON.stringify({ group }) }),
+    reject: (id: number) => request('/agents/' + id + '/reject', { method: 'POST' }),
+    listBlocks: () => request<AgentBlock[]>('/agents/blocks'),
+    deleteBlock: (id: number) => request('/agents/blocks/' + id, { method: 'DELETE' }),
+    // 分批升级
+    listRollouts: () => request<AgentRollout[]>('/agents/rollouts'),
+    createRollout: (data: { name?: string; selector?: string; waves: string; wave_timeout?: number; max_failures?: number }) =>
+      request<AgentRollout>('/agents/rollouts', { method: 'POST', body: JSON.stringify(data) }),
+    getRollout: (id: number) => request<{ rollout: AgentRollout; targets: AgentRolloutTarget[] }>('/agents/rollouts/' + id),
+    pauseRollout: (id: number) => request('/agents/rollouts/' + id + '/pause', { method: 'POST' }),
+    resumeRollout: (id: number) => request('/agents/rollouts/' + id + '/resume', { method: 'POST' }),
+    cancelRollout: (id: number) => request('/agents/rollouts/' + id + '/cancel', { method: 'POST' }),
+    deleteRollout: (id: number) => request('/agents/rollouts/' + id, { method: 'DELETE' }),
+    // 脚本同步
+    listSyncMappings: (id: number) => request<AgentSyncMapping[]>('/agents/' + id + '/sync'),
+    createSyncMapping: (id: number, data: AgentSyncMappingForm) =>
+      request<AgentSyncMapping>('/agents/' + id + '/sync', { method: 'POST', body: JSON.stringify(data) }),
+    updateSyncMapping: (mappingId: number, data: AgentSyncMappingForm) =>
+      request<AgentSyncMapping>('/agents/sync/' + mappingId, { method: 'PUT', body: JSON.stringify(data) }),
+    deleteSyncMapping: (mappingId: number) => request('/agents/sync/' + mappingId, { method: 'DELETE' }),
+    runSync: (id: number) => request('/agents/' + id + '/sync/run', { method: 'POST' }),
+    // 运行配置
+    getConfig: (id: number) => request<AgentConfig>('/agents/' + id + '/config'),
+    saveConfig: (id: number, data: AgentConfigForm) =>
+      request<AgentConfig>('/agents/' + id + '/config', { method: 'PUT', body: JSON.stringify(data) }),
+    // 远程文件（仅管理员）
+    remoteTree: (id: number) => request<FileNode>(`/files/tree?agent_id=${id}`),
+    remoteContent: (id: number, path: string) =>
+      request<{ path: string; content: string }>(`/files/content?agent_id=${id}&path=${encodeURIComponent(path)}`),
+    remoteDownloadUrl: (id: number, path: string) => `${API_BASE_URL}/files/download?agent_id=${id}&path=${encodeURIComponent(path)}`,
+    remoteSave: (id: number, path: string, content: string) =>
+      request(`/files/content?agent_id=${id}`, { method: 'POST', body: JSON.stringify({ path, content }) }),
+    remoteCreate: (id: number, path: string, isDir: boolean) =>
+      request(`/files/create?agent_id=${id}`, { method: 'POST', body: JSON.stringify({ path, isDir }) }),
+    remoteDelete: (id: number, path: string) =>
+      request(`/files/delete?agent_id=${id}`, { method: 'POST', body: JSON.stringify({ path }) }),
+    remoteUpload: async (id: number, files: FileList, targetPath?: string) => {
+      const formData = new FormData()
+      for (let i = 0; i < files.length; i++) {
+        const file = files[i]
+        if (file) formData.append('files', file)
+      }
+      if (targetPath) formData.append('path', targetPath)
+
+      const res = await fetch(`${API_BASE_URL}/files/uploadfiles?agent_id=${id}`, {
+        method: 'POST',
+        credentials: 'include',
+        body: formData
+      })
+      const json: ApiResponse<null> = await res.json()
+      if (json.code === 401) {
+        window.location.href = BASE_URL + '/login'
+        throw new Error('请先登录')
+      }
+      if (json.code !== 200) throw new Error(json.msg || '上传失败')
+    },
+    // 远程访问记录
+    listSessions: (params: { page?: number; page_size?: number; agent_id?: number }) => {
+      const query = new URLSearchParams()
+      if (params.page) query.set('page', String(params.page))
+      if (params.page_size) query.set('page_size', String(params.page_size))
+      if (params.agent_id) query.set('agent_id', String(params.agent_id))
+      return request<{ data: AgentSession[]; total: number; page: number; page_size: number }>(`/agents/sessions?${query}`)
+    }
+  },
+  notify: {
+    getTypes: () => request<NotifyTypes>('/notify/types'),
+    listChannels: () => request<NotifyChannel[]>('/notify/channels'),
+    createChannel: (data: Partial<NotifyChannel>) =>
+      request<NotifyChannel>('/notify/channels', { method: 'POST', body: JSON.stringify(data) }),
+    updateChannel: (id: number, data: Partial<NotifyChannel>) =>
+      request<NotifyChannel>('/notify/channels/' + id, { method: 'PUT', body: JSON.stringify(data) }),
+    deleteChannel: (id: number) => request('/notify/channels/' + id, { method: 'DELETE' }),
+    testChannel: (id: number, data?: Partial<NotifyChannel>) =>
+      request('/notify/channels/' + id + '/test', { method: 'POST', body: data ? JSON.stringify(data) : undefined }),
+    listRules: (taskId?: number) =>
+      request<NotifyRule[]>('/notify/rules' + (taskId !== undefined ? `?task_id=${taskId}` : '')),
+    createRule: (data: Partial<NotifyRule>) =>
+      request<NotifyRule>('/notify/rules', { method: 'POST', body: JSON.stringify(data) }),
+    updateRule: (id: number, data: Partial<NotifyRule>) =>
+      request<NotifyRule>('/notify/rules/' + id, { method: 'PUT', body: JSON.stringify(data) }),
+    deleteRule: (id: number) => request('/notify/rules/' + id, { method: 'DELETE' }),
+    getScript: () => request<{ webhook: string; smtp: string }>('/notify/script'),
+    updateScript: (data: { webhook: string; smtp: string }) =>
+      request('/notify/script', { method: 'PUT', body: JSON.stringify(data) })
   }
 }
 
@@ -251,7 +354,13 @@ export interface Task {
   clean_config: string
   envs: string
   agent_id: number | null
+  agent_selector: string
+  dispatch_mode: string
+  fallback_agents: string
+  fallback_local: boolean
   enabled: boolean
+  health: string
+  health_reason: string
   last_run: string
   next_run: string
 }
@@ -321,6 +430,8 @@ export interface TaskLog {
   task_id: number
   task_name: string
   task_type: string
+  agent_id: number | null
+  dispatch_note: string
   command: string
   status: string
   duration: number
@@ -328,6 +439,13 @@ export interface TaskLog {
   start_time: string | null
   end_time: string | null
   created_at: string
+  notifications?: ScriptNotification[]
+}
+
+export interface ScriptNotification {
+  time: string
+  title: string
+  content: string
 }
 
 export interface LogListResponse {
@@ -340,6 +458,8 @@ export interface LogListResponse {
 export interface LogDetail {
   id: number
   task_id: number
+  agent_id: number | null
+  dispatch_note: string
   command: string
   output: string
   error: string | null
@@ -348,6 +468,7 @@ export interface LogDetail {
   start_time: string | null
   end_time: string | null
   created_at: string
+  notifications?: ScriptNotification[]
 }
 
 export interface AboutInfo {
@@ -369,6 +490,75 @@ export interface SiteSettings {
   cookie_days: string
 }
 
+export interface NotifyField {
+  key: string
+  label: string
+  required: boolean
+  secret: boolean
+  placeholder: string
+}
+
+export interface NotifyChannelType {
+  type: string
+  name: string
+  fields: NotifyField[]
+}
+
+export interface NotifyTypes {
+  types: NotifyChannelType[]
+  events: string[]
+  title_template: string
+  body_template: string
+}
+
+export interface NotifyChannel {
+  id: number
+  name: string
+  type: string
+  config: string
+  title_template: string
+  body_template: string
+  enabled: boolean
+  created_at: string
+  updated_at: string
+}
+
+export interface NotifyRule {
+  id: number
+  task_id: number
+  channel_id: number
+  events: string
+  enabled: boolean
+  created_at: string
+  updated_at: string
+}
+
+export interface HealthSettings {
+  fail_streak: string
+  window_hours: string
+  fail_rate: string
+  degraded_rate: string
+  min_runs: string
+  missed_grace: string
+}
+
+export interface TaskHealthOverview {
+  healthy: number
+  degraded: number
+  failing: number
+  tasks: { id: number; name: string; health: string; health_reason: string; last_run: string | null }[]
+}
+
+export interface MissedRun {
+  id: number
+  task_id: number
+  task_name: string
+  expected_at: string
+  count: number
+  reason: string
+  created_at: string
+}
+
 export interface SchedulerSettings {
   worker_count: string
   queue_size: string
@@ -423,6 +613,11 @@ export interface Agent {
   token: string
   machine_id: string
   description: string
+  group: string
+  labels: string
+  config_group: string
+  config_labels: string
+  effective_labels: Record<string, string>
   status: string
   last_seen: string
   ip: string
@@ -432,10 +627,129 @@ export interface Agent {
   os: string
   arch: string
   enabled: boolean
+  approval: 'pending' | 'approved'
+  cert_expires_at: string | null
+  signed_messages: boolean
+  secrets_pinned: boolean
+  telemetry: AgentTelemetry | null
   created_at: string
   updated_at: string
 }
 
+export interface AgentSession {
+  id: number
+  user_id: number
+  username: string
+  agent_id: number
+  agent_name: string
+  kind: 'shell' | 'file'
+  action: string
+  path: string
+  ip: string
+  error: string
+  ended_at: string | null
+  created_at: string
+}
+
+export interface AgentSyncMapping {
+  id: number
+  agent_id: number
+  source: string
+  target: string
+  delete: boolean
+  enabled: boolean
+  last_sync_at: string | null
+  last_error: string
+  last_updated: number
+  last_deleted: number
+  created_at: string
+  updated_at: string
+}
+
+export interface AgentSyncMappingForm {
+  source: string
+  target: string
+  delete: boolean
+  enabled: boolean
+}
+
+export interface AgentConfigForm {
+  worker_count: number
+  queue_size: number
+  log_level: string
+  allowed_work_dirs: string
+  proxy: string
+  no_proxy: string
+  memory_limit: number
+  cpu_limit: number
+  open_files_limit: number
+}
+
+export interface AgentConfig extends AgentConfigForm {
+  version: number
+  group: string
+  labels: string
+  effective: Record<string, string>
+  applied_version: number
+  applied_at: string | null
+  apply_error: string
+  online: boolean
+  synced: boolean
+  overrides: { key: string; local: string; panel: string }[]
+}
+
+export interface AgentTelemetry {
+  time: number
+  num_cpu: number
+  cpu: number
+  mem_used: number
+  mem_total: number
+  mem_percent: number
+  load1: number
+  load5: number
+  load15: number
+  disk_used: number
+  disk_total: number
+  disk_percent: number
+  running: number
+  queued: number
+}
+
+export interface AgentRollout {
+  id: number
+  name: string
+  target_version: string
+  selector: string
+  waves: string
+  current_wave: number
+  wave_timeout: number
+  max_failures: number
+  status: 'running' | 'paused' | 'completed' | 'cancelled'
+  pause_reason: string
+  wave_started_at: string | null
+  finished_at: string | null
+  created_at: string
+  wave_count: number
+  total: number
+  counts: Record<string, number>
+}
+
+export interface AgentRolloutTarget {
+  id: number
+  rollout_id: number
+  agent_id: number
+  agent_name: string
+  wave: number
+  from_version: string
+  status: 'pending' | 'updating' | 'success' | 'failed' | 'skipped'
+  error: string
+  started_at: string | null
+  finished_at: string | null
+  online: boolean
+  version: string
+  build_time: string
+}
+
 export interface AgentToken {
   id: number
   token: string
@@ -443,6 +757,16 @@ export interface AgentToken {
   max_uses: number
   used_count: number
   expires_at: string | null
+  auto_approve: boolean
+  group: string
   enabled: boolean
   created_at: string
 }
+
+export interface AgentBlock {
+  id: number
+  machine_id: string
+  hostname: string
+  ip: string
+  created_at: string
+}
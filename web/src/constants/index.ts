@@ -22,12 +22,20 @@ export const FILE_RUNNERS: Record<string, string> = {
 export const TASK_STATUS = {
   SUCCESS: 'success',
   FAILED: 'failed',
+  WARNING: 'warning',
   RUNNING: 'running',
   PENDING: 'pending',
   TIMEOUT: 'timeout',
   CANCELLED: 'cancelled',
 } as const
 
+// 任务健康状态
+export const TASK_HEALTH = {
+  HEALTHY: 'healthy',
+  DEGRADED: 'degraded',
+  FAILING: 'failing',
+} as const
+
 // 任务类型
 export const TASK_TYPE = {
   NORMAL: 'task',
@@ -39,3 +47,16 @@ export const AGENT_STATUS = {
   ONLINE: 'online',
   OFFLINE: 'offline',
 } as const
+
+// 按标签选择 Agent 时的分发模式
+export const DISPATCH_MODE = {
+  ANY: 'any',
+  ALL: 'all',
+  STICKY: 'sticky',
+} as const
+
+export const DISPATCH_MODE_LABELS: Record<string, string> = {
+  any: '任一（负载均衡）',
+  all: '全部（每个 Agent 各执行一次）',
+  sticky: '优先 Agent（不可用时切换）',
+}
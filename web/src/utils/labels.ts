@@ -0,0 +1,25 @@
+import type { Agent } from '@/api'
+
+// 判断 Agent 的标签是否满足选择器（与服务端规则一致：逗号分隔的条件需全部满足，支持 k=v、k!=v、k、!k）
+export function matchSelector(selector: string, labels: Record<string, string> = {}): boolean {
+  const items = selector.split(',').map(s => s.trim()).filter(Boolean)
+  if (items.length === 0) return false
+  return items.every(item => {
+    if (item.includes('!=')) {
+      const idx = item.indexOf('!=')
+      return labels[item.slice(0, idx).trim()] !== item.slice(idx + 2).trim()
+    }
+    if (item.includes('=')) {
+      const idx = item.indexOf('=')
+      const k = item.slice(0, idx).trim()
+      return k in labels && labels[k] === item.slice(idx + 1).trim()
+    }
+    if (item.startsWith('!')) return !(item.slice(1).trim() in labels)
+    return item in labels
+  })
+}
+
+// 返回选择器匹配的已启用 Agent
+export function matchAgents(selector: string, agents: Agent[]): Agent[] {
+  return agents.filter(a => a.enabled && matchSelector(selector, a.effective_labels))
+}